@@ -1470,6 +1470,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep := "/terraform/v1/ai-talk/stage/start"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		createNewStage := func(ctx context.Context, room *SrsLiveRoom) (*Stage, error) {
 			ctx = logger.WithContext(ctx)
@@ -1526,7 +1527,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -1624,6 +1625,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/stage/conversation"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1642,7 +1644,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -1693,6 +1695,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/stage/upload"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		var sreq *StageRequest
 		if err := func() error {
@@ -1725,7 +1728,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -1889,6 +1892,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/stage/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1910,7 +1914,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -1961,6 +1965,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/stage/hello-voices/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			filename := r.URL.Path[len("/terraform/v1/ai-talk/stage/hello-voices/"):]
@@ -1990,6 +1995,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/stage/verify"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var roomToken string
@@ -2028,6 +2034,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/subscribe/start"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -2046,7 +2053,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -2117,6 +2124,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/subscribe/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -2143,7 +2151,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Authenticate by bearer token if no room token
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -2231,6 +2239,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/subscribe/tts"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			q := r.URL.Query()
@@ -2310,6 +2319,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/subscribe/remove"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -2331,7 +2341,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -2391,6 +2401,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/user/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -2411,7 +2422,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -2459,6 +2470,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai-talk/user/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -2482,7 +2494,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 			if roomToken == "" {
 				apiSecret := envApiSecret()
-				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 					return errors.Wrapf(err, "authenticate")
 				}
 			}
@@ -0,0 +1,452 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The prefix of API key, to identify it from the bearer secret and JWT token.
+const ApiKeyPrefix = "oryx-"
+
+// The interval to update the last used time of API key, to avoid writing redis for each request.
+const ApiKeyLastUsedInterval = time.Minute
+
+// The resources of management API, which are never allowed for API key, whatever the scopes, to avoid
+// escalating privilege by creating users, API keys or root tokens, or by reading the root secret.
+var apiKeyDeniedResources = []string{"users", "apikeys", "token", "secret"}
+
+// The resources of management API, by path prefix.
+var apiKeyResources = []struct {
+	prefix   string
+	resource string
+}{
+	{"/terraform/v1/mgmt/users/", "users"},
+	{"/terraform/v1/mgmt/apikeys/", "apikeys"},
+	{"/terraform/v1/mgmt/token", "token"},
+	{"/terraform/v1/mgmt/secret/", "secret"},
+	{"/terraform/v1/mgmt/init", "secret"},
+	{"/terraform/v1/mgmt/audit", "audit"},
+	{"/terraform/v1/mgmt/streams/", "streams"},
+	{"/terraform/v1/mgmt/hooks/", "hooks"},
+	{"/terraform/v1/hooks/srs/", "hooks"},
+	{"/terraform/v1/ffmpeg/forward/", "forward"},
+	{"/terraform/v1/ffmpeg/vlive/", "vlive"},
+	{"/terraform/v1/ffmpeg/camera/", "camera"},
+	{"/terraform/v1/ffmpeg/transcode/", "transcode"},
+	{"/terraform/v1/ffmpeg/fallback/", "fallback"},
+	{"/terraform/v1/ffmpeg/mosaic/", "mosaic"},
+	{"/terraform/v1/ffmpeg/upload/", "upload"},
+	{"/terraform/v1/hooks/record/", "record"},
+	{"/terraform/v1/hooks/dvr/", "dvr"},
+	{"/terraform/v1/hooks/vod/", "vod"},
+	{"/terraform/v1/live/room/", "rooms"},
+	{"/terraform/v1/ai/ocr/", "ocr"},
+	{"/terraform/v1/ai/transcript/", "transcript"},
+	{"/terraform/v1/ai-talk/", "aitalk"},
+	{"/terraform/v1/dubbing/", "dubbing"},
+	{"/api/", "srs"},
+}
+
+// RequiredApiKeyScope returns the scope required by the management API request, in the form of
+// resource:action, where action is read or write, for example, streams:read or forward:write.
+func RequiredApiKeyScope(r *http.Request) string {
+	resource := "system"
+	for _, e := range apiKeyResources {
+		if strings.HasPrefix(r.URL.Path, e.prefix) {
+			resource = e.resource
+			break
+		}
+	}
+
+	action := "write"
+	if RequiredUserRole(r) == UserRoleViewer {
+		action = "read"
+	}
+
+	return fmt.Sprintf("%v:%v", resource, action)
+}
+
+// SrsApiKey is a named API key, with scopes and optional expiration.
+type SrsApiKey struct {
+	// The API key ID, a UUID string.
+	ID string `json:"id"`
+	// The name of API key, for example, CI.
+	Name string `json:"name"`
+	// The prefix of key, to identify the key without exposing it.
+	Prefix string `json:"prefix"`
+	// The SHA256 hash of key, never save the key itself.
+	Hash string `json:"hash,omitempty"`
+	// The scopes of key, in the form of resource:action, support glob like record:* or *:read.
+	Scopes []string `json:"scopes"`
+	// The expiration time in RFC3339, never expire if empty.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Whether the key is revoked.
+	Revoked bool `json:"revoked"`
+	// Create and last used time.
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+func NewSrsApiKey(opts ...func(key *SrsApiKey)) *SrsApiKey {
+	v := &SrsApiKey{
+		ID:        uuid.NewString(),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *SrsApiKey) String() string {
+	return fmt.Sprintf("id=%v, name=%v, prefix=%v, scopes=%v, expires=%v, revoked=%v, created=%v, used=%v",
+		v.ID, v.Name, v.Prefix, v.Scopes, v.ExpiresAt, v.Revoked, v.CreatedAt, v.LastUsedAt,
+	)
+}
+
+// Generate a new key, save the hash and return the key, which is only visible once.
+func (v *SrsApiKey) Generate() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "generate key")
+	}
+
+	key := fmt.Sprintf("%v%v", ApiKeyPrefix, hex.EncodeToString(b))
+	v.Prefix = key[:len(ApiKeyPrefix)+6]
+	v.Hash = apiKeyHash(key)
+	return key, nil
+}
+
+// Expired whether the key is expired.
+func (v *SrsApiKey) Expired() bool {
+	if v.ExpiresAt == "" {
+		return false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, v.ExpiresAt)
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// Allows whether the scopes of key match the required scope.
+func (v *SrsApiKey) Allows(scope string) bool {
+	resource := strings.Split(scope, ":")[0]
+	for _, denied := range apiKeyDeniedResources {
+		if resource == denied {
+			return false
+		}
+	}
+
+	for _, pattern := range v.Scopes {
+		if pattern == "*" {
+			return true
+		}
+		if ok, err := path.Match(pattern, scope); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Public returns a copy of key without the hash, to response to client.
+func (v *SrsApiKey) Public() *SrsApiKey {
+	key := *v
+	key.Hash = ""
+	return &key
+}
+
+// Save the key to redis, indexed by the hash of key.
+func (v *SrsApiKey) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_API_KEYS, v.Hash, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_API_KEYS, v.ID)
+	}
+	return nil
+}
+
+// apiKeyHash builds the SHA256 hash of key. Note that the key is random enough, so no salt is required.
+func apiKeyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// LoadSrsApiKey loads the API key by the key itself, or nil if not exists.
+func LoadSrsApiKey(ctx context.Context, key string) (*SrsApiKey, error) {
+	hash := apiKeyHash(key)
+	r0, err := rdb.HGet(ctx, SRS_API_KEYS, hash).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_API_KEYS, hash)
+	} else if r0 == "" {
+		return nil, nil
+	}
+
+	var obj SrsApiKey
+	if err = json.Unmarshal([]byte(r0), &obj); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", r0)
+	}
+
+	if used, err := rdb.HGet(ctx, SRS_API_KEYS_USED, hash).Result(); err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_API_KEYS_USED, hash)
+	} else if used != "" {
+		obj.LastUsedAt = used
+	}
+	return &obj, nil
+}
+
+// LoadSrsApiKeys loads all API keys, sorted by create time.
+func LoadSrsApiKeys(ctx context.Context) ([]*SrsApiKey, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_API_KEYS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_API_KEYS)
+	}
+
+	used, err := rdb.HGetAll(ctx, SRS_API_KEYS_USED).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_API_KEYS_USED)
+	}
+
+	keys := []*SrsApiKey{}
+	for hash, obj := range objs {
+		var key SrsApiKey
+		if err := json.Unmarshal([]byte(obj), &key); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", obj)
+		}
+		if lastUsedAt, ok := used[hash]; ok {
+			key.LastUsedAt = lastUsedAt
+		}
+		keys = append(keys, &key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+	return keys, nil
+}
+
+// authenticateApiKey verifies the API key, and whether it's allowed to access the request.
+func authenticateApiKey(ctx context.Context, key string, r *http.Request) (*SrsApiKey, error) {
+	obj, err := LoadSrsApiKey(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load api key")
+	} else if obj == nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	if obj.Revoked {
+		return nil, errors.Errorf("api key %v revoked", obj.ID)
+	}
+	if obj.Expired() {
+		return nil, errors.Errorf("api key %v expired at %v", obj.ID, obj.ExpiresAt)
+	}
+	if scope := RequiredApiKeyScope(r); !obj.Allows(scope) {
+		return nil, errors.Errorf("api key %v scopes %v not allowed, %v requires %v", obj.ID, obj.Scopes, r.URL.Path, scope)
+	}
+
+	// Update the last used time, but not too frequently. Note that we only write the field of key, never save
+	// the whole key, which might overwrite the key revoked by others.
+	if lastUsedAt, err := time.Parse(time.RFC3339, obj.LastUsedAt); err != nil || time.Since(lastUsedAt) > ApiKeyLastUsedInterval {
+		obj.LastUsedAt = time.Now().Format(time.RFC3339)
+		if err := rdb.HSet(ctx, SRS_API_KEYS_USED, obj.Hash, obj.LastUsedAt).Err(); err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hset %v %v %v", SRS_API_KEYS_USED, obj.ID, obj.LastUsedAt)
+		}
+	}
+
+	return obj, nil
+}
+
+func handleApiKeyService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/mgmt/apikeys/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name, expiresAt string
+			var scopes []string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string   `json:"token"`
+				Name      *string   `json:"name"`
+				Scopes    *[]string `json:"scopes"`
+				ExpiresAt *string   `json:"expires_at"`
+			}{
+				Token: &token, Name: &name, Scopes: &scopes, ExpiresAt: &expiresAt,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if name == "" {
+				return errors.New("no name")
+			}
+			if len(scopes) == 0 {
+				return errors.New("no scopes")
+			}
+			for _, scope := range scopes {
+				if _, err := path.Match(scope, ""); err != nil {
+					return errors.Wrapf(err, "invalid scope %v", scope)
+				}
+			}
+			if expiresAt != "" {
+				if _, err := time.Parse(time.RFC3339, expiresAt); err != nil {
+					return errors.Wrapf(err, "invalid expires_at %v", expiresAt)
+				}
+			}
+
+			obj := NewSrsApiKey(func(key *SrsApiKey) {
+				key.Name, key.Scopes, key.ExpiresAt = name, scopes, expiresAt
+			})
+			key, err := obj.Generate()
+			if err != nil {
+				return errors.Wrapf(err, "generate key")
+			}
+			if err := obj.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save api key %v", obj.String())
+			}
+
+			// Note that the key is only visible once, we never save it.
+			ohttp.WriteData(ctx, w, r, &struct {
+				*SrsApiKey
+				Key string `json:"key"`
+			}{
+				SrsApiKey: obj.Public(), Key: key,
+			})
+			logger.Tf(ctx, "api key create ok, %v, token=%vB", obj.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/apikeys/list"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			keys, err := LoadSrsApiKeys(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load api keys")
+			}
+
+			var publicKeys []*SrsApiKey
+			for _, key := range keys {
+				publicKeys = append(publicKeys, key.Public())
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Keys []*SrsApiKey `json:"keys"`
+			}{
+				Keys: publicKeys,
+			})
+			logger.Tf(ctx, "api key list ok, keys=%v, token=%vB", len(keys), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	// Revoke or remove the API key by id. The revoked key is kept for the last used time, while the
+	// removed key is deleted.
+	for _, e := range []struct {
+		ep     string
+		remove bool
+	}{
+		{"/terraform/v1/mgmt/apikeys/revoke", false},
+		{"/terraform/v1/mgmt/apikeys/remove", true},
+	} {
+		ep, remove := e.ep, e.remove
+		logger.Tf(ctx, "Handle %v", ep)
+		auditMutating(ep)
+		handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+			if err := func() error {
+				var token, id string
+				if err := ParseBody(ctx, r.Body, &struct {
+					Token *string `json:"token"`
+					ID    *string `json:"id"`
+				}{
+					Token: &token, ID: &id,
+				}); err != nil {
+					return errors.Wrapf(err, "parse body")
+				}
+
+				apiSecret := envApiSecret()
+				if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+
+				keys, err := LoadSrsApiKeys(ctx)
+				if err != nil {
+					return errors.Wrapf(err, "load api keys")
+				}
+
+				var obj *SrsApiKey
+				for _, key := range keys {
+					if key.ID == id {
+						obj = key
+						break
+					}
+				}
+				if obj == nil {
+					return errors.Errorf("api key %v not exists", id)
+				}
+
+				if remove {
+					if err := rdb.HDel(ctx, SRS_API_KEYS, obj.Hash).Err(); err != nil && err != redis.Nil {
+						return errors.Wrapf(err, "hdel %v %v", SRS_API_KEYS, obj.ID)
+					}
+					if err := rdb.HDel(ctx, SRS_API_KEYS_USED, obj.Hash).Err(); err != nil && err != redis.Nil {
+						return errors.Wrapf(err, "hdel %v %v", SRS_API_KEYS_USED, obj.ID)
+					}
+				} else {
+					obj.Revoked = true
+					if err := obj.Save(ctx); err != nil {
+						return errors.Wrapf(err, "save api key %v", obj.String())
+					}
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "api key %v ok, remove=%v, %v, token=%vB", ep, remove, obj.String(), len(token))
+				return nil
+			}(); err != nil {
+				ohttp.WriteError(ctx, w, r, err)
+			}
+		})
+	}
+
+	return nil
+}
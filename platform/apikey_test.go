@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApiKey_RequiredScope(t *testing.T) {
+	for _, e := range []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/query", scope: "streams:read"},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/kickoff", scope: "streams:write"},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/forward/secret", scope: "forward:write"},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/record/query", scope: "record:read"},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/apikeys/create", scope: "apikeys:write"},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/ssl", scope: "system:write"},
+		{method: http.MethodGet, path: "/api/v1/streams/", scope: "srs:read"},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/secret/query", scope: "secret:write"},
+	} {
+		r := httptest.NewRequest(e.method, e.path, nil)
+		if scope := RequiredApiKeyScope(r); scope != e.scope {
+			t.Errorf("Fail for %v %v, expect %v, actual %v", e.method, e.path, e.scope, scope)
+		}
+	}
+}
+
+func TestApiKey_Allows(t *testing.T) {
+	for _, e := range []struct {
+		scopes []string
+		scope  string
+		allow  bool
+	}{
+		{scopes: []string{"streams:read"}, scope: "streams:read", allow: true},
+		{scopes: []string{"streams:read"}, scope: "streams:write", allow: false},
+		{scopes: []string{"record:*"}, scope: "record:write", allow: true},
+		{scopes: []string{"*:read"}, scope: "forward:read", allow: true},
+		{scopes: []string{"*:read"}, scope: "forward:write", allow: false},
+		{scopes: []string{"*"}, scope: "system:write", allow: true},
+		{scopes: []string{"*"}, scope: "apikeys:write", allow: false},
+		{scopes: []string{"*"}, scope: "users:read", allow: false},
+		{scopes: []string{"*"}, scope: "token:read", allow: false},
+		{scopes: []string{"*"}, scope: "secret:write", allow: false},
+		{scopes: []string{"secret:*"}, scope: "secret:read", allow: false},
+		{scopes: nil, scope: "streams:read", allow: false},
+	} {
+		key := NewSrsApiKey(func(key *SrsApiKey) {
+			key.Scopes = e.scopes
+		})
+		if allow := key.Allows(e.scope); allow != e.allow {
+			t.Errorf("Fail for scopes %v of %v, expect %v, actual %v", e.scopes, e.scope, e.allow, allow)
+		}
+	}
+}
+
+func TestApiKey_Generate(t *testing.T) {
+	obj := NewSrsApiKey()
+	key, err := obj.Generate()
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if obj.Hash != apiKeyHash(key) || obj.Prefix == "" || obj.Prefix == key {
+		t.Errorf("Fail for key %vB, %v", len(key), obj.String())
+	}
+
+	if obj.Expired() {
+		t.Errorf("Fail for key without expiration should not expire")
+	}
+	obj.ExpiresAt = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	if !obj.Expired() {
+		t.Errorf("Fail for key should expire, %v", obj.String())
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The max number of audit entries, the oldest entries are trimmed.
+const AuditMaxEntries = 10000
+
+// The max size of request body to summarize, the larger body is not parsed.
+const AuditMaxRequestBody = 64 * 1024
+
+// The max size of request summary and error message in audit entry.
+const AuditMaxSummary = 1024
+
+// The route table of API, the key is the pattern of endpoint, the value is whether it's mutating, to be audited.
+// Each endpoint is registered by auditMutating or auditReadOnly, before handled by the mux.
+var auditRoutes sync.Map
+
+// auditMutating registers the endpoint as mutating, which changes the state of system, to be audited.
+func auditMutating(ep string) {
+	auditRoutes.Store(ep, true)
+}
+
+// auditReadOnly registers the endpoint as read-only, which never changes the state of system.
+func auditReadOnly(ep string) {
+	auditRoutes.Store(ep, false)
+}
+
+// auditSensitiveField whether the field of request body is sensitive, whose value should be redacted.
+func auditSensitiveField(k string) bool {
+	lk := strings.ToLower(k)
+	for _, field := range []string{"token", "password", "secret"} {
+		if strings.Contains(lk, field) {
+			return true
+		}
+	}
+	return strings.HasSuffix(lk, "key") || lk == "ca" || lk == "bearer"
+}
+
+type auditContextKey struct{}
+
+// AuditEntry is an entry of audit log, for a mutating management API call.
+type AuditEntry struct {
+	// The time of request, in RFC3339.
+	Time string `json:"time"`
+	// The caller identity, such as root, user:name or apikey:id, empty if not authenticated.
+	Identity string `json:"identity"`
+	// The client IP.
+	IP string `json:"ip"`
+	// The HTTP method and endpoint.
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+	// The redacted summary of request body.
+	Request string `json:"request"`
+	// The outcome, the HTTP status and error message if failed.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// The duration in milliseconds.
+	Duration int64 `json:"duration"`
+}
+
+func (v *AuditEntry) String() string {
+	return fmt.Sprintf("time=%v, identity=%v, ip=%v, method=%v, endpoint=%v, status=%v, error=%v, duration=%vms",
+		v.Time, v.Identity, v.IP, v.Method, v.Endpoint, v.Status, v.Error, v.Duration,
+	)
+}
+
+// isAuditMutation whether the request is a mutating management API call.
+func isAuditMutation(r *http.Request) bool {
+	p := r.URL.Path
+
+	// The SRS HTTP API, for example, kickoff client by DELETE.
+	if strings.HasPrefix(p, "/api/") {
+		return r.Method != http.MethodGet
+	}
+
+	// Match the route table like the mux, the exact endpoint, or the longest pattern ends with slash.
+	if mutating, ok := auditRoutes.Load(p); ok {
+		return mutating.(bool)
+	}
+
+	var pattern string
+	var mutating bool
+	auditRoutes.Range(func(k, v interface{}) bool {
+		if ep := k.(string); strings.HasSuffix(ep, "/") && strings.HasPrefix(p, ep) && len(ep) > len(pattern) {
+			pattern, mutating = ep, v.(bool)
+		}
+		return true
+	})
+	return mutating
+}
+
+// auditRedact summarizes the JSON request body, and redacts the values of sensitive fields.
+func auditRedact(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Sprintf("<%vB>", len(b))
+	}
+
+	var redact func(obj map[string]interface{})
+	redact = func(obj map[string]interface{}) {
+		for k := range obj {
+			if auditSensitiveField(k) {
+				obj[k] = "***"
+			}
+			if child, ok := obj[k].(map[string]interface{}); ok {
+				redact(child)
+			}
+		}
+	}
+	redact(obj)
+
+	summary, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Sprintf("<%vB>", len(b))
+	}
+	if len(summary) > AuditMaxSummary {
+		return string(summary[:AuditMaxSummary]) + "..."
+	}
+	return string(summary)
+}
+
+// auditResponseWriter captures the status and error message of response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (v *auditResponseWriter) WriteHeader(status int) {
+	if v.status == 0 {
+		v.status = status
+	}
+	v.ResponseWriter.WriteHeader(status)
+}
+
+func (v *auditResponseWriter) Write(b []byte) (int, error) {
+	if v.status == 0 {
+		v.status = http.StatusOK
+	}
+	if v.status >= http.StatusBadRequest && v.body.Len() < AuditMaxSummary {
+		v.body.Write(b)
+	}
+	return v.ResponseWriter.Write(b)
+}
+
+// auditIdentity sets the caller identity of request, if audited.
+func auditIdentity(r *http.Request, identity *AuthIdentity) {
+	if entry, ok := r.Context().Value(auditContextKey{}).(*AuditEntry); ok {
+		entry.Identity = identity.String()
+	}
+}
+
+// newAuditEntry creates the audit entry of request. Note that the IP is the peer address, the forwarded
+// headers are only trusted from the trusted proxies, see httpClientIP.
+func newAuditEntry(r *http.Request, starttime time.Time) *AuditEntry {
+	return &AuditEntry{
+		Time: starttime.Format(time.RFC3339), IP: httpClientIP(r), Method: r.Method, Endpoint: r.URL.Path,
+	}
+}
+
+// handleAuditRequest serves the request by next handler, and writes the audit entry if it's a mutating
+// management API call.
+func handleAuditRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if !isAuditMutation(r) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	starttime := time.Now()
+	entry := newAuditEntry(r, starttime)
+
+	// Read the head of body to summarize, and restore it for the next handler.
+	if r.Body != nil && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		head, err := ioutil.ReadAll(io.LimitReader(r.Body, AuditMaxRequestBody+1))
+		if err == nil && len(head) <= AuditMaxRequestBody {
+			entry.Request = auditRedact(head)
+		} else {
+			entry.Request = fmt.Sprintf("<%vB+>", len(head))
+		}
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(head), r.Body))
+	}
+
+	aw := &auditResponseWriter{ResponseWriter: w}
+	next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, entry)))
+
+	entry.Status, entry.Duration = aw.status, time.Since(starttime).Milliseconds()
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	if entry.Status >= http.StatusBadRequest {
+		entry.Error = strings.TrimSpace(aw.body.String())
+	}
+
+	if err := entry.Save(ctx); err != nil {
+		logger.Wf(ctx, "ignore audit err %+v", err)
+	}
+}
+
+// Save the audit entry to redis stream, which is capped to AuditMaxEntries.
+func (v *AuditEntry) Save(ctx context.Context) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	}
+
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: SRS_AUDIT, MaxLen: AuditMaxEntries, Approx: true,
+		Values: map[string]interface{}{"entry": string(b)},
+	}).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "xadd %v %v", SRS_AUDIT, string(b))
+	}
+	return nil
+}
+
+func handleAuditService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/mgmt/audit"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, start, end, endpoint string
+			var limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Start    *string `json:"start"`
+				End      *string `json:"end"`
+				Endpoint *string `json:"endpoint"`
+				Limit    *int    `json:"limit"`
+			}{
+				Token: &token, Start: &start, End: &end, Endpoint: &endpoint, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if limit <= 0 {
+				limit = 100
+			} else if limit > 1000 {
+				limit = 1000
+			}
+
+			// Convert the time range to stream ID, which is the milliseconds of time.
+			parseStreamID := func(t, defaultID string) (string, error) {
+				if t == "" {
+					return defaultID, nil
+				}
+				v, err := time.Parse(time.RFC3339, t)
+				if err != nil {
+					return "", errors.Wrapf(err, "parse time %v", t)
+				}
+				return strconv.FormatInt(v.UnixMilli(), 10), nil
+			}
+
+			startID, err := parseStreamID(start, "-")
+			if err != nil {
+				return errors.Wrapf(err, "start")
+			}
+			endID, err := parseStreamID(end, "+")
+			if err != nil {
+				return errors.Wrapf(err, "end")
+			}
+
+			// Query the latest entries first, filter by endpoint.
+			messages, err := rdb.XRevRangeN(ctx, SRS_AUDIT, endID, startID, AuditMaxEntries).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "xrevrange %v %v %v", SRS_AUDIT, endID, startID)
+			}
+
+			entries := []*AuditEntry{}
+			for _, message := range messages {
+				if len(entries) >= limit {
+					break
+				}
+
+				s, ok := message.Values["entry"].(string)
+				if !ok {
+					continue
+				}
+
+				var entry AuditEntry
+				if err := json.Unmarshal([]byte(s), &entry); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", message.ID, s)
+				}
+				if endpoint != "" && !strings.Contains(entry.Endpoint, endpoint) {
+					continue
+				}
+				entries = append(entries, &entry)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Entries []*AuditEntry `json:"entries"`
+			}{
+				Entries: entries,
+			})
+			logger.Tf(ctx, "audit query ok, start=%v, end=%v, endpoint=%v, limit=%v, entries=%v, token=%vB",
+				start, end, endpoint, limit, len(entries), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
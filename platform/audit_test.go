@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit_IsMutation(t *testing.T) {
+	for _, ep := range []string{
+		"/terraform/v1/ffmpeg/forward/secret", "/terraform/v1/mgmt/streams/kickoff", "/terraform/v1/hooks/record/apply",
+		"/terraform/v1/live/room/remove", "/terraform/v1/ffmpeg/upload/chunk/",
+	} {
+		auditMutating(ep)
+	}
+	for _, ep := range []string{
+		"/", "/terraform/v1/hooks/srs/secret", "/terraform/v1/hooks/srs/secret/query",
+		"/terraform/v1/mgmt/streams/query", "/terraform/v1/hooks/srs/verify",
+	} {
+		auditReadOnly(ep)
+	}
+
+	for _, e := range []struct {
+		method   string
+		path     string
+		mutation bool
+	}{
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/forward/secret", mutation: true},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/kickoff", mutation: true},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/record/apply", mutation: true},
+		{method: http.MethodPost, path: "/terraform/v1/live/room/remove", mutation: true},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/srs/secret", mutation: false},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/srs/secret/query", mutation: false},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/query", mutation: false},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/srs/verify", mutation: false},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/upload/chunk/xxx", mutation: true},
+		{method: http.MethodPost, path: "/terraform/v1/unknown", mutation: false},
+		{method: http.MethodGet, path: "/api/v1/clients/", mutation: false},
+		{method: http.MethodDelete, path: "/api/v1/clients/1234", mutation: true},
+	} {
+		r := httptest.NewRequest(e.method, e.path, nil)
+		if mutation := isAuditMutation(r); mutation != e.mutation {
+			t.Errorf("Fail for %v %v, expect %v, actual %v", e.method, e.path, e.mutation, mutation)
+		}
+	}
+}
+
+// Each endpoint should be registered to the route table of audit, right before handled by the mux.
+func TestAudit_RoutesClassified(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Errorf("Fail for glob, err=%v", err)
+	}
+
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Errorf("Fail for parse %v, err=%v", file, err)
+			continue
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			block, ok := n.(*ast.BlockStmt)
+			if !ok {
+				return true
+			}
+
+			for i, stmt := range block.List {
+				call := auditCallOf(stmt)
+				if call == nil || auditCallName(call) != "handler.HandleFunc" {
+					continue
+				}
+
+				// The root handler of server, not an endpoint.
+				if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Value == `"/"` {
+					continue
+				}
+
+				var prev *ast.CallExpr
+				if i > 0 {
+					prev = auditCallOf(block.List[i-1])
+				}
+				if prev == nil || (auditCallName(prev) != "auditMutating" && auditCallName(prev) != "auditReadOnly") {
+					t.Errorf("Fail for %v, endpoint is not classified by auditMutating or auditReadOnly",
+						fset.Position(call.Pos()))
+				}
+			}
+			return true
+		})
+	}
+}
+
+func auditCallOf(stmt ast.Stmt) *ast.CallExpr {
+	if expr, ok := stmt.(*ast.ExprStmt); ok {
+		if call, ok := expr.X.(*ast.CallExpr); ok {
+			return call
+		}
+	}
+	return nil
+}
+
+func auditCallName(call *ast.CallExpr) string {
+	switch fn := call.Fun.(type) {
+	case *ast.Ident:
+		return fn.Name
+	case *ast.SelectorExpr:
+		if x, ok := fn.X.(*ast.Ident); ok {
+			return fmt.Sprintf("%v.%v", x.Name, fn.Sel.Name)
+		}
+	}
+	return ""
+}
+
+func TestAudit_Redact(t *testing.T) {
+	summary := auditRedact([]byte(`{"token":"xxx","secret":"yyy","action":"update","custom":{"apiKey":"zzz","camera":"cam"}}`))
+	for _, value := range []string{"xxx", "yyy", "zzz"} {
+		if strings.Contains(summary, value) {
+			t.Errorf("Fail for %v should be redacted, summary=%v", value, summary)
+		}
+	}
+	for _, value := range []string{"update", "cam"} {
+		if !strings.Contains(summary, value) {
+			t.Errorf("Fail for %v should be kept, summary=%v", value, summary)
+		}
+	}
+
+	if summary := auditRedact([]byte("not json")); summary != "<8B>" {
+		t.Errorf("Fail for non-json body, summary=%v", summary)
+	}
+}
+
+func TestAudit_EntryIP(t *testing.T) {
+	defer os.Setenv("TRUSTED_PROXIES", os.Getenv("TRUSTED_PROXIES"))
+	os.Setenv("TRUSTED_PROXIES", "")
+
+	r := httptest.NewRequest(http.MethodPost, "/terraform/v1/ffmpeg/forward/secret", nil)
+	r.RemoteAddr = "203.0.113.7:34567"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+
+	// The forwarded headers are forged by client, which is not a trusted proxy.
+	if entry := newAuditEntry(r, time.Now()); entry.IP != "203.0.113.7" {
+		t.Errorf("Fail for ip %v", entry.IP)
+	}
+}
@@ -6,12 +6,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"io/ioutil"
 	"net/http"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,19 +42,25 @@ type CallbackWorker struct {
 	ephemeralConfig CallbackConfig
 	// Whether update the config immediately.
 	updateConfig chan bool
+	// Whether deliver the callbacks in outbox immediately.
+	deliverNow chan bool
 
 	lock sync.Mutex
+	// To protect the deliveries in outbox.
+	outboxLock sync.Mutex
 }
 
 func NewCallbackWorker() *CallbackWorker {
 	return &CallbackWorker{
 		updateConfig: make(chan bool, 1),
+		deliverNow:   make(chan bool, 1),
 	}
 }
 
 func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/mgmt/hooks/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -62,7 +74,7 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -81,6 +93,12 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 				return errors.Wrapf(err, "hget %v res", SRS_HOOKS)
 			}
 
+			// Never response the secret of default target and targets, see CallbackTarget.Redacted.
+			config.Secret = callbackRedactSecret(config.Secret)
+			for i, target := range config.Targets {
+				config.Targets[i] = target.Redacted()
+			}
+
 			type HooksQueryResult struct {
 				Request  string `json:"req"`
 				Response string `json:"res"`
@@ -100,10 +118,17 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 
 	ep = "/terraform/v1/mgmt/hooks/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			var token string
+			// Only overwrite the fields in request, keep others as is, so load the config before parsing.
 			var config CallbackConfig
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load")
+			}
+			prevSecret := config.Secret
+
+			var token string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
 				*CallbackConfig
@@ -115,18 +140,13 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if err := rdb.HSet(ctx, SRS_HOOKS, "target", config.Target).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hset %v target %v", SRS_HOOKS, config.Target)
-			}
-			if err := rdb.HSet(ctx, SRS_HOOKS, "opaque", config.Opaque).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hset %v opaque %v", SRS_HOOKS, config.Opaque)
-			}
-			if err := rdb.HSet(ctx, SRS_HOOKS, "all", fmt.Sprintf("%v", config.All)).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hset %v all %v", SRS_HOOKS, config.All)
+			// Keep the secret if not changed, because the client only gets the redacted secret.
+			if config.Secret == "" || config.Secret == callbackRedactSecret(prevSecret) {
+				config.Secret = prevSecret
 			}
 
 			// Use the request host as the default host.
@@ -136,8 +156,19 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 					config.Host = fmt.Sprintf("https://%v", r.Host)
 				}
 			}
-			if err := rdb.HSet(ctx, SRS_HOOKS, "host", config.Host).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hset %v host %v", SRS_HOOKS, config.Host)
+
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
+			// Write all fields at once, never write a partial config.
+			if err := rdb.HSet(ctx, SRS_HOOKS,
+				"target", config.Target, "opaque", config.Opaque, "all", fmt.Sprintf("%v", config.All),
+				"host", config.Host, "async", fmt.Sprintf("%v", config.Async),
+				"maxAttempts", fmt.Sprintf("%v", config.MaxAttempts), "secret", config.Secret,
+				"verify", fmt.Sprintf("%v", config.Verify), "ca", config.CA,
+			).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_HOOKS, config.String())
 			}
 
 			// Notify the callback worker to update the config.
@@ -155,223 +186,564 @@ func (v *CallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) err
 		}
 	})
 
-	ep = "/terraform/v1/mgmt/hooks/example"
+	ep = "/terraform/v1/mgmt/hooks/deliveries"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			q := r.URL.Query()
+			var token string
+			var status CallbackDeliveryStatus
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string                 `json:"token"`
+				Status *CallbackDeliveryStatus `json:"status"`
+			}{
+				Token: &token, Status: &status,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
 
-			var fail bool
-			if value := q.Get("fail"); value == "true" || value == "1" {
-				fail = true
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
 			}
 
-			var action, opaque string
+			deliveries, err := LoadCallbackDeliveries(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load deliveries")
+			}
+
+			// Filter by status, list all deliveries if not specified.
+			filtered := []*CallbackDelivery{}
+			for _, delivery := range deliveries {
+				if status == "" || delivery.Status == status {
+					filtered = append(filtered, delivery)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, filtered)
+			logger.Tf(ctx, "hooks deliveries ok, status=%v, deliveries=%v, token=%vB", status, len(filtered), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/hooks/deliveries/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
 			if err := ParseBody(ctx, r.Body, &struct {
-				Action *string `json:"action"`
-				Opaque *string `json:"opaque"`
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
 			}{
-				Action: &action,
-				Opaque: &opaque,
+				Token: &token, ID: &id,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
 
-			if fail {
-				return errors.Errorf("fail as required, action=%v, opaque=%v", action, opaque)
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
 			}
 
-			ohttp.WriteData(ctx, w, r, nil)
-			logger.Tf(ctx, "hooks example ok, action=%v, opaque=%v", action, opaque)
+			if id == "" {
+				return errors.New("no id")
+			}
+
+			var delivery CallbackDelivery
+			if obj, err := rdb.HGet(ctx, SRS_HOOKS_DELIVERY, id).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_HOOKS_DELIVERY, id)
+			} else if obj == "" {
+				return errors.Errorf("no delivery %v", id)
+			} else if err = json.Unmarshal([]byte(obj), &delivery); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", obj)
+			}
+
+			ohttp.WriteData(ctx, w, r, &delivery)
+			logger.Tf(ctx, "hooks delivery query ok, %v, token=%vB", delivery.String(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
 		}
 	})
 
-	return nil
-}
-
-func (v *CallbackWorker) Close() error {
-	if v.cancel != nil {
-		v.cancel()
-	}
-	v.wg.Wait()
-	return nil
-}
+	ep = "/terraform/v1/mgmt/hooks/deliveries/replay"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			var all bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+				All   *bool   `json:"all"`
+			}{
+				Token: &token, ID: &id, All: &all,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
 
-func (v *CallbackWorker) Start(ctx context.Context) error {
-	wg := &v.wg
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
 
-	ctx, cancel := context.WithCancel(ctx)
-	v.cancel = cancel
+			if id == "" && !all {
+				return errors.New("no id or all")
+			}
 
-	ctx = logger.WithContext(ctx)
-	logger.Tf(ctx, "callback start a worker")
+			v.outboxLock.Lock()
+			defer v.outboxLock.Unlock()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+			deliveries, err := LoadCallbackDeliveries(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load deliveries")
+			}
 
-		for ctx.Err() == nil {
-			var config CallbackConfig
-			if err := config.Load(ctx); err != nil {
-				logger.Wf(ctx, "load config %v err %+v", config, err)
+			// Replay the dead deliveries, by id or all.
+			var replayed int
+			for _, delivery := range deliveries {
+				if delivery.Status != CallbackDeliveryStatusDead {
+					continue
+				}
+				if !all && delivery.ID != id {
+					continue
+				}
 
-				select {
-				case <-ctx.Done():
-				case <-time.After(10 * time.Second):
+				delivery.Replay()
+				if err := delivery.Save(ctx); err != nil {
+					return errors.Wrapf(err, "save delivery %v", delivery.String())
 				}
-				continue
+				replayed++
+			}
+			if !all && replayed == 0 {
+				return errors.Errorf("no dead delivery %v", id)
 			}
 
-			func() {
-				v.lock.Lock()
-				defer v.lock.Unlock()
-				v.ephemeralConfig = config
-			}()
-
+			// Notify the outbox worker to deliver it immediately.
 			select {
-			case <-ctx.Done():
-			case <-time.After(time.Second * 3):
-			case <-v.updateConfig:
+			case v.deliverNow <- true:
+			default:
 			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Replayed int `json:"replayed"`
+			}{
+				Replayed: replayed,
+			})
+			logger.Tf(ctx, "hooks delivery replay ok, id=%v, all=%v, replayed=%v, token=%vB", id, all, replayed, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
 		}
-	}()
+	})
 
-	return nil
-}
+	ep = "/terraform/v1/mgmt/hooks/targets/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var target CallbackTarget
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*CallbackTarget
+			}{
+				Token: &token, CallbackTarget: &target,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
 
-func (v *CallbackWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) error {
-	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
-		return nil
-	}
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
 
-	var config CallbackConfig
-	func() {
-		v.lock.Lock()
-		defer v.lock.Unlock()
-		config = v.ephemeralConfig
-	}()
+			if err := target.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", target.String())
+			}
 
-	if !config.All || config.Target == "" {
-		return nil
-	}
+			target.ID = uuid.NewString()
+			target.Update = time.Now().Format(time.RFC3339)
+			if err := target.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save target %v", target.String())
+			}
 
-	req := &struct {
-		RequestID string `json:"request_id"`
-		// The callback parameters.
-		Action string `json:"action"`
-		Opaque string `json:"opaque"`
-		Vhost  string `json:"vhost,omitempty"`
-		App    string `json:"app,omitempty"`
-		Stream string `json:"stream,omitempty"`
-		Param  string `json:"param,omitempty"`
-	}{
-		RequestID: uuid.NewString(),
-		// The callback parameters.
-		Action: string(action),
-		Opaque: config.Opaque,
-		Vhost:  streamObj.Vhost,
-		App:    streamObj.App,
-		Stream: streamObj.Stream,
-	}
-	if action == SrsActionOnPublish {
-		req.Param = streamObj.Param
-	}
+			// Notify the callback worker to update the config.
+			select {
+			case v.updateConfig <- true:
+			case <-ctx.Done():
+			default:
+			}
 
-	pfn4 := func(b, b2 []byte, code int) error {
-		if code != 0 {
-			return errors.Errorf("response code %v", code)
+			ohttp.WriteData(ctx, w, r, target.Redacted())
+			logger.Tf(ctx, "hooks target create ok, %v, token=%vB", target.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
 		}
+	})
 
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
-		return nil
-	}
+	ep = "/terraform/v1/mgmt/hooks/targets/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
 
-	pfn3 := func(b, b2 []byte) error {
-		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
-		}
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
 
-		var code int
-		if err := json.Unmarshal(b2, &struct {
-			Code *int `json:"code"`
-		}{
-			Code: &code,
-		}); err != nil {
-			return errors.Wrapf(err, "unmarshal response")
-		}
-		return pfn4(b, b2, code)
-	}
+			var target CallbackTarget
+			if r0, err := rdb.HGet(ctx, SRS_HOOKS_TARGETS, id).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_HOOKS_TARGETS, id)
+			} else if r0 == "" {
+				return errors.Errorf("target %v not exists", id)
+			} else if err = json.Unmarshal([]byte(r0), &target); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", id, r0)
+			}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
+			ohttp.WriteData(ctx, w, r, target.Redacted())
+			logger.Tf(ctx, "hooks target query ok, %v, token=%vB", target.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
 		}
+	})
 
-		req.Header.Set("Content-Type", "application/json")
-
-		var res *http.Response
-		if strings.HasPrefix(config.Target, "https://") {
-			client := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
+	ep = "/terraform/v1/mgmt/hooks/targets/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var target CallbackTarget
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*CallbackTarget
+			}{
+				Token: &token, CallbackTarget: &target,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
 			}
-			res, err = client.Do(req)
-		} else {
-			res, err = http.DefaultClient.Do(req)
-		}
-		if err != nil {
-			return errors.Wrapf(err, "http post")
-		}
-		defer res.Body.Close()
-
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
-		}
 
-		b2, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "read body")
-		}
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
-		}
+			var prev CallbackTarget
+			if r0, err := rdb.HGet(ctx, SRS_HOOKS_TARGETS, target.ID).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_HOOKS_TARGETS, target.ID)
+			} else if r0 == "" {
+				return errors.Errorf("target %v not exists", target.ID)
+			} else if err = json.Unmarshal([]byte(r0), &prev); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", target.ID, r0)
+			}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
-		}
+			// Keep the secret if not changed, because the client only gets the redacted secret.
+			if target.Secret != "" && target.Secret == callbackRedactSecret(prev.Secret) {
+				target.Secret = prev.Secret
+			}
 
-		return nil
-	}
+			if err := target.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", target.String())
+			}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
-		}
+			target.Update = time.Now().Format(time.RFC3339)
+			if err := target.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save target %v", target.String())
+			}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
-		}
+			// Notify the callback worker to update the config.
+			select {
+			case v.updateConfig <- true:
+			case <-ctx.Done():
+			default:
+			}
 
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
+			ohttp.WriteData(ctx, w, r, target.Redacted())
+			logger.Tf(ctx, "hooks target update ok, %v, token=%vB", target.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
 		}
+	})
 
-		return nil
-	}
-
-	if err := pfn(); err != nil {
-		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
-	}
-	return nil
+	ep = "/terraform/v1/mgmt/hooks/targets/list"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			targets, err := LoadCallbackTargets(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load targets")
+			}
+
+			redacted := []*CallbackTarget{}
+			for _, target := range targets {
+				redacted = append(redacted, target.Redacted())
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Targets []*CallbackTarget `json:"targets"`
+			}{
+				Targets: redacted,
+			})
+			logger.Tf(ctx, "hooks target list ok, targets=%v, token=%vB", len(targets), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/hooks/targets/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if r0, err := rdb.HGet(ctx, SRS_HOOKS_TARGETS, id).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_HOOKS_TARGETS, id)
+			} else if r0 == "" {
+				return errors.Errorf("target %v not exists", id)
+			}
+
+			if err := rdb.HDel(ctx, SRS_HOOKS_TARGETS, id).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_HOOKS_TARGETS, id)
+			}
+
+			// Notify the callback worker to update the config.
+			select {
+			case v.updateConfig <- true:
+			case <-ctx.Done():
+			default:
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks target remove ok, id=%v, token=%vB", id, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/hooks/example"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			q := r.URL.Query()
+
+			var fail bool
+			if value := q.Get("fail"); value == "true" || value == "1" {
+				fail = true
+			}
+
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return errors.Wrapf(err, "read body")
+			}
+
+			// Verify the signature if secret is specified, for example, ?secret=xxx
+			if secret := q.Get("secret"); secret != "" {
+				timestamp, signature := r.Header.Get(CallbackHeaderTimestamp), r.Header.Get(CallbackHeaderSignature)
+				if err := CallbackVerifySignature(secret, timestamp, signature, b, CallbackSignatureTolerance); err != nil {
+					return errors.Wrapf(err, "verify signature")
+				}
+			}
+
+			var action, opaque string
+			if len(b) > 0 {
+				if err := json.Unmarshal(b, &struct {
+					Action *string `json:"action"`
+					Opaque *string `json:"opaque"`
+				}{
+					Action: &action,
+					Opaque: &opaque,
+				}); err != nil {
+					return errors.Wrapf(err, "json unmarshal %v", string(b))
+				}
+			}
+
+			if fail {
+				return errors.Errorf("fail as required, action=%v, opaque=%v", action, opaque)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks example ok, action=%v, opaque=%v", action, opaque)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *CallbackWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *CallbackWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "callback start a worker")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			var config CallbackConfig
+			if err := config.Load(ctx); err != nil {
+				logger.Wf(ctx, "load config %v err %+v", config, err)
+
+				select {
+				case <-ctx.Done():
+				case <-time.After(10 * time.Second):
+				}
+				continue
+			}
+
+			func() {
+				v.lock.Lock()
+				defer v.lock.Unlock()
+				v.ephemeralConfig = config
+			}()
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 3):
+			case <-v.updateConfig:
+			}
+		}
+	}()
+
+	// Deliver the callbacks in outbox, with retry.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			next, err := v.consumeOutbox(ctx)
+			if err != nil && ctx.Err() == nil {
+				logger.Wf(ctx, "ignore outbox err %+v", err)
+			}
+
+			// Wait for the next retry, or the new delivery which notifies by deliverNow. Note that we still
+			// check the outbox periodically, because the error might interrupt the delivery.
+			wait := CallbackOutboxInterval
+			if !next.IsZero() && time.Until(next) < wait {
+				wait = time.Until(next)
+			}
+			if err != nil || wait < time.Second {
+				wait = time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			case <-v.deliverNow:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (v *CallbackWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) error {
+	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
+		return nil
+	}
+
+	var config CallbackConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.ephemeralConfig
+	}()
+
+	targets := config.Subscribers(action, streamObj.App, streamObj.Stream)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	req := &struct {
+		RequestID string `json:"request_id"`
+		// The callback parameters.
+		Action string `json:"action"`
+		Opaque string `json:"opaque"`
+		Vhost  string `json:"vhost,omitempty"`
+		App    string `json:"app,omitempty"`
+		Stream string `json:"stream,omitempty"`
+		Param  string `json:"param,omitempty"`
+	}{
+		RequestID: uuid.NewString(),
+		// The callback parameters.
+		Action: string(action),
+		Opaque: config.Opaque,
+		Vhost:  streamObj.Vhost,
+		App:    streamObj.App,
+		Stream: streamObj.Stream,
+	}
+	if action == SrsActionOnPublish {
+		req.Param = streamObj.Param
+	}
+
+	if err := v.deliver(ctx, &config, targets, action, req); err != nil {
+		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	}
+	return nil
 }
 
 func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, artifact *M3u8VoDArtifact) error {
@@ -389,7 +761,8 @@ func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction,
 		config = v.ephemeralConfig
 	}()
 
-	if !config.All || config.Target == "" {
+	targets := config.Subscribers(action, message.App, message.Stream)
+	if len(targets) == 0 {
 		return nil
 	}
 
@@ -405,6 +778,12 @@ func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction,
 		ArtifactCode *int   `json:"artifact_code,omitempty"`
 		ArtifactPath string `json:"artifact_path,omitempty"`
 		ArtifactURL  string `json:"artifact_url,omitempty"`
+		// The HLS VoD address of artifact, the ArtifactURL is the MP4 address.
+		ArtifactM3u8URL string `json:"artifact_m3u8_url,omitempty"`
+		// The duration in seconds, the number of ts files and the total size in bytes.
+		Duration float64 `json:"duration,omitempty"`
+		NN       int     `json:"nn,omitempty"`
+		Size     uint64  `json:"size,omitempty"`
 	}{
 		RequestID: uuid.NewString(),
 		// The callback parameters.
@@ -424,104 +803,137 @@ func (v *CallbackWorker) OnRecordMessage(ctx context.Context, action SrsAction,
 		req.ArtifactCode = &code
 		req.ArtifactPath = fmt.Sprintf("%v/record/%v/index.mp4", serverDataDirectory, artifact.UUID)
 		req.ArtifactURL = fmt.Sprintf("%v/terraform/v1/hooks/record/hls/%v/index.mp4", config.Host, artifact.UUID)
-	}
+		req.ArtifactM3u8URL = fmt.Sprintf("%v/terraform/v1/hooks/record/hls/%v/index.m3u8", config.Host, artifact.UUID)
 
-	pfn4 := func(b, b2 []byte, code int) error {
-		if code != 0 {
-			return errors.Errorf("response code %v", code)
+		for _, file := range artifact.Files {
+			req.Duration += file.Duration
+			req.Size += file.Size
 		}
-
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
-		return nil
+		req.NN = len(artifact.Files)
 	}
 
-	pfn3 := func(b, b2 []byte) error {
-		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
-		}
-
-		var code int
-		if err := json.Unmarshal(b2, &struct {
-			Code *int `json:"code"`
-		}{
-			Code: &code,
-		}); err != nil {
-			return errors.Wrapf(err, "unmarshal response")
-		}
-		return pfn4(b, b2, code)
+	if err := v.deliver(ctx, &config, targets, action, req); err != nil {
+		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
 	}
+	return nil
+}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
-		}
+func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, prompt, result string) error {
+	if action != SrsActionOnOcr {
+		return nil
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	var config CallbackConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.ephemeralConfig
+	}()
 
-		var res *http.Response
-		if strings.HasPrefix(config.Target, "https://") {
-			client := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
-			}
-			res, err = client.Do(req)
-		} else {
-			res, err = http.DefaultClient.Do(req)
-		}
-		if err != nil {
-			return errors.Wrapf(err, "http post")
-		}
-		defer res.Body.Close()
+	targets := config.Subscribers(action, message.App, message.Stream)
+	if len(targets) == 0 {
+		return nil
+	}
 
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
-		}
+	req := &struct {
+		RequestID string `json:"request_id"`
+		// The callback parameters.
+		Action string `json:"action"`
+		Opaque string `json:"opaque"`
+		Vhost  string `json:"vhost,omitempty"`
+		App    string `json:"app,omitempty"`
+		Stream string `json:"stream,omitempty"`
+		// The OCR task UUID.
+		UUID string `json:"uuid,omitempty"`
+		// The OCR prompt.
+		Prompt string `json:"prompt,omitempty"`
+		// The OCR result.
+		Result string `json:"result,omitempty"`
+	}{
+		RequestID: uuid.NewString(),
+		// The callback parameters.
+		Action: string(action),
+		Opaque: config.Opaque,
+		Vhost:  message.Vhost,
+		App:    message.App,
+		Stream: message.Stream,
+		// The OCR task UUID.
+		UUID: taskUUID,
+		// The OCR prompt.
+		Prompt: prompt,
+		// The OCR result.
+		Result: result,
+	}
 
-		b2, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "read body")
-		}
+	if err := v.deliver(ctx, &config, targets, action, req); err != nil {
+		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	}
+	return nil
+}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
-		}
+func (v *CallbackWorker) OnMotion(ctx context.Context, action SrsAction, event *CameraMotionEvent) error {
+	if action != SrsActionOnMotion {
+		return nil
+	}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
-		}
+	var config CallbackConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.ephemeralConfig
+	}()
 
+	targets := config.Subscribers(action, event.App, event.Stream)
+	if len(targets) == 0 {
 		return nil
 	}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
-		}
-
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
-		}
-
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
-		}
-
-		return nil
+	req := &struct {
+		RequestID string `json:"request_id"`
+		// The callback parameters.
+		Action string `json:"action"`
+		Opaque string `json:"opaque"`
+		App    string `json:"app,omitempty"`
+		Stream string `json:"stream,omitempty"`
+		// The motion event UUID.
+		UUID string `json:"uuid"`
+		// The platform of IP camera.
+		Platform string `json:"platform"`
+		// The scene change score.
+		Score float64 `json:"score"`
+		// The event time, in RFC3339.
+		Time string `json:"time"`
+		// The snapshot JPEG address, which requires token.
+		SnapshotURL string `json:"snapshot_url,omitempty"`
+		// Whether triggered the record.
+		Record bool `json:"record,omitempty"`
+	}{
+		RequestID: uuid.NewString(),
+		// The callback parameters.
+		Action: string(action),
+		Opaque: config.Opaque,
+		App:    event.App,
+		Stream: event.Stream,
+		// The motion event.
+		UUID:     event.UUID,
+		Platform: event.Platform,
+		Score:    event.Score,
+		Time:     event.Time,
+		Record:   event.Record,
+	}
+
+	if event.Snapshot != "" {
+		req.SnapshotURL = fmt.Sprintf("%v/terraform/v1/ffmpeg/camera/motion/snapshot/%v", config.Host, event.Snapshot)
 	}
 
-	if err := pfn(); err != nil {
+	if err := v.deliver(ctx, &config, targets, action, req); err != nil {
 		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
 	}
 	return nil
 }
 
-func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID string, message *SrsOnHlsMessage, prompt, result string) error {
-	if action != SrsActionOnOcr {
+func (v *CallbackWorker) OnCameraHealth(ctx context.Context, action SrsAction, platform, app, stream string, health *CameraHealth) error {
+	if action != SrsActionOnCameraOffline && action != SrsActionOnCameraOnline {
 		return nil
 	}
 
@@ -532,7 +944,8 @@ func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID s
 		config = v.ephemeralConfig
 	}()
 
-	if !config.All || config.Target == "" {
+	targets := config.Subscribers(action, app, stream)
+	if len(targets) == 0 {
 		return nil
 	}
 
@@ -541,43 +954,102 @@ func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID s
 		// The callback parameters.
 		Action string `json:"action"`
 		Opaque string `json:"opaque"`
-		Vhost  string `json:"vhost,omitempty"`
 		App    string `json:"app,omitempty"`
 		Stream string `json:"stream,omitempty"`
-		// The OCR task UUID.
-		UUID string `json:"uuid,omitempty"`
-		// The OCR prompt.
-		Prompt string `json:"prompt,omitempty"`
-		// The OCR result.
-		Result string `json:"result,omitempty"`
+		// The platform of IP camera.
+		Platform string `json:"platform"`
+		// The health state, and the time when it started.
+		State string `json:"state"`
+		Since string `json:"since"`
+		// The number of consecutive failures, and the last error.
+		Failures int    `json:"failures,omitempty"`
+		Error    string `json:"error,omitempty"`
 	}{
 		RequestID: uuid.NewString(),
 		// The callback parameters.
 		Action: string(action),
 		Opaque: config.Opaque,
-		Vhost:  message.Vhost,
-		App:    message.App,
-		Stream: message.Stream,
-		// The OCR task UUID.
-		UUID: taskUUID,
-		// The OCR prompt.
-		Prompt: prompt,
-		// The OCR result.
-		Result: result,
+		App:    app,
+		Stream: stream,
+		// The IP camera health.
+		Platform: platform,
+		State:    health.State,
+		Since:    health.Since,
+		Failures: health.Failures,
+		Error:    health.Error,
+	}
+
+	if err := v.deliver(ctx, &config, targets, action, req); err != nil {
+		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	}
+	return nil
+}
+
+// deliver marshals the callback request, and posts it to each target synchronously, or saves it to
+// the outbox if async delivery is enabled, which will be delivered by the outbox worker with retry.
+func (v *CallbackWorker) deliver(ctx context.Context, config *CallbackConfig, targets []*CallbackTarget, action SrsAction, req interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "marshal req")
+	}
+
+	if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
+	}
+
+	// The on_publish is a gating event, which allows or rejects the publisher, so it's always delivered
+	// synchronously, even if async delivery is enabled.
+	if config.Async && action != SrsActionOnPublish {
+		for _, target := range targets {
+			delivery := NewCallbackDelivery(func(delivery *CallbackDelivery) {
+				delivery.Action = action
+				delivery.TargetID = target.ID
+				delivery.Target = target.Target
+				delivery.Body = string(b)
+			})
+			if err := delivery.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save delivery %v", delivery.String())
+			}
+			logger.Tf(ctx, "callback queued, %v", delivery.String())
+		}
+
+		// Notify the outbox worker to deliver it immediately.
+		select {
+		case v.deliverNow <- true:
+		default:
+		}
+		return nil
+	}
+
+	// Post to all targets, and return the first error. For on_publish, the publisher is rejected if any
+	// target fails.
+	var r0 error
+	for _, target := range targets {
+		if err := v.post(ctx, target, b); err != nil && r0 == nil {
+			r0 = errors.Wrapf(err, "post to %v with %s", target.String(), string(b))
+		}
 	}
 
-	pfn4 := func(b, b2 []byte, code int) error {
+	return r0
+}
+
+// post the callback request body b to the target, and check the response. The request is signed by the
+// secret of target, and the TLS is verified if required.
+func (v *CallbackWorker) post(ctx context.Context, callbackTarget *CallbackTarget, b []byte) error {
+	target := callbackTarget.Target
+
+	pfn3 := func(b2 []byte, code int) error {
 		if code != 0 {
 			return errors.Errorf("response code %v", code)
 		}
 
-		logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
+		logger.Tf(ctx, "callback ok, post %v with %s, response %v", target, string(b), string(b2))
 		return nil
 	}
 
-	pfn3 := func(b, b2 []byte) error {
+	pfn2 := func(b2 []byte) error {
 		if code, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
-			return pfn4(b, b2, int(code))
+			return pfn3(b2, int(code))
 		}
 
 		var code int
@@ -588,78 +1060,366 @@ func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID s
 		}); err != nil {
 			return errors.Wrapf(err, "unmarshal response")
 		}
-		return pfn4(b, b2, code)
+		return pfn3(b2, code)
 	}
 
-	pfn2 := func(b []byte) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
-		if err != nil {
-			return errors.Wrapf(err, "new request")
-		}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "new request")
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/json")
 
-		var res *http.Response
-		if strings.HasPrefix(config.Target, "https://") {
-			client := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true,
-					},
-				},
-			}
-			res, err = client.Do(req)
-		} else {
-			res, err = http.DefaultClient.Do(req)
-		}
-		if err != nil {
-			return errors.Wrapf(err, "http post")
-		}
-		defer res.Body.Close()
+	// Sign the body with timestamp, so the target is able to reject the forged or replayed request.
+	if callbackTarget.Secret != "" {
+		timestamp := fmt.Sprintf("%v", time.Now().Unix())
+		req.Header.Set(CallbackHeaderTimestamp, timestamp)
+		req.Header.Set(CallbackHeaderSignature, CallbackSignature(callbackTarget.Secret, timestamp, b))
+	}
+
+	client, err := callbackTarget.HTTPClient()
+	if err != nil {
+		return errors.Wrapf(err, "http client")
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "http post")
+	}
+	defer res.Body.Close()
 
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("response status %v", res.StatusCode)
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("response status %v", res.StatusCode)
+	}
+
+	b2, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+
+	if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
+	}
+
+	if err := pfn2(b2); err != nil {
+		return errors.Wrapf(err, "res body %v", string(b2))
+	}
+
+	return nil
+}
+
+// consumeOutbox delivers all pending deliveries which are ready to retry, and returns the time of next
+// retry, zero if no pending delivery. Note that the deliveries are posted without the outbox lock, so the
+// replay API is not blocked by a slow target.
+func (v *CallbackWorker) consumeOutbox(ctx context.Context) (time.Time, error) {
+	var config CallbackConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.ephemeralConfig
+	}()
+
+	// The time of next retry, the earliest one of pending deliveries.
+	var next time.Time
+	retryAt := func(delivery *CallbackDelivery) {
+		if t, err := time.Parse(time.RFC3339, delivery.Next); err == nil && (next.IsZero() || t.Before(next)) {
+			next = t
 		}
+	}
+
+	// Copy the ready deliveries under the lock, and drop the oldest dead deliveries, to limit the size of
+	// dead-letter list.
+	var readies []*CallbackDelivery
+	if err := func() error {
+		v.outboxLock.Lock()
+		defer v.outboxLock.Unlock()
 
-		b2, err := ioutil.ReadAll(res.Body)
+		deliveries, err := LoadCallbackDeliveries(ctx)
 		if err != nil {
-			return errors.Wrapf(err, "read body")
+			return errors.Wrapf(err, "load deliveries")
 		}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
+		var deads []*CallbackDelivery
+		for _, delivery := range deliveries {
+			if delivery.Status == CallbackDeliveryStatusDead {
+				deads = append(deads, delivery)
+			} else if delivery.Ready() {
+				readies = append(readies, delivery)
+			} else {
+				retryAt(delivery)
+			}
 		}
 
-		if err := pfn3(b, b2); err != nil {
-			return errors.Wrapf(err, "res body %v", string(b2))
+		if len(deads) > CallbackDeliveryMaxDead {
+			sort.Slice(deads, func(i, j int) bool {
+				return deads[i].Update < deads[j].Update
+			})
+			for _, delivery := range deads[:len(deads)-CallbackDeliveryMaxDead] {
+				if err := delivery.Remove(ctx); err != nil {
+					return errors.Wrapf(err, "remove delivery %v", delivery.String())
+				}
+			}
 		}
-
 		return nil
+	}(); err != nil {
+		return next, err
 	}
 
-	pfn := func() error {
-		b, err := json.Marshal(req)
-		if err != nil {
-			return errors.Wrapf(err, "marshal req")
+	for _, delivery := range readies {
+		if ctx.Err() != nil {
+			return next, ctx.Err()
+		}
+
+		// Never block the outbox by a stuck target.
+		err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+
+			// Note that we use the target URL of delivery, because the config might be changed.
+			target := config.FindTarget(delivery.TargetID)
+			if target == nil {
+				return errors.Errorf("no target %v", delivery.TargetID)
+			}
+
+			deliveryTarget := *target
+			deliveryTarget.Target = delivery.Target
+			return v.post(ctx, &deliveryTarget, []byte(delivery.Body))
+		}()
+
+		// Server is quiting, do not count it as a failed attempt.
+		if err != nil && ctx.Err() != nil {
+			return next, ctx.Err()
 		}
 
-		if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
+		// Update the delivery under the lock, after posted.
+		if r0 := func() error {
+			v.outboxLock.Lock()
+			defer v.outboxLock.Unlock()
+
+			if err == nil {
+				if err := delivery.Remove(ctx); err != nil {
+					return errors.Wrapf(err, "remove delivery %v", delivery.String())
+				}
+				logger.Tf(ctx, "callback delivered, %v", delivery.String())
+				return nil
+			}
+
+			delivery.Failed(err, config.MaxAttempts)
+			if err := delivery.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save delivery %v", delivery.String())
+			}
+			logger.Wf(ctx, "callback delivery failed, %v, err %+v", delivery.String(), err)
+			return nil
+		}(); r0 != nil {
+			return next, r0
 		}
 
-		if err := pfn2(b); err != nil {
-			return errors.Wrapf(err, "post with %s", string(b))
+		if err != nil && delivery.Status == CallbackDeliveryStatusPending {
+			retryAt(delivery)
 		}
+	}
 
-		return nil
+	return next, nil
+}
+
+// The HTTP headers for callback signature.
+const (
+	// The unix timestamp in seconds when signing the request.
+	CallbackHeaderTimestamp = "X-Oryx-Timestamp"
+	// The signature of request, format is sha256={hex}, see CallbackSignature.
+	CallbackHeaderSignature = "X-Oryx-Signature"
+)
+
+// The max time difference between the signed timestamp and now, to reject the replayed request.
+const CallbackSignatureTolerance = 5 * time.Minute
+
+// CallbackSignature returns the signature of callback body, which is the HMAC-SHA256 of the string
+// "{timestamp}.{body}" by secret, in format sha256={hex}.
+func CallbackSignature(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return fmt.Sprintf("sha256=%v", hex.EncodeToString(h.Sum(nil)))
+}
+
+// CallbackVerifySignature verifies the signature and timestamp of callback body by secret. The timestamp
+// should be in the tolerance of now, to reject the replayed request.
+func CallbackVerifySignature(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	if timestamp == "" || signature == "" {
+		return errors.Errorf("no timestamp %v or signature %v", timestamp, signature)
 	}
 
-	if err := pfn(); err != nil {
-		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "parse timestamp %v", timestamp)
+	}
+
+	if diff := time.Since(time.Unix(ts, 0)); diff > tolerance || diff < -tolerance {
+		return errors.Errorf("timestamp %v exceed tolerance %v", timestamp, tolerance)
+	}
+
+	expect := CallbackSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expect), []byte(signature)) {
+		return errors.Errorf("invalid signature %v", signature)
+	}
+
+	return nil
+}
+
+// The status of callback delivery.
+type CallbackDeliveryStatus string
+
+const (
+	// The delivery is pending, waiting to be delivered or retried.
+	CallbackDeliveryStatusPending CallbackDeliveryStatus = "pending"
+	// The delivery is failed for max attempts, it's in the dead-letter list, and can be replayed.
+	CallbackDeliveryStatusDead CallbackDeliveryStatus = "dead"
+)
+
+// The default max attempts to deliver a callback, before move it to the dead-letter list.
+const CallbackDeliveryMaxAttempts = 10
+
+// The max number of dead deliveries to keep.
+const CallbackDeliveryMaxDead = 1000
+
+// The max interval to check the outbox, when there is no pending delivery to retry.
+const CallbackOutboxInterval = 30 * time.Second
+
+// CallbackDelivery is a callback request in outbox, which is delivered asynchronously with retry.
+type CallbackDelivery struct {
+	// The delivery ID, a UUID string.
+	ID string `json:"id"`
+	// The callback action, for example, on_unpublish.
+	Action SrsAction `json:"action"`
+	// The callback target ID, see CallbackTarget.
+	TargetID string `json:"targetId"`
+	// The callback target URL.
+	Target string `json:"target"`
+	// The callback request body, in JSON.
+	Body string `json:"body"`
+	// The status of delivery.
+	Status CallbackDeliveryStatus `json:"status"`
+	// The number of delivery attempts.
+	Attempts int `json:"attempts"`
+	// The last error of delivery.
+	Error string `json:"error,omitempty"`
+	// The create time.
+	Create string `json:"create"`
+	// The last update time.
+	Update string `json:"update"`
+	// The time to retry next, only for pending delivery.
+	Next string `json:"next,omitempty"`
+}
+
+func NewCallbackDelivery(opts ...func(delivery *CallbackDelivery)) *CallbackDelivery {
+	v := &CallbackDelivery{
+		ID:     uuid.NewString(),
+		Status: CallbackDeliveryStatusPending,
+		Create: time.Now().Format(time.RFC3339),
+	}
+	v.Update, v.Next = v.Create, v.Create
+
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *CallbackDelivery) String() string {
+	return fmt.Sprintf("id=%v, action=%v, target=%v, status=%v, attempts=%v, next=%v, error=%v",
+		v.ID, v.Action, v.Target, v.Status, v.Attempts, v.Next, v.Error,
+	)
+}
+
+// Ready whether the pending delivery should be delivered now.
+func (v *CallbackDelivery) Ready() bool {
+	if v.Status != CallbackDeliveryStatusPending {
+		return false
+	}
+
+	next, err := time.Parse(time.RFC3339, v.Next)
+	return err != nil || !next.After(time.Now())
+}
+
+// Failed updates the delivery for the failed attempt, schedule the next retry by exponential backoff,
+// or move it to the dead-letter list if exceed the maxAttempts.
+func (v *CallbackDelivery) Failed(err error, maxAttempts int) {
+	if maxAttempts <= 0 {
+		maxAttempts = CallbackDeliveryMaxAttempts
+	}
+
+	v.Attempts++
+	v.Error = err.Error()
+	v.Update = time.Now().Format(time.RFC3339)
+
+	if v.Attempts >= maxAttempts {
+		v.Status, v.Next = CallbackDeliveryStatusDead, ""
+		return
+	}
+
+	v.Next = time.Now().Add(CallbackDeliveryBackoff(v.Attempts)).Format(time.RFC3339)
+}
+
+// Replay resets the dead delivery to pending, to deliver it again.
+func (v *CallbackDelivery) Replay() {
+	v.Status, v.Attempts, v.Error = CallbackDeliveryStatusPending, 0, ""
+	v.Update = time.Now().Format(time.RFC3339)
+	v.Next = v.Update
+}
+
+func (v *CallbackDelivery) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_HOOKS_DELIVERY, v.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_HOOKS_DELIVERY, v.ID, string(b))
+	}
+	return nil
+}
+
+func (v *CallbackDelivery) Remove(ctx context.Context) error {
+	if err := rdb.HDel(ctx, SRS_HOOKS_DELIVERY, v.ID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_HOOKS_DELIVERY, v.ID)
 	}
 	return nil
 }
 
+// LoadCallbackDeliveries loads all deliveries in outbox, sorted by create time.
+func LoadCallbackDeliveries(ctx context.Context) ([]*CallbackDelivery, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_HOOKS_DELIVERY).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_HOOKS_DELIVERY)
+	}
+
+	deliveries := []*CallbackDelivery{}
+	for id, obj := range objs {
+		var delivery CallbackDelivery
+		if err := json.Unmarshal([]byte(obj), &delivery); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, obj)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Create < deliveries[j].Create
+	})
+	return deliveries, nil
+}
+
+// CallbackDeliveryBackoff returns the exponential backoff duration to retry after the attempts, which
+// starts from 2s and doubles for each attempt, and no more than 5m.
+func CallbackDeliveryBackoff(attempts int) time.Duration {
+	backoff, maxBackoff := 2*time.Second, 5*time.Minute
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
 type CallbackConfig struct {
 	// The callback target.
 	Target string `json:"target"`
@@ -669,11 +1429,213 @@ type CallbackConfig struct {
 	All bool `json:"all"`
 	// The full host to generate the full URl for callback.
 	Host string `json:"host"`
+	// Whether deliver the non-gating events asynchronously, by outbox with retry. Note that on_publish is
+	// always delivered synchronously, because it allows or rejects the publisher.
+	Async bool `json:"async"`
+	// The max attempts for async delivery, before move to the dead-letter list. Use default if 0.
+	MaxAttempts int `json:"maxAttempts"`
+	// The secret to sign the callback request by HMAC-SHA256, no signature if empty.
+	Secret string `json:"secret"`
+	// Whether verify the TLS certificate of HTTPS target. For compatibility, it's not verified by default,
+	// which allows the self-signed certificate.
+	Verify bool `json:"verify"`
+	// The PEM of custom CA to verify the HTTPS target, use system roots if empty.
+	CA string `json:"ca"`
+	// The extra callback targets, each subscribes some actions of some streams.
+	Targets []*CallbackTarget `json:"targets,omitempty"`
 }
 
 func (v CallbackConfig) String() string {
-	return fmt.Sprintf("target=%v, opaque=%v, all=%v, host=%v",
-		v.Target, v.Opaque, v.All, v.Host)
+	return fmt.Sprintf("target=%v, opaque=%v, all=%v, host=%v, async=%v, maxAttempts=%v, secret=%vB, verify=%v, ca=%vB, targets=%v",
+		v.Target, v.Opaque, v.All, v.Host, v.Async, v.MaxAttempts, len(v.Secret), v.Verify, len(v.CA), len(v.Targets))
+}
+
+// Validate the config, such as the max attempts and the custom CA.
+func (v *CallbackConfig) Validate() error {
+	if v.MaxAttempts < 0 {
+		return errors.Errorf("invalid maxAttempts %v", v.MaxAttempts)
+	}
+
+	if v.CA != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(v.CA)) {
+		return errors.Errorf("invalid ca %vB", len(v.CA))
+	}
+
+	return nil
+}
+
+// The ID of default target, which is the target of CallbackConfig.
+const CallbackDefaultTargetID = "default"
+
+// DefaultTarget returns the target of config, which subscribes all actions of all streams if enabled.
+func (v *CallbackConfig) DefaultTarget() *CallbackTarget {
+	return &CallbackTarget{
+		ID:      CallbackDefaultTargetID,
+		Target:  v.Target,
+		Enabled: v.All && v.Target != "",
+		Secret:  v.Secret,
+		Verify:  v.Verify,
+		CA:      v.CA,
+	}
+}
+
+// FindTarget returns the target by id, or nil if not found. Use the default target if id is empty, for
+// deliveries created by previous versions.
+func (v *CallbackConfig) FindTarget(id string) *CallbackTarget {
+	if id == "" || id == CallbackDefaultTargetID {
+		return v.DefaultTarget()
+	}
+
+	for _, target := range v.Targets {
+		if target.ID == id {
+			return target
+		}
+	}
+	return nil
+}
+
+// Subscribers returns the enabled targets which subscribe the action of stream.
+func (v *CallbackConfig) Subscribers(action SrsAction, app, stream string) []*CallbackTarget {
+	var targets []*CallbackTarget
+	if target := v.DefaultTarget(); target.Enabled {
+		targets = append(targets, target)
+	}
+
+	for _, target := range v.Targets {
+		if target.Enabled && target.Match(action, app, stream) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// CallbackTarget is a callback endpoint, which subscribes a set of actions, for some streams.
+type CallbackTarget struct {
+	// The target ID, a UUID string.
+	ID string `json:"id"`
+	// The name of target, for example, CMS.
+	Name string `json:"name"`
+	// The callback target URL.
+	Target string `json:"target"`
+	// Whether the target is enabled.
+	Enabled bool `json:"enabled"`
+	// The subscribed actions, support glob like on_record_*. Subscribe all actions if empty.
+	Actions []string `json:"actions"`
+	// The glob filters to match the stream URL /app/stream, like /live/*. Match all streams if empty.
+	Streams []string `json:"streams"`
+	// The secret to sign the callback request by HMAC-SHA256, no signature if empty.
+	Secret string `json:"secret"`
+	// Whether verify the TLS certificate of HTTPS target.
+	Verify bool `json:"verify"`
+	// The PEM of custom CA to verify the HTTPS target, use system roots if empty.
+	CA string `json:"ca"`
+	// The last update time.
+	Update string `json:"update"`
+}
+
+func (v *CallbackTarget) String() string {
+	return fmt.Sprintf("id=%v, name=%v, target=%v, enabled=%v, actions=%v, streams=%v, secret=%vB, verify=%v, ca=%vB",
+		v.ID, v.Name, v.Target, v.Enabled, v.Actions, v.Streams, len(v.Secret), v.Verify, len(v.CA),
+	)
+}
+
+// Redacted returns a copy of target with the secret redacted, to response to the client.
+func (v *CallbackTarget) Redacted() *CallbackTarget {
+	target := *v
+	target.Secret = callbackRedactSecret(v.Secret)
+	return &target
+}
+
+// callbackRedactSecret keeps only the last 4 characters of secret, for example, ***abcd, or hides all if the
+// secret is too short.
+func callbackRedactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return "***"
+	}
+	return "***" + secret[len(secret)-4:]
+}
+
+// Validate whether the target is valid, for example, the URL, globs and CA.
+func (v *CallbackTarget) Validate() error {
+	if !strings.HasPrefix(v.Target, "http://") && !strings.HasPrefix(v.Target, "https://") {
+		return errors.Errorf("invalid target %v", v.Target)
+	}
+
+	for _, glob := range append(append([]string{}, v.Actions...), v.Streams...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return errors.Wrapf(err, "invalid glob %v", glob)
+		}
+	}
+
+	if v.CA != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(v.CA)) {
+		return errors.Errorf("invalid ca %vB", len(v.CA))
+	}
+
+	return nil
+}
+
+// Match whether the target subscribes the action of stream.
+func (v *CallbackTarget) Match(action SrsAction, app, stream string) bool {
+	matchAny := func(globs []string, value string) bool {
+		if len(globs) == 0 {
+			return true
+		}
+
+		for _, glob := range globs {
+			if ok, err := path.Match(glob, value); err == nil && ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return matchAny(v.Actions, string(action)) && matchAny(v.Streams, fmt.Sprintf("/%v/%v", app, stream))
+}
+
+// The HTTP clients for HTTPS targets, key is the TLS options in string, value is *http.Client. The client and
+// its transport are reused by the targets with the same options, to keep alive the connections.
+var callbackHTTPClients sync.Map
+
+// HTTPClient returns the HTTP client for target, verify the TLS certificate by system roots or custom CA
+// if required.
+func (v *CallbackTarget) HTTPClient() (*http.Client, error) {
+	if !strings.HasPrefix(v.Target, "https://") {
+		return http.DefaultClient, nil
+	}
+
+	key := fmt.Sprintf("verify=%v, ca=%v", v.Verify, v.CA)
+	if client, ok := callbackHTTPClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !v.Verify,
+	}
+	if v.Verify && v.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(v.CA)) {
+			return nil, errors.Errorf("invalid ca %vB", len(v.CA))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client, _ := callbackHTTPClients.LoadOrStore(key, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
+
+// Save the target to redis.
+func (v *CallbackTarget) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_HOOKS_TARGETS, v.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_HOOKS_TARGETS, v.ID, string(b))
+	}
+	return nil
 }
 
 func (v *CallbackConfig) Load(ctx context.Context) (err error) {
@@ -695,5 +1657,63 @@ func (v *CallbackConfig) Load(ctx context.Context) (err error) {
 		return errors.Wrapf(err, "hget %v host", SRS_HOOKS)
 	}
 
+	if async, err := rdb.HGet(ctx, SRS_HOOKS, "async").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v async", SRS_HOOKS)
+	} else if async == "true" {
+		v.Async = true
+	}
+
+	if v.Secret, err = rdb.HGet(ctx, SRS_HOOKS, "secret").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v secret", SRS_HOOKS)
+	}
+
+	if verify, err := rdb.HGet(ctx, SRS_HOOKS, "verify").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v verify", SRS_HOOKS)
+	} else if verify == "true" {
+		v.Verify = true
+	}
+
+	if v.CA, err = rdb.HGet(ctx, SRS_HOOKS, "ca").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v ca", SRS_HOOKS)
+	}
+
+	if targets, err := LoadCallbackTargets(ctx); err != nil {
+		return errors.Wrapf(err, "load targets")
+	} else {
+		v.Targets = targets
+	}
+
+	if maxAttempts, err := rdb.HGet(ctx, SRS_HOOKS, "maxAttempts").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v maxAttempts", SRS_HOOKS)
+	} else if maxAttempts != "" {
+		if iv, err := strconv.ParseInt(maxAttempts, 10, 64); err != nil {
+			return errors.Wrapf(err, "parse maxAttempts %v", maxAttempts)
+		} else {
+			v.MaxAttempts = int(iv)
+		}
+	}
+
 	return nil
 }
+
+// LoadCallbackTargets loads all extra callback targets, sorted by name.
+func LoadCallbackTargets(ctx context.Context) ([]*CallbackTarget, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_HOOKS_TARGETS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_HOOKS_TARGETS)
+	}
+
+	targets := []*CallbackTarget{}
+	for id, obj := range objs {
+		var target CallbackTarget
+		if err := json.Unmarshal([]byte(obj), &target); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, obj)
+		}
+		targets = append(targets, &target)
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+	return targets, nil
+}
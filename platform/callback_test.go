@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+func TestCallback_DeliveryBackoff(t *testing.T) {
+	for _, e := range []struct {
+		attempts int
+		backoff  time.Duration
+	}{
+		{attempts: 0, backoff: 2 * time.Second},
+		{attempts: 1, backoff: 2 * time.Second},
+		{attempts: 2, backoff: 4 * time.Second},
+		{attempts: 3, backoff: 8 * time.Second},
+		{attempts: 8, backoff: 256 * time.Second},
+		{attempts: 9, backoff: 5 * time.Minute},
+		{attempts: 100, backoff: 5 * time.Minute},
+	} {
+		if backoff := CallbackDeliveryBackoff(e.attempts); backoff != e.backoff {
+			t.Errorf("Fail for attempts %v, expect %v, actual %v", e.attempts, e.backoff, backoff)
+		}
+	}
+}
+
+func TestCallback_DeliveryFailedToDead(t *testing.T) {
+	delivery := NewCallbackDelivery()
+	if !delivery.Ready() {
+		t.Errorf("Fail for fresh delivery should be ready, %v", delivery.String())
+		return
+	}
+
+	delivery.Failed(errors.New("mock error"), 2)
+	if delivery.Status != CallbackDeliveryStatusPending || delivery.Attempts != 1 || delivery.Ready() {
+		t.Errorf("Fail for delivery should retry later, %v", delivery.String())
+		return
+	}
+
+	delivery.Failed(errors.New("mock error"), 2)
+	if delivery.Status != CallbackDeliveryStatusDead || delivery.Attempts != 2 || delivery.Ready() {
+		t.Errorf("Fail for delivery should be dead, %v", delivery.String())
+		return
+	}
+
+	delivery.Replay()
+	if delivery.Status != CallbackDeliveryStatusPending || delivery.Attempts != 0 || !delivery.Ready() {
+		t.Errorf("Fail for replayed delivery should be ready, %v", delivery.String())
+	}
+}
+
+func TestCallback_SignatureVerify(t *testing.T) {
+	body := []byte(`{"action":"on_publish","stream":"livestream"}`)
+	timestamp := fmt.Sprintf("%v", time.Now().Unix())
+	signature := CallbackSignature("secret", timestamp, body)
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("Fail for invalid signature format %v", signature)
+		return
+	}
+	if err := CallbackVerifySignature("secret", timestamp, signature, body, time.Minute); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	// Should fail for wrong secret, modified body, missing or expired timestamp.
+	if err := CallbackVerifySignature("other", timestamp, signature, body, time.Minute); err == nil {
+		t.Errorf("Fail for wrong secret should fail")
+	}
+	if err := CallbackVerifySignature("secret", timestamp, signature, []byte(`{}`), time.Minute); err == nil {
+		t.Errorf("Fail for modified body should fail")
+	}
+	if err := CallbackVerifySignature("secret", "", signature, body, time.Minute); err == nil {
+		t.Errorf("Fail for no timestamp should fail")
+	}
+
+	expired := fmt.Sprintf("%v", time.Now().Add(-10*time.Minute).Unix())
+	if err := CallbackVerifySignature("secret", expired, CallbackSignature("secret", expired, body), body, time.Minute); err == nil {
+		t.Errorf("Fail for expired timestamp should fail")
+	}
+}
+
+func TestCallback_TargetMatch(t *testing.T) {
+	for _, e := range []struct {
+		actions []string
+		streams []string
+		action  SrsAction
+		app     string
+		stream  string
+		match   bool
+	}{
+		{action: SrsActionOnPublish, app: "live", stream: "livestream", match: true},
+		{actions: []string{"*"}, action: SrsActionOnOcr, app: "live", stream: "livestream", match: true},
+		{actions: []string{"on_ocr"}, action: SrsActionOnOcr, app: "live", stream: "livestream", match: true},
+		{actions: []string{"on_ocr"}, action: SrsActionOnPublish, app: "live", stream: "livestream", match: false},
+		{actions: []string{"on_record_*"}, action: SrsActionOnRecordEnd, app: "live", stream: "livestream", match: true},
+		{actions: []string{"on_publish", "on_unpublish"}, action: SrsActionOnUnpublish, app: "live", stream: "livestream", match: true},
+		{streams: []string{"/live/*"}, action: SrsActionOnPublish, app: "live", stream: "livestream", match: true},
+		{streams: []string{"/live/*"}, action: SrsActionOnPublish, app: "vod", stream: "livestream", match: false},
+		{actions: []string{"on_publish"}, streams: []string{"/vod/*"}, action: SrsActionOnPublish, app: "live", stream: "livestream", match: false},
+	} {
+		target := &CallbackTarget{Actions: e.actions, Streams: e.streams}
+		if match := target.Match(e.action, e.app, e.stream); match != e.match {
+			t.Errorf("Fail for %v of /%v/%v, target=%v, expect %v, actual %v",
+				e.action, e.app, e.stream, target.String(), e.match, match)
+		}
+	}
+}
+
+func TestCallback_TargetRedacted(t *testing.T) {
+	for _, e := range []struct {
+		secret string
+		expect string
+	}{
+		{secret: "", expect: ""},
+		{secret: "short", expect: "***"},
+		{secret: "0123456789abcdef", expect: "***cdef"},
+	} {
+		target := &CallbackTarget{ID: "xxx", Secret: e.secret}
+		if redacted := target.Redacted(); redacted.Secret != e.expect || redacted.ID != target.ID {
+			t.Errorf("Fail for secret %v, expect %v, actual %v", e.secret, e.expect, redacted.Secret)
+		}
+		if target.Secret != e.secret {
+			t.Errorf("Fail for secret %v, changed to %v", e.secret, target.Secret)
+		}
+	}
+}
+
+func TestCallback_TargetValidate(t *testing.T) {
+	for _, e := range []struct {
+		target CallbackTarget
+		valid  bool
+	}{
+		{target: CallbackTarget{Target: "http://127.0.0.1/hooks"}, valid: true},
+		{target: CallbackTarget{Target: "https://127.0.0.1/hooks", Actions: []string{"on_*"}}, valid: true},
+		{target: CallbackTarget{Target: "ftp://127.0.0.1/hooks"}, valid: false},
+		{target: CallbackTarget{Target: "http://127.0.0.1/hooks", Streams: []string{"/live/["}}, valid: false},
+		{target: CallbackTarget{Target: "https://127.0.0.1/hooks", CA: "invalid"}, valid: false},
+	} {
+		if err := e.target.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for target=%v, expect valid=%v, err=%v", e.target.String(), e.valid, err)
+		}
+	}
+}
+
+func TestCallback_ConfigValidate(t *testing.T) {
+	for _, e := range []struct {
+		config CallbackConfig
+		valid  bool
+	}{
+		{config: CallbackConfig{}, valid: true},
+		{config: CallbackConfig{Target: "http://127.0.0.1/hooks", MaxAttempts: 3}, valid: true},
+		{config: CallbackConfig{Target: "http://127.0.0.1/hooks", MaxAttempts: -1}, valid: false},
+		{config: CallbackConfig{Target: "https://127.0.0.1/hooks", CA: "invalid"}, valid: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for config=%v, expect valid=%v, err=%v", e.config.String(), e.valid, err)
+		}
+	}
+}
+
+func TestCallback_TargetHTTPClient(t *testing.T) {
+	newClient := func(target CallbackTarget) *http.Client {
+		client, err := target.HTTPClient()
+		if err != nil {
+			t.Errorf("Fail for target=%v, err=%v", target.String(), err)
+		}
+		return client
+	}
+
+	// Reuse the client for the targets with the same TLS options.
+	a := newClient(CallbackTarget{Target: "https://a.example.com/hooks"})
+	if b := newClient(CallbackTarget{Target: "https://b.example.com/hooks"}); a != b {
+		t.Errorf("Fail for client %p and %p", a, b)
+	}
+	if b := newClient(CallbackTarget{Target: "https://b.example.com/hooks", Verify: true}); a == b {
+		t.Errorf("Fail for client %p and %p", a, b)
+	}
+	if b := newClient(CallbackTarget{Target: "http://b.example.com/hooks"}); b != http.DefaultClient {
+		t.Errorf("Fail for client %p", b)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// The IP camera is streaming, and the frames are updating.
+	CameraHealthOnline = "online"
+	// The IP camera is streaming, but the frames are stalled.
+	CameraHealthDegraded = "degraded"
+	// The IP camera is not streaming, and is reconnecting.
+	CameraHealthOffline = "offline"
+
+	// The max number of state changes in history, the oldest changes are trimmed.
+	CameraHealthMaxHistory = 100
+	// The IP camera is degraded if no frame updated for this duration.
+	CameraHealthStallDuration = 10 * time.Second
+	// The default duration in seconds for IP camera offline to notify.
+	CameraHealthDefaultOfflineThreshold = 60
+)
+
+// The state change of IP camera health.
+type CameraHealthChange struct {
+	// The new state.
+	State string `json:"state"`
+	// The change time, in RFC3339.
+	Time string `json:"time"`
+	// The reason of change, for example, the error of FFmpeg.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CameraHealth is the health state of IP camera, with the uptime and downtime history.
+type CameraHealth struct {
+	// The current state, online, degraded or offline.
+	State string `json:"state"`
+	// The time when current state started, in RFC3339.
+	Since string `json:"since"`
+	// The accumulated uptime and downtime in seconds, excluding the current state.
+	Uptime   float64 `json:"uptime"`
+	Downtime float64 `json:"downtime"`
+	// The number of consecutive failures, reset when online.
+	Failures int `json:"failures"`
+	// The last error and its time, in RFC3339.
+	Error     string `json:"error,omitempty"`
+	ErrorTime string `json:"errorTime,omitempty"`
+	// The next reconnect time, in RFC3339.
+	Next string `json:"next,omitempty"`
+	// Whether notified the offline, to notify the recover.
+	Notified bool `json:"notified"`
+	// Whether IP camera is disabled, which is offline without notification.
+	Disabled bool `json:"disabled,omitempty"`
+	// The history of state changes.
+	History []*CameraHealthChange `json:"history"`
+}
+
+func NewCameraHealth(now time.Time) *CameraHealth {
+	return &CameraHealth{State: CameraHealthOffline, Since: now.Format(time.RFC3339)}
+}
+
+func (v *CameraHealth) String() string {
+	return fmt.Sprintf("state=%v, since=%v, uptime=%v, downtime=%v, failures=%v, error=%v, next=%v, notified=%v, history=%v",
+		v.State, v.Since, v.Uptime, v.Downtime, v.Failures, v.Error, v.Next, v.Notified, len(v.History),
+	)
+}
+
+// accumulate the duration of current state to uptime or downtime.
+func (v *CameraHealth) accumulate(now time.Time) {
+	since, err := time.Parse(time.RFC3339, v.Since)
+	if err != nil || !now.After(since) {
+		return
+	}
+
+	if elapsed := now.Sub(since).Seconds(); v.State == CameraHealthOffline {
+		v.Downtime += elapsed
+	} else {
+		v.Uptime += elapsed
+	}
+}
+
+// transition to the state, and accumulate the duration of previous state.
+func (v *CameraHealth) transition(now time.Time, state, reason string) {
+	if v.State == state {
+		return
+	}
+	v.enter(now, state, reason)
+}
+
+// enter the state from now, even if it's the current state.
+func (v *CameraHealth) enter(now time.Time, state, reason string) {
+	v.accumulate(now)
+	v.State, v.Since = state, now.Format(time.RFC3339)
+	v.History = append(v.History, &CameraHealthChange{State: state, Time: v.Since, Reason: reason})
+	if len(v.History) > CameraHealthMaxHistory {
+		v.History = v.History[len(v.History)-CameraHealthMaxHistory:]
+	}
+}
+
+// OnReady when FFmpeg is ready, returns whether recovered from a notified offline.
+func (v *CameraHealth) OnReady(now time.Time) bool {
+	recovered := v.Notified
+	v.Failures, v.Next, v.Notified, v.Disabled = 0, "", false, false
+	v.transition(now, CameraHealthOnline, "ready")
+	return recovered
+}
+
+// OnFailure when FFmpeg terminated, returns the backoff duration to reconnect.
+func (v *CameraHealth) OnFailure(now time.Time, err error) time.Duration {
+	v.Failures++
+	v.Error, v.ErrorTime = err.Error(), now.Format(time.RFC3339)
+
+	backoff := CameraReconnectBackoff(v.Failures)
+	v.Next = now.Add(backoff).Format(time.RFC3339)
+
+	v.transition(now, CameraHealthOffline, v.Error)
+	return backoff
+}
+
+// OnFrame updates the state by whether the frames are stalled, returns whether changed.
+func (v *CameraHealth) OnFrame(now time.Time, stalled bool) bool {
+	if v.State == CameraHealthOnline && stalled {
+		v.transition(now, CameraHealthDegraded, "stalled")
+		return true
+	}
+	if v.State == CameraHealthDegraded && !stalled {
+		v.transition(now, CameraHealthOnline, "resumed")
+		return true
+	}
+	return false
+}
+
+// OnDisabled when IP camera is disabled, it's offline without notification, returns whether changed.
+func (v *CameraHealth) OnDisabled(now time.Time) bool {
+	if v.Disabled {
+		return false
+	}
+
+	v.Disabled, v.Failures, v.Next, v.Notified = true, 0, "", false
+	v.transition(now, CameraHealthOffline, "disabled")
+	return true
+}
+
+// OnEnabled when IP camera is enabled again, it's offline from now until ready, returns whether changed.
+func (v *CameraHealth) OnEnabled(now time.Time) bool {
+	if !v.Disabled {
+		return false
+	}
+
+	v.Disabled = false
+	v.enter(now, CameraHealthOffline, "enabled")
+	return true
+}
+
+// Offline whether the IP camera is offline longer than threshold and not notified yet, and mark it as
+// notified if so.
+func (v *CameraHealth) Offline(now time.Time, threshold time.Duration) bool {
+	if v.State != CameraHealthOffline || v.Notified || v.Disabled {
+		return false
+	}
+
+	if since, err := time.Parse(time.RFC3339, v.Since); err != nil || now.Sub(since) < threshold {
+		return false
+	}
+
+	v.Notified = true
+	return true
+}
+
+// Query returns a copy of health, with the duration of current state accumulated.
+func (v *CameraHealth) Query(now time.Time) *CameraHealth {
+	health := *v
+	health.History = append([]*CameraHealthChange{}, v.History...)
+	health.accumulate(now)
+	return &health
+}
+
+// CameraReconnectBackoff returns the exponential backoff duration to reconnect after the failures, which
+// starts from 3s and doubles for each failure, and no more than 2m.
+func CameraReconnectBackoff(failures int) time.Duration {
+	backoff, maxBackoff := 3*time.Second, 2*time.Minute
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// loadHealth loads the health of IP camera from redis, or create a new one.
+func (v *CameraTask) loadHealth(ctx context.Context) error {
+	now := time.Now()
+	health := NewCameraHealth(now)
+	if b, err := rdb.HGet(ctx, SRS_CAMERA_HEALTH, v.Platform).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_CAMERA_HEALTH, v.Platform)
+	} else if b != "" {
+		if err := json.Unmarshal([]byte(b), health); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		// The task is reloaded, so it's offline until ready again.
+		health.transition(now, CameraHealthOffline, "reload")
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.health = health
+	return nil
+}
+
+// saveHealth saves the health of IP camera to redis.
+func (v *CameraTask) saveHealth(ctx context.Context) error {
+	var b []byte
+	var err error
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		b, err = json.Marshal(v.health)
+	}()
+	if err != nil {
+		return errors.Wrapf(err, "marshal health")
+	}
+
+	if err := rdb.HSet(ctx, SRS_CAMERA_HEALTH, v.Platform, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_CAMERA_HEALTH, v.Platform, string(b))
+	}
+	return nil
+}
+
+// queryHealth returns a copy of health, or nil if not initialized.
+func (v *CameraTask) queryHealth() *CameraHealth {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.health == nil {
+		return nil
+	}
+	return v.health.Query(time.Now())
+}
+
+// onHealthReady updates the health when FFmpeg is ready, and notify if recovered.
+func (v *CameraTask) onHealthReady(ctx context.Context) {
+	var recovered bool
+	var health CameraHealth
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		recovered = v.health.OnReady(time.Now())
+		health = *v.health
+	}()
+
+	if err := v.saveHealth(ctx); err != nil {
+		logger.Wf(ctx, "ignore save health err %+v", err)
+	}
+	logger.Tf(ctx, "Camera: Health online, platform=%v, recovered=%v, health=%v", v.Platform, recovered, health.String())
+
+	if recovered {
+		app, stream := v.config.LocalStream()
+		if err := callbackWorker.OnCameraHealth(ctx, SrsActionOnCameraOnline, v.Platform, app, stream, &health); err != nil {
+			logger.Wf(ctx, "ignore callback err %+v", err)
+		}
+	}
+}
+
+// onHealthFailure updates the health when FFmpeg terminated, returns the backoff duration to reconnect.
+func (v *CameraTask) onHealthFailure(ctx context.Context, err error) time.Duration {
+	var backoff time.Duration
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		backoff = v.health.OnFailure(time.Now(), err)
+	}()
+
+	if err := v.saveHealth(ctx); err != nil {
+		logger.Wf(ctx, "ignore save health err %+v", err)
+	}
+	return backoff
+}
+
+// checkHealth checks whether the frames are stalled, and whether offline longer than threshold to notify.
+func (v *CameraTask) checkHealth(ctx context.Context) {
+	var changed, offline bool
+	var health CameraHealth
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		if v.health == nil || v.config == nil {
+			return
+		}
+
+		now := time.Now()
+		if !v.config.Enabled {
+			changed = v.health.OnDisabled(now)
+			health = *v.health
+			return
+		}
+
+		changed = v.health.OnEnabled(now)
+
+		stalled := v.update == nil || now.Sub(*v.update) > CameraHealthStallDuration
+		changed = v.health.OnFrame(now, stalled) || changed
+
+		threshold := time.Duration(v.config.OfflineThreshold) * time.Second
+		if threshold <= 0 {
+			threshold = CameraHealthDefaultOfflineThreshold * time.Second
+		}
+		offline = v.health.Offline(now, threshold)
+
+		health = *v.health
+	}()
+
+	if changed || offline {
+		if err := v.saveHealth(ctx); err != nil {
+			logger.Wf(ctx, "ignore save health err %+v", err)
+		}
+		logger.Tf(ctx, "Camera: Health changed, platform=%v, offline=%v, health=%v", v.Platform, offline, health.String())
+	}
+
+	if offline {
+		app, stream := v.config.LocalStream()
+		if err := callbackWorker.OnCameraHealth(ctx, SrsActionOnCameraOffline, v.Platform, app, stream, &health); err != nil {
+			logger.Wf(ctx, "ignore callback err %+v", err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCameraHealth_ReconnectBackoff(t *testing.T) {
+	for _, e := range []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{failures: 0, backoff: 3 * time.Second},
+		{failures: 1, backoff: 3 * time.Second},
+		{failures: 2, backoff: 6 * time.Second},
+		{failures: 5, backoff: 48 * time.Second},
+		{failures: 6, backoff: 96 * time.Second},
+		{failures: 7, backoff: 2 * time.Minute},
+		{failures: 100, backoff: 2 * time.Minute},
+	} {
+		if backoff := CameraReconnectBackoff(e.failures); backoff != e.backoff {
+			t.Errorf("Fail for failures %v, expect %v, actual %v", e.failures, e.backoff, backoff)
+		}
+	}
+}
+
+func TestCameraHealth_Transition(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	health := NewCameraHealth(now)
+	threshold := 60 * time.Second
+
+	// Online after 10s, then stalled after 100s, and resumed after 10s.
+	if health.OnReady(now.Add(10 * time.Second)) {
+		t.Errorf("Fail for health %v, should not recover", health.String())
+	}
+	if !health.OnFrame(now.Add(110*time.Second), true) || health.State != CameraHealthDegraded {
+		t.Errorf("Fail for health %v, should degrade", health.String())
+	}
+	if !health.OnFrame(now.Add(120*time.Second), false) || health.State != CameraHealthOnline {
+		t.Errorf("Fail for health %v, should resume", health.String())
+	}
+
+	// Offline after 80s, and reconnect with backoff.
+	if backoff := health.OnFailure(now.Add(200*time.Second), errors.New("timeout")); backoff != 3*time.Second {
+		t.Errorf("Fail for backoff %v", backoff)
+	}
+	if backoff := health.OnFailure(now.Add(205*time.Second), errors.New("timeout")); backoff != 6*time.Second {
+		t.Errorf("Fail for backoff %v", backoff)
+	}
+	if health.State != CameraHealthOffline || health.Failures != 2 || health.Error != "timeout" {
+		t.Errorf("Fail for health %v", health.String())
+	}
+
+	// Notify offline only once, after threshold.
+	if health.Offline(now.Add(230*time.Second), threshold) {
+		t.Errorf("Fail for health %v, should not notify before threshold", health.String())
+	}
+	if !health.Offline(now.Add(260*time.Second), threshold) || health.Offline(now.Add(270*time.Second), threshold) {
+		t.Errorf("Fail for health %v, should notify once", health.String())
+	}
+
+	// Recover after offline 100s.
+	if !health.OnReady(now.Add(300*time.Second)) || health.Failures != 0 || health.Notified {
+		t.Errorf("Fail for health %v, should recover", health.String())
+	}
+	if health.Uptime != 190 || health.Downtime != 110 {
+		t.Errorf("Fail for health %v, uptime and downtime", health.String())
+	}
+	if q := health.Query(now.Add(400 * time.Second)); q.Uptime != 290 || q.Downtime != 110 || health.Uptime != 190 {
+		t.Errorf("Fail for query %v", q.String())
+	}
+
+	var states []string
+	for _, change := range health.History {
+		states = append(states, change.State)
+	}
+	if len(states) != 5 || states[1] != CameraHealthDegraded || states[3] != CameraHealthOffline {
+		t.Errorf("Fail for history %v", states)
+	}
+}
+
+func TestCameraHealth_Disabled(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	health := NewCameraHealth(now)
+	threshold := 60 * time.Second
+
+	// Never notify offline when disabled.
+	health.OnReady(now)
+	if !health.OnDisabled(now.Add(10*time.Second)) || health.OnDisabled(now.Add(20*time.Second)) {
+		t.Errorf("Fail for health %v, disable once", health.String())
+	}
+	if health.Offline(now.Add(100*time.Second), threshold) {
+		t.Errorf("Fail for health %v, should not notify when disabled", health.String())
+	}
+
+	// Notify offline after threshold from enabled.
+	if !health.OnEnabled(now.Add(200*time.Second)) || health.State != CameraHealthOffline {
+		t.Errorf("Fail for health %v, should enable", health.String())
+	}
+	if health.Offline(now.Add(230*time.Second), threshold) || !health.Offline(now.Add(260*time.Second), threshold) {
+		t.Errorf("Fail for health %v, should notify after threshold", health.String())
+	}
+}
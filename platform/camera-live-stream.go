@@ -27,6 +27,17 @@ import (
 
 var cameraWorker *CameraWorker
 
+const (
+	// Replace the audio stream of IP camera by silent AAC.
+	CameraExtraAudioSilent = "silent"
+	// Transcode the audio stream of IP camera to AAC, copy if already AAC.
+	CameraExtraAudioAAC = "aac"
+	// Transcode the audio stream of IP camera to AAC only if it's G.711 or PCM detected by ffprobe, otherwise
+	// copy it. Note that there is no Opus strategy, because SRS converts AAC to Opus for WebRTC viewers, and
+	// the SRT ingest of SRS does not carry Opus.
+	CameraExtraAudioAuto = "auto"
+)
+
 type CameraWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -49,6 +60,7 @@ func (v *CameraWorker) GetTask(platform string) *CameraTask {
 func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/camera/secret"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, action string
@@ -64,7 +76,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -149,6 +161,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/camera/streams"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -161,7 +174,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -177,17 +190,24 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 					var pid int32
 					var inputUUID, frame, update, starttime, ready string
+					var health *CameraHealth
 					if task := cameraWorker.GetTask(config.Platform); task != nil {
 						pid, inputUUID, frame, update, starttime, ready = task.queryFrame()
+						health = task.queryHealth()
 					}
 
 					elem := map[string]interface{}{
-						"platform":   config.Platform,
-						"enabled":    config.Enabled,
-						"custom":     config.Customed,
-						"label":      config.Label,
-						"files":      config.Streams,
-						"extraAudio": config.ExtraAudio,
+						"platform":         config.Platform,
+						"enabled":          config.Enabled,
+						"custom":           config.Customed,
+						"label":            config.Label,
+						"files":            config.Streams,
+						"extraAudio":       config.ExtraAudio,
+						"offlineThreshold": config.OfflineThreshold,
+					}
+
+					if config.Enabled && health != nil {
+						elem["health"] = health
 					}
 
 					if pid > 0 {
@@ -218,6 +238,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/camera/stream-url"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -232,7 +253,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -278,6 +299,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/camera/source"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			type CameraTempFile struct {
@@ -306,7 +328,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -322,6 +344,13 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				if stream.Type != FFprobeSourceTypeStream {
 					return errors.Errorf("invalid target %v type %v", stream.Target, stream.Type)
 				}
+
+				// Restore the password of ONVIF profile, which is redacted when responsed to client.
+				if target, err := onvifRestoreURI(ctx, stream.Target); err != nil {
+					return errors.Wrapf(err, "restore %v", stream.Target)
+				} else {
+					stream.Target = target
+				}
 			}
 
 			// Check platform.
@@ -427,6 +456,12 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					}
 				}
 
+				// The G.711 or PCM audio can't be played by RTMP or WebRTC viewers, so it should be transcoded.
+				if matchAudio != nil && cameraAudioIsPCM(matchAudio.CodecName) {
+					logger.Wf(ctx, "Camera: audio codec %v of %v requires extraAudio=%v or %v",
+						matchAudio.CodecName, stream.Target, CameraExtraAudioAuto, CameraExtraAudioAAC)
+				}
+
 				parsedStream := &FFprobeSource{
 					Name: stream.Name, Size: uint64(stream.Size), UUID: stream.UUID,
 					Target: stream.Target,
@@ -476,6 +511,14 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	if err := v.handleOnvif(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle onvif")
+	}
+
+	if err := v.handleMotion(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle motion")
+	}
+
 	return nil
 }
 
@@ -508,7 +551,7 @@ func (v *CameraWorker) Start(ctx context.Context) error {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			if task.PID > 0 {
+			if task.PID > 0 || task.MotionPID > 0 {
 				task.cleanup(ctx)
 			}
 		}
@@ -518,6 +561,23 @@ func (v *CameraWorker) Start(ctx context.Context) error {
 		}
 	}
 
+	// Remove the expired motion events and snapshots.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			if err := CameraMotionCleanup(ctx, time.Now()); err != nil {
+				logger.Wf(ctx, "ignore motion cleanup err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Hour):
+			}
+		}
+	}()
+
 	// Load all configurations from redis.
 	loadTasks := func() error {
 		configItems, err := rdb.HGetAll(ctx, SRS_CAMERA_CONFIG).Result()
@@ -586,6 +646,11 @@ func (v *CameraWorker) Start(ctx context.Context) error {
 				duration = 10 * time.Second
 			}
 
+			v.tasks.Range(func(key, value interface{}) bool {
+				value.(*CameraTask).checkHealth(ctx)
+				return true
+			})
+
 			select {
 			case <-ctx.Done():
 			case <-time.After(duration):
@@ -612,18 +677,73 @@ type CameraConfigure struct {
 	Label string `json:"label"`
 	// The extra audio stream strategy.
 	ExtraAudio string `json:"extraAudio"`
+	// The duration in seconds for IP camera offline to notify, use default if 0.
+	OfflineThreshold int `json:"offlineThreshold"`
 
 	// The input files for IP camera.
 	Streams []*FFprobeSource `json:"files"`
+
+	// The motion detection, which is updated by motion API, not by secret API.
+	Motion *CameraMotionConfig `json:"motion,omitempty"`
 }
 
 func (v CameraConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, extraAudio=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.ExtraAudio,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, extraAudio=%v, offlineThreshold=%v, motion=(%v)",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.ExtraAudio, v.OfflineThreshold, v.Motion,
 	)
 }
 
+// OutputURL builds the output url by server and secret, for example, rtmp://localhost/live/livestream
+func (v *CameraConfigure) OutputURL() string {
+	outputServer := v.Server
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.Secret, "/") && v.Secret != "" {
+		outputServer += "/"
+	}
+	return fmt.Sprintf("%v%v", outputServer, v.Secret)
+}
+
+// cameraAudioIsPCM whether the audio codec from ffprobe is G.711 or PCM, for example, pcm_alaw, pcm_mulaw and
+// pcm_s16le, which can't be played by RTMP or WebRTC viewers.
+func cameraAudioIsPCM(codec string) bool {
+	return strings.HasPrefix(codec, "pcm_")
+}
+
+// AudioArgs builds the FFmpeg arguments for audio and video codec, by the extra audio strategy and the
+// audio codec of input from ffprobe.
+func (v *CameraConfigure) AudioArgs(input *FFprobeSource) []string {
+	// Resample the G.711 or PCM, which is generally 8kHz mono, to AAC.
+	aac := []string{"-c:v", "copy", "-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "64k"}
+
+	switch v.ExtraAudio {
+	case CameraExtraAudioSilent:
+		return []string{
+			"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100", // Silent audio stream.
+			"-map", "0:v", "-map", "1:a", // Ignore the original audio stream.
+			"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k", // Encode audio stream.
+			"-c:v", "copy", // Copy video stream.
+		}
+	case CameraExtraAudioAAC:
+		// Copy if no audio stream, or already AAC.
+		if input.Audio == nil || input.Audio.CodecName == "aac" {
+			return []string{"-c", "copy"}
+		}
+		return aac
+	case CameraExtraAudioAuto:
+		// Only transcode the G.711 or PCM, detected by ffprobe.
+		if input.Audio != nil && cameraAudioIsPCM(input.Audio.CodecName) {
+			return aac
+		}
+	}
+
+	return []string{"-c", "copy"}
+}
+
 func (v *CameraConfigure) Update(u *CameraConfigure) error {
+	allowedExtraAudios := []string{"", CameraExtraAudioSilent, CameraExtraAudioAAC, CameraExtraAudioAuto}
+	if !slicesContains(allowedExtraAudios, u.ExtraAudio) {
+		return errors.Errorf("invalid extraAudio %v", u.ExtraAudio)
+	}
+
 	v.Platform = u.Platform
 	v.Server = u.Server
 	v.Secret = u.Secret
@@ -632,6 +752,7 @@ func (v *CameraConfigure) Update(u *CameraConfigure) error {
 	v.Customed = u.Customed
 	v.Streams = append([]*FFprobeSource{}, u.Streams...)
 	v.ExtraAudio = u.ExtraAudio
+	v.OfflineThreshold = u.OfflineThreshold
 	return nil
 }
 
@@ -651,6 +772,8 @@ type CameraTask struct {
 
 	// FFmpeg pid.
 	PID int32 `json:"pid"`
+	// FFmpeg pid of motion detection.
+	MotionPID int32 `json:"motion_pid"`
 	// FFmpeg last frame.
 	frame string
 	// The last update time.
@@ -667,6 +790,10 @@ type CameraTask struct {
 	config *CameraConfigure
 	// The IP camera worker.
 	cameraWorker *CameraWorker
+	// The health of IP camera.
+	health *CameraHealth
+	// Notify to reconnect immediately, when restarted by user.
+	restartNow chan bool
 
 	// To protect the fields.
 	lock sync.Mutex
@@ -695,6 +822,12 @@ func (v *CameraTask) cleanup(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	if v.MotionPID > 0 {
+		logger.Wf(ctx, "kill task motion pid=%v", v.MotionPID)
+		syscall.Kill(int(v.MotionPID), syscall.SIGKILL)
+		v.MotionPID = 0
+	}
+
 	if v.PID <= 0 {
 		return nil
 	}
@@ -716,6 +849,12 @@ func (v *CameraTask) Restart(ctx context.Context) error {
 		v.cancel()
 	}
 
+	// Reconnect immediately, and the termination is not a failure of IP camera.
+	select {
+	case v.restartNow <- true:
+	default:
+	}
+
 	// Reload config from redis.
 	if b, err := rdb.HGet(ctx, SRS_CAMERA_CONFIG, v.Platform).Result(); err != nil {
 		return errors.Wrapf(err, "hget %v %v", SRS_CAMERA_CONFIG, v.Platform)
@@ -760,8 +899,13 @@ func (v *CameraTask) queryFrame() (int32, string, string, string, string, string
 
 func (v *CameraTask) Initialize(ctx context.Context, w *CameraWorker) error {
 	v.cameraWorker = w
+	v.restartNow = make(chan bool, 1)
 	logger.Tf(ctx, "Camera: Initialize uuid=%v, platform=%v", v.UUID, v.Platform)
 
+	if err := v.loadHealth(ctx); err != nil {
+		return errors.Wrapf(err, "load health")
+	}
+
 	if err := v.saveTask(ctx); err != nil {
 		return errors.Wrapf(err, "save task")
 	}
@@ -803,16 +947,32 @@ func (v *CameraTask) Run(ctx context.Context) error {
 			return errors.Wrapf(err, "do IP camera")
 		}
 
-		return nil
+		// The IP camera stream should never terminate, unless restarted.
+		return errors.New("stream terminated")
 	}
 
 	for ctx.Err() == nil {
 		if err := pfn(ctx); err != nil {
-			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
+			// Reconnect immediately if restarted by user, for example, the configure changed.
+			select {
+			case <-v.restartNow:
+				logger.Tf(ctx, "Camera: Restart %v, err %v", v.String(), err)
+				continue
+			default:
+			}
+
+			// Quit normally, it's not a failure of IP camera.
+			if ctx.Err() != nil {
+				break
+			}
+
+			backoff := v.onHealthFailure(ctx, err)
+			logger.Wf(ctx, "ignore %v err %+v, reconnect after %v", v.String(), err, backoff)
 
 			select {
 			case <-ctx.Done():
-			case <-time.After(3500 * time.Millisecond):
+			case <-v.restartNow:
+			case <-time.After(backoff):
 			}
 			continue
 		}
@@ -832,15 +992,8 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 	ctx, cancel := context.WithCancel(ctx)
 	v.cancel = cancel
 
-	// Build input URL.
-	host := "localhost"
-
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL := v.config.OutputURL()
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -869,17 +1022,8 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 	} else {
 		args = append(args, "-i", input.Target)
 	}
-	// Whether insert extra audio stream.
-	if v.config.ExtraAudio == "silent" {
-		args = append(args,
-			"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100", // Silent audio stream.
-			"-map", "0:v", "-map", "1:a", // Ignore the original audio stream.
-			"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k", // Encode audio stream.
-			"-c:v", "copy", // Copy video stream.
-		)
-	} else {
-		args = append(args, "-c", "copy")
-	}
+	// Whether insert extra audio stream, or transcode the audio stream.
+	args = append(args, v.config.AudioArgs(input)...)
 	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
 	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
 		args = append(args, "-f", "flv")
@@ -920,6 +1064,22 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 		return errors.Wrapf(err, "save task %v", v.String())
 	}
 
+	// Start the motion detection side path, which stops with the streaming.
+	if motion := v.config.Motion; motion != nil && motion.Enabled {
+		motionCtx, motionCancel := context.WithCancel(ctx)
+		var motionWG sync.WaitGroup
+		defer func() {
+			motionCancel()
+			motionWG.Wait()
+		}()
+
+		motionWG.Add(1)
+		go func() {
+			defer motionWG.Done()
+			v.runMotionDetection(motionCtx, input, outputURL)
+		}()
+	}
+
 	// Pull the latest log frame.
 	heartbeat.Polling(ctx, stderr)
 	go func() {
@@ -928,6 +1088,7 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 			return
 		case <-heartbeat.firstReadyCtx.Done():
 			v.firstReadyTime = &heartbeat.firstReadyTime
+			v.onHealthReady(ctx)
 		}
 
 		for {
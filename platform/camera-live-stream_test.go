@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCamera_AudioArgs(t *testing.T) {
+	pcma := &FFprobeSource{Audio: &FFprobeAudio{CodecType: "audio", CodecName: "pcm_alaw", SampleRate: "8000", Channels: 1}}
+	pcmu := &FFprobeSource{Audio: &FFprobeAudio{CodecType: "audio", CodecName: "pcm_mulaw", SampleRate: "8000", Channels: 1}}
+	aac := &FFprobeSource{Audio: &FFprobeAudio{CodecType: "audio", CodecName: "aac", SampleRate: "44100", Channels: 2}}
+	noAudio := &FFprobeSource{}
+
+	for _, e := range []struct {
+		extraAudio string
+		input      *FFprobeSource
+		args       string
+	}{
+		{extraAudio: "", input: pcma, args: "-c copy"},
+		{extraAudio: "", input: aac, args: "-c copy"},
+		{extraAudio: "", input: noAudio, args: "-c copy"},
+		{extraAudio: CameraExtraAudioAuto, input: pcma, args: "-c:v copy -c:a aac -ac 2 -ar 44100 -b:a 64k"},
+		{extraAudio: CameraExtraAudioAuto, input: pcmu, args: "-c:v copy -c:a aac -ac 2 -ar 44100 -b:a 64k"},
+		{extraAudio: CameraExtraAudioAuto, input: aac, args: "-c copy"},
+		{extraAudio: CameraExtraAudioAuto, input: noAudio, args: "-c copy"},
+		{extraAudio: CameraExtraAudioSilent, input: pcma, args: "-f lavfi -i anullsrc=channel_layout=stereo:sample_rate=44100 " +
+			"-map 0:v -map 1:a -c:a aac -ac 2 -ar 44100 -b:a 20k -c:v copy"},
+		{extraAudio: CameraExtraAudioAAC, input: pcma, args: "-c:v copy -c:a aac -ac 2 -ar 44100 -b:a 64k"},
+		{extraAudio: CameraExtraAudioAAC, input: aac, args: "-c copy"},
+		{extraAudio: CameraExtraAudioAAC, input: noAudio, args: "-c copy"},
+	} {
+		config := CameraConfigure{ExtraAudio: e.extraAudio}
+		if args := strings.Join(config.AudioArgs(e.input), " "); args != e.args {
+			t.Errorf("Fail for extraAudio=%v, expect %v, actual %v", e.extraAudio, e.args, args)
+		}
+	}
+}
+
+func TestCamera_UpdateExtraAudio(t *testing.T) {
+	for _, e := range []struct {
+		config CameraConfigure
+		valid  bool
+	}{
+		{config: CameraConfigure{Server: "rtmp://localhost/live", Secret: "camera1"}, valid: true},
+		{config: CameraConfigure{Server: "rtmp://localhost/live", Secret: "camera1", ExtraAudio: CameraExtraAudioAAC}, valid: true},
+		{config: CameraConfigure{Server: "rtmp://localhost/live", Secret: "camera1", ExtraAudio: CameraExtraAudioAuto}, valid: true},
+		{config: CameraConfigure{Server: "srt://localhost:10080", Secret: "?streamid=#!::r=live/camera1,m=publish",
+			ExtraAudio: "opus"}, valid: false},
+		{config: CameraConfigure{Server: "rtmp://localhost/live", Secret: "camera1", ExtraAudio: "mp3"}, valid: false},
+	} {
+		var target CameraConfigure
+		if err := target.Update(&e.config); (err == nil) != e.valid {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.valid, err)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The directory to store the snapshots of motion events.
+var dirMotionPath = path.Join("containers", "data", "motion")
+
+const (
+	// The max number of motion events, the oldest events are trimmed.
+	CameraMotionMaxEvents = 10000
+	// The motion events and snapshots older than this are removed.
+	CameraMotionExpireDuration = 7 * 24 * time.Hour
+
+	// The default scene change score to trigger motion event.
+	CameraMotionDefaultThreshold = 0.05
+	// The default frames per second to sample for detection.
+	CameraMotionDefaultFPS = 2
+	// The default minimum interval in seconds between motion events.
+	CameraMotionDefaultCooldown = 10
+)
+
+func (v *CameraWorker) handleMotion(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/camera/motion"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, action, platform string
+			var motion *CameraMotionConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string              `json:"token"`
+				Action   *string              `json:"action"`
+				Platform *string              `json:"platform"`
+				Motion   **CameraMotionConfig `json:"motion"`
+			}{
+				Token: &token, Action: &action, Platform: &platform, Motion: &motion,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			allowedActions := []string{"update"}
+			if action != "" && !slicesContains(allowedActions, action) {
+				return errors.Errorf("invalid action=%v", action)
+			}
+			if platform == "" {
+				return errors.New("no platform")
+			}
+
+			var config CameraConfigure
+			if b, err := rdb.HGet(ctx, SRS_CAMERA_CONFIG, platform).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_CAMERA_CONFIG, platform)
+			} else if b == "" {
+				return errors.Errorf("no platform %v", platform)
+			} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			if action == "update" {
+				// Remove the motion detection if not specified.
+				if motion != nil {
+					if err := motion.Validate(); err != nil {
+						return errors.Wrapf(err, "validate %v", motion.String())
+					}
+
+					// The triggered record depends on the HLS of local stream.
+					if app, stream := config.LocalStream(); motion.Record > 0 && (app == "" || stream == "") {
+						return errors.Errorf("record requires local stream, server=%v", config.Server)
+					}
+				}
+				config.Motion = motion
+
+				if b, err := json.Marshal(&config); err != nil {
+					return errors.Wrapf(err, "marshal %v", config.String())
+				} else if err = rdb.HSet(ctx, SRS_CAMERA_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v %v %v", SRS_CAMERA_CONFIG, platform, string(b))
+				}
+
+				// Restart the IP camera if exists, to start or stop the detection.
+				if task := cameraWorker.GetTask(platform); task != nil {
+					if err := task.Restart(ctx); err != nil {
+						return errors.Wrapf(err, "restart task %v", platform)
+					}
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "Camera: Update motion ok, platform=%v, motion=(%v), token=%vB",
+					platform, motion, len(token))
+				return nil
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Platform string              `json:"platform"`
+				Motion   *CameraMotionConfig `json:"motion"`
+			}{
+				Platform: platform, Motion: config.Motion,
+			})
+			logger.Tf(ctx, "Camera: Query motion ok, platform=%v, token=%vB", platform, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/motion/events"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform, start, end string
+			var limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Platform *string `json:"platform"`
+				Start    *string `json:"start"`
+				End      *string `json:"end"`
+				Limit    *int    `json:"limit"`
+			}{
+				Token: &token, Platform: &platform, Start: &start, End: &end, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if limit <= 0 {
+				limit = 100
+			} else if limit > 1000 {
+				limit = 1000
+			}
+
+			events, err := QueryCameraMotionEvents(ctx, platform, start, end, limit)
+			if err != nil {
+				return errors.Wrapf(err, "query events")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Events []*CameraMotionEvent `json:"events"`
+			}{
+				Events: events,
+			})
+			logger.Tf(ctx, "Camera: Query motion events ok, platform=%v, start=%v, end=%v, limit=%v, events=%v, token=%vB",
+				platform, start, end, limit, len(events), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/motion/snapshot/"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Allow token in query, because the snapshot is usually loaded by img tag.
+			token := r.URL.Query().Get("token")
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Format is :uuid.jpg
+			filename := r.URL.Path[len("/terraform/v1/ffmpeg/camera/motion/snapshot/"):]
+			eventUUID := strings.TrimSuffix(filename, ".jpg")
+			if _, err := uuid.Parse(eventUUID); err != nil || filename != eventUUID+".jpg" {
+				return errors.Errorf("invalid snapshot %v", filename)
+			}
+
+			snapshotFile := path.Join(dirMotionPath, filename)
+			if _, err := os.Stat(snapshotFile); err != nil {
+				return errors.Wrapf(err, "no snapshot %v", snapshotFile)
+			}
+
+			http.ServeFile(w, r, snapshotFile)
+			logger.Tf(ctx, "Camera: Serve motion snapshot ok, file=%v, token=%vB", snapshotFile, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+// The region of frame, in ratio of width and height, from 0 to 1.
+type CameraMotionRegion struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (v *CameraMotionRegion) String() string {
+	return fmt.Sprintf("x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+}
+
+func (v *CameraMotionRegion) Validate() error {
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.Errorf("invalid region %v", v.String())
+	}
+	if v.X+v.Width > 1 || v.Y+v.Height > 1 {
+		return errors.Errorf("region %v out of frame", v.String())
+	}
+	return nil
+}
+
+// The motion detection of IP camera, by the scene change score of FFmpeg.
+type CameraMotionConfig struct {
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The scene change score from 0 to 1, to trigger the motion event. Use default if 0.
+	Threshold float64 `json:"threshold"`
+	// The frames per second to sample for detection. Use default if 0.
+	FPS int `json:"fps"`
+	// The region to detect, detect the whole frame if not set.
+	Region *CameraMotionRegion `json:"region"`
+	// The regions to ignore, for example, the trees or the clock.
+	Masks []*CameraMotionRegion `json:"masks"`
+	// The minimum interval in seconds between motion events. Use default if 0.
+	Cooldown int `json:"cooldown"`
+	// The duration in seconds to record the stream when motion, disabled if 0.
+	Record int `json:"record"`
+}
+
+func (v *CameraMotionConfig) String() string {
+	return fmt.Sprintf("enabled=%v, threshold=%v, fps=%v, region=(%v), masks=%v, cooldown=%v, record=%v",
+		v.Enabled, v.Threshold, v.FPS, v.Region, len(v.Masks), v.Cooldown, v.Record,
+	)
+}
+
+func (v *CameraMotionConfig) Validate() error {
+	if v.Threshold < 0 || v.Threshold > 1 {
+		return errors.Errorf("invalid threshold %v", v.Threshold)
+	}
+	if v.FPS < 0 || v.FPS > 25 {
+		return errors.Errorf("invalid fps %v", v.FPS)
+	}
+	if v.Cooldown < 0 || v.Record < 0 {
+		return errors.Errorf("invalid cooldown %v or record %v", v.Cooldown, v.Record)
+	}
+
+	if v.Region != nil {
+		if err := v.Region.Validate(); err != nil {
+			return errors.Wrapf(err, "region")
+		}
+	}
+	for _, mask := range v.Masks {
+		if err := mask.Validate(); err != nil {
+			return errors.Wrapf(err, "mask")
+		}
+	}
+
+	return nil
+}
+
+// CooldownDuration returns the minimum interval between motion events.
+func (v *CameraMotionConfig) CooldownDuration() time.Duration {
+	if v.Cooldown > 0 {
+		return time.Duration(v.Cooldown) * time.Second
+	}
+	return CameraMotionDefaultCooldown * time.Second
+}
+
+// Filter builds the FFmpeg filter graph, which outputs the sampled frame as [snapshot], and the frames
+// whose scene change score exceeds threshold as [motion], with the score printed by metadata filter.
+func (v *CameraMotionConfig) Filter() string {
+	fps, threshold := v.FPS, v.Threshold
+	if fps <= 0 {
+		fps = CameraMotionDefaultFPS
+	}
+	if threshold <= 0 {
+		threshold = CameraMotionDefaultThreshold
+	}
+
+	// Fill the masks by black, before crop the region, so the masks are in the whole frame.
+	var detect []string
+	for _, mask := range v.Masks {
+		detect = append(detect, fmt.Sprintf("drawbox=x=iw*%.4f:y=ih*%.4f:w=iw*%.4f:h=ih*%.4f:color=black:t=fill",
+			mask.X, mask.Y, mask.Width, mask.Height,
+		))
+	}
+	if v.Region != nil {
+		detect = append(detect, fmt.Sprintf("crop=iw*%.4f:ih*%.4f:iw*%.4f:ih*%.4f",
+			v.Region.Width, v.Region.Height, v.Region.X, v.Region.Y,
+		))
+	}
+	detect = append(detect, "scale=320:-2", fmt.Sprintf("select=gt(scene\\,%.4f)", threshold), "metadata=print")
+
+	return fmt.Sprintf("[0:v]fps=%v,split=2[full][detect];[full]scale=640:-2[snapshot];[detect]%v[motion]",
+		fps, strings.Join(detect, ","),
+	)
+}
+
+// CameraMotionParseScore parses the scene change score from FFmpeg log, which is printed by metadata
+// filter, for example:
+//
+//	[Parsed_metadata_6 @ 0x7f9c8c004a80] lavfi.scene_score=0.123456
+func CameraMotionParseScore(line string) (float64, bool) {
+	const key = "lavfi.scene_score="
+	index := strings.Index(line, key)
+	if index < 0 {
+		return 0, false
+	}
+
+	score, err := strconv.ParseFloat(strings.TrimSpace(line[index+len(key):]), 64)
+	if err != nil {
+		return 0, false
+	}
+	return score, true
+}
+
+// The motion event of IP camera.
+type CameraMotionEvent struct {
+	// The event UUID.
+	UUID string `json:"uuid"`
+	// The platform of IP camera.
+	Platform string `json:"platform"`
+	// The local stream of IP camera, empty if not local.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	// The scene change score.
+	Score float64 `json:"score"`
+	// The event time, in RFC3339.
+	Time string `json:"time"`
+	// The snapshot file name, in dirMotionPath.
+	Snapshot string `json:"snapshot,omitempty"`
+	// Whether triggered the record.
+	Record bool `json:"record,omitempty"`
+}
+
+func (v *CameraMotionEvent) String() string {
+	return fmt.Sprintf("uuid=%v, platform=%v, app=%v, stream=%v, score=%v, time=%v, snapshot=%v, record=%v",
+		v.UUID, v.Platform, v.App, v.Stream, v.Score, v.Time, v.Snapshot, v.Record,
+	)
+}
+
+// Save the event to redis stream, which is capped to CameraMotionMaxEvents.
+func (v *CameraMotionEvent) Save(ctx context.Context) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	}
+
+	if err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: SRS_CAMERA_MOTION, MaxLen: CameraMotionMaxEvents, Approx: true,
+		Values: map[string]interface{}{"platform": v.Platform, "event": string(b)},
+	}).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "xadd %v %v", SRS_CAMERA_MOTION, string(b))
+	}
+	return nil
+}
+
+// QueryCameraMotionEvents queries the latest events of platform, or all platforms if empty, in the
+// time range in RFC3339.
+func QueryCameraMotionEvents(ctx context.Context, platform, start, end string, limit int) ([]*CameraMotionEvent, error) {
+	// Convert the time range to stream ID, which is the milliseconds of time.
+	parseStreamID := func(t, defaultID string) (string, error) {
+		if t == "" {
+			return defaultID, nil
+		}
+		v, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse time %v", t)
+		}
+		return strconv.FormatInt(v.UnixMilli(), 10), nil
+	}
+
+	startID, err := parseStreamID(start, "-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "start")
+	}
+	endID, err := parseStreamID(end, "+")
+	if err != nil {
+		return nil, errors.Wrapf(err, "end")
+	}
+
+	messages, err := rdb.XRevRangeN(ctx, SRS_CAMERA_MOTION, endID, startID, CameraMotionMaxEvents).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "xrevrange %v %v %v", SRS_CAMERA_MOTION, endID, startID)
+	}
+
+	events := []*CameraMotionEvent{}
+	for _, message := range messages {
+		if len(events) >= limit {
+			break
+		}
+		if p, ok := message.Values["platform"].(string); !ok || (platform != "" && p != platform) {
+			continue
+		}
+
+		s, ok := message.Values["event"].(string)
+		if !ok {
+			continue
+		}
+
+		var event CameraMotionEvent
+		if err := json.Unmarshal([]byte(s), &event); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", message.ID, s)
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// CameraMotionCleanup removes the events and snapshots which are expired.
+func CameraMotionCleanup(ctx context.Context, now time.Time) error {
+	expired := now.Add(-CameraMotionExpireDuration)
+
+	minID := strconv.FormatInt(expired.UnixMilli(), 10)
+	if err := rdb.XTrimMinID(ctx, SRS_CAMERA_MOTION, minID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "xtrim %v %v", SRS_CAMERA_MOTION, minID)
+	}
+
+	entries, err := os.ReadDir(dirMotionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read dir %v", dirMotionPath)
+	}
+
+	for _, entry := range entries {
+		if info, err := entry.Info(); err != nil || info.IsDir() || !info.ModTime().Before(expired) {
+			continue
+		}
+
+		file := path.Join(dirMotionPath, entry.Name())
+		if err := os.Remove(file); err != nil {
+			return errors.Wrapf(err, "remove %v", file)
+		}
+		logger.Tf(ctx, "Camera: Remove expired motion snapshot %v", file)
+	}
+
+	return nil
+}
+
+// runMotionDetection runs the motion detection side path, until ctx is done.
+func (v *CameraTask) runMotionDetection(ctx context.Context, input *FFprobeSource, outputURL string) {
+	for ctx.Err() == nil {
+		if err := v.doMotionDetection(ctx, input, outputURL); err != nil {
+			logger.Wf(ctx, "Camera: ignore motion detection of %v err %+v", v.Platform, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(3500 * time.Millisecond):
+		}
+	}
+}
+
+func (v *CameraTask) doMotionDetection(ctx context.Context, input *FFprobeSource, outputURL string) error {
+	motion := v.config.Motion
+	if motion == nil || !motion.Enabled {
+		return nil
+	}
+	app, stream := v.config.LocalStream()
+
+	args := []string{"-nostats"}
+	if app != "" && stream != "" {
+		// Analyze the local stream, to avoid pulling the IP camera twice.
+		args = append(args, "-i", withInternalPlay(outputURL))
+	} else {
+		// For RTSP stream source, always use TCP transport.
+		if strings.HasPrefix(input.Target, "rtsp://") {
+			args = append(args, "-rtsp_transport", "tcp")
+		}
+		// Rebuild the stream url, because it may contain special characters.
+		if strings.Contains(input.Target, "://") {
+			if u, err := RebuildStreamURL(input.Target); err != nil {
+				return errors.Wrapf(err, "rebuild %v", input.Target)
+			} else {
+				args = append(args, "-i", u.String())
+			}
+		} else {
+			args = append(args, "-i", input.Target)
+		}
+	}
+
+	// The latest sampled frame, copied as snapshot when motion.
+	latest := path.Join(dirMotionPath, fmt.Sprintf("%v.jpg", v.UUID))
+	args = append(args, "-filter_complex", motion.Filter(),
+		"-map", "[snapshot]", "-q:v", "5", "-update", "1", "-atomic_writing", "1", "-y", latest,
+		"-map", "[motion]", "-f", "null", "-",
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	pid := int32(cmd.Process.Pid)
+	v.lock.Lock()
+	v.MotionPID = pid
+	v.lock.Unlock()
+	defer func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		if v.MotionPID == pid {
+			v.MotionPID = 0
+		}
+	}()
+	logger.Tf(ctx, "Camera: Start motion detection, platform=%v, motion=(%v), pid=%v",
+		v.Platform, motion.String(), pid)
+
+	var last time.Time
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		score, ok := CameraMotionParseScore(scanner.Text())
+		if !ok || time.Since(last) < motion.CooldownDuration() {
+			continue
+		}
+		last = time.Now()
+
+		if err := v.onMotion(ctx, motion, latest, score, app, stream); err != nil {
+			logger.Wf(ctx, "Camera: ignore motion of %v err %+v", v.Platform, err)
+		}
+	}
+
+	// Drain the stderr, to make sure FFmpeg is not blocked.
+	_, _ = io.Copy(io.Discard, stderr)
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return errors.Wrapf(err, "wait ffmpeg")
+	}
+	return nil
+}
+
+// onMotion saves the motion event with snapshot, triggers the record and callback.
+func (v *CameraTask) onMotion(ctx context.Context, motion *CameraMotionConfig, latest string, score float64, app, stream string) error {
+	event := &CameraMotionEvent{
+		UUID: uuid.NewString(), Platform: v.Platform, App: app, Stream: stream, Score: score,
+		Time: time.Now().Format(time.RFC3339),
+	}
+
+	snapshot := fmt.Sprintf("%v.jpg", event.UUID)
+	if err := copyFile(latest, path.Join(dirMotionPath, snapshot)); err != nil {
+		logger.Wf(ctx, "Camera: ignore snapshot of %v err %+v", event.String(), err)
+	} else {
+		event.Snapshot = snapshot
+	}
+
+	if motion.Record > 0 && app != "" && stream != "" {
+		recordWorker.Trigger(fmt.Sprintf("/%v/%v", app, stream), time.Now().Add(time.Duration(motion.Record)*time.Second))
+		event.Record = true
+	}
+
+	if err := event.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save %v", event.String())
+	}
+
+	if err := callbackWorker.OnMotion(ctx, SrsActionOnMotion, event); err != nil {
+		return errors.Wrapf(err, "callback %v", event.String())
+	}
+
+	logger.Tf(ctx, "Camera: Motion event %v", event.String())
+	return nil
+}
+
+// LocalStream parses the app and stream, if IP camera publishes to local server, otherwise empty.
+func (v *CameraConfigure) LocalStream() (string, string) {
+	u, err := url.Parse(v.OutputURL())
+	if err != nil || u.Scheme != "rtmp" || (u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1") {
+		return "", ""
+	}
+
+	app, stream := path.Split(strings.Trim(u.Path, "/"))
+	return strings.Trim(app, "/"), stream
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCameraMotion_Filter(t *testing.T) {
+	for _, e := range []struct {
+		config CameraMotionConfig
+		filter string
+	}{
+		{config: CameraMotionConfig{}, filter: "[0:v]fps=2,split=2[full][detect];[full]scale=640:-2[snapshot];" +
+			"[detect]scale=320:-2,select=gt(scene\\,0.0500),metadata=print[motion]"},
+		{config: CameraMotionConfig{
+			Threshold: 0.2, FPS: 5,
+			Region: &CameraMotionRegion{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.5},
+			Masks:  []*CameraMotionRegion{{X: 0, Y: 0, Width: 0.2, Height: 0.1}},
+		}, filter: "[0:v]fps=5,split=2[full][detect];[full]scale=640:-2[snapshot];" +
+			"[detect]drawbox=x=iw*0.0000:y=ih*0.0000:w=iw*0.2000:h=ih*0.1000:color=black:t=fill," +
+			"crop=iw*0.5000:ih*0.5000:iw*0.2500:ih*0.5000," +
+			"scale=320:-2,select=gt(scene\\,0.2000),metadata=print[motion]"},
+	} {
+		if filter := e.config.Filter(); filter != e.filter {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.filter, filter)
+		}
+	}
+}
+
+func TestCameraMotion_Validate(t *testing.T) {
+	for _, e := range []struct {
+		config CameraMotionConfig
+		valid  bool
+	}{
+		{config: CameraMotionConfig{Enabled: true}, valid: true},
+		{config: CameraMotionConfig{Threshold: 0.1, FPS: 2, Cooldown: 30, Record: 60,
+			Region: &CameraMotionRegion{X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}}, valid: true},
+		{config: CameraMotionConfig{Threshold: 1.5}, valid: false},
+		{config: CameraMotionConfig{FPS: 30}, valid: false},
+		{config: CameraMotionConfig{Record: -1}, valid: false},
+		{config: CameraMotionConfig{Region: &CameraMotionRegion{X: 0.6, Y: 0, Width: 0.5, Height: 0.5}}, valid: false},
+		{config: CameraMotionConfig{Masks: []*CameraMotionRegion{{X: 0, Y: 0, Width: 0, Height: 0.5}}}, valid: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.valid, err)
+		}
+	}
+}
+
+func TestCameraMotion_ParseScore(t *testing.T) {
+	for _, e := range []struct {
+		line  string
+		score float64
+		ok    bool
+	}{
+		{line: "[Parsed_metadata_6 @ 0x7f9c8c004a80] lavfi.scene_score=0.123456", score: 0.123456, ok: true},
+		{line: "[Parsed_metadata_6 @ 0x7f9c8c004a80] frame:12   pts:24    pts_time:6", ok: false},
+		{line: "[Parsed_metadata_6 @ 0x7f9c8c004a80] lavfi.scene_score=nan0", ok: false},
+	} {
+		if score, ok := CameraMotionParseScore(e.line); score != e.score || ok != e.ok {
+			t.Errorf("Fail for line %v, expect %v %v, actual %v %v", e.line, e.score, e.ok, score, ok)
+		}
+	}
+}
+
+func TestCameraMotion_LocalStream(t *testing.T) {
+	for _, e := range []struct {
+		config CameraConfigure
+		app    string
+		stream string
+	}{
+		{config: CameraConfigure{Server: "rtmp://localhost/live", Secret: "camera1"}, app: "live", stream: "camera1"},
+		{config: CameraConfigure{Server: "rtmp://127.0.0.1/live/", Secret: "camera1?secret=xxx"}, app: "live", stream: "camera1"},
+		{config: CameraConfigure{Server: "rtmp://live.example.com/live", Secret: "camera1"}},
+		{config: CameraConfigure{Server: "srt://localhost:10080", Secret: "?streamid=#!::r=live/camera1,m=publish"}},
+	} {
+		if app, stream := e.config.LocalStream(); app != e.app || stream != e.stream {
+			t.Errorf("Fail for config %v, expect %v/%v, actual %v/%v", e.config.String(), e.app, e.stream, app, stream)
+		}
+	}
+}
+
+func TestCameraMotion_RecordTrigger(t *testing.T) {
+	worker := NewRecordWorker()
+	if worker.Triggered("live", "camera1") {
+		t.Errorf("Fail for not triggered")
+	}
+
+	worker.Trigger("/live/camera1", time.Now().Add(time.Minute))
+	if !worker.Triggered("live", "camera1") || worker.Triggered("live", "camera2") {
+		t.Errorf("Fail for triggered")
+	}
+
+	// Should not shorten the deadline.
+	worker.Trigger("/live/camera1", time.Now().Add(-time.Minute))
+	if !worker.Triggered("live", "camera1") {
+		t.Errorf("Fail for triggered")
+	}
+
+	worker.Trigger("/live/camera2", time.Now().Add(-time.Minute))
+	if worker.Triggered("live", "camera2") {
+		t.Errorf("Fail for expired")
+	}
+}
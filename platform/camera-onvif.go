@@ -0,0 +1,745 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The WS-Discovery multicast address, see https://www.onvif.org/specs/core/ONVIF-Core-Specification.pdf
+var onvifDiscoveryAddress = "239.255.255.250:3702"
+
+const (
+	// The default timeout to wait for ONVIF discovery responses.
+	OnvifDiscoveryTimeout = 3 * time.Second
+	// The max timeout to wait for ONVIF discovery responses.
+	OnvifDiscoveryMaxTimeout = 10 * time.Second
+	// The timeout for each ONVIF SOAP request.
+	OnvifRequestTimeout = 10 * time.Second
+	// The max size of ONVIF SOAP response.
+	OnvifMaxResponseSize = 1024 * 1024
+)
+
+// The HTTP client for ONVIF SOAP request, never wait for the device for ever.
+var onvifHTTPClient = &http.Client{Timeout: OnvifRequestTimeout}
+
+// The password of RTSP url redacted by url.URL.Redacted, to response the profiles to client.
+const onvifRedactedPassword = "xxxxx"
+
+func (v *CameraWorker) handleOnvif(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/camera/onvif/discover"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var timeout int64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// The timeout in ms to wait for responses.
+				Timeout *int64 `json:"timeout"`
+			}{
+				Token: &token, Timeout: &timeout,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			duration := OnvifDiscoveryTimeout
+			if timeout > 0 {
+				duration = time.Duration(timeout) * time.Millisecond
+			}
+			if duration > OnvifDiscoveryMaxTimeout {
+				duration = OnvifDiscoveryMaxTimeout
+			}
+
+			devices, err := OnvifDiscover(ctx, onvifDiscoveryAddress, duration)
+			if err != nil {
+				return errors.Wrapf(err, "discover")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Devices []*OnvifDevice `json:"devices"`
+			}{
+				Devices: devices,
+			})
+			logger.Tf(ctx, "Camera: ONVIF discover ok, timeout=%v, devices=%v, token=%vB",
+				duration, len(devices), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/onvif/profiles"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var device OnvifDevice
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*OnvifDevice
+			}{
+				Token: &token, OnvifDevice: &device,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := device.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load device %v", device.XAddr)
+			}
+
+			profiles, err := device.Profiles(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query profiles of %v", device.XAddr)
+			}
+
+			// Save the device with credentials, for PTZ control.
+			if err := device.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save device %v", device.XAddr)
+			}
+
+			// Response in the same structure of camera stream-url, so that the profiles can be used as files of
+			// camera source, and parsed as FFprobeSource.
+			type OnvifStreamFile struct {
+				// The file name.
+				Name string `json:"name"`
+				// The file UUID.
+				UUID string `json:"uuid"`
+				// The target file name.
+				Target string `json:"target"`
+				// The source type.
+				Type FFprobeSourceType `json:"type"`
+				// The ONVIF profile.
+				Profile *OnvifProfile `json:"profile"`
+			}
+
+			// Never response the password of device, which is restored by onvifRestoreURI.
+			var files []*OnvifStreamFile
+			for _, profile := range profiles {
+				if u, err := url.Parse(profile.URI); err == nil {
+					profile.URI = u.Redacted()
+				}
+
+				files = append(files, &OnvifStreamFile{
+					Name: profile.Name, UUID: uuid.NewString(), Target: profile.URI,
+					Type: FFprobeSourceTypeStream, Profile: profile,
+				})
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				XAddr string             `json:"xaddr"`
+				Files []*OnvifStreamFile `json:"files"`
+			}{
+				XAddr: device.XAddr, Files: files,
+			})
+			logger.Tf(ctx, "Camera: ONVIF profiles ok, device=%v, profiles=%v, token=%vB",
+				device.String(), len(profiles), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/onvif/ptz"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var device OnvifDevice
+			var ptz OnvifPTZRequest
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*OnvifDevice
+				*OnvifPTZRequest
+			}{
+				Token: &token, OnvifDevice: &device, OnvifPTZRequest: &ptz,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := ptz.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", ptz.String())
+			}
+
+			if err := device.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load device %v", device.XAddr)
+			}
+
+			presets, err := device.PTZ(ctx, &ptz)
+			if err != nil {
+				return errors.Wrapf(err, "ptz %v of %v", ptz.String(), device.XAddr)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Action  string         `json:"action"`
+				Presets []*OnvifPreset `json:"presets,omitempty"`
+			}{
+				Action: ptz.Action, Presets: presets,
+			})
+			logger.Tf(ctx, "Camera: ONVIF ptz ok, device=%v, ptz=%v, token=%vB",
+				device.String(), ptz.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+// OnvifDiscover sends WS-Discovery probe to address, and collects the responses until timeout.
+func OnvifDiscover(ctx context.Context, address string, timeout time.Duration) ([]*OnvifDevice, error) {
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve %v", address)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen udp")
+	}
+	defer conn.Close()
+
+	probe := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<e:Header><w:MessageID>uuid:%v</w:MessageID><w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To><w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action></e:Header>
+<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>
+</e:Envelope>`, uuid.NewString())
+	if _, err := conn.WriteToUDP([]byte(probe), addr); err != nil {
+		return nil, errors.Wrapf(err, "probe %v", address)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, errors.Wrapf(err, "set deadline")
+	}
+
+	// Each device may response more than once, so we filter by the endpoint address.
+	var devices []*OnvifDevice
+	endpoints := make(map[string]bool)
+
+	buf := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		nn, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			return nil, errors.Wrapf(err, "read udp")
+		}
+
+		matches, err := onvifParseProbeMatches(buf[:nn])
+		if err != nil {
+			logger.Wf(ctx, "Camera: ignore invalid ONVIF response from %v, err %+v", from, err)
+			continue
+		}
+
+		for _, device := range matches {
+			if endpoints[device.Endpoint] {
+				continue
+			}
+			endpoints[device.Endpoint] = true
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, nil
+}
+
+// onvifParseProbeMatches parses the WS-Discovery ProbeMatches message to devices.
+func onvifParseProbeMatches(b []byte) ([]*OnvifDevice, error) {
+	var envelope struct {
+		Matches []struct {
+			Address string `xml:"EndpointReference>Address"`
+			Types   string `xml:"Types"`
+			Scopes  string `xml:"Scopes"`
+			XAddrs  string `xml:"XAddrs"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(b, &envelope); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal")
+	}
+
+	var devices []*OnvifDevice
+	for _, match := range envelope.Matches {
+		xaddrs := strings.Fields(match.XAddrs)
+		if len(xaddrs) == 0 {
+			continue
+		}
+
+		device := &OnvifDevice{Endpoint: match.Address, XAddr: xaddrs[0]}
+		if device.Endpoint == "" {
+			device.Endpoint = device.XAddr
+		}
+
+		// Parse scopes, for example, onvif://www.onvif.org/name/IPC
+		for _, scope := range strings.Fields(match.Scopes) {
+			if v := strings.TrimPrefix(scope, "onvif://www.onvif.org/name/"); v != scope {
+				device.Name, _ = url.PathUnescape(v)
+			} else if v := strings.TrimPrefix(scope, "onvif://www.onvif.org/hardware/"); v != scope {
+				device.Hardware, _ = url.PathUnescape(v)
+			} else if v := strings.TrimPrefix(scope, "onvif://www.onvif.org/location/"); v != scope {
+				device.Location, _ = url.PathUnescape(v)
+			}
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// The ONVIF device, discovered by WS-Discovery, or specified by user.
+type OnvifDevice struct {
+	// The endpoint reference address, generally an urn:uuid.
+	Endpoint string `json:"endpoint,omitempty"`
+	// The device service address, for example, http://192.168.1.100/onvif/device_service
+	XAddr string `json:"xaddr"`
+	// The name from scopes.
+	Name string `json:"name,omitempty"`
+	// The hardware from scopes.
+	Hardware string `json:"hardware,omitempty"`
+	// The location from scopes.
+	Location string `json:"location,omitempty"`
+
+	// The credentials of device, which is never responsed to client.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// The media service address, from GetCapabilities, never from client.
+	MediaXAddr string `json:"media,omitempty"`
+	// The PTZ service address, from GetCapabilities, never from client.
+	PTZXAddr string `json:"ptz,omitempty"`
+}
+
+func (v *OnvifDevice) String() string {
+	return fmt.Sprintf("endpoint=%v, xaddr=%v, name=%v, hardware=%v, username=%v, password=%vB, media=%v, ptz=%v",
+		v.Endpoint, v.XAddr, v.Name, v.Hardware, v.Username, len(v.Password), v.MediaXAddr, v.PTZXAddr,
+	)
+}
+
+// Load the saved device by xaddr, use the saved credentials if not specified. Note that the service
+// addresses specified by client are ignored, because the credentials are sent to them.
+func (v *OnvifDevice) Load(ctx context.Context) error {
+	v.MediaXAddr, v.PTZXAddr = "", ""
+
+	if v.XAddr == "" {
+		return errors.New("no xaddr")
+	}
+	if u, err := url.Parse(v.XAddr); err != nil {
+		return errors.Wrapf(err, "parse %v", v.XAddr)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("invalid xaddr scheme %v", u.Scheme)
+	}
+
+	if b, err := rdb.HGet(ctx, SRS_CAMERA_ONVIF, v.XAddr).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_CAMERA_ONVIF, v.XAddr)
+	} else if b != "" {
+		var saved OnvifDevice
+		if err := json.Unmarshal([]byte(b), &saved); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		if v.Username == "" && v.Password == "" {
+			v.Username, v.Password = saved.Username, saved.Password
+		}
+		if v.Name == "" {
+			v.Name = saved.Name
+		}
+	}
+
+	return nil
+}
+
+// Save the device with credentials, keyed by xaddr.
+func (v *OnvifDevice) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err := rdb.HSet(ctx, SRS_CAMERA_ONVIF, v.XAddr, string(b)).Err(); err != nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_CAMERA_ONVIF, v.XAddr)
+	}
+	return nil
+}
+
+// Capabilities queries the media and PTZ service address of device.
+func (v *OnvifDevice) Capabilities(ctx context.Context) error {
+	var res struct {
+		Media string `xml:"Capabilities>Media>XAddr"`
+		PTZ   string `xml:"Capabilities>PTZ>XAddr"`
+	}
+	body := `<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>All</Category></GetCapabilities>`
+	if err := onvifCall(ctx, v.XAddr, v.Username, v.Password, body, &res); err != nil {
+		return errors.Wrapf(err, "get capabilities")
+	}
+
+	if res.Media == "" {
+		return errors.Errorf("no media service")
+	}
+	v.MediaXAddr, v.PTZXAddr = strings.TrimSpace(res.Media), strings.TrimSpace(res.PTZ)
+
+	// Never send the credentials to other hosts, because the service address is from the device.
+	if !onvifSameHost(v.XAddr, v.MediaXAddr) {
+		return errors.Errorf("media %v not in device %v", v.MediaXAddr, v.XAddr)
+	}
+	if v.PTZXAddr != "" && !onvifSameHost(v.XAddr, v.PTZXAddr) {
+		return errors.Errorf("ptz %v not in device %v", v.PTZXAddr, v.XAddr)
+	}
+	return nil
+}
+
+// Profiles queries the media profiles with RTSP url of device, with credentials in the url.
+func (v *OnvifDevice) Profiles(ctx context.Context) ([]*OnvifProfile, error) {
+	if err := v.Capabilities(ctx); err != nil {
+		return nil, errors.Wrapf(err, "capabilities")
+	}
+
+	var res struct {
+		Profiles []struct {
+			Token    string `xml:"token,attr"`
+			Name     string `xml:"Name"`
+			Encoding string `xml:"VideoEncoderConfiguration>Encoding"`
+			Width    int    `xml:"VideoEncoderConfiguration>Resolution>Width"`
+			Height   int    `xml:"VideoEncoderConfiguration>Resolution>Height"`
+			PTZ      *struct {
+				Token string `xml:"token,attr"`
+			} `xml:"PTZConfiguration"`
+		} `xml:"Profiles"`
+	}
+	body := `<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`
+	if err := onvifCall(ctx, v.MediaXAddr, v.Username, v.Password, body, &res); err != nil {
+		return nil, errors.Wrapf(err, "get profiles")
+	}
+
+	var profiles []*OnvifProfile
+	for _, p := range res.Profiles {
+		var uri struct {
+			URI string `xml:"MediaUri>Uri"`
+		}
+		body := fmt.Sprintf(`<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl">`+
+			`<StreamSetup><Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>`+
+			`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport></StreamSetup>`+
+			`<ProfileToken>%v</ProfileToken></GetStreamUri>`, onvifEscape(p.Token))
+		if err := onvifCall(ctx, v.MediaXAddr, v.Username, v.Password, body, &uri); err != nil {
+			return nil, errors.Wrapf(err, "get stream uri of %v", p.Token)
+		}
+
+		u, err := url.Parse(strings.TrimSpace(uri.URI))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %v", uri.URI)
+		}
+		if u.Scheme != "rtsp" {
+			return nil, errors.Errorf("invalid stream uri %v of %v", uri.URI, p.Token)
+		}
+		if v.Username != "" && u.User == nil && onvifSameHost(v.XAddr, u.String()) {
+			u.User = url.UserPassword(v.Username, v.Password)
+		}
+
+		profiles = append(profiles, &OnvifProfile{
+			Token: p.Token, Name: p.Name, Encoding: p.Encoding, Width: p.Width, Height: p.Height,
+			PTZ: p.PTZ != nil && v.PTZXAddr != "", URI: u.String(),
+		})
+	}
+
+	return profiles, nil
+}
+
+// PTZ controls the camera by the PTZ service, returns the presets for presets action.
+func (v *OnvifDevice) PTZ(ctx context.Context, req *OnvifPTZRequest) ([]*OnvifPreset, error) {
+	if err := v.Capabilities(ctx); err != nil {
+		return nil, errors.Wrapf(err, "capabilities")
+	}
+	if v.PTZXAddr == "" {
+		return nil, errors.Errorf("no ptz service")
+	}
+
+	const ns = "http://www.onvif.org/ver20/ptz/wsdl"
+	const schema = "http://www.onvif.org/ver10/schema"
+	profile := onvifEscape(req.Profile)
+
+	var body string
+	switch req.Action {
+	case "move", "zoom":
+		var velocity string
+		if req.Action == "move" {
+			velocity = fmt.Sprintf(`<PanTilt xmlns="%v" x="%v" y="%v"/>`, schema, req.X, req.Y)
+		} else {
+			velocity = fmt.Sprintf(`<Zoom xmlns="%v" x="%v"/>`, schema, req.Zoom)
+		}
+
+		var timeout string
+		if req.Timeout > 0 {
+			timeout = fmt.Sprintf(`<Timeout>PT%.3fS</Timeout>`, float64(req.Timeout)/1000)
+		}
+
+		body = fmt.Sprintf(`<ContinuousMove xmlns="%v"><ProfileToken>%v</ProfileToken><Velocity>%v</Velocity>%v</ContinuousMove>`,
+			ns, profile, velocity, timeout)
+	case "stop":
+		body = fmt.Sprintf(`<Stop xmlns="%v"><ProfileToken>%v</ProfileToken><PanTilt>true</PanTilt><Zoom>true</Zoom></Stop>`,
+			ns, profile)
+	case "preset":
+		body = fmt.Sprintf(`<GotoPreset xmlns="%v"><ProfileToken>%v</ProfileToken><PresetToken>%v</PresetToken></GotoPreset>`,
+			ns, profile, onvifEscape(req.Preset))
+	case "presets":
+		var res struct {
+			Presets []*OnvifPreset `xml:"Preset"`
+		}
+		body = fmt.Sprintf(`<GetPresets xmlns="%v"><ProfileToken>%v</ProfileToken></GetPresets>`, ns, profile)
+		if err := onvifCall(ctx, v.PTZXAddr, v.Username, v.Password, body, &res); err != nil {
+			return nil, errors.Wrapf(err, "get presets")
+		}
+		return res.Presets, nil
+	}
+
+	var res struct{}
+	if err := onvifCall(ctx, v.PTZXAddr, v.Username, v.Password, body, &res); err != nil {
+		return nil, errors.Wrapf(err, "%v", req.Action)
+	}
+	return nil, nil
+}
+
+// The ONVIF media profile of device.
+type OnvifProfile struct {
+	// The profile token.
+	Token string `json:"token"`
+	// The profile name.
+	Name string `json:"name"`
+	// The video encoding, for example, H264.
+	Encoding string `json:"encoding,omitempty"`
+	// The video resolution.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Whether profile supports PTZ.
+	PTZ bool `json:"ptz"`
+	// The RTSP url, with credentials.
+	URI string `json:"-"`
+}
+
+// The ONVIF PTZ preset.
+type OnvifPreset struct {
+	// The preset token.
+	Token string `xml:"token,attr" json:"token"`
+	// The preset name.
+	Name string `xml:"Name" json:"name"`
+}
+
+// The ONVIF PTZ request.
+type OnvifPTZRequest struct {
+	// The action, move, zoom, stop, preset or presets.
+	Action string `json:"action"`
+	// The profile token.
+	Profile string `json:"profile"`
+	// The pan and tilt velocity for move, in [-1, 1].
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// The zoom velocity for zoom, in [-1, 1].
+	Zoom float64 `json:"zoom"`
+	// The preset token for preset.
+	Preset string `json:"preset"`
+	// The timeout in ms for move and zoom, camera stops when timeout.
+	Timeout int64 `json:"timeout"`
+}
+
+func (v *OnvifPTZRequest) String() string {
+	return fmt.Sprintf("action=%v, profile=%v, x=%v, y=%v, zoom=%v, preset=%v, timeout=%v",
+		v.Action, v.Profile, v.X, v.Y, v.Zoom, v.Preset, v.Timeout,
+	)
+}
+
+func (v *OnvifPTZRequest) Validate() error {
+	if !slicesContains([]string{"move", "zoom", "stop", "preset", "presets"}, v.Action) {
+		return errors.Errorf("invalid action %v", v.Action)
+	}
+	if v.Profile == "" {
+		return errors.New("no profile")
+	}
+	if v.X < -1 || v.X > 1 || v.Y < -1 || v.Y > 1 || v.Zoom < -1 || v.Zoom > 1 {
+		return errors.Errorf("invalid velocity x=%v, y=%v, zoom=%v", v.X, v.Y, v.Zoom)
+	}
+	if v.Action == "preset" && v.Preset == "" {
+		return errors.New("no preset")
+	}
+	if v.Timeout < 0 {
+		return errors.Errorf("invalid timeout %v", v.Timeout)
+	}
+	return nil
+}
+
+// onvifSameHost whether the address is in the same host of device xaddr.
+func onvifSameHost(xaddr, address string) bool {
+	a, err := url.Parse(xaddr)
+	if err != nil {
+		return false
+	}
+
+	b, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+
+	return a.Hostname() != "" && strings.EqualFold(a.Hostname(), b.Hostname())
+}
+
+// onvifRestoreURI restores the password of RTSP url redacted by the profiles API, from the saved device of the same
+// host and username. The url is not changed if not redacted.
+func onvifRestoreURI(ctx context.Context, target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.User == nil {
+		return target, nil
+	}
+	if password, ok := u.User.Password(); !ok || password != onvifRedactedPassword {
+		return target, nil
+	}
+
+	devices, err := rdb.HGetAll(ctx, SRS_CAMERA_ONVIF).Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hgetall %v", SRS_CAMERA_ONVIF)
+	}
+
+	for _, b := range devices {
+		var device OnvifDevice
+		if err := json.Unmarshal([]byte(b), &device); err != nil {
+			return "", errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		if device.Username == u.User.Username() && onvifSameHost(device.XAddr, target) {
+			u.User = url.UserPassword(device.Username, device.Password)
+			return u.String(), nil
+		}
+	}
+	return "", errors.Errorf("no device for %v", u.Redacted())
+}
+
+// onvifCall sends the SOAP request to endpoint, with WS-Security UsernameToken if username is not empty,
+// and unmarshal the SOAP body to res.
+func onvifCall(ctx context.Context, endpoint, username, password, body string, res interface{}) error {
+	var header string
+	if username != "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return errors.Wrapf(err, "generate nonce")
+		}
+		created := time.Now().UTC().Format(time.RFC3339)
+		digest := onvifPasswordDigest(nonce, created, password)
+
+		header = fmt.Sprintf(`<s:Header><Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">`+
+			`<UsernameToken><Username>%v</Username>`+
+			`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%v</Password>`+
+			`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">%v</Nonce>`+
+			`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">%v</Created>`+
+			`</UsernameToken></Security></s:Header>`,
+			onvifEscape(username), digest, base64.StdEncoding.EncodeToString(nonce), created,
+		)
+	}
+
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">%v<s:Body>%v</s:Body></s:Envelope>`,
+		header, body,
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, OnvifRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader([]byte(envelope)))
+	if err != nil {
+		return errors.Wrapf(err, "new request %v", endpoint)
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := onvifHTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "post %v", endpoint)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, OnvifMaxResponseSize))
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+
+	var response struct {
+		Body struct {
+			Fault *struct {
+				Code   string `xml:"Code>Subcode>Value"`
+				Reason string `xml:"Reason>Text"`
+			} `xml:"Fault"`
+			Content []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err := xml.Unmarshal(b, &response); err != nil {
+		return errors.Wrapf(err, "unmarshal status=%v, body %v", resp.StatusCode, string(b))
+	}
+
+	if fault := response.Body.Fault; fault != nil {
+		return errors.Errorf("fault code=%v, reason=%v, status=%v", fault.Code, fault.Reason, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("invalid status=%v, body %v", resp.StatusCode, string(b))
+	}
+
+	// Some devices response empty body for actions like Stop.
+	if len(bytes.TrimSpace(response.Body.Content)) == 0 {
+		return nil
+	}
+	if err := xml.Unmarshal(response.Body.Content, res); err != nil {
+		return errors.Wrapf(err, "unmarshal body %v", string(response.Body.Content))
+	}
+	return nil
+}
+
+// onvifPasswordDigest builds the WS-Security password digest, by Base64(SHA1(nonce + created + password)).
+func onvifPasswordDigest(nonce []byte, created, password string) string {
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func onvifEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
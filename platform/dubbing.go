@@ -47,6 +47,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep := "/terraform/v1/dubbing/create"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, title string
@@ -64,7 +65,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -76,9 +77,18 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			if targetFile == nil {
 				return errors.Errorf("invalid file")
 			}
-			if targetFile.Type != FFprobeSourceTypeFile && targetFile.Type != FFprobeSourceTypeUpload && targetFile.Type != FFprobeSourceTypeYTDL {
+			if targetFile.Type != FFprobeSourceTypeFile && targetFile.Type != FFprobeSourceTypeUpload && targetFile.Type != FFprobeSourceTypeYTDL &&
+				targetFile.Type != FFprobeSourceTypeRecord {
 				return errors.Errorf("invalid file type %v", targetFile.Type)
 			}
+			// Always use the file of record, because the target is specified by user.
+			if targetFile.Type == FFprobeSourceTypeRecord {
+				if _, target, err := RecordArtifactFile(ctx, targetFile.UUID); err != nil {
+					return errors.Wrapf(err, "record %v", targetFile.UUID)
+				} else {
+					targetFile.Target = target
+				}
+			}
 			if targetFile.Target == "" {
 				return errors.Errorf("invalid file path")
 			}
@@ -115,8 +125,13 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 					fmt.Sprintf("%v%v", dubbing.SourceUUID, path.Ext(info.Name())),
 				)
 
+				// Copy the file of record, which should never be moved or removed.
 				absSourcePath := path.Join(conf.Pwd, aiDubbingWorkDir, dubbing.SourcePath)
-				if err := os.Rename(fileAbsPath, absSourcePath); err != nil {
+				if targetFile.Type == FFprobeSourceTypeRecord {
+					if err := copyFile(fileAbsPath, absSourcePath); err != nil {
+						return errors.Wrapf(err, "copy %v to %v", fileAbsPath, absSourcePath)
+					}
+				} else if err := os.Rename(fileAbsPath, absSourcePath); err != nil {
 					return errors.Wrapf(err, "rename %v to %v", fileAbsPath, absSourcePath)
 				}
 			}
@@ -141,6 +156,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/list"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -153,7 +169,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -184,6 +200,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/remove"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, dubbingUUID string
@@ -197,7 +214,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -230,6 +247,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, dubbingUUID string
@@ -243,7 +261,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -268,6 +286,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -282,7 +301,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -315,6 +334,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/play"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			q := r.URL.Query()
@@ -332,7 +352,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, "", r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, "", r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -367,6 +387,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/export"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -382,7 +403,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -565,6 +586,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/task-start"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -579,7 +601,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -653,6 +675,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/task-rephrase"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -670,7 +693,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -769,6 +792,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/task-merge"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -787,7 +811,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -848,6 +872,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/task-query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -863,7 +888,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -900,6 +925,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/task-tts"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			q := r.URL.Query()
@@ -921,7 +947,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, "", r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, "", r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -965,6 +991,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/dubbing/source"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			type DubbingTempFile struct {
@@ -994,7 +1021,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1005,7 +1032,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 			// Always cleanup the files in upload.
 			var tempFiles []string
 			for _, f := range files {
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRecord {
 					tempFiles = append(tempFiles, f.Target)
 				}
 			}
@@ -1020,10 +1047,17 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 
 			// Check files.
 			for _, f := range files {
+				if f.Type == FFprobeSourceTypeRecord {
+					if _, target, err := RecordArtifactFile(ctx, f.UUID); err != nil {
+						return errors.Wrapf(err, "record %v", f.UUID)
+					} else {
+						f.Target = target
+					}
+				}
 				if f.Target == "" {
 					return errors.New("no target")
 				}
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRecord {
 					if _, err := os.Stat(f.Target); err != nil {
 						return errors.Wrapf(err, "no file %v", f.Target)
 					}
@@ -1109,7 +1143,7 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 					Target: file.Target, Type: file.Type,
 					Format: &format.Format, Video: matchVideo, Audio: matchAudio,
 				}
-				if file.Type != FFprobeSourceTypeStream {
+				if file.Type != FFprobeSourceTypeStream && file.Type != FFprobeSourceTypeRecord {
 					parsedFile.Target = path.Join(dirDubbingPath, fmt.Sprintf("%v%v", file.UUID, path.Ext(file.Target)))
 					if err = os.Rename(file.Target, parsedFile.Target); err != nil {
 						return errors.Wrapf(err, "rename %v to %v", file.Target, parsedFile.Target)
@@ -2245,7 +2279,8 @@ func (v *SrsDubbingProject) Save(ctx context.Context) error {
 }
 
 func (v *SrsDubbingProject) CheckSource(ctx context.Context, target string) error {
-	if v.FileType != FFprobeSourceTypeFile && v.FileType != FFprobeSourceTypeUpload && v.FileType != FFprobeSourceTypeYTDL {
+	if v.FileType != FFprobeSourceTypeFile && v.FileType != FFprobeSourceTypeUpload && v.FileType != FFprobeSourceTypeYTDL &&
+		v.FileType != FFprobeSourceTypeRecord {
 		return errors.Errorf("unsupported file type %v", v.FileType)
 	}
 
@@ -40,6 +40,9 @@ type RecordWorker struct {
 	msgs chan *SrsOnHlsObject
 	// The streams we're recording, key is m3u8 URL in string, value is m3u8 object *RecordM3u8Stream.
 	streams sync.Map
+	// The streams triggered to record, for example, by motion detection, key is stream URL /app/stream in
+	// string, value is the deadline in time.Time.
+	triggers sync.Map
 }
 
 func NewRecordWorker() *RecordWorker {
@@ -51,6 +54,7 @@ func NewRecordWorker() *RecordWorker {
 func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/hooks/record/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -63,7 +67,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -107,6 +111,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -121,7 +126,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -139,6 +144,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/globs"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -153,7 +159,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -180,6 +186,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/post-processing"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -195,7 +202,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -223,6 +230,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/remove"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, uuid string
@@ -236,7 +244,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -293,6 +301,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/end"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, uuid string
@@ -306,7 +315,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -332,6 +341,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/files"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -344,7 +354,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -515,6 +525,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/hooks/record/hls/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			if strings.HasSuffix(r.URL.Path, ".m3u8") {
@@ -534,6 +545,34 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 	return nil
 }
 
+// RecordArtifactFile returns the artifact and MP4 file of the finished record by UUID, which is used as a
+// source of vLive or dubbing.
+func RecordArtifactFile(ctx context.Context, uuid string) (*M3u8VoDArtifact, string, error) {
+	if uuid == "" || strings.ContainsAny(uuid, "/\\.") {
+		return nil, "", errors.Errorf("invalid record uuid %v", uuid)
+	}
+
+	var artifact M3u8VoDArtifact
+	if b, err := rdb.HGet(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Result(); err != nil && err != redis.Nil {
+		return nil, "", errors.Wrapf(err, "hget %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+	} else if b == "" {
+		return nil, "", errors.Errorf("no record for uuid=%v", uuid)
+	} else if err = json.Unmarshal([]byte(b), &artifact); err != nil {
+		return nil, "", errors.Wrapf(err, "parse %v", b)
+	}
+
+	if artifact.Processing {
+		return nil, "", errors.Errorf("record %v is processing", uuid)
+	}
+
+	mp4File := path.Join("record", uuid, "index.mp4")
+	if _, err := os.Stat(mp4File); err != nil {
+		return nil, "", errors.Wrapf(err, "no mp4 file %v", mp4File)
+	}
+
+	return &artifact, mp4File, nil
+}
+
 func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
 	// Copy the ts file to temporary cache dir.
 	tsid := uuid.NewString()
@@ -571,6 +610,27 @@ func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage)
 	return nil
 }
 
+// Trigger to record the stream until deadline, even if record is not enabled, or the stream is not matched
+// by the glob filters. Extend the deadline if already triggered.
+func (v *RecordWorker) Trigger(stream string, until time.Time) {
+	if deadline, loaded := v.triggers.Load(stream); loaded && deadline.(time.Time).After(until) {
+		return
+	}
+	v.triggers.Store(stream, until)
+}
+
+// Triggered whether the stream is triggered to record and not expired.
+func (v *RecordWorker) Triggered(app, stream string) bool {
+	key := fmt.Sprintf("/%v/%v", app, stream)
+	if deadline, loaded := v.triggers.Load(key); !loaded {
+		return false
+	} else if time.Now().After(deadline.(time.Time)) {
+		v.triggers.Delete(key)
+		return false
+	}
+	return true
+}
+
 func (v *RecordWorker) Close() error {
 	if v.cancel != nil {
 		v.cancel()
@@ -644,8 +704,9 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 			}
 		}
 
-		// If glob filters are empty, ignore it, and record all streams.
-		if len(globFilters) > 0 {
+		// If glob filters are empty, ignore it, and record all streams. The triggered stream is always
+		// recorded, for example, by motion detection.
+		if len(globFilters) > 0 && !v.Triggered(msg.Msg.App, msg.Msg.Stream) {
 			var globMatched bool
 			streamURL := fmt.Sprintf("/%v/%v", msg.Msg.App, msg.Msg.Stream)
 			for _, globFilter := range globFilters {
@@ -1094,15 +1155,23 @@ func (v *RecordM3u8Stream) callbackBegin(ctx context.Context) (*SrsOnHlsObject,
 
 	message := messages[0]
 	if err := callbackWorker.OnRecordMessage(ctx, SrsActionOnRecordBegin, v.UUID, message.Msg, nil); err != nil {
-		return message, errors.Wrapf(err, "on record end %v", message)
+		return message, errors.Wrapf(err, "on record begin %v", message)
 	}
 
 	return message, nil
 }
 
 func (v *RecordM3u8Stream) callbackEnd(ctx context.Context, message *SrsOnHlsObject) error {
+	// When the task is reloaded from redis after restart, all messages might have been consumed, so we
+	// build the stream information from the artifact, to make sure the end event is never dropped.
 	if message == nil {
-		return nil
+		if v.artifact == nil || v.artifact.Stream == "" {
+			return nil
+		}
+
+		message = &SrsOnHlsObject{Msg: &SrsOnHlsMessage{
+			Vhost: v.artifact.Vhost, App: v.artifact.App, Stream: v.artifact.Stream, M3u8URL: v.M3u8URL,
+		}}
 	}
 
 	if err := callbackWorker.OnRecordMessage(ctx, SrsActionOnRecordEnd, v.UUID, message.Msg, v.artifact); err != nil {
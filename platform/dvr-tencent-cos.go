@@ -57,6 +57,7 @@ func (v *DvrWorker) ready() bool {
 func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/hooks/dvr/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -69,7 +70,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -99,6 +100,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/dvr/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -113,7 +115,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -131,6 +133,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/dvr/files"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -143,7 +146,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -192,6 +195,7 @@ func (v *DvrWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/dvr/hls/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			// Format is :uuid.m3u8 or :uuid/index.m3u8
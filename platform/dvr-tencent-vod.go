@@ -61,6 +61,7 @@ func (v *VodWorker) ready() bool {
 func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/hooks/vod/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -73,7 +74,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -112,6 +113,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/vod/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -126,7 +128,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -144,6 +146,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/vod/files"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -156,7 +159,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -282,6 +285,7 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/vod/hls/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			// Format is :uuid.m3u8 or :uuid/index.m3u8
@@ -0,0 +1,887 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The image files allowed as filler, which is looped as a slate.
+var fallbackAllowImageFiles []string = []string{".png", ".jpg", ".jpeg"}
+
+var fallbackWorker *FallbackWorker
+
+// FallbackWorker keeps a stable output stream for the source stream, which relays the source stream when
+// it's publishing, or publishes the filler when the publisher drops, so the downstream such as forwarding
+// always gets a continuous feed. The stable stream is published by a long-lived FFmpeg, which transcodes
+// the input relayed by another FFmpeg, so only the relay is restarted when switching input.
+type FallbackWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The fallback tasks, key is the source stream in /app/stream, value is *FallbackTask.
+	tasks sync.Map
+}
+
+func NewFallbackWorker() *FallbackWorker {
+	return &FallbackWorker{}
+}
+
+func (v *FallbackWorker) GetTask(stream string) *FallbackTask {
+	if task, loaded := v.tasks.Load(stream); loaded {
+		return task.(*FallbackTask)
+	}
+	return nil
+}
+
+// OnStreamMessage switches the input of fallback task immediately, when the source stream is published or
+// unpublished.
+func (v *FallbackWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) {
+	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
+		return
+	}
+
+	source := fmt.Sprintf("/%v/%v", streamObj.App, streamObj.Stream)
+	if task := v.GetTask(source); task != nil {
+		logger.Tf(ctx, "fallback: Switch input for action=%v, stream=%v", action, source)
+		task.Restart(ctx)
+	}
+}
+
+func (v *FallbackWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/fallback/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			res := make([]map[string]interface{}, 0)
+			if configs, err := rdb.HGetAll(ctx, SRS_FALLBACK_CONFIG).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_FALLBACK_CONFIG)
+			} else {
+				for k, v := range configs {
+					var config FallbackConfig
+					if err = json.Unmarshal([]byte(v), &config); err != nil {
+						return errors.Wrapf(err, "unmarshal %v %v", k, v)
+					}
+
+					elem := map[string]interface{}{
+						"stream":  config.Stream,
+						"output":  config.Output,
+						"enabled": config.Enabled,
+						"filler":  config.Filler,
+						"audio":   config.Audio,
+					}
+
+					if task := fallbackWorker.GetTask(config.Stream); task != nil {
+						pid, live, switches, frame, update := task.queryFrame()
+						if pid > 0 {
+							elem["live"] = live
+							elem["switches"] = switches
+							elem["frame"] = map[string]string{
+								"log":    frame,
+								"update": update,
+							}
+						}
+					}
+
+					res = append(res, elem)
+				}
+			}
+
+			sort.Slice(res, func(i, j int) bool {
+				return res[i]["stream"].(string) < res[j]["stream"].(string)
+			})
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "fallback: Query ok, streams=%v, token=%vB", len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/fallback/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, action string
+			var userConf FallbackConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Action *string `json:"action"`
+				*FallbackConfig
+			}{
+				Token: &token, Action: &action, FallbackConfig: &userConf,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			allowedActions := []string{"update", "remove"}
+			if !slicesContains(allowedActions, action) {
+				return errors.Errorf("invalid action=%v", action)
+			}
+			if userConf.Stream == "" {
+				return errors.New("no stream")
+			}
+
+			var targetConf FallbackConfig
+			if b, err := rdb.HGet(ctx, SRS_FALLBACK_CONFIG, userConf.Stream).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_FALLBACK_CONFIG, userConf.Stream)
+			} else if b != "" {
+				if err = json.Unmarshal([]byte(b), &targetConf); err != nil {
+					return errors.Wrapf(err, "unmarshal %v", b)
+				}
+			}
+
+			if action == "remove" {
+				if err := rdb.HDel(ctx, SRS_FALLBACK_CONFIG, userConf.Stream).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_FALLBACK_CONFIG, userConf.Stream)
+				}
+				targetConf.removeFiles(nil)
+
+				// Stop the task, which quits because no config.
+				if task := fallbackWorker.GetTask(userConf.Stream); task != nil {
+					task.Restart(ctx)
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "fallback: Remove ok, stream=%v, token=%vB", userConf.Stream, len(token))
+				return nil
+			}
+
+			// Use the uploaded filler files, which are moved after validated, or keep the current files.
+			uploads := make(map[string]string)
+			for _, file := range []*FFprobeSource{userConf.Filler, userConf.Audio} {
+				if file == nil || !strings.HasPrefix(path.Clean(file.Target), dirUploadPath+"/") {
+					continue
+				}
+				if _, err := os.Stat(file.Target); err != nil {
+					return errors.Wrapf(err, "no file %v", file.Target)
+				}
+				if file.UUID == "" {
+					file.UUID = uuid.NewString()
+				}
+
+				target := path.Join(dirFallbackPath, fmt.Sprintf("%v%v", file.UUID, path.Ext(file.Target)))
+				uploads[target] = path.Clean(file.Target)
+				file.Target, file.Type = target, FFprobeSourceTypeFile
+			}
+
+			if err := userConf.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", userConf.String())
+			}
+
+			for target, upload := range uploads {
+				if err := os.Rename(upload, target); err != nil {
+					return errors.Wrapf(err, "rename %v to %v", upload, target)
+				}
+			}
+
+			if b, err := json.Marshal(&userConf); err != nil {
+				return errors.Wrapf(err, "marshal %v", userConf.String())
+			} else if err = rdb.HSet(ctx, SRS_FALLBACK_CONFIG, userConf.Stream, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_FALLBACK_CONFIG, userConf.Stream, string(b))
+			}
+			targetConf.removeFiles(&userConf)
+
+			// Restart the task if exists, to use the new config.
+			if task := fallbackWorker.GetTask(userConf.Stream); task != nil {
+				task.Restart(ctx)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "fallback: Update ok, config=%v, token=%vB", userConf.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *FallbackWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *FallbackWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "fallback: Start a worker")
+
+	// Load tasks from redis and force to kill all.
+	if objs, err := rdb.HGetAll(ctx, SRS_FALLBACK_TASK).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_FALLBACK_TASK)
+	} else if len(objs) > 0 {
+		for uuid, obj := range objs {
+			logger.Tf(ctx, "fallback: Load task %v object %v", uuid, obj)
+
+			var task FallbackTask
+			if err = json.Unmarshal([]byte(obj), &task); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
+			}
+
+			if task.PID > 0 {
+				task.cleanup(ctx)
+			}
+			if task.PublisherPID > 0 {
+				task.cleanupPublisher(ctx)
+			}
+		}
+
+		if err = rdb.Del(ctx, SRS_FALLBACK_TASK).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "del %v", SRS_FALLBACK_TASK)
+		}
+	}
+
+	// Load all configurations from redis, start a task for each enabled stream.
+	loadTasks := func() error {
+		configs, err := rdb.HGetAll(ctx, SRS_FALLBACK_CONFIG).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_FALLBACK_CONFIG)
+		}
+
+		for stream, b := range configs {
+			var config FallbackConfig
+			if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", stream, b)
+			}
+			if !config.Enabled {
+				continue
+			}
+
+			task := &FallbackTask{UUID: uuid.NewString(), Stream: stream, fallbackWorker: v}
+			if _, loaded := v.tasks.LoadOrStore(stream, task); loaded {
+				continue
+			}
+			logger.Tf(ctx, "fallback: Create stream=%v task is %v", stream, task.String())
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// Remove the task when done, the worker will start it again if required.
+				defer task.remove(ctx)
+
+				if err := task.Run(ctx); err != nil {
+					logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			duration := 3 * time.Second
+			if err := loadTasks(); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 10 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// FallbackConfig is the configure of fallback for a source stream.
+type FallbackConfig struct {
+	// The source stream in /app/stream, for example, /live/livestream
+	Stream string `json:"stream"`
+	// The stable output stream in /app/stream, for example, /live/livestream-stable
+	Output string `json:"output"`
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The filler file to publish when the publisher drops, a video file, or an image as a slate.
+	Filler *FFprobeSource `json:"filler"`
+	// The audio file for the image filler, optional, use silence if not set.
+	Audio *FFprobeSource `json:"audio"`
+}
+
+func (v FallbackConfig) String() string {
+	return fmt.Sprintf("stream=%v, output=%v, enabled=%v, filler=(%v), audio=(%v)",
+		v.Stream, v.Output, v.Enabled, v.Filler, v.Audio,
+	)
+}
+
+func (v *FallbackConfig) Validate() error {
+	isStream := func(stream string) bool {
+		parts := strings.Split(stream, "/")
+		return len(parts) == 3 && parts[0] == "" && parts[1] != "" && parts[2] != "" && path.Clean(stream) == stream
+	}
+	if !isStream(v.Stream) {
+		return errors.Errorf("invalid stream %v, should be /app/stream", v.Stream)
+	}
+	if !isStream(v.Output) {
+		return errors.Errorf("invalid output %v, should be /app/stream", v.Output)
+	}
+	if v.Output == v.Stream {
+		return errors.Errorf("output should not be the stream %v", v.Stream)
+	}
+
+	if v.Filler == nil || v.Filler.Target == "" {
+		return errors.New("no filler")
+	}
+	for _, file := range []*FFprobeSource{v.Filler, v.Audio} {
+		if file != nil && !strings.HasPrefix(file.Target, dirFallbackPath+"/") {
+			return errors.Errorf("invalid target %v, should be in %v", file.Target, dirFallbackPath)
+		}
+	}
+	ext := strings.ToLower(path.Ext(v.Filler.Target))
+	if !slicesContains(append(serverAllowVideoFiles, fallbackAllowImageFiles...), ext) {
+		return errors.Errorf("invalid filler %v, should be %v", v.Filler.Target,
+			append(serverAllowVideoFiles, fallbackAllowImageFiles...))
+	}
+
+	if v.Audio != nil {
+		if !v.IsImage() {
+			return errors.Errorf("audio %v is only for image filler", v.Audio.Target)
+		}
+		if ext := strings.ToLower(path.Ext(v.Audio.Target)); !slicesContains(serverAllowAudioFiles, ext) {
+			return errors.Errorf("invalid audio %v, should be %v", v.Audio.Target, serverAllowAudioFiles)
+		}
+	}
+
+	return nil
+}
+
+// IsImage whether the filler is an image, which is looped as a slate.
+func (v *FallbackConfig) IsImage() bool {
+	return v.Filler != nil && slicesContains(fallbackAllowImageFiles, strings.ToLower(path.Ext(v.Filler.Target)))
+}
+
+// OutputURL returns the RTMP URL of the stable output stream, with the publish secret if not empty.
+func (v *FallbackConfig) OutputURL(secret string) string {
+	if secret == "" {
+		return fmt.Sprintf("rtmp://localhost%v", v.Output)
+	}
+	return fmt.Sprintf("rtmp://localhost%v?secret=%v", v.Output, url.QueryEscape(secret))
+}
+
+// PublisherArgs returns the FFmpeg arguments to publish the relay to the stable output stream. Because the
+// input switches between the source stream and filler, which have different codec parameters and
+// timestamps, the relay is always transcoded to the same size by wall clock.
+func (v *FallbackConfig) PublisherArgs(relay, outputURL string) []string {
+	return []string{
+		"-fflags", "+genpts", "-use_wallclock_as_timestamps", "1",
+		"-i", fmt.Sprintf("%v?overrun_nonfatal=1&fifo_size=50000000", relay),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2:color=black,setsar=1,fps=25",
+		"-af", "aresample=async=1",
+		"-vcodec", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-g", "50", "-bf", "0",
+		"-acodec", "aac", "-ar", "44100", "-ac", "2", "-b:a", "128k",
+		"-f", "flv", outputURL,
+	}
+}
+
+// FillerArgs returns the FFmpeg arguments to publish the filler in loop. The filler is always transcoded
+// to H.264 and AAC, because it's played as a part of the stable stream.
+func (v *FallbackConfig) FillerArgs() []string {
+	var args []string
+	if v.IsImage() {
+		args = append(args, "-re", "-loop", "1", "-framerate", "25", "-i", v.Filler.Target)
+		if v.Audio != nil {
+			args = append(args, "-stream_loop", "-1", "-re", "-i", v.Audio.Target)
+		} else {
+			args = append(args, "-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo")
+		}
+		args = append(args, "-map", "0:v", "-map", "1:a", "-tune", "stillimage")
+	} else {
+		args = append(args, "-stream_loop", "-1", "-re", "-i", v.Filler.Target)
+	}
+
+	return append(args,
+		"-vcodec", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-r", "25", "-g", "50", "-bf", "0",
+		"-acodec", "aac", "-b:a", "128k",
+	)
+}
+
+// removeFiles removes the filler files, which are not used by the new configure.
+func (v *FallbackConfig) removeFiles(next *FallbackConfig) {
+	var used []string
+	if next != nil {
+		for _, file := range []*FFprobeSource{next.Filler, next.Audio} {
+			if file != nil {
+				used = append(used, file.Target)
+			}
+		}
+	}
+
+	for _, file := range []*FFprobeSource{v.Filler, v.Audio} {
+		if file == nil || slicesContains(used, file.Target) || !strings.HasPrefix(file.Target, dirFallbackPath) {
+			continue
+		}
+		if _, err := os.Stat(file.Target); err == nil {
+			os.Remove(file.Target)
+		}
+	}
+}
+
+// FallbackTask is a task for FFmpeg to publish the stable output stream, from source stream or filler.
+type FallbackTask struct {
+	// The ID for task.
+	UUID string `json:"uuid"`
+	// The source stream in /app/stream.
+	Stream string `json:"stream"`
+
+	// The input url or filler file.
+	Input string `json:"input"`
+	// The local UDP url to relay the input to publisher.
+	Relay string `json:"relay"`
+	// The output url
+	Output string `json:"output"`
+
+	// FFmpeg pid, to relay the input.
+	PID int32 `json:"pid"`
+	// FFmpeg pid, to publish the stable output stream.
+	PublisherPID int32 `json:"publisherPid"`
+	// Whether relay the live stream, or publish the filler.
+	live bool
+	// The number of switches between live stream and filler.
+	switches int
+	// FFmpeg last frame.
+	frame string
+	// The last update time.
+	update time.Time
+
+	// The context for current relay.
+	cancel context.CancelFunc
+	// The context for current publisher.
+	publisherCancel context.CancelFunc
+
+	// The configure for fallback task.
+	config FallbackConfig
+	// The output url with publish secret of config.
+	outputURL string
+	// The fallback worker.
+	fallbackWorker *FallbackWorker
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func (v *FallbackTask) String() string {
+	return fmt.Sprintf("uuid=%v, stream=%v, input=%v, relay=%v, output=%v, pid=%v, publisher=%v, live=%v, switches=%v, config is %v",
+		v.UUID, v.Stream, v.Input, v.Relay, v.Output, v.PID, v.PublisherPID, v.live, v.switches, v.config.String(),
+	)
+}
+
+// remove the task from worker and redis, when task is done.
+func (v *FallbackTask) remove(ctx context.Context) {
+	v.fallbackWorker.tasks.Delete(v.Stream)
+
+	if err := rdb.HDel(ctx, SRS_FALLBACK_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "ignore hdel %v %v err %+v", SRS_FALLBACK_TASK, v.UUID, err)
+	}
+}
+
+// Restart the relay to switch the input, while the publisher keeps running.
+func (v *FallbackTask) Restart(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.cancel != nil {
+		v.cancel()
+	}
+
+	return nil
+}
+
+func (v *FallbackTask) Run(ctx context.Context) error {
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "fallback: Run task %v", v.String())
+
+	// Allocate a local UDP port to relay the input to publisher.
+	if conn, err := net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		return errors.Wrapf(err, "listen udp")
+	} else {
+		v.Relay = fmt.Sprintf("udp://127.0.0.1:%v", conn.LocalAddr().(*net.UDPAddr).Port)
+		conn.Close()
+	}
+
+	// Start the publisher, which lives as long as the task, and restarts only when failed or output changed.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.doPublish(ctx); err != nil {
+				logger.Wf(ctx, "ignore publish %v err %+v", v.String(), err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+
+	// Whether the source stream is publishing.
+	isPublishing := func() (bool, error) {
+		streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+		if err != nil && err != redis.Nil {
+			return false, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+		}
+
+		for _, value := range streams {
+			var stream SrsStream
+			if err := json.Unmarshal([]byte(value), &stream); err != nil {
+				return false, errors.Wrapf(err, "unmarshal %v", value)
+			}
+
+			if fmt.Sprintf("/%v/%v", stream.App, stream.Stream) == v.Stream {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	// Whether the task should quit, for example, the config is removed or disabled.
+	var quit bool
+
+	pfn := func(ctx context.Context) error {
+		var config FallbackConfig
+		if b, err := rdb.HGet(ctx, SRS_FALLBACK_CONFIG, v.Stream).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_FALLBACK_CONFIG, v.Stream)
+		} else if b == "" {
+			quit = true
+			return nil
+		} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		if !config.Enabled {
+			quit = true
+			return nil
+		}
+
+		secret, err := publishSecretOf(ctx, config.Output)
+		if err != nil {
+			return errors.Wrapf(err, "query secret of %v", config.Output)
+		}
+
+		// Restart the publisher if the output changed, for example, the stream or secret is updated.
+		v.lock.Lock()
+		v.config, v.outputURL = config, config.OutputURL(secret)
+		if v.publisherCancel != nil && v.Output != "" && v.Output != v.outputURL {
+			v.publisherCancel()
+		}
+		v.lock.Unlock()
+
+		live, err := isPublishing()
+		if err != nil {
+			return errors.Wrapf(err, "query stream")
+		}
+
+		if err := v.doFallback(ctx, live); err != nil {
+			return errors.Wrapf(err, "do fallback")
+		}
+
+		return nil
+	}
+
+	for ctx.Err() == nil && !quit {
+		if err := pfn(ctx); err != nil {
+			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(3500 * time.Millisecond):
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	return nil
+}
+
+func (v *FallbackTask) doFallback(ctx context.Context, live bool) error {
+	// Create context for current task.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	v.lock.Lock()
+	v.cancel = cancel
+	v.lock.Unlock()
+
+	// Build input URL, the source stream or the filler.
+	input := v.config.Filler.Target
+	if live {
+		input = withInternalPlay(fmt.Sprintf("rtmp://localhost%v", v.Stream))
+	}
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Start FFmpeg process, relay the input to publisher.
+	var args []string
+	if live {
+		args = append(args, "-i", input, "-c", "copy")
+	} else {
+		args = append(args, v.config.FillerArgs()...)
+	}
+	args = append(args, "-f", "mpegts", fmt.Sprintf("%v?pkt_size=1316", v.Relay))
+	// Create the command object.
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	if v.Input != "" && v.live != live {
+		v.switches++
+	}
+	v.PID, v.live, v.Input = int32(cmd.Process.Pid), live, input
+	v.lock.Unlock()
+	defer func() {
+		// If we got a PID, sleep for a while, to avoid too fast restart.
+		if v.PID > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanup(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "fallback: Start, stream=%v, live=%v, input=%v, relay=%v, pid=%v",
+		v.Stream, live, input, v.Relay, v.PID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Drop the log frame, we use the frame of publisher.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.FrameLogs:
+			}
+		}
+	}()
+
+	// Process terminated, or user cancel the process, or switch the input.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "fallback: Cycle stopping, stream=%v, live=%v, pid=%v", v.Stream, live, v.PID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "fallback: Cycle done, stream=%v, live=%v, pid=%v, err=%v", v.Stream, live, v.PID, err)
+	return err
+}
+
+func (v *FallbackTask) doPublish(ctx context.Context) error {
+	// Create context for current publisher.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	v.lock.Lock()
+	v.publisherCancel = cancel
+	relay, outputURL, config := v.Relay, v.outputURL, v.config
+	v.lock.Unlock()
+
+	// Wait for the config to be loaded.
+	if outputURL == "" {
+		return nil
+	}
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Start FFmpeg process.
+	args := config.PublisherArgs(relay, outputURL)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	v.PublisherPID, v.Output = int32(cmd.Process.Pid), outputURL
+	v.lock.Unlock()
+	defer func() {
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanupPublisher(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "fallback: Start publisher, stream=%v, relay=%v, output=%v, pid=%v",
+		v.Stream, relay, outputURL, v.PublisherPID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Pull the latest log frame.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-heartbeat.FrameLogs:
+				v.updateFrame(frame)
+			}
+		}
+	}()
+
+	// Process terminated, or task is done, or output changed.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "fallback: Publisher stopping, stream=%v, pid=%v", v.Stream, v.PublisherPID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "fallback: Publisher done, stream=%v, pid=%v, err=%v", v.Stream, v.PublisherPID, err)
+	return err
+}
+
+func (v *FallbackTask) updateFrame(frame string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.frame = strings.TrimSpace(frame)
+	v.update = time.Now()
+}
+
+func (v *FallbackTask) queryFrame() (int32, bool, int, string, string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.PublisherPID, v.live, v.switches, v.frame, v.update.Format(time.RFC3339)
+}
+
+func (v *FallbackTask) saveTask(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_FALLBACK_TASK, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_FALLBACK_TASK, v.UUID, string(b))
+	}
+
+	return nil
+}
+
+func (v *FallbackTask) cleanup(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill task pid=%v", v.PID)
+	syscall.Kill(int(v.PID), syscall.SIGKILL)
+
+	v.PID = 0
+	v.cancel = nil
+
+	return nil
+}
+
+func (v *FallbackTask) cleanupPublisher(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PublisherPID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill publisher pid=%v", v.PublisherPID)
+	syscall.Kill(int(v.PublisherPID), syscall.SIGKILL)
+
+	v.PublisherPID = 0
+	v.publisherCancel = nil
+
+	return nil
+}
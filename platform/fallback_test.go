@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFallback_Validate(t *testing.T) {
+	newFile := func(name string) *FFprobeSource {
+		return &FFprobeSource{Target: dirFallbackPath + "/" + name}
+	}
+
+	for _, e := range []struct {
+		config FallbackConfig
+		valid  bool
+	}{
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable", Filler: newFile("a.mp4")}, valid: true},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable", Filler: newFile("a.png"),
+			Audio: newFile("a.mp3")}, valid: true},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable", Filler: newFile("a.mp4"),
+			Audio: newFile("a.mp3")}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable", Filler: newFile("a.png"),
+			Audio: newFile("a.mp4")}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable", Filler: newFile("a.txt")}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable"}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/stable",
+			Filler: &FFprobeSource{Target: "/etc/a.mp4"}}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/livestream", Filler: newFile("a.mp4")}, valid: false},
+		{config: FallbackConfig{Stream: "livestream", Output: "/live/stable", Filler: newFile("a.mp4")}, valid: false},
+		{config: FallbackConfig{Stream: "/live/livestream", Output: "/live/../stable", Filler: newFile("a.mp4")}, valid: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.valid, err)
+		}
+	}
+}
+
+func TestFallback_FillerArgs(t *testing.T) {
+	for _, e := range []struct {
+		config FallbackConfig
+		args   string
+	}{
+		{config: FallbackConfig{Filler: &FFprobeSource{Target: "a.mp4"}},
+			args: "-stream_loop -1 -re -i a.mp4"},
+		{config: FallbackConfig{Filler: &FFprobeSource{Target: "a.png"}},
+			args: "-re -loop 1 -framerate 25 -i a.png -f lavfi -i anullsrc=r=44100:cl=stereo -map 0:v -map 1:a -tune stillimage"},
+		{config: FallbackConfig{Filler: &FFprobeSource{Target: "a.JPG"}, Audio: &FFprobeSource{Target: "a.mp3"}},
+			args: "-re -loop 1 -framerate 25 -i a.JPG -stream_loop -1 -re -i a.mp3 -map 0:v -map 1:a -tune stillimage"},
+	} {
+		if args := strings.Join(e.config.FillerArgs(), " "); !strings.HasPrefix(args, e.args+" -vcodec libx264 -preset veryfast") {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.args, args)
+		}
+	}
+}
+
+func TestFallback_PublisherArgs(t *testing.T) {
+	config := FallbackConfig{Output: "/live/stable"}
+	for _, e := range []struct {
+		secret string
+		output string
+	}{
+		{secret: "", output: "rtmp://localhost/live/stable"},
+		{secret: "xxx", output: "rtmp://localhost/live/stable?secret=xxx"},
+	} {
+		if output := config.OutputURL(e.secret); output != e.output {
+			t.Errorf("Fail for secret %v, expect %v, actual %v", e.secret, e.output, output)
+		}
+	}
+
+	// The publisher always transcodes the relay, because the input switches between stream and filler.
+	args := strings.Join(config.PublisherArgs("udp://127.0.0.1:5000", config.OutputURL("xxx")), " ")
+	for _, expect := range []string{
+		"-use_wallclock_as_timestamps 1 -i udp://127.0.0.1:5000?overrun_nonfatal=1",
+		"-vcodec libx264 -preset veryfast", "-acodec aac", "-f flv rtmp://localhost/live/stable?secret=xxx",
+	} {
+		if !strings.Contains(args, expect) {
+			t.Errorf("Fail for args %v, expect %v", args, expect)
+		}
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"path"
 	"sort"
 	"strings"
 	"sync"
@@ -27,11 +30,22 @@ import (
 
 var forwardWorker *ForwardWorker
 
+// The default number of consecutive failures to fail over to the backup destination.
+const ForwardMaxFailures = 3
+
+// The interval to probe the primary destination to fail back, when using the backup destination.
+const ForwardFailbackInterval = 30 * time.Second
+
+// The hold-down period to fail back, the primary destination should keep reachable for this period, to avoid
+// flapping between the primary and backup destinations.
+const ForwardFailbackHoldDown = 2 * time.Minute
+
 type ForwardWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The tasks we have started to forward streams,, key is platform in string, value is *ForwardTask.
+	// The tasks we have started to forward streams, key is the pair of platform, source stream and destination
+	// in string, see ForwardTask.Key, value is *ForwardTask.
 	tasks sync.Map
 }
 
@@ -39,16 +53,26 @@ func NewForwardWorker() *ForwardWorker {
 	return &ForwardWorker{}
 }
 
-func (v *ForwardWorker) GetTask(platform string) *ForwardTask {
-	if task, loaded := v.tasks.Load(platform); loaded {
-		return task.(*ForwardTask)
-	}
-	return nil
+// GetTasks returns the tasks of platform, sorted by the source stream and destination.
+func (v *ForwardWorker) GetTasks(platform string) []*ForwardTask {
+	var tasks []*ForwardTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*ForwardTask); task.Platform == platform {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Key() < tasks[j].Key()
+	})
+	return tasks
 }
 
 func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/forward/secret"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, action string
@@ -64,7 +88,7 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -84,11 +108,36 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 					return errors.Errorf("invalid platform=%v", userConf.Platform)
 				}
 
-				if userConf.Server == "" {
+				if userConf.Server == "" && len(userConf.Destinations) == 0 {
 					return errors.New("no server")
 				}
-				if userConf.Server == "" && userConf.Secret == "" {
-					return errors.New("no secret")
+				for _, glob := range userConf.Streams {
+					if _, err := path.Match(glob, ""); err != nil {
+						return errors.Wrapf(err, "invalid stream glob %v", glob)
+					}
+				}
+				if userConf.ServerProfile != nil {
+					if err := userConf.ServerProfile.Validate(); err != nil {
+						return errors.Wrapf(err, "invalid profile %v", userConf.ServerProfile.String())
+					}
+				}
+				for _, destination := range userConf.Destinations {
+					if destination == nil || !strings.Contains(destination.URL, "://") {
+						return errors.Errorf("invalid destination %v", destination)
+					}
+					if destination.Profile != nil {
+						if err := destination.Profile.Validate(); err != nil {
+							return errors.Wrapf(err, "invalid profile %v", destination.Profile.String())
+						}
+					}
+				}
+				for _, backup := range userConf.Backups {
+					if !strings.Contains(backup, "://") {
+						return errors.Errorf("invalid backup %v", backup)
+					}
+				}
+				if userConf.MaxFailures < 0 {
+					return errors.Errorf("invalid maxFailures %v", userConf.MaxFailures)
 				}
 			}
 
@@ -112,7 +161,7 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 				}
 
 				// Restart the forwarding if exists.
-				if task := v.GetTask(userConf.Platform); task != nil {
+				for _, task := range v.GetTasks(userConf.Platform) {
 					if err := task.Restart(ctx); err != nil {
 						return errors.Wrapf(err, "restart task %v", userConf.String())
 					}
@@ -146,6 +195,7 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 
 	ep = "/terraform/v1/ffmpeg/forward/streams"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -158,7 +208,7 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -172,10 +222,27 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 						return errors.Wrapf(err, "unmarshal %v %v", k, configItem)
 					}
 
-					var pid int32
-					var streamURL, frame, update, starttime, ready string
-					if task := v.GetTask(config.Platform); task != nil {
-						pid, streamURL, frame, update, starttime, ready = task.queryFrame()
+					// The status of each running pair of source stream and destination.
+					pairs := make([]map[string]interface{}, 0)
+					for _, task := range v.GetTasks(config.Platform) {
+						pid, streamURL, output, frame, update, starttime, ready := task.queryFrame()
+						if pid <= 0 {
+							continue
+						}
+
+						active, failovers := task.queryFailover()
+						pairs = append(pairs, map[string]interface{}{
+							"stream":      streamURL,
+							"destination": output,
+							"active":      active,
+							"failovers":   failovers,
+							"start":       starttime,
+							"ready":       ready,
+							"frame": map[string]string{
+								"log":    frame,
+								"update": update,
+							},
+						})
 					}
 
 					elem := map[string]interface{}{
@@ -183,15 +250,14 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 						"enabled":  config.Enabled,
 						"custom":   config.Customed,
 						"label":    config.Label,
+						"streams":  config.Streams,
+						"pairs":    pairs,
 					}
 
-					if pid > 0 {
-						elem["stream"] = streamURL
-						elem["start"] = starttime
-						elem["ready"] = ready
-						elem["frame"] = map[string]string{
-							"log":    frame,
-							"update": update,
+					// For compatibility, the first pair is also the status of platform.
+					if len(pairs) > 0 {
+						for _, k := range []string{"stream", "start", "ready", "frame"} {
+							elem[k] = pairs[0][k]
 						}
 					}
 
@@ -263,41 +329,83 @@ func (v *ForwardWorker) Start(ctx context.Context) error {
 			return nil
 		}
 
-		for platform, configItem := range configItems {
-			var config ForwardConfigure
-			if err = json.Unmarshal([]byte(configItem), &config); err != nil {
-				return errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+		// Load the active streams, to route to destinations.
+		streamItems, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+		}
+
+		var streams []*SrsStream
+		for k, streamItem := range streamItems {
+			var stream SrsStream
+			if err = json.Unmarshal([]byte(streamItem), &stream); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", k, streamItem)
 			}
+			streams = append(streams, &stream)
+		}
 
+		startTask := func(config ForwardConfigure, source, destination string) error {
 			var task *ForwardTask
-			if tv, loaded := v.tasks.LoadOrStore(config.Platform, &ForwardTask{
-				UUID:     uuid.NewString(),
-				Platform: config.Platform,
-				config:   &config,
+			if tv, loaded := v.tasks.LoadOrStore(forwardTaskKey(config.Platform, source, destination), &ForwardTask{
+				UUID:        uuid.NewString(),
+				Platform:    config.Platform,
+				Source:      source,
+				Destination: destination,
+				config:      &config,
 			}); loaded {
 				// Ignore if exists.
-				continue
+				return nil
 			} else {
 				task = tv.(*ForwardTask)
-				logger.Tf(ctx, "Forward create platform=%v task is %v", platform, task.String())
+				logger.Tf(ctx, "Forward create platform=%v task is %v", config.Platform, task.String())
 			}
 
 			// Initialize object.
 			if err := task.Initialize(ctx, v); err != nil {
+				v.tasks.Delete(task.Key())
 				return errors.Wrapf(err, "init %v", task.String())
 			}
 
-			// Store in memory object.
-			v.tasks.Store(platform, task)
-
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 
+				// Remove the task when done, the worker will start it again if required.
+				defer task.remove(ctx)
+
 				if err := task.Run(ctx); err != nil {
 					logger.Wf(ctx, "run task %v err %+v", task.String(), err)
 				}
 			}()
+			return nil
+		}
+
+		for platform, configItem := range configItems {
+			var config ForwardConfigure
+			if err = json.Unmarshal([]byte(configItem), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+			}
+
+			// Without routing rules, select the stream when running, so there is only one source. With routing
+			// rules, each matched active stream is a source.
+			sources := []string{""}
+			if config.Routed() {
+				sources = nil
+				for _, stream := range streams {
+					if source := fmt.Sprintf("/%v/%v", stream.App, stream.Stream); config.Enabled && config.Match(source) {
+						sources = append(sources, source)
+					}
+				}
+			}
+
+			// Start a task for each pair of source stream and destination.
+			for _, source := range sources {
+				for _, destination := range config.Outputs() {
+					if err := startTask(config, source, destination); err != nil {
+						return errors.Wrapf(err, "start platform=%v, source=%v", platform, source)
+					}
+				}
+			}
 		}
 
 		return nil
@@ -347,14 +455,74 @@ type ForwardConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// The routing rules, the globs of source streams in /app/stream, for example, /live/*. Each matched active
+	// stream is forwarded to all destinations. If empty, forward the stream specified by Stream, or the latest
+	// active stream.
+	Streams []string `json:"streams,omitempty"`
+	// The extra destinations, besides the Server and Secret, each with its own encoding profile.
+	Destinations []*ForwardDestination `json:"destinations,omitempty"`
+	// The encoding profile to transcode for the Server and Secret, also for its backups, nil to copy the stream.
+	ServerProfile *ForwardProfile `json:"serverProfile,omitempty"`
+	// The ordered backup URLs of the Server and Secret, for example, the backup RTMP server of platform. Fail
+	// over to the next backup if the active one fails, and fail back when the primary recovers, see
+	// ForwardFailbackHoldDown.
+	Backups []string `json:"backups,omitempty"`
+	// The number of consecutive failures to fail over, use ForwardMaxFailures if zero.
+	MaxFailures int `json:"maxFailures,omitempty"`
 }
 
 func (v *ForwardConfigure) String() string {
-	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v",
-		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label,
+	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, "+
+		"streams=%v, destinations=%v, serverProfile=<%v>, backups=%v, maxFailures=%v",
+		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.Destinations,
+		v.ServerProfile.String(), v.Backups, v.MaxFailures,
 	)
 }
 
+// Routed whether forward streams by the routing rules.
+func (v *ForwardConfigure) Routed() bool {
+	return len(v.Streams) > 0
+}
+
+// Match whether the source stream in /app/stream matches the routing rules.
+func (v *ForwardConfigure) Match(source string) bool {
+	for _, glob := range v.Streams {
+		if ok, err := path.Match(glob, source); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Outputs returns the destination URLs, the Server and Secret first, then the extra Destinations.
+func (v *ForwardConfigure) Outputs() []string {
+	var outputs []string
+	if v.Server != "" {
+		server := v.Server
+		if !strings.HasSuffix(server, "/") && !strings.HasPrefix(v.Secret, "/") && v.Secret != "" {
+			server += "/"
+		}
+		outputs = append(outputs, fmt.Sprintf("%v%v", server, v.Secret))
+	}
+	for _, destination := range v.Destinations {
+		outputs = append(outputs, destination.URL)
+	}
+	return outputs
+}
+
+// ProfileOf returns the encoding profile of the destination URL, nil to copy the stream.
+func (v *ForwardConfigure) ProfileOf(destination string) *ForwardProfile {
+	if outputs := v.Outputs(); v.Server != "" && len(outputs) > 0 && outputs[0] == destination {
+		return v.ServerProfile
+	}
+	for _, d := range v.Destinations {
+		if d.URL == destination {
+			return d.Profile
+		}
+	}
+	return nil
+}
+
 func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
 	v.Platform = u.Platform
 	v.Stream = u.Stream
@@ -363,15 +531,155 @@ func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
+	v.Streams = u.Streams
+	v.Destinations = u.Destinations
+	v.ServerProfile = u.ServerProfile
+	v.Backups = u.Backups
+	v.MaxFailures = u.MaxFailures
+	return nil
+}
+
+// ForwardDestination is an extra destination for forwarding, with its own encoding profile.
+type ForwardDestination struct {
+	// The destination URL, for example, rtmp://host/live/[stream] where the [app] and [stream] is replaced by
+	// the source stream.
+	URL string `json:"url"`
+	// The encoding profile to transcode for the destination, nil to copy the stream.
+	Profile *ForwardProfile `json:"profile,omitempty"`
+}
+
+func (v *ForwardDestination) String() string {
+	return fmt.Sprintf("url=%v, profile=<%v>", v.URL, v.Profile.String())
+}
+
+// ForwardProfile is the encoding profile for forwarding, to transcode the stream for the destination, for
+// example, 720p and 2500kbps for a platform with strict ingest requirements.
+type ForwardProfile struct {
+	// The video codec name, for example, libx264. Copy the video if empty or copy.
+	VideoCodec string `json:"vcodec"`
+	// The video bitrate in kbps.
+	VideoBitrate int `json:"vbitrate"`
+	// The video profile, for example, main.
+	VideoProfile string `json:"vprofile"`
+	// The video preset, for example, veryfast.
+	VideoPreset string `json:"vpreset"`
+	// The resolution of video, keep the aspect ratio if only one is set, keep the source if both are zero.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// The frame rate of video.
+	Fps int `json:"fps"`
+	// The GOP size of video, in frames.
+	Gop int `json:"gop"`
+	// The audio codec name, for example, aac. Copy the audio if empty or copy.
+	AudioCodec string `json:"acodec"`
+	// The audio bitrate in kbps.
+	AudioBitrate int `json:"abitrate"`
+	// The audio sample rate in Hz, for example, 44100.
+	AudioSampleRate int `json:"asamplerate"`
+	// The audio channels.
+	AudioChannels int `json:"achannels"`
+}
+
+func (v *ForwardProfile) String() string {
+	if v == nil {
+		return "copy"
+	}
+	return fmt.Sprintf("vcodec=%v, vbitrate=%v, vprofile=%v, vpreset=%v, width=%v, height=%v, fps=%v, gop=%v, "+
+		"acodec=%v, abitrate=%v, asamplerate=%v, achannels=%v",
+		v.VideoCodec, v.VideoBitrate, v.VideoProfile, v.VideoPreset, v.Width, v.Height, v.Fps, v.Gop,
+		v.AudioCodec, v.AudioBitrate, v.AudioSampleRate, v.AudioChannels,
+	)
+}
+
+func (v *ForwardProfile) Validate() error {
+	// The names are passed to FFmpeg as arguments, so only allow simple names.
+	for _, name := range []string{v.VideoCodec, v.VideoProfile, v.VideoPreset, v.AudioCodec} {
+		for _, c := range name {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '_' && c != '-' {
+				return errors.Errorf("invalid name %v", name)
+			}
+		}
+		if strings.HasPrefix(name, "-") {
+			return errors.Errorf("invalid name %v", name)
+		}
+	}
+
+	for _, value := range []int{
+		v.VideoBitrate, v.Width, v.Height, v.Fps, v.Gop, v.AudioBitrate, v.AudioSampleRate, v.AudioChannels,
+	} {
+		if value < 0 {
+			return errors.Errorf("invalid value %v", value)
+		}
+	}
 	return nil
 }
 
+// Args builds the FFmpeg arguments of codec for the profile, copy the stream if profile is nil.
+func (v *ForwardProfile) Args() []string {
+	if v == nil {
+		return []string{"-c", "copy"}
+	}
+
+	args := []string{}
+	if v.VideoCodec == "" || v.VideoCodec == "copy" {
+		args = append(args, "-vcodec", "copy")
+	} else {
+		args = append(args, "-vcodec", v.VideoCodec)
+		if v.VideoProfile != "" {
+			args = append(args, "-profile:v", v.VideoProfile)
+		}
+		if v.VideoPreset != "" {
+			args = append(args, "-preset:v", v.VideoPreset)
+		}
+		if v.VideoBitrate > 0 {
+			args = append(args, "-b:v", fmt.Sprintf("%vk", v.VideoBitrate))
+		}
+		if v.Width > 0 || v.Height > 0 {
+			// Use -2 to keep the aspect ratio, and make sure the size is even.
+			width, height := v.Width, v.Height
+			if width <= 0 {
+				width = -2
+			}
+			if height <= 0 {
+				height = -2
+			}
+			args = append(args, "-vf", fmt.Sprintf("scale=%v:%v", width, height))
+		}
+		if v.Fps > 0 {
+			args = append(args, "-r", fmt.Sprintf("%v", v.Fps))
+		}
+		if v.Gop > 0 {
+			args = append(args, "-g", fmt.Sprintf("%v", v.Gop))
+		}
+	}
+
+	if v.AudioCodec == "" || v.AudioCodec == "copy" {
+		args = append(args, "-acodec", "copy")
+	} else {
+		args = append(args, "-acodec", v.AudioCodec)
+		if v.AudioBitrate > 0 {
+			args = append(args, "-b:a", fmt.Sprintf("%vk", v.AudioBitrate))
+		}
+		if v.AudioSampleRate > 0 {
+			args = append(args, "-ar", fmt.Sprintf("%v", v.AudioSampleRate))
+		}
+		if v.AudioChannels > 0 {
+			args = append(args, "-ac", fmt.Sprintf("%v", v.AudioChannels))
+		}
+	}
+	return args
+}
+
 // ForwardTask is a task for FFmpeg to forward stream, with a configure.
 type ForwardTask struct {
 	// The ID for task.
 	UUID string `json:"uuid"`
 	// The platform for task.
 	Platform string `json:"platform"`
+	// The source stream in /app/stream matched by routing rules, empty to select by configure.
+	Source string `json:"source"`
+	// The destination URL, the [app] and [stream] is replaced by the input stream.
+	Destination string `json:"destination"`
 
 	// The input url.
 	Input string `json:"input"`
@@ -391,6 +699,15 @@ type ForwardTask struct {
 	// The first ready time.
 	firstReadyTime *time.Time
 
+	// The index of active destination, 0 is the primary destination, others are the backups.
+	activeIndex int
+	// The number of consecutive failures of active destination.
+	failures int
+	// The number of failover.
+	failovers int
+	// Whether the FFmpeg is canceled on purpose, for example, restart or fail back, which is not a failure.
+	switched bool
+
 	// The context for current task.
 	cancel context.CancelFunc
 
@@ -404,11 +721,61 @@ type ForwardTask struct {
 }
 
 func (v *ForwardTask) String() string {
-	return fmt.Sprintf("uuid=%v, platform=%v, input=%v, output=%v, pid=%v, frame=%vB, config is %v",
-		v.UUID, v.Platform, v.Input, v.Output, v.PID, len(v.frame), v.config.String(),
+	return fmt.Sprintf("uuid=%v, platform=%v, source=%v, input=%v, output=%v, pid=%v, frame=%vB, config is %v",
+		v.UUID, v.Platform, v.Source, v.Input, v.Output, v.PID, len(v.frame), v.config.String(),
 	)
 }
 
+func forwardTaskKey(platform, source, destination string) string {
+	return fmt.Sprintf("%v %v %v", platform, source, destination)
+}
+
+// Key is the identity of task, the pair of platform, source stream and destination.
+func (v *ForwardTask) Key() string {
+	return forwardTaskKey(v.Platform, v.Source, v.Destination)
+}
+
+// forwardRedactURL hides the stream key and query string of URL, to report the destination safely.
+func forwardRedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "***"
+	}
+
+	p := u.Path
+	if index := strings.LastIndex(p, "/"); index > 0 {
+		p = p[:index+1] + "***"
+	}
+	return fmt.Sprintf("%v://%v%v", u.Scheme, u.Host, p)
+}
+
+// expected whether the task is still expected by the configure, for example, the destination is not removed
+// and the source stream still matches the routing rules.
+func (v *ForwardTask) expected() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.config.Routed() != (v.Source != "") {
+		return false
+	}
+	if !slicesContains(v.config.Outputs(), v.Destination) {
+		return false
+	}
+	if v.Source != "" && (!v.config.Enabled || !v.config.Match(v.Source)) {
+		return false
+	}
+	return true
+}
+
+// remove the task from worker and redis, when task is done.
+func (v *ForwardTask) remove(ctx context.Context) {
+	v.forwardWorker.tasks.Delete(v.Key())
+
+	if err := rdb.HDel(ctx, SRS_FORWARD_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "ignore hdel %v %v err %+v", SRS_FORWARD_TASK, v.UUID, err)
+	}
+}
+
 func (v *ForwardTask) saveTask(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -446,13 +813,23 @@ func (v *ForwardTask) Restart(ctx context.Context) error {
 	if v.cancel != nil {
 		v.cancel()
 	}
+	// Only the running FFmpeg is switched on purpose, which is not a failure of destination.
+	if v.PID > 0 {
+		v.switched = true
+	}
 
-	// Reload config from redis.
+	// Use the primary destination for the new configure.
+	v.activeIndex, v.failures = 0, 0
+
+	// Reload config from redis. Note that we must use a new object, or the fields which are omitted by the
+	// new config, such as the backup destinations, are kept.
+	var config ForwardConfigure
 	if b, err := rdb.HGet(ctx, SRS_FORWARD_CONFIG, v.Platform).Result(); err != nil {
 		return errors.Wrapf(err, "hget %v %v", SRS_FORWARD_CONFIG, v.Platform)
-	} else if err = json.Unmarshal([]byte(b), v.config); err != nil {
+	} else if err = json.Unmarshal([]byte(b), &config); err != nil {
 		return errors.Wrapf(err, "unmarshal %v", b)
 	}
+	v.config = &config
 
 	return nil
 }
@@ -467,7 +844,7 @@ func (v *ForwardTask) updateFrame(frame string) {
 	v.update = &now
 }
 
-func (v *ForwardTask) queryFrame() (int32, string, string, string, string, string) {
+func (v *ForwardTask) queryFrame() (int32, string, string, string, string, string, string) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
@@ -486,12 +863,146 @@ func (v *ForwardTask) queryFrame() (int32, string, string, string, string, strin
 		starttime = v.starttime.Format(time.RFC3339)
 	}
 
-	return v.PID, v.inputStreamURL, v.frame, update, starttime, ready
+	return v.PID, v.inputStreamURL, forwardRedactURL(v.Output), v.frame, update, starttime, ready
+}
+
+// candidates returns the primary destination and the backups, note that only the destination of Server and
+// Secret has backups.
+func (v *ForwardTask) candidates() []string {
+	if outputs := v.config.Outputs(); v.config.Server == "" || len(outputs) == 0 || outputs[0] != v.Destination {
+		return []string{v.Destination}
+	}
+	return append([]string{v.Destination}, v.config.Backups...)
+}
+
+// activeDestination returns the index and URL of active destination.
+func (v *ForwardTask) activeDestination() (int, string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	candidates := v.candidates()
+	if v.activeIndex >= len(candidates) {
+		v.activeIndex = 0
+	}
+	return v.activeIndex, candidates[v.activeIndex]
+}
+
+// failover records the result of FFmpeg, and fails over to the next destination if the active destination
+// fails too many times, or stalled.
+func (v *ForwardTask) failover(ctx context.Context, ready, stalled bool, err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Ignore if canceled on purpose, or quit normally, for example, the stream is unpublished.
+	if v.switched {
+		v.switched = false
+		return
+	}
+	if err == nil && !stalled {
+		return
+	}
+
+	// Consecutive failures, reset if ready, because the destination worked.
+	if ready {
+		v.failures = 0
+	}
+	v.failures++
+
+	maxFailures := v.config.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = ForwardMaxFailures
+	}
+
+	candidates := v.candidates()
+	if len(candidates) <= 1 || (!stalled && v.failures < maxFailures) {
+		return
+	}
+
+	from := v.activeIndex
+	v.activeIndex, v.failures, v.failovers = (v.activeIndex+1)%len(candidates), 0, v.failovers+1
+	logger.Wf(ctx, "forward failover platform=%v, source=%v, from=%v, to=%v, stalled=%v, failovers=%v, err=%v",
+		v.Platform, v.Source, from, v.activeIndex, stalled, v.failovers, err)
+}
+
+// failback switches to the primary destination, and cancel the FFmpeg of backup.
+func (v *ForwardTask) failback(ctx context.Context, cancel context.CancelFunc) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Ignore if FFmpeg is already done.
+	if ctx.Err() != nil {
+		return
+	}
+
+	logger.Tf(ctx, "forward failback platform=%v, source=%v, from=%v", v.Platform, v.Source, v.activeIndex)
+	v.activeIndex, v.failures, v.switched = 0, 0, true
+	cancel()
+}
+
+// queryFailover returns the index of active destination and the number of failover.
+func (v *ForwardTask) queryFailover() (int, int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.activeIndex, v.failovers
+}
+
+// forwardHoldDown tracks the reachability of primary destination, to fail back only when it keeps reachable
+// for the hold-down period.
+type forwardHoldDown struct {
+	// The time since the primary destination is reachable, zero if not reachable.
+	since time.Time
+}
+
+// Update the reachability of primary destination, return whether it's ok to fail back.
+func (v *forwardHoldDown) Update(reachable bool, now time.Time) bool {
+	if !reachable {
+		v.since = time.Time{}
+		return false
+	}
+
+	if v.since.IsZero() {
+		v.since = now
+	}
+	return now.Sub(v.since) >= ForwardFailbackHoldDown
+}
+
+// forwardProbe whether the destination is reachable by TCP, to detect the recovery of primary destination. For
+// SRT over UDP, it's not possible to probe, so always try it.
+func forwardProbe(ctx context.Context, s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "rtmp":
+			host = net.JoinHostPort(u.Hostname(), "1935")
+		case "rtmps", "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		case "srt":
+			return true
+		default:
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	} else if u.Scheme == "srt" {
+		return true
+	}
+
+	dialer := net.Dialer{Timeout: 3 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
 }
 
 func (v *ForwardTask) Initialize(ctx context.Context, w *ForwardWorker) error {
 	v.forwardWorker = w
-	logger.Tf(ctx, "forward initialize uuid=%v, platform=%v", v.UUID, v.Platform)
+	logger.Tf(ctx, "forward initialize uuid=%v, platform=%v, source=%v", v.UUID, v.Platform, v.Source)
 
 	if err := v.saveTask(ctx); err != nil {
 		return errors.Wrapf(err, "save task")
@@ -514,10 +1025,17 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 		streamName := v.config.Stream
 
 		var best *SrsStream
-		for _, v := range streams {
+		for _, sv := range streams {
 			var stream SrsStream
-			if err := json.Unmarshal([]byte(v), &stream); err != nil {
-				return nil, errors.Wrapf(err, "unmarshal %v", v)
+			if err := json.Unmarshal([]byte(sv), &stream); err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %v", sv)
+			}
+			if v.Source != "" {
+				if fmt.Sprintf("/%v/%v", stream.App, stream.Stream) == v.Source {
+					best = &stream
+					break
+				}
+				continue
 			}
 			if streamName != "" {
 				if stream.Stream == streamName {
@@ -556,6 +1074,9 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 		return best, nil
 	}
 
+	// Whether the source stream matched by routing rules is unpublished.
+	var unpublished bool
+
 	pfn := func(ctx context.Context) error {
 		// Ignore when not enabled.
 		if !v.config.Enabled {
@@ -569,6 +1090,7 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 		}
 
 		if input == nil {
+			unpublished = v.Source != ""
 			return nil
 		}
 
@@ -580,7 +1102,13 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 		return nil
 	}
 
-	for ctx.Err() == nil {
+	for ctx.Err() == nil && !unpublished {
+		// Quit if not expected by the configure anymore, and the worker will start new tasks if required.
+		if !v.expected() {
+			logger.Tf(ctx, "forward quit task %v", v.String())
+			return nil
+		}
+
 		if err := pfn(ctx); err != nil {
 			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
 
@@ -608,14 +1136,17 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 
 	// Build input URL.
 	host := "localhost"
-	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
+	inputURL := withInternalPlay(fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream))
 
-	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	// Build output URL, use the backup destination if failed over.
+	activeIndex, destination := v.activeDestination()
+	v.lock.Lock()
+	profile := v.config.ProfileOf(v.Destination)
+	// Reset the switch of previous FFmpeg, which should not affect this one.
+	v.switched = false
+	v.lock.Unlock()
+	replacer := strings.NewReplacer("[app]", input.App, "[stream]", input.Stream, "localhost", host)
+	outputURL := replacer.Replace(destination)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -642,7 +1173,8 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 	} else {
 		args = append(args, "-i", inputURL)
 	}
-	args = append(args, "-c", "copy")
+	// Copy the stream, or transcode by the profile of destination.
+	args = append(args, profile.Args()...)
 	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
 	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
 		args = append(args, "-f", "flv")
@@ -703,6 +1235,26 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		}
 	}()
 
+	// Fail back to the primary destination when it recovers, and keeps reachable for the hold-down period.
+	if activeIndex > 0 {
+		go func() {
+			primaryURL := replacer.Replace(v.Destination)
+			var holdDown forwardHoldDown
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(ForwardFailbackInterval):
+				}
+
+				if holdDown.Update(forwardProbe(ctx, primaryURL), time.Now()) {
+					v.failback(ctx, cancel)
+					return
+				}
+			}
+		}()
+	}
+
 	// Process terminated, or user cancel the process.
 	select {
 	case <-parentCtx.Done():
@@ -717,5 +1269,11 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		v.Platform, input.StreamURL(), v.PID, err,
 	)
 
+	// Whether the destination fails, note that we ignore the error if parent is canceled, because the task is
+	// quit, not the destination fails.
+	if parentCtx.Err() == nil {
+		v.failover(ctx, heartbeat.firstReadyCtx.Err() != nil, heartbeat.stalled, err)
+	}
+
 	return err
 }
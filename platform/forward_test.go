@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+func TestForward_Outputs(t *testing.T) {
+	for _, e := range []struct {
+		config  ForwardConfigure
+		outputs string
+	}{
+		{config: ForwardConfigure{}, outputs: ""},
+		{config: ForwardConfigure{Server: "rtmp://a/live", Secret: "s0"}, outputs: "rtmp://a/live/s0"},
+		{config: ForwardConfigure{Server: "rtmp://a/live/", Secret: "s0"}, outputs: "rtmp://a/live/s0"},
+		{config: ForwardConfigure{Server: "rtmp://a/live/s0"}, outputs: "rtmp://a/live/s0"},
+		{config: ForwardConfigure{
+			Server: "rtmp://a/live", Secret: "s0", Destinations: []*ForwardDestination{{URL: "rtmp://b/live/[stream]"}},
+		}, outputs: "rtmp://a/live/s0 rtmp://b/live/[stream]"},
+		{config: ForwardConfigure{Destinations: []*ForwardDestination{{URL: "srt://b:10080"}}}, outputs: "srt://b:10080"},
+	} {
+		if outputs := strings.Join(e.config.Outputs(), " "); outputs != e.outputs {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.outputs, outputs)
+		}
+	}
+}
+
+func TestForward_Match(t *testing.T) {
+	for _, e := range []struct {
+		streams []string
+		source  string
+		match   bool
+	}{
+		{streams: nil, source: "/live/livestream", match: false},
+		{streams: []string{"/live/*"}, source: "/live/livestream", match: true},
+		{streams: []string{"/live/*"}, source: "/game/livestream", match: false},
+		{streams: []string{"/game/*", "/*/channel-?"}, source: "/live/channel-1", match: true},
+		{streams: []string{"/live/livestream"}, source: "/live/livestream2", match: false},
+	} {
+		config := ForwardConfigure{Streams: e.streams}
+		if match := config.Match(e.source); match != e.match {
+			t.Errorf("Fail for streams %v source %v, expect %v, actual %v", e.streams, e.source, e.match, match)
+		}
+	}
+}
+
+func TestForward_RedactURL(t *testing.T) {
+	for _, e := range []struct {
+		url    string
+		redact string
+	}{
+		{url: "rtmp://a/live/secret", redact: "rtmp://a/live/***"},
+		{url: "rtmp://a:1935/live/secret?auth=xxx", redact: "rtmp://a:1935/live/***"},
+		{url: "srt://a:10080?streamid=#!::r=live/secret", redact: "srt://a:10080"},
+		{url: "invalid", redact: "***"},
+	} {
+		if redact := forwardRedactURL(e.url); redact != e.redact {
+			t.Errorf("Fail for url %v, expect %v, actual %v", e.url, e.redact, redact)
+		}
+	}
+}
+
+func TestForward_ProfileArgs(t *testing.T) {
+	for _, e := range []struct {
+		profile *ForwardProfile
+		args    string
+	}{
+		{profile: nil, args: "-c copy"},
+		{profile: &ForwardProfile{}, args: "-vcodec copy -acodec copy"},
+		{profile: &ForwardProfile{VideoCodec: "copy", AudioCodec: "aac", AudioBitrate: 128},
+			args: "-vcodec copy -acodec aac -b:a 128k"},
+		{profile: &ForwardProfile{
+			VideoCodec: "libx264", VideoBitrate: 2500, Height: 720, Fps: 30, Gop: 60,
+			AudioCodec: "aac", AudioSampleRate: 44100, AudioChannels: 2,
+		}, args: "-vcodec libx264 -b:v 2500k -vf scale=-2:720 -r 30 -g 60 -acodec aac -ar 44100 -ac 2"},
+		{profile: &ForwardProfile{VideoCodec: "libx264", VideoPreset: "veryfast", Width: 1920, Height: 1080},
+			args: "-vcodec libx264 -preset:v veryfast -vf scale=1920:1080 -acodec copy"},
+	} {
+		if args := strings.Join(e.profile.Args(), " "); args != e.args {
+			t.Errorf("Fail for profile %v, expect %v, actual %v", e.profile.String(), e.args, args)
+		}
+	}
+}
+
+func TestForward_ProfileOf(t *testing.T) {
+	server, destination := &ForwardProfile{VideoCodec: "libx264"}, &ForwardProfile{AudioCodec: "aac"}
+	config := ForwardConfigure{Server: "rtmp://a/live", Secret: "s0", ServerProfile: server, Destinations: []*ForwardDestination{
+		{URL: "rtmp://b/live/[stream]", Profile: destination}, {URL: "rtmp://c/live/[stream]"},
+	}}
+
+	for _, e := range []struct {
+		destination string
+		profile     *ForwardProfile
+	}{
+		{destination: "rtmp://a/live/s0", profile: server},
+		{destination: "rtmp://b/live/[stream]", profile: destination},
+		{destination: "rtmp://c/live/[stream]", profile: nil},
+		{destination: "rtmp://d/live/[stream]", profile: nil},
+	} {
+		if profile := config.ProfileOf(e.destination); profile != e.profile {
+			t.Errorf("Fail for destination %v, expect %v, actual %v", e.destination, e.profile.String(), profile.String())
+		}
+	}
+}
+
+func TestForward_ProfileValidate(t *testing.T) {
+	for _, e := range []struct {
+		profile ForwardProfile
+		valid   bool
+	}{
+		{profile: ForwardProfile{}, valid: true},
+		{profile: ForwardProfile{VideoCodec: "libx264", VideoPreset: "veryfast", AudioCodec: "aac"}, valid: true},
+		{profile: ForwardProfile{VideoCodec: "-y"}, valid: false},
+		{profile: ForwardProfile{VideoCodec: "libx264 -y"}, valid: false},
+		{profile: ForwardProfile{VideoBitrate: -1}, valid: false},
+	} {
+		if err := e.profile.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for profile %v, expect %v, actual %v", e.profile.String(), e.valid, err)
+		}
+	}
+}
+
+func TestForward_Failover(t *testing.T) {
+	ctx := context.Background()
+	task := &ForwardTask{Destination: "rtmp://a/live/s0", config: &ForwardConfigure{
+		Server: "rtmp://a/live", Secret: "s0", Backups: []string{"rtmp://b/live/s0"}, MaxFailures: 2,
+	}}
+
+	if index, destination := task.activeDestination(); index != 0 || destination != "rtmp://a/live/s0" {
+		t.Errorf("Fail for index=%v, destination=%v", index, destination)
+	}
+
+	// Quit normally, or canceled on purpose, should not fail over.
+	task.failover(ctx, false, false, nil)
+	task.switched = true
+	task.failover(ctx, false, false, errors.New("canceled"))
+	if index, failovers := task.queryFailover(); index != 0 || failovers != 0 || task.failures != 0 {
+		t.Errorf("Fail for index=%v, failovers=%v, failures=%v", index, failovers, task.failures)
+	}
+
+	// Fail over after consecutive failures.
+	task.failover(ctx, false, false, errors.New("refused"))
+	task.failover(ctx, false, false, errors.New("refused"))
+	if index, destination := task.activeDestination(); index != 1 || destination != "rtmp://b/live/s0" {
+		t.Errorf("Fail for index=%v, destination=%v", index, destination)
+	}
+
+	// Fail over immediately when stalled, back to the primary.
+	task.failover(ctx, true, true, errors.New("killed"))
+	if index, failovers := task.queryFailover(); index != 0 || failovers != 2 {
+		t.Errorf("Fail for index=%v, failovers=%v", index, failovers)
+	}
+
+	// The extra destinations have no backups.
+	task = &ForwardTask{Destination: "rtmp://c/live/s1", config: &ForwardConfigure{
+		Server: "rtmp://a/live", Secret: "s0", Destinations: []*ForwardDestination{{URL: "rtmp://c/live/s1"}},
+		Backups: []string{"rtmp://b/live/s0"}, MaxFailures: 1,
+	}}
+	task.failover(ctx, false, true, errors.New("killed"))
+	if index, failovers := task.queryFailover(); index != 0 || failovers != 0 {
+		t.Errorf("Fail for index=%v, failovers=%v", index, failovers)
+	}
+}
+
+func TestForward_HoldDown(t *testing.T) {
+	var holdDown forwardHoldDown
+	now := time.Now()
+
+	for _, e := range []struct {
+		elapsed   time.Duration
+		reachable bool
+		failback  bool
+	}{
+		{elapsed: 0, reachable: true, failback: false},
+		{elapsed: ForwardFailbackInterval, reachable: true, failback: false},
+		// The primary flaps, so restart the hold-down period.
+		{elapsed: 2 * ForwardFailbackInterval, reachable: false, failback: false},
+		{elapsed: 3 * ForwardFailbackInterval, reachable: true, failback: false},
+		{elapsed: 3*ForwardFailbackInterval + ForwardFailbackHoldDown - time.Second, reachable: true, failback: false},
+		{elapsed: 3*ForwardFailbackInterval + ForwardFailbackHoldDown, reachable: true, failback: true},
+	} {
+		if failback := holdDown.Update(e.reachable, now.Add(e.elapsed)); failback != e.failback {
+			t.Errorf("Fail for %v, expect %v, actual %v", e, e.failback, failback)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/live/room/create"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, title string
@@ -32,7 +33,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -63,6 +64,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/live/room/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, rid string
@@ -76,7 +78,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -99,6 +101,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/live/room/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -113,7 +116,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -136,6 +139,16 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "hset %v %v %v", SRS_AUTH_SECRET, roomPublishAuthKey, room.Secret)
 			}
 
+			// Whether the players of room require play token, see on_play hook.
+			roomPlayAuthKey := GenerateRoomPlayKey(room.StreamName)
+			if room.PlayAuth {
+				if err := rdb.HSet(ctx, SRS_AUTH_SECRET, roomPlayAuthKey, "true").Err(); err != nil {
+					return errors.Wrapf(err, "hset %v %v true", SRS_AUTH_SECRET, roomPlayAuthKey)
+				}
+			} else if err := rdb.HDel(ctx, SRS_AUTH_SECRET, roomPlayAuthKey).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_AUTH_SECRET, roomPlayAuthKey)
+			}
+
 			// Limit the changing rate for AI Assistant.
 			select {
 			case <-ctx.Done():
@@ -152,6 +165,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/live/room/list"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -164,7 +178,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -195,6 +209,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/live/room/remove"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, roomUUID string
@@ -208,7 +223,7 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -230,6 +245,10 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 			if err := rdb.HDel(ctx, SRS_AUTH_SECRET, roomPublishAuthKey).Err(); err != nil {
 				return errors.Wrapf(err, "hdel %v %v", SRS_AUTH_SECRET, roomPublishAuthKey)
 			}
+			roomPlayAuthKey := GenerateRoomPlayKey(room.StreamName)
+			if err := rdb.HDel(ctx, SRS_AUTH_SECRET, roomPlayAuthKey).Err(); err != nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_AUTH_SECRET, roomPlayAuthKey)
+			}
 
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "srs remove room ok, uuid=%v", roomUUID)
@@ -251,6 +270,8 @@ type SrsLiveRoom struct {
 	StreamName string `json:"stream"`
 	// Live room secret.
 	Secret string `json:"secret"`
+	// Whether require play token for players of live room.
+	PlayAuth bool `json:"playAuth"`
 	// The AI assistant settings.
 	SrsAssistant
 	// The current AI assistant stage, might change to others.
@@ -284,8 +305,8 @@ func NewLiveRoom(opts ...func(room *SrsLiveRoom)) *SrsLiveRoom {
 }
 
 func (v *SrsLiveRoom) String() string {
-	return fmt.Sprintf("uuid=%v, title=%v, stream=%v, secret=%vB, roomToken=%vB, stage=%v, assistant=<%v>",
-		v.UUID, v.Title, v.StreamName, len(v.Secret), len(v.RoomToken), v.StageUUID, v.SrsAssistant.String())
+	return fmt.Sprintf("uuid=%v, title=%v, stream=%v, secret=%vB, playAuth=%v, roomToken=%vB, stage=%v, assistant=<%v>",
+		v.UUID, v.Title, v.StreamName, len(v.Secret), v.PlayAuth, len(v.RoomToken), v.StageUUID, v.SrsAssistant.String())
 }
 
 func (v *SrsLiveRoom) UpdateStage(ctx context.Context) error {
@@ -131,6 +131,10 @@ func doMain(ctx context.Context) error {
 	setEnvDefault("SRS_FORWARD_LIMIT", "10")
 	setEnvDefault("SRS_VLIVE_LIMIT", "10")
 	setEnvDefault("SRS_CAMERA_LIMIT", "10")
+	setEnvDefault("SRS_TRANSCODE_LIMIT", "2")
+	// For API rate limit, per client IP.
+	setEnvDefault("API_RATE_LIMIT", "50")
+	setEnvDefault("API_RATE_LIMIT_EXPENSIVE", "30")
 
 	logger.Tf(ctx, "load .env as MGMT_PASSWORD=%vB, GO_PPROF=%v, "+
 		"SRS_PLATFORM_SECRET=%vB, CLOUD=%v, REGION=%v, SOURCE=%v, SRT_PORT=%v, RTC_PORT=%v, "+
@@ -138,7 +142,8 @@ func doMain(ctx context.Context) error {
 		"PUBLIC_URL=%v, BUILD_PATH=%v, REACT_APP_LOCALE=%v, PLATFORM_LISTEN=%v, HTTP_PORT=%v, "+
 		"REGISTRY=%v, MGMT_LISTEN=%v, HTTPS_LISTEN=%v, AUTO_SELF_SIGNED_CERTIFICATE=%v, "+
 		"NAME_LOOKUP=%v, PLATFORM_DOCKER=%v, SRS_FORWARD_LIMIT=%v, SRS_VLIVE_LIMIT=%v, "+
-		"SRS_CAMERA_LIMIT=%v, YTDL_PROXY=%v",
+		"SRS_CAMERA_LIMIT=%v, SRS_TRANSCODE_LIMIT=%v, YTDL_PROXY=%v, API_RATE_LIMIT=%v, API_RATE_LIMIT_EXPENSIVE=%v, "+
+		"TRUSTED_PROXIES=%v",
 		len(envMgmtPassword()), envGoPprof(), len(envApiSecret()), envCloud(),
 		envRegion(), envSource(), envSrtListen(), envRtcListen(),
 		envNodeEnv(), envLocalRelease(),
@@ -148,7 +153,8 @@ func doMain(ctx context.Context) error {
 		envRegistry(), envMgmtListen(), envHttpListen(),
 		envSelfSignedCertificate(), envNameLookup(),
 		envPlatformDocker(), envForwardLimit(), envVLiveLimit(),
-		envCameraLimit(), envYtdlProxy(),
+		envCameraLimit(), envTranscodeLimit(), envYtdlProxy(), envApiRateLimit(), envApiRateLimitExpensive(),
+		envTrustedProxies(),
 	)
 
 	// Start the Go pprof if enabled.
@@ -230,6 +236,27 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start transcode worker")
 	}
 
+	// Create worker for resumable upload, expire the abandoned uploads.
+	uploadWorker = NewUploadWorker()
+	defer uploadWorker.Close()
+	if err := uploadWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start upload worker")
+	}
+
+	// Create worker for fallback, publish filler when publisher drops.
+	fallbackWorker = NewFallbackWorker()
+	defer fallbackWorker.Close()
+	if err := fallbackWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start fallback worker")
+	}
+
+	// Create worker for mosaic, compose multiple inputs to a stream.
+	mosaicWorker = NewMosaicWorker()
+	defer mosaicWorker.Close()
+	if err := mosaicWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start mosaic worker")
+	}
+
 	// Create worker for RECORD, covert live stream to local file.
 	recordWorker = NewRecordWorker()
 	defer recordWorker.Close()
@@ -438,7 +465,8 @@ func initPlatform(ctx context.Context) error {
 		"containers/data/upload", "containers/data/vlive", "containers/data/signals",
 		"containers/data/lego", "containers/data/.well-known", "containers/data/config",
 		"containers/data/transcript", "containers/data/srs-s3-bucket", "containers/data/ai-talk",
-		"containers/data/dubbing", "containers/data/ocr",
+		"containers/data/dubbing", "containers/data/ocr", "containers/data/fallback",
+		"containers/data/motion", "containers/data/mosaic",
 	} {
 		if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
 			if err = os.MkdirAll(dir, os.ModeDir|os.FileMode(0755)); err != nil {
@@ -511,6 +539,16 @@ func initPlatform(ctx context.Context) error {
 		}
 	}
 
+	// Setup the play secret for first run, which is used to sign the play token.
+	if play, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playSecret").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v playSecret", SRS_AUTH_SECRET)
+	} else if play == "" {
+		play = strings.ReplaceAll(uuid.NewString(), "-", "")
+		if err = rdb.HSet(ctx, SRS_AUTH_SECRET, "playSecret", play).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hset %v playSecret %v", SRS_AUTH_SECRET, play)
+		}
+	}
+
 	// Migrate from previous versions.
 	for _, migrate := range []struct {
 		PVK string
@@ -0,0 +1,1226 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// The input is a live stream of SRS, in /app/stream.
+	MosaicInputStream = "stream"
+	// The input is an IP camera by platform, which reads the local stream the camera publishes to.
+	MosaicInputCamera = "camera"
+	// The input is a video or image file, which is looped.
+	MosaicInputFile = "file"
+
+	// The tiles are arranged in a grid, with the same size.
+	MosaicLayoutGrid = "grid"
+	// The first input is full screen, while others are small windows at the bottom right.
+	MosaicLayoutPIP = "pip"
+	// The tiles are custom rectangles, one for each input.
+	MosaicLayoutCustom = "custom"
+
+	// The max number of inputs for a mosaic.
+	MosaicMaxInputs = 16
+	// The default size of composed stream.
+	MosaicDefaultWidth  = 1280
+	MosaicDefaultHeight = 720
+)
+
+var mosaicWorker *MosaicWorker
+
+// MosaicWorker composes multiple inputs, such as live streams, IP cameras and files, into a single output
+// stream by layout, for example, the multi-camera monitoring wall, or the host with screen share. The output
+// stream is published by a long-lived FFmpeg, which transcodes the relay of composer, so only the composer
+// is restarted when the inputs or layout changed.
+type MosaicWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The mosaic tasks, key is the output stream in /app/stream, value is *MosaicTask.
+	tasks sync.Map
+}
+
+func NewMosaicWorker() *MosaicWorker {
+	return &MosaicWorker{}
+}
+
+func (v *MosaicWorker) GetTask(output string) *MosaicTask {
+	if task, loaded := v.tasks.Load(output); loaded {
+		return task.(*MosaicTask)
+	}
+	return nil
+}
+
+// OnStreamMessage restarts the composer of mosaic tasks which use the stream, when it's published or
+// unpublished, to replace the tile by the stream or placeholder.
+func (v *MosaicWorker) OnStreamMessage(ctx context.Context, action SrsAction, streamObj *SrsStream) {
+	if action != SrsActionOnPublish && action != SrsActionOnUnpublish {
+		return
+	}
+
+	source := fmt.Sprintf("/%v/%v", streamObj.App, streamObj.Stream)
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*MosaicTask); task.uses(source) {
+			logger.Tf(ctx, "mosaic: Update tile for action=%v, stream=%v, output=%v", action, source, key)
+			task.Restart(ctx)
+		}
+		return true
+	})
+}
+
+func (v *MosaicWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/mosaic/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			res := make([]map[string]interface{}, 0)
+			if configs, err := rdb.HGetAll(ctx, SRS_MOSAIC_CONFIG).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_CONFIG)
+			} else {
+				for k, v := range configs {
+					var config MosaicConfig
+					if err = json.Unmarshal([]byte(v), &config); err != nil {
+						return errors.Wrapf(err, "unmarshal %v %v", k, v)
+					}
+
+					width, height := config.Size()
+					elem := map[string]interface{}{
+						"output":  config.Output,
+						"enabled": config.Enabled,
+						"width":   width,
+						"height":  height,
+						"inputs":  config.Inputs,
+						"layout":  config.Layout,
+					}
+
+					if task := mosaicWorker.GetTask(config.Output); task != nil {
+						pid, inputs, frame, update := task.queryFrame()
+						if pid > 0 {
+							tiles := make([]bool, len(inputs))
+							for i, input := range inputs {
+								tiles[i] = input != ""
+							}
+							elem["tiles"] = tiles
+							elem["frame"] = map[string]string{
+								"log":    frame,
+								"update": update,
+							}
+						}
+					}
+
+					res = append(res, elem)
+				}
+			}
+
+			sort.Slice(res, func(i, j int) bool {
+				return res[i]["output"].(string) < res[j]["output"].(string)
+			})
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "mosaic: Query ok, mosaics=%v, token=%vB", len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/mosaic/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, action string
+			var userConf MosaicConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Action *string `json:"action"`
+				*MosaicConfig
+			}{
+				Token: &token, Action: &action, MosaicConfig: &userConf,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			allowedActions := []string{"update", "remove"}
+			if !slicesContains(allowedActions, action) {
+				return errors.Errorf("invalid action=%v", action)
+			}
+			if userConf.Output == "" {
+				return errors.New("no output")
+			}
+
+			var targetConf MosaicConfig
+			if b, err := rdb.HGet(ctx, SRS_MOSAIC_CONFIG, userConf.Output).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_MOSAIC_CONFIG, userConf.Output)
+			} else if b != "" {
+				if err = json.Unmarshal([]byte(b), &targetConf); err != nil {
+					return errors.Wrapf(err, "unmarshal %v", b)
+				}
+			}
+
+			if action == "remove" {
+				if err := rdb.HDel(ctx, SRS_MOSAIC_CONFIG, userConf.Output).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_MOSAIC_CONFIG, userConf.Output)
+				}
+				targetConf.removeFiles(nil)
+
+				// Stop the task, which quits because no config.
+				if task := mosaicWorker.GetTask(userConf.Output); task != nil {
+					task.Restart(ctx)
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "mosaic: Remove ok, output=%v, token=%vB", userConf.Output, len(token))
+				return nil
+			}
+
+			// Use the uploaded input files, which are moved after validated, or keep the current files.
+			uploads := make(map[string]string)
+			for _, input := range userConf.Inputs {
+				if input == nil || input.Type != MosaicInputFile || !strings.HasPrefix(path.Clean(input.Target), dirUploadPath+"/") {
+					continue
+				}
+				if _, err := os.Stat(input.Target); err != nil {
+					return errors.Wrapf(err, "no file %v", input.Target)
+				}
+
+				target := path.Join(dirMosaicPath, fmt.Sprintf("%v%v", uuid.NewString(), path.Ext(input.Target)))
+				uploads[target] = path.Clean(input.Target)
+				input.Target = target
+			}
+
+			if err := userConf.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", userConf.String())
+			}
+
+			for target, upload := range uploads {
+				if err := os.Rename(upload, target); err != nil {
+					return errors.Wrapf(err, "rename %v to %v", upload, target)
+				}
+			}
+
+			if b, err := json.Marshal(&userConf); err != nil {
+				return errors.Wrapf(err, "marshal %v", userConf.String())
+			} else if err = rdb.HSet(ctx, SRS_MOSAIC_CONFIG, userConf.Output, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_MOSAIC_CONFIG, userConf.Output, string(b))
+			}
+			targetConf.removeFiles(&userConf)
+
+			// Restart the task if exists, to use the new config.
+			if task := mosaicWorker.GetTask(userConf.Output); task != nil {
+				task.Restart(ctx)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "mosaic: Update ok, config=%v, token=%vB", userConf.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/mosaic/layout"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, output string
+			var layout MosaicLayout
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string       `json:"token"`
+				Output *string       `json:"output"`
+				Layout *MosaicLayout `json:"layout"`
+			}{
+				Token: &token, Output: &output, Layout: &layout,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			var config MosaicConfig
+			if b, err := rdb.HGet(ctx, SRS_MOSAIC_CONFIG, output).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_MOSAIC_CONFIG, output)
+			} else if b == "" {
+				return errors.Errorf("no mosaic %v", output)
+			} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			// Only switch the layout, keep the inputs and other configs.
+			config.Layout = &layout
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
+			if b, err := json.Marshal(&config); err != nil {
+				return errors.Wrapf(err, "marshal %v", config.String())
+			} else if err = rdb.HSet(ctx, SRS_MOSAIC_CONFIG, output, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_MOSAIC_CONFIG, output, string(b))
+			}
+
+			// Restart the composer, to compose by the new layout.
+			if task := mosaicWorker.GetTask(output); task != nil {
+				task.Restart(ctx)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "mosaic: Switch layout ok, output=%v, layout=%v, token=%vB", output, layout.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *MosaicWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *MosaicWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "mosaic: Start a worker")
+
+	// Load tasks from redis and force to kill all.
+	if objs, err := rdb.HGetAll(ctx, SRS_MOSAIC_TASK).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_TASK)
+	} else if len(objs) > 0 {
+		for uuid, obj := range objs {
+			logger.Tf(ctx, "mosaic: Load task %v object %v", uuid, obj)
+
+			var task MosaicTask
+			if err = json.Unmarshal([]byte(obj), &task); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
+			}
+
+			if task.PID > 0 {
+				task.cleanup(ctx)
+			}
+			if task.PublisherPID > 0 {
+				task.cleanupPublisher(ctx)
+			}
+		}
+
+		if err = rdb.Del(ctx, SRS_MOSAIC_TASK).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "del %v", SRS_MOSAIC_TASK)
+		}
+	}
+
+	// Load all configurations from redis, start a task for each enabled mosaic.
+	loadTasks := func() error {
+		configs, err := rdb.HGetAll(ctx, SRS_MOSAIC_CONFIG).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_CONFIG)
+		}
+
+		for output, b := range configs {
+			var config MosaicConfig
+			if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", output, b)
+			}
+			if !config.Enabled {
+				continue
+			}
+
+			task := &MosaicTask{UUID: uuid.NewString(), Output: output, mosaicWorker: v}
+			if _, loaded := v.tasks.LoadOrStore(output, task); loaded {
+				continue
+			}
+			logger.Tf(ctx, "mosaic: Create output=%v task is %v", output, task.String())
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// Remove the task when done, the worker will start it again if required.
+				defer task.remove(ctx)
+
+				if err := task.Run(ctx); err != nil {
+					logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			duration := 3 * time.Second
+			if err := loadTasks(); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 10 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// MosaicInput is an input of mosaic, which is a tile of the composed stream.
+type MosaicInput struct {
+	// The type of input, stream, camera or file.
+	Type string `json:"type"`
+	// The stream in /app/stream, or the camera platform, or the file path.
+	Target string `json:"target"`
+	// The label to draw on the tile, optional.
+	Label string `json:"label,omitempty"`
+	// Whether use the audio of this input, at most one input. Use silence if not set.
+	Audio bool `json:"audio,omitempty"`
+}
+
+func (v MosaicInput) String() string {
+	return fmt.Sprintf("type=%v, target=%v, label=%v, audio=%v", v.Type, v.Target, v.Label, v.Audio)
+}
+
+// IsImage whether the input is an image file, which is looped as a still tile.
+func (v *MosaicInput) IsImage() bool {
+	return v.Type == MosaicInputFile && slicesContains(fallbackAllowImageFiles, strings.ToLower(path.Ext(v.Target)))
+}
+
+// MosaicRect is the rectangle of tile, in ratio of the composed stream size, from 0 to 1.
+type MosaicRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (v MosaicRect) String() string {
+	return fmt.Sprintf("x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+}
+
+func (v *MosaicRect) Validate() error {
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.Errorf("invalid rect %v, should be positive", v.String())
+	}
+	if v.X+v.Width > 1 || v.Y+v.Height > 1 {
+		return errors.Errorf("invalid rect %v, should be in the stream", v.String())
+	}
+	return nil
+}
+
+// MosaicLayout is the layout of tiles, which is switchable at runtime.
+type MosaicLayout struct {
+	// The type of layout, grid, pip or custom.
+	Type string `json:"type"`
+	// The rectangles for custom layout, one for each input in order.
+	Rects []*MosaicRect `json:"rects,omitempty"`
+}
+
+func (v MosaicLayout) String() string {
+	return fmt.Sprintf("type=%v, rects=%v", v.Type, len(v.Rects))
+}
+
+// Tiles returns the rectangles of n tiles by layout.
+func (v *MosaicLayout) Tiles(n int) []*MosaicRect {
+	var tiles []*MosaicRect
+	switch v.Type {
+	case MosaicLayoutCustom:
+		tiles = append(tiles, v.Rects...)
+	case MosaicLayoutPIP:
+		// The small windows are stacked up from the bottom right, with a margin.
+		size, margin := 0.25, 0.02
+		tiles = append(tiles, &MosaicRect{X: 0, Y: 0, Width: 1, Height: 1})
+		for i := 1; i < n; i++ {
+			tiles = append(tiles, &MosaicRect{
+				X: 1 - size - margin, Y: 1 - float64(i)*(size+margin), Width: size, Height: size,
+			})
+		}
+	default:
+		cols := int(math.Ceil(math.Sqrt(float64(n))))
+		rows := int(math.Ceil(float64(n) / float64(cols)))
+		for i := 0; i < n; i++ {
+			tiles = append(tiles, &MosaicRect{
+				X: float64(i%cols) / float64(cols), Y: float64(i/cols) / float64(rows),
+				Width: 1 / float64(cols), Height: 1 / float64(rows),
+			})
+		}
+	}
+	return tiles
+}
+
+// MosaicConfig is the configure of mosaic, to compose the inputs to the output stream.
+type MosaicConfig struct {
+	// The composed output stream in /app/stream, for example, /live/mosaic
+	Output string `json:"output"`
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The size of composed stream, use default 1280x720 if not set.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// The inputs, each is a tile of the composed stream.
+	Inputs []*MosaicInput `json:"inputs"`
+	// The layout of tiles.
+	Layout *MosaicLayout `json:"layout"`
+}
+
+func (v MosaicConfig) String() string {
+	return fmt.Sprintf("output=%v, enabled=%v, width=%v, height=%v, inputs=%v, layout=(%v)",
+		v.Output, v.Enabled, v.Width, v.Height, v.Inputs, v.Layout,
+	)
+}
+
+func (v *MosaicConfig) Validate() error {
+	isStream := func(stream string) bool {
+		parts := strings.Split(stream, "/")
+		return len(parts) == 3 && parts[0] == "" && parts[1] != "" && parts[2] != "" && path.Clean(stream) == stream
+	}
+	if !isStream(v.Output) {
+		return errors.Errorf("invalid output %v, should be /app/stream", v.Output)
+	}
+
+	if v.Width != 0 || v.Height != 0 {
+		if v.Width < 160 || v.Width > 3840 || v.Width%2 != 0 || v.Height < 90 || v.Height > 2160 || v.Height%2 != 0 {
+			return errors.Errorf("invalid size %vx%v, should be even and in 160x90 to 3840x2160", v.Width, v.Height)
+		}
+	}
+
+	if len(v.Inputs) == 0 || len(v.Inputs) > MosaicMaxInputs {
+		return errors.Errorf("invalid inputs %v, should be 1 to %v", len(v.Inputs), MosaicMaxInputs)
+	}
+
+	var audios int
+	allowedFiles := append(append([]string{}, serverAllowVideoFiles...), fallbackAllowImageFiles...)
+	for _, input := range v.Inputs {
+		if input == nil {
+			return errors.New("no input")
+		}
+
+		switch input.Type {
+		case MosaicInputStream:
+			if !isStream(input.Target) {
+				return errors.Errorf("invalid stream %v, should be /app/stream", input.Target)
+			}
+			if input.Target == v.Output {
+				return errors.Errorf("input should not be the output %v", v.Output)
+			}
+		case MosaicInputCamera:
+			if input.Target == "" {
+				return errors.New("no camera platform")
+			}
+		case MosaicInputFile:
+			if !strings.HasPrefix(input.Target, dirMosaicPath+"/") {
+				return errors.Errorf("invalid target %v, should be in %v", input.Target, dirMosaicPath)
+			}
+			if ext := strings.ToLower(path.Ext(input.Target)); !slicesContains(allowedFiles, ext) {
+				return errors.Errorf("invalid file %v, should be %v", input.Target, allowedFiles)
+			}
+			if input.Audio && input.IsImage() {
+				return errors.Errorf("no audio for image %v", input.Target)
+			}
+		default:
+			return errors.Errorf("invalid input type %v", input.Type)
+		}
+
+		if len(input.Label) > 64 {
+			return errors.Errorf("invalid label %v, should be no more than 64 bytes", input.Label)
+		}
+		if input.Audio {
+			audios++
+		}
+	}
+	if audios > 1 {
+		return errors.Errorf("invalid audio inputs %v, should be at most 1", audios)
+	}
+
+	if v.Layout == nil {
+		return errors.New("no layout")
+	}
+	switch v.Layout.Type {
+	case MosaicLayoutGrid:
+	case MosaicLayoutPIP:
+		if len(v.Inputs) < 2 || len(v.Inputs) > 4 {
+			return errors.Errorf("invalid inputs %v for pip, should be 2 to 4", len(v.Inputs))
+		}
+	case MosaicLayoutCustom:
+		if len(v.Layout.Rects) != len(v.Inputs) {
+			return errors.Errorf("invalid rects %v, should be one for each of %v inputs", len(v.Layout.Rects), len(v.Inputs))
+		}
+		for _, rect := range v.Layout.Rects {
+			if rect == nil {
+				return errors.New("no rect")
+			}
+			if err := rect.Validate(); err != nil {
+				return errors.Wrapf(err, "validate rect")
+			}
+		}
+	default:
+		return errors.Errorf("invalid layout %v", v.Layout.Type)
+	}
+
+	return nil
+}
+
+// Size returns the size of composed stream, or the default size.
+func (v *MosaicConfig) Size() (int, int) {
+	if v.Width == 0 || v.Height == 0 {
+		return MosaicDefaultWidth, MosaicDefaultHeight
+	}
+	return v.Width, v.Height
+}
+
+// OutputURL returns the RTMP URL of the composed output stream, with the publish secret if not empty.
+func (v *MosaicConfig) OutputURL(secret string) string {
+	if secret == "" {
+		return fmt.Sprintf("rtmp://localhost%v", v.Output)
+	}
+	return fmt.Sprintf("rtmp://localhost%v?secret=%v", v.Output, url.QueryEscape(secret))
+}
+
+// PublisherArgs returns the FFmpeg arguments to publish the relay of composer to the output stream. Because
+// the composer restarts when the inputs or layout changed, the relay is transcoded by wall clock.
+func (v *MosaicConfig) PublisherArgs(relay, outputURL string) []string {
+	width, height := v.Size()
+	return []string{
+		"-fflags", "+genpts", "-use_wallclock_as_timestamps", "1",
+		"-i", fmt.Sprintf("%v?overrun_nonfatal=1&fifo_size=50000000", relay),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=%v:%v,setsar=1,fps=25", width, height),
+		"-af", "aresample=async=1",
+		"-vcodec", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-g", "50", "-bf", "0",
+		"-acodec", "aac", "-ar", "44100", "-ac", "2", "-b:a", "128k",
+		"-f", "flv", outputURL,
+	}
+}
+
+// InputArgs returns the FFmpeg arguments of the available inputs, while the empty input is missing and
+// replaced by placeholder. The silent audio is the last input, if no audio input is available.
+func (v *MosaicConfig) InputArgs(inputs []string) []string {
+	var args []string
+	var hasAudio bool
+	for i, input := range inputs {
+		if input == "" {
+			continue
+		}
+
+		if conf := v.Inputs[i]; conf.IsImage() {
+			args = append(args, "-re", "-loop", "1", "-framerate", "25", "-i", input)
+		} else if conf.Type == MosaicInputFile {
+			args = append(args, "-stream_loop", "-1", "-re", "-i", input)
+		} else {
+			args = append(args, "-i", input)
+		}
+		hasAudio = hasAudio || v.Inputs[i].Audio
+	}
+
+	if !hasAudio {
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo")
+	}
+	return args
+}
+
+// FilterComplex returns the FFmpeg filter graph to compose the inputs by layout, and the audio stream to
+// map. Each tile is scaled and padded to its rectangle, with optional label, and a placeholder tile is
+// generated for the missing input.
+func (v *MosaicConfig) FilterComplex(inputs []string) (string, string) {
+	width, height := v.Size()
+	even := func(ratio float64, size int) int {
+		return int(math.Round(ratio*float64(size)/2)) * 2
+	}
+	drawtext := func(text string, size int, x, y string) string {
+		return fmt.Sprintf("drawtext=text=%v:expansion=none:fontcolor=white:fontsize=%v:x=%v:y=%v",
+			mosaicEscapeText(text), size, x, y)
+	}
+
+	var filters []string
+	filters = append(filters, fmt.Sprintf("color=c=black:s=%vx%v:r=25[bg]", width, height))
+
+	var index int
+	audio := ""
+	tiles := v.Layout.Tiles(len(inputs))
+	for i, input := range inputs {
+		rect := tiles[i]
+		w, h := even(rect.Width, width), even(rect.Height, height)
+		if w < 2 {
+			w = 2
+		}
+		if h < 2 {
+			h = 2
+		}
+
+		var tile string
+		if input != "" {
+			tile = fmt.Sprintf("[%v:v]scale=%v:%v:force_original_aspect_ratio=decrease,"+
+				"pad=%v:%v:(ow-iw)/2:(oh-ih)/2:color=black,setsar=1,fps=25", index, w, h, w, h)
+			if v.Inputs[i].Audio {
+				audio = fmt.Sprintf("%v:a?", index)
+			}
+			index++
+		} else {
+			tile = fmt.Sprintf("color=c=0x202020:s=%vx%v:r=25,%v", w, h,
+				drawtext("No Signal", int(math.Max(12, float64(h/10))), "(w-text_w)/2", "(h-text_h)/2"))
+		}
+
+		if label := v.Inputs[i].Label; label != "" {
+			tile += fmt.Sprintf(",%v:box=1:boxcolor=black@0.5:boxborderw=4",
+				drawtext(label, int(math.Max(12, float64(h/16))), "8", "8"))
+		}
+		filters = append(filters, fmt.Sprintf("%v[t%v]", tile, i))
+	}
+
+	// Overlay the tiles on the background in order, so the latter tile is on top, like the pip.
+	last := "bg"
+	for i, rect := range tiles[:len(inputs)] {
+		next := fmt.Sprintf("o%v", i)
+		if i == len(inputs)-1 {
+			next = "v"
+		}
+
+		overlay := fmt.Sprintf("[%v][t%v]overlay=x=%v:y=%v", last, i, even(rect.X, width), even(rect.Y, height))
+		if next == "v" {
+			// Limit the speed, because the placeholder is generated as fast as possible.
+			overlay += ",realtime"
+		}
+		filters = append(filters, fmt.Sprintf("%v[%v]", overlay, next))
+		last = next
+	}
+
+	// Use the silent audio, which is the last input.
+	if audio == "" {
+		audio = fmt.Sprintf("%v:a", index)
+	}
+
+	return strings.Join(filters, ";"), audio
+}
+
+// mosaicEscapeText escapes the text for drawtext in filter graph, which is escaped for the option value,
+// then quoted for the filter graph.
+func mosaicEscapeText(text string) string {
+	text = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(text)
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// removeFiles removes the input files, which are not used by the new configure.
+func (v *MosaicConfig) removeFiles(next *MosaicConfig) {
+	var used []string
+	if next != nil {
+		for _, input := range next.Inputs {
+			if input != nil && input.Type == MosaicInputFile {
+				used = append(used, input.Target)
+			}
+		}
+	}
+
+	for _, input := range v.Inputs {
+		if input == nil || input.Type != MosaicInputFile || slicesContains(used, input.Target) {
+			continue
+		}
+		if !strings.HasPrefix(input.Target, dirMosaicPath) {
+			continue
+		}
+		if _, err := os.Stat(input.Target); err == nil {
+			os.Remove(input.Target)
+		}
+	}
+}
+
+// MosaicTask is a task for FFmpeg to compose the inputs to the output stream.
+type MosaicTask struct {
+	// The ID for task.
+	UUID string `json:"uuid"`
+	// The output stream in /app/stream.
+	Output string `json:"output"`
+
+	// The input urls, empty if missing and replaced by placeholder.
+	Inputs []string `json:"inputs"`
+	// The local UDP url to relay the composed stream to publisher.
+	Relay string `json:"relay"`
+
+	// FFmpeg pid, to compose the inputs.
+	PID int32 `json:"pid"`
+	// FFmpeg pid, to publish the output stream.
+	PublisherPID int32 `json:"publisherPid"`
+	// The local streams used by inputs, to update the tiles when published or unpublished.
+	streams []string
+	// FFmpeg last frame.
+	frame string
+	// The last update time.
+	update time.Time
+
+	// The context for current composer.
+	cancel context.CancelFunc
+	// The context for current publisher.
+	publisherCancel context.CancelFunc
+	// The arguments of current publisher, to restart it when changed.
+	publisherArgs string
+
+	// The configure for mosaic task.
+	config MosaicConfig
+	// The output url with publish secret of config.
+	outputURL string
+	// The mosaic worker.
+	mosaicWorker *MosaicWorker
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func (v *MosaicTask) String() string {
+	return fmt.Sprintf("uuid=%v, output=%v, inputs=%v, relay=%v, pid=%v, publisher=%v, config is %v",
+		v.UUID, v.Output, v.Inputs, v.Relay, v.PID, v.PublisherPID, v.config.String(),
+	)
+}
+
+// remove the task from worker and redis, when task is done.
+func (v *MosaicTask) remove(ctx context.Context) {
+	v.mosaicWorker.tasks.Delete(v.Output)
+
+	if err := rdb.HDel(ctx, SRS_MOSAIC_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "ignore hdel %v %v err %+v", SRS_MOSAIC_TASK, v.UUID, err)
+	}
+}
+
+// uses whether the task uses the local stream as input.
+func (v *MosaicTask) uses(stream string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return slicesContains(v.streams, stream)
+}
+
+// Restart the composer to use the new inputs or layout, while the publisher keeps running.
+func (v *MosaicTask) Restart(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.cancel != nil {
+		v.cancel()
+	}
+
+	return nil
+}
+
+// resolveInputs returns the input urls, empty if missing, and the local streams used by inputs.
+func (v *MosaicTask) resolveInputs(ctx context.Context, config *MosaicConfig) ([]string, []string, error) {
+	active := make(map[string]bool)
+	if streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result(); err != nil && err != redis.Nil {
+		return nil, nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+	} else {
+		for _, value := range streams {
+			var stream SrsStream
+			if err := json.Unmarshal([]byte(value), &stream); err != nil {
+				return nil, nil, errors.Wrapf(err, "unmarshal %v", value)
+			}
+			active[fmt.Sprintf("/%v/%v", stream.App, stream.Stream)] = true
+		}
+	}
+
+	var inputs, streams []string
+	for _, input := range config.Inputs {
+		var stream string
+		switch input.Type {
+		case MosaicInputStream:
+			stream = input.Target
+		case MosaicInputCamera:
+			// Use the local stream the camera publishes to, rather than pull the camera again.
+			var camera CameraConfigure
+			if b, err := rdb.HGet(ctx, SRS_CAMERA_CONFIG, input.Target).Result(); err != nil && err != redis.Nil {
+				return nil, nil, errors.Wrapf(err, "hget %v %v", SRS_CAMERA_CONFIG, input.Target)
+			} else if b != "" {
+				if err = json.Unmarshal([]byte(b), &camera); err != nil {
+					return nil, nil, errors.Wrapf(err, "unmarshal %v", b)
+				}
+				if app, name := camera.LocalStream(); app != "" && name != "" {
+					stream = fmt.Sprintf("/%v/%v", app, name)
+				}
+			}
+		case MosaicInputFile:
+			if _, err := os.Stat(input.Target); err == nil {
+				inputs = append(inputs, input.Target)
+			} else {
+				inputs = append(inputs, "")
+			}
+			continue
+		}
+
+		if stream != "" {
+			streams = append(streams, stream)
+		}
+		if stream != "" && active[stream] {
+			inputs = append(inputs, withInternalPlay(fmt.Sprintf("rtmp://localhost%v", stream)))
+		} else {
+			inputs = append(inputs, "")
+		}
+	}
+
+	return inputs, streams, nil
+}
+
+func (v *MosaicTask) Run(ctx context.Context) error {
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "mosaic: Run task %v", v.String())
+
+	// Allocate a local UDP port to relay the composed stream to publisher.
+	if conn, err := net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		return errors.Wrapf(err, "listen udp")
+	} else {
+		v.Relay = fmt.Sprintf("udp://127.0.0.1:%v", conn.LocalAddr().(*net.UDPAddr).Port)
+		conn.Close()
+	}
+
+	// Start the publisher, which lives as long as the task, and restarts only when failed or output changed.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.doPublish(ctx); err != nil {
+				logger.Wf(ctx, "ignore publish %v err %+v", v.String(), err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+
+	// Whether the task should quit, for example, the config is removed or disabled.
+	var quit bool
+
+	pfn := func(ctx context.Context) error {
+		var config MosaicConfig
+		if b, err := rdb.HGet(ctx, SRS_MOSAIC_CONFIG, v.Output).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_MOSAIC_CONFIG, v.Output)
+		} else if b == "" {
+			quit = true
+			return nil
+		} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		if !config.Enabled {
+			quit = true
+			return nil
+		}
+
+		inputs, streams, err := v.resolveInputs(ctx, &config)
+		if err != nil {
+			return errors.Wrapf(err, "resolve inputs")
+		}
+
+		secret, err := publishSecretOf(ctx, config.Output)
+		if err != nil {
+			return errors.Wrapf(err, "query secret of %v", config.Output)
+		}
+
+		// Restart the publisher if the output changed, for example, the size or secret is updated.
+		v.lock.Lock()
+		v.config, v.streams, v.outputURL = config, streams, config.OutputURL(secret)
+		if v.publisherCancel != nil && v.publisherArgs != "" &&
+			v.publisherArgs != strings.Join(config.PublisherArgs(v.Relay, v.outputURL), " ") {
+			v.publisherCancel()
+		}
+		v.lock.Unlock()
+
+		if err := v.doMosaic(ctx, inputs); err != nil {
+			return errors.Wrapf(err, "do mosaic")
+		}
+
+		return nil
+	}
+
+	for ctx.Err() == nil && !quit {
+		if err := pfn(ctx); err != nil {
+			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(3500 * time.Millisecond):
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	return nil
+}
+
+func (v *MosaicTask) doMosaic(ctx context.Context, inputs []string) error {
+	// Create context for current task.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	v.lock.Lock()
+	v.cancel = cancel
+	v.lock.Unlock()
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Start FFmpeg process, relay the composed stream to publisher.
+	filter, audio := v.config.FilterComplex(inputs)
+	args := v.config.InputArgs(inputs)
+	args = append(args, "-filter_complex", filter, "-map", "[v]", "-map", audio,
+		"-vcodec", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-crf", "18",
+		"-pix_fmt", "yuv420p", "-r", "25", "-g", "50", "-bf", "0",
+		"-acodec", "aac", "-b:a", "128k",
+		"-f", "mpegts", fmt.Sprintf("%v?pkt_size=1316", v.Relay),
+	)
+	// Create the command object.
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	v.PID, v.Inputs = int32(cmd.Process.Pid), inputs
+	v.lock.Unlock()
+	defer func() {
+		// If we got a PID, sleep for a while, to avoid too fast restart.
+		if v.PID > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanup(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "mosaic: Start, output=%v, inputs=%v, layout=%v, relay=%v, pid=%v",
+		v.Output, inputs, v.config.Layout, v.Relay, v.PID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Drop the log frame, we use the frame of publisher.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.FrameLogs:
+			}
+		}
+	}()
+
+	// Process terminated, or user cancel the process, or the inputs or layout changed.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "mosaic: Cycle stopping, output=%v, pid=%v", v.Output, v.PID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "mosaic: Cycle done, output=%v, pid=%v, err=%v", v.Output, v.PID, err)
+	return err
+}
+
+func (v *MosaicTask) doPublish(ctx context.Context) error {
+	// Create context for current publisher.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	v.lock.Lock()
+	v.publisherCancel = cancel
+	relay, outputURL, config := v.Relay, v.outputURL, v.config
+	v.lock.Unlock()
+
+	// Wait for the config to be loaded.
+	if outputURL == "" {
+		return nil
+	}
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Start FFmpeg process.
+	args := config.PublisherArgs(relay, outputURL)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	v.PublisherPID, v.publisherArgs = int32(cmd.Process.Pid), strings.Join(args, " ")
+	v.lock.Unlock()
+	defer func() {
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanupPublisher(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "mosaic: Start publisher, relay=%v, output=%v, pid=%v", relay, outputURL, v.PublisherPID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Pull the latest log frame.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-heartbeat.FrameLogs:
+				v.updateFrame(frame)
+			}
+		}
+	}()
+
+	// Process terminated, or task is done, or output changed.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "mosaic: Publisher stopping, output=%v, pid=%v", v.Output, v.PublisherPID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "mosaic: Publisher done, output=%v, pid=%v, err=%v", v.Output, v.PublisherPID, err)
+	return err
+}
+
+func (v *MosaicTask) updateFrame(frame string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.frame = strings.TrimSpace(frame)
+	v.update = time.Now()
+}
+
+func (v *MosaicTask) queryFrame() (int32, []string, string, string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.PID, append([]string{}, v.Inputs...), v.frame, v.update.Format(time.RFC3339)
+}
+
+func (v *MosaicTask) saveTask(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_MOSAIC_TASK, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_MOSAIC_TASK, v.UUID, string(b))
+	}
+
+	return nil
+}
+
+func (v *MosaicTask) cleanup(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill task pid=%v", v.PID)
+	syscall.Kill(int(v.PID), syscall.SIGKILL)
+
+	v.PID = 0
+	v.cancel = nil
+
+	return nil
+}
+
+func (v *MosaicTask) cleanupPublisher(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PublisherPID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill publisher pid=%v", v.PublisherPID)
+	syscall.Kill(int(v.PublisherPID), syscall.SIGKILL)
+
+	v.PublisherPID = 0
+	v.publisherCancel = nil
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMosaic_Validate(t *testing.T) {
+	newStream := func(stream string) *MosaicInput {
+		return &MosaicInput{Type: MosaicInputStream, Target: stream}
+	}
+	grid := &MosaicLayout{Type: MosaicLayoutGrid}
+	pip := &MosaicLayout{Type: MosaicLayoutPIP}
+
+	for _, e := range []struct {
+		config MosaicConfig
+		valid  bool
+	}{
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid, Inputs: []*MosaicInput{
+			newStream("/live/a"), {Type: MosaicInputCamera, Target: "camera-1", Label: "Gate"},
+			{Type: MosaicInputFile, Target: dirMosaicPath + "/a.png"},
+		}}, valid: true},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: pip, Width: 1920, Height: 1080, Inputs: []*MosaicInput{
+			{Type: MosaicInputStream, Target: "/live/host", Audio: true}, newStream("/live/screen"),
+		}}, valid: true},
+		{config: MosaicConfig{Output: "/live/mosaic", Inputs: []*MosaicInput{newStream("/live/a"), newStream("/live/b")},
+			Layout: &MosaicLayout{Type: MosaicLayoutCustom, Rects: []*MosaicRect{
+				{X: 0, Y: 0, Width: 0.5, Height: 1}, {X: 0.5, Y: 0, Width: 0.5, Height: 1},
+			}}}, valid: true},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid}, valid: false},
+		{config: MosaicConfig{Output: "mosaic", Layout: grid, Inputs: []*MosaicInput{newStream("/live/a")}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Inputs: []*MosaicInput{newStream("/live/a")}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid, Inputs: []*MosaicInput{newStream("/live/mosaic")}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid, Width: 1281, Height: 720,
+			Inputs: []*MosaicInput{newStream("/live/a")}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: pip, Inputs: []*MosaicInput{newStream("/live/a")}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid, Inputs: []*MosaicInput{
+			{Type: MosaicInputFile, Target: "/etc/a.mp4"},
+		}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Layout: grid, Inputs: []*MosaicInput{
+			{Type: MosaicInputStream, Target: "/live/a", Audio: true}, {Type: MosaicInputStream, Target: "/live/b", Audio: true},
+		}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Inputs: []*MosaicInput{newStream("/live/a"), newStream("/live/b")},
+			Layout: &MosaicLayout{Type: MosaicLayoutCustom, Rects: []*MosaicRect{{X: 0, Y: 0, Width: 1, Height: 1}}}}, valid: false},
+		{config: MosaicConfig{Output: "/live/mosaic", Inputs: []*MosaicInput{newStream("/live/a")},
+			Layout: &MosaicLayout{Type: MosaicLayoutCustom, Rects: []*MosaicRect{{X: 0.5, Y: 0, Width: 0.6, Height: 1}}}}, valid: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.valid, err)
+		}
+	}
+}
+
+func TestMosaic_Tiles(t *testing.T) {
+	for _, e := range []struct {
+		layout MosaicLayout
+		n      int
+		tiles  []MosaicRect
+	}{
+		{layout: MosaicLayout{Type: MosaicLayoutGrid}, n: 1, tiles: []MosaicRect{{0, 0, 1, 1}}},
+		{layout: MosaicLayout{Type: MosaicLayoutGrid}, n: 3, tiles: []MosaicRect{
+			{0, 0, 0.5, 0.5}, {0.5, 0, 0.5, 0.5}, {0, 0.5, 0.5, 0.5},
+		}},
+		{layout: MosaicLayout{Type: MosaicLayoutPIP}, n: 2, tiles: []MosaicRect{{0, 0, 1, 1}, {0.73, 0.73, 0.25, 0.25}}},
+	} {
+		tiles := e.layout.Tiles(e.n)
+		if len(tiles) != len(e.tiles) {
+			t.Errorf("Fail for layout %v, expect %v, actual %v", e.layout.String(), len(e.tiles), len(tiles))
+			continue
+		}
+		for i, tile := range tiles {
+			if expect := e.tiles[i]; tile.String() != expect.String() {
+				t.Errorf("Fail for layout %v tile %v, expect %v, actual %v", e.layout.String(), i, expect.String(), tile.String())
+			}
+		}
+	}
+}
+
+func TestMosaic_FilterComplex(t *testing.T) {
+	config := MosaicConfig{Width: 640, Height: 360, Layout: &MosaicLayout{Type: MosaicLayoutGrid}, Inputs: []*MosaicInput{
+		{Type: MosaicInputStream, Target: "/live/a", Label: "Gate"},
+		{Type: MosaicInputStream, Target: "/live/b"},
+		{Type: MosaicInputFile, Target: dirMosaicPath + "/c.mp4", Audio: true},
+	}}
+
+	// The second input is missing, so replaced by placeholder, and the file is the second FFmpeg input.
+	inputs := []string{"rtmp://localhost/live/a", "", dirMosaicPath + "/c.mp4"}
+	if args := strings.Join(config.InputArgs(inputs), " "); args != "-i rtmp://localhost/live/a "+
+		"-stream_loop -1 -re -i "+dirMosaicPath+"/c.mp4" {
+		t.Errorf("Fail for args %v", args)
+	}
+
+	filter, audio := config.FilterComplex(inputs)
+	if audio != "1:a?" {
+		t.Errorf("Fail for audio %v", audio)
+	}
+	for _, expect := range []string{
+		"color=c=black:s=640x360:r=25[bg]",
+		"[0:v]scale=320:180:force_original_aspect_ratio=decrease,pad=320:180:(ow-iw)/2:(oh-ih)/2:color=black,setsar=1,fps=25," +
+			"drawtext=text='Gate':expansion=none:fontcolor=white:fontsize=12:x=8:y=8:box=1:boxcolor=black@0.5:boxborderw=4[t0]",
+		"color=c=0x202020:s=320x180:r=25,drawtext=text='No Signal':expansion=none:fontcolor=white:fontsize=18:" +
+			"x=(w-text_w)/2:y=(h-text_h)/2[t1]",
+		"[1:v]scale=320:180",
+		"[bg][t0]overlay=x=0:y=0[o0]",
+		"[o0][t1]overlay=x=320:y=0[o1]",
+		"[o1][t2]overlay=x=0:y=180,realtime[v]",
+	} {
+		if !strings.Contains(filter, expect) {
+			t.Errorf("Fail for filter %v, expect %v", filter, expect)
+		}
+	}
+
+	// Use the silent audio if no audio input available.
+	inputs = []string{"rtmp://localhost/live/a", "", ""}
+	if args := strings.Join(config.InputArgs(inputs), " "); !strings.HasSuffix(args, "-f lavfi -i anullsrc=r=44100:cl=stereo") {
+		t.Errorf("Fail for args %v", args)
+	}
+	if _, audio := config.FilterComplex(inputs); audio != "1:a" {
+		t.Errorf("Fail for audio %v", audio)
+	}
+}
+
+func TestMosaic_EscapeText(t *testing.T) {
+	for _, e := range []struct {
+		text   string
+		expect string
+	}{
+		{text: "Gate", expect: `'Gate'`},
+		{text: "Cam: 1", expect: `'Cam\: 1'`},
+		{text: "Host's", expect: `'Host\'\''s'`},
+		{text: `a\b,[c];100%`, expect: `'a\\b,[c];100%'`},
+	} {
+		if text := mosaicEscapeText(e.text); text != e.expect {
+			t.Errorf("Fail for text %v, expect %v, actual %v", e.text, e.expect, text)
+		}
+	}
+}
+
+func TestMosaic_PublisherArgs(t *testing.T) {
+	config := MosaicConfig{Output: "/live/mosaic", Width: 640, Height: 360}
+	if output := config.OutputURL("xxx"); output != "rtmp://localhost/live/mosaic?secret=xxx" {
+		t.Errorf("Fail for output %v", output)
+	}
+	if output := config.OutputURL(""); output != "rtmp://localhost/live/mosaic" {
+		t.Errorf("Fail for output %v", output)
+	}
+
+	args := strings.Join(config.PublisherArgs("udp://127.0.0.1:5000", config.OutputURL("xxx")), " ")
+	for _, expect := range []string{
+		"-use_wallclock_as_timestamps 1 -i udp://127.0.0.1:5000?overrun_nonfatal=1",
+		"-vf scale=640:360,setsar=1,fps=25", "-f flv rtmp://localhost/live/mosaic?secret=xxx",
+	} {
+		if !strings.Contains(args, expect) {
+			t.Errorf("Fail for args %v, expect %v", args, expect)
+		}
+	}
+}
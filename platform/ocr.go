@@ -61,6 +61,7 @@ func NewOCRWorker() *OCRWorker {
 func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ai/ocr/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -73,7 +74,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -105,6 +106,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -122,7 +124,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -155,6 +157,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/check"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -170,7 +173,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -216,6 +219,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/reset"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -231,7 +235,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -258,6 +262,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/live-queue"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -270,7 +275,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -310,6 +315,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/ocr-queue"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -322,7 +328,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -370,6 +376,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/callback-queue"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -382,7 +389,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -438,6 +445,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/cleanup-queue"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -450,7 +458,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -510,6 +518,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/ai/ocr/image/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			// Format is /image/:uuid.jpg
@@ -0,0 +1,244 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The idle duration to cleanup the rate limit buckets and login failures.
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// The expensive API prefixes, such as uploading files, which have a separate and smaller budget.
+var rateLimitExpensivePrefixes = []string{
+	"/terraform/v1/ffmpeg/vlive/upload/",
+	"/terraform/v1/ffmpeg/upload/create",
+	"/terraform/v1/ffmpeg/camera/onvif/discover",
+	"/terraform/v1/ai-talk/stage/upload",
+	"/terraform/v1/dubbing/source",
+}
+
+// RateLimiter is a token bucket rate limiter, by key such as client IP.
+type RateLimiter struct {
+	// The rate to refill tokens, per second.
+	rate float64
+	// The capacity of bucket, the max burst of requests.
+	burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*rateLimitBucket
+	// The last time to cleanup the idle buckets.
+	cleanup time.Time
+}
+
+type rateLimitBucket struct {
+	tokens float64
+	update time.Time
+}
+
+func NewRateLimiter(rate, burst float64) *RateLimiter {
+	return &RateLimiter{
+		rate: rate, burst: burst, buckets: make(map[string]*rateLimitBucket), cleanup: time.Now(),
+	}
+}
+
+// Allow whether the request of key is allowed, and consume a token if allowed. Return the duration to
+// wait for next token if not allowed.
+func (v *RateLimiter) Allow(key string) (bool, time.Duration) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(v.cleanup) > rateLimitIdleTimeout {
+		for k, bucket := range v.buckets {
+			if now.Sub(bucket.update) > rateLimitIdleTimeout {
+				delete(v.buckets, k)
+			}
+		}
+		v.cleanup = now
+	}
+
+	bucket, ok := v.buckets[key]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: v.burst, update: now}
+		v.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(v.burst, bucket.tokens+now.Sub(bucket.update).Seconds()*v.rate)
+	bucket.update = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / v.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// ApiRateLimiter limits the request rate of management API, per client IP.
+type ApiRateLimiter struct {
+	// For normal API, nil if disabled.
+	normal *RateLimiter
+	// For expensive API, nil if disabled.
+	expensive *RateLimiter
+}
+
+// NewApiRateLimiter creates the limiter by env API_RATE_LIMIT, the requests per second of normal API,
+// and API_RATE_LIMIT_EXPENSIVE, the requests per minute of expensive API. Disabled if set to 0.
+func NewApiRateLimiter() (*ApiRateLimiter, error) {
+	v := &ApiRateLimiter{}
+
+	if rate, err := strconv.ParseFloat(envApiRateLimit(), 64); err != nil {
+		return nil, errors.Wrapf(err, "parse API_RATE_LIMIT %v", envApiRateLimit())
+	} else if rate > 0 {
+		v.normal = NewRateLimiter(rate, rate*2)
+	}
+
+	if rate, err := strconv.ParseFloat(envApiRateLimitExpensive(), 64); err != nil {
+		return nil, errors.Wrapf(err, "parse API_RATE_LIMIT_EXPENSIVE %v", envApiRateLimitExpensive())
+	} else if rate > 0 {
+		v.expensive = NewRateLimiter(rate/60, rate)
+	}
+
+	return v, nil
+}
+
+// Allow whether the request is allowed, response 429 if not.
+func (v *ApiRateLimiter) Allow(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	p := r.URL.Path
+	if !strings.HasPrefix(p, "/terraform/v1/") {
+		return true
+	}
+
+	// Never limit the requests from localhost, such as the callbacks from SRS. Note that the client IP is
+	// the peer address, unless forwarded by trusted proxy, so it's not able to be forged.
+	ip := httpClientIP(r)
+	if isLoopbackIP(ip) {
+		return true
+	}
+
+	limiter := v.normal
+	for _, prefix := range rateLimitExpensivePrefixes {
+		if strings.HasPrefix(p, prefix) {
+			limiter = v.expensive
+			break
+		}
+	}
+	if limiter == nil {
+		return true
+	}
+
+	if ok, wait := limiter.Allow(ip); !ok {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, fmt.Sprintf("rate limited, retry after %vs", retryAfter), http.StatusTooManyRequests)
+		logger.Wf(ctx, "rate limited, ip=%v, path=%v, retry=%vs", ip, p, retryAfter)
+		return false
+	}
+
+	return true
+}
+
+// The number of failed logins before lockout.
+const LoginMaxFailures = 5
+
+// The base and max duration of lockout, which is doubled by each failure after LoginMaxFailures.
+const LoginLockoutBase = 30 * time.Second
+const LoginLockoutMax = time.Hour
+
+// LoginLockout returns the lockout duration for the number of failed logins.
+func LoginLockout(failures int) time.Duration {
+	if failures < LoginMaxFailures {
+		return 0
+	}
+
+	lockout := LoginLockoutBase
+	for i := LoginMaxFailures; i < failures && lockout < LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > LoginLockoutMax {
+		lockout = LoginLockoutMax
+	}
+	return lockout
+}
+
+// LoginGuard tracks the failed logins by key, such as client IP and account, and locks out the key
+// progressively.
+type LoginGuard struct {
+	lock     sync.Mutex
+	failures map[string]*loginFailure
+}
+
+type loginFailure struct {
+	count  int
+	locked time.Time
+	update time.Time
+}
+
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{failures: make(map[string]*loginFailure)}
+}
+
+// Locked returns the remaining lockout duration of keys, zero if not locked.
+func (v *LoginGuard) Locked(keys ...string) time.Duration {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var remaining time.Duration
+	for _, key := range keys {
+		if failure, ok := v.failures[key]; ok {
+			if d := time.Until(failure.locked); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining
+}
+
+// Failed records a failed login of keys, and locks out the keys if too many failures.
+func (v *LoginGuard) Failed(keys ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := time.Now()
+	for k, failure := range v.failures {
+		if now.Sub(failure.update) > LoginLockoutMax && now.After(failure.locked) {
+			delete(v.failures, k)
+		}
+	}
+
+	for _, key := range keys {
+		failure, ok := v.failures[key]
+		if !ok {
+			failure = &loginFailure{}
+			v.failures[key] = failure
+		}
+
+		failure.count++
+		failure.update = now
+		if lockout := LoginLockout(failure.count); lockout > 0 {
+			failure.locked = now.Add(lockout)
+		}
+	}
+}
+
+// Succeeded resets the failed logins of keys.
+func (v *LoginGuard) Succeeded(keys ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, key := range keys {
+		delete(v.failures, key)
+	}
+}
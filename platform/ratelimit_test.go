@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit_TokenBucket(t *testing.T) {
+	limiter := NewRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("ip"); !ok {
+			t.Errorf("Fail for request #%v should be allowed by burst", i)
+		}
+	}
+
+	if ok, wait := limiter.Allow("ip"); ok || wait <= 0 || wait > time.Second {
+		t.Errorf("Fail for request should be limited, ok=%v, wait=%v", ok, wait)
+	}
+	if ok, _ := limiter.Allow("other"); !ok {
+		t.Errorf("Fail for other key should be allowed")
+	}
+}
+
+func TestRateLimit_LoginLockout(t *testing.T) {
+	for _, e := range []struct {
+		failures int
+		lockout  time.Duration
+	}{
+		{failures: 0, lockout: 0},
+		{failures: LoginMaxFailures - 1, lockout: 0},
+		{failures: LoginMaxFailures, lockout: LoginLockoutBase},
+		{failures: LoginMaxFailures + 1, lockout: 2 * LoginLockoutBase},
+		{failures: LoginMaxFailures + 2, lockout: 4 * LoginLockoutBase},
+		{failures: 100, lockout: LoginLockoutMax},
+	} {
+		if lockout := LoginLockout(e.failures); lockout != e.lockout {
+			t.Errorf("Fail for failures %v, expect %v, actual %v", e.failures, e.lockout, lockout)
+		}
+	}
+}
+
+func TestRateLimit_LoginGuard(t *testing.T) {
+	guard := NewLoginGuard()
+	for i := 0; i < LoginMaxFailures-1; i++ {
+		guard.Failed("ip:1.2.3.4", "user:admin")
+	}
+	if locked := guard.Locked("ip:1.2.3.4"); locked != 0 {
+		t.Errorf("Fail for should not lock before max failures, locked=%v", locked)
+	}
+
+	guard.Failed("ip:1.2.3.4", "user:admin")
+	if locked := guard.Locked("user:admin"); locked <= 0 {
+		t.Errorf("Fail for should lock after max failures")
+	}
+	if locked := guard.Locked("ip:5.6.7.8"); locked != 0 {
+		t.Errorf("Fail for other ip should not lock, locked=%v", locked)
+	}
+
+	guard.Succeeded("ip:1.2.3.4", "user:admin")
+	if locked := guard.Locked("ip:1.2.3.4", "user:admin"); locked != 0 {
+		t.Errorf("Fail for should unlock after succeeded, locked=%v", locked)
+	}
+}
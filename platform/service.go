@@ -85,6 +85,11 @@ func (v *httpService) Run(ctx context.Context) error {
 			return errors.Wrapf(err, "handle service")
 		}
 
+		rateLimiter, err := NewApiRateLimiter()
+		if err != nil {
+			return errors.Wrapf(err, "create rate limiter")
+		}
+
 		handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			// Set common header.
 			ohttp.SetHeader(w)
@@ -98,8 +103,13 @@ func (v *httpService) Run(ctx context.Context) error {
 				return
 			}
 
-			// Handle by service handler.
-			serviceHandler.ServeHTTP(w, r)
+			// Limit the request rate of API, per client IP.
+			if !rateLimiter.Allow(ctx, w, r) {
+				return
+			}
+
+			// Handle by service handler, and audit the mutating API calls.
+			handleAuditRequest(ctx, w, r, serviceHandler)
 		})
 	}
 
@@ -233,10 +243,22 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle forward")
 	}
 
+	if err := fallbackWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle fallback")
+	}
+
+	if err := mosaicWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle mosaic")
+	}
+
 	if err := vLiveWorker.Handle(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle vLive")
 	}
 
+	if err := uploadWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle upload")
+	}
+
 	if err := cameraWorker.Handle(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle IP camera")
 	}
@@ -245,6 +267,22 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle hooks")
 	}
 
+	if err := handleStreamKeyService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle stream keys")
+	}
+
+	if err := handleUserService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle users")
+	}
+
+	if err := handleApiKeyService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle api keys")
+	}
+
+	if err := handleAuditService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle audit")
+	}
+
 	if err := handleLiveRoomService(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle live room")
 	}
@@ -308,12 +346,22 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return err
 	}
 
+	// Never expose the internal play token to players, by the redirect or playlist of SRS HLS.
+	modifyResponse8080 := proxy8080.ModifyResponse
+	proxy8080.ModifyResponse = func(resp *http.Response) error {
+		if err := modifyResponse8080(resp); err != nil {
+			return err
+		}
+		return hlsStripInternalResponse(resp)
+	}
+
 	platformFileServer := http.FileServer(http.Dir(path.Join(conf.Pwd, "containers/www")))
 	wellKnownFileServer := http.FileServer(http.Dir(path.Join(conf.Pwd, "containers/data")))
 	hlsFileServer := http.FileServer(http.Dir(path.Join(conf.Pwd, "containers/objs/nginx/html")))
 
 	ep = "/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		// For version management.
 		if strings.HasPrefix(r.URL.Path, "/terraform/v1/releases") {
@@ -367,6 +415,25 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 				}
 			}
 
+			// Verify the play token of WHEP, because SRS only sees the platform as the player.
+			if strings.Contains(r.URL.Path, "/whep/") {
+				q := r.URL.Query()
+				app, stream := q.Get("app"), q.Get("stream")
+				verifiedBy, err := verifyPlayAuth(ctx, app, stream, q.Get("token"), httpClientIP(r))
+				if err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					ohttp.WriteError(ctx, w, r, errors.Wrapf(err, "verify %v", r.URL.Path))
+					return
+				}
+
+				q.Del("token")
+				q.Del("oryx_internal")
+				if verifiedBy != "noVerify" {
+					q.Set("oryx_internal", newInternalPlayToken(app, stream))
+				}
+				r.URL.RawQuery = q.Encode()
+			}
+
 			proxyWhxp.ServeHTTP(&whxpResponseModifier{w}, r)
 			return
 		}
@@ -382,7 +449,7 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		if strings.HasPrefix(r.URL.Path, "/api/") {
 			token := r.URL.Query().Get("token")
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				ohttp.WriteError(ctx, w, r, err)
 				return
@@ -393,6 +460,63 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 			return
 		}
 
+		// Verify the play token of HTTP streams, because SRS only sees the platform as the player. The verified
+		// players of protected streams are marked as internal players of the stream, to pass the on_play of SRS.
+		isHttpStream := strings.HasSuffix(r.URL.Path, ".flv") || strings.HasSuffix(r.URL.Path, ".m3u8") ||
+			strings.HasSuffix(r.URL.Path, ".ts") || strings.HasSuffix(r.URL.Path, ".aac") ||
+			strings.HasSuffix(r.URL.Path, ".mp3")
+		if isHttpStream {
+			q := r.URL.Query()
+			token := q.Get("token")
+			app, stream := httpStreamOfPath(r.URL.Path)
+
+			// The renditions of ABR transcoding are verified by the token of the ABR stream.
+			authApp, authStream := app, stream
+			if abrApp, abrStream, ok := transcodeWorker.ABRStreamOf(app, stream); ok {
+				authApp, authStream = abrApp, abrStream
+			}
+
+			verifiedBy, err := verifyPlayAuth(ctx, authApp, authStream, token, httpClientIP(r))
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				ohttp.WriteError(ctx, w, r, errors.Wrapf(err, "verify %v", r.URL.Path))
+				return
+			}
+
+			q.Del("token")
+			q.Del("oryx_internal")
+			if verifiedBy != "noVerify" {
+				q.Set("oryx_internal", newInternalPlayToken(app, stream))
+			}
+			r.URL.RawQuery = q.Encode()
+
+			// Serve the HLS master playlist of ABR transcoding, which is generated by us.
+			if strings.HasSuffix(r.URL.Path, ".m3u8") {
+				playToken := token
+				if verifiedBy == "noVerify" {
+					playToken = ""
+				}
+				if transcodeWorker.ServeMasterPlaylist(ctx, w, r, playToken) {
+					return
+				}
+			}
+
+			// Serve the HLS playlist with token for each segment, because the segments are also verified.
+			if verifiedBy != "noVerify" && strings.HasSuffix(r.URL.Path, ".m3u8") {
+				filename := path.Join(conf.Pwd, "containers/objs/nginx/html", path.Clean(r.URL.Path))
+				b, err := ioutil.ReadFile(filename)
+				if err != nil {
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Header().Set("Cache-Control", "no-cache, max-age=0")
+				w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+				w.Write([]byte(hlsRewritePlaylist(string(b), token)))
+				return
+			}
+		}
+
 		// Always directly serve the HLS ts files.
 		if fastCache.HLSHighPerformance && strings.HasSuffix(r.URL.Path, ".m3u8") {
 			var m3u8ExpireInSeconds int = 10
@@ -428,6 +552,7 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 func handleDebuggingGoroutines(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/debug/goroutines"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		buf := make([]byte, 1<<16)
 		stacklen := runtime.Stack(buf, true)
@@ -438,6 +563,7 @@ func handleDebuggingGoroutines(ctx context.Context, handler *http.ServeMux) {
 func handleHostVersions(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/host/versions"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		ohttp.WriteData(ctx, w, r, &struct {
 			Version string `json:"version"`
@@ -450,6 +576,7 @@ func handleHostVersions(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtVersions(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/versions"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		ohttp.WriteData(ctx, w, r, &struct {
 			Version string `json:"version"`
@@ -462,6 +589,7 @@ func handleMgmtVersions(ctx context.Context, handler *http.ServeMux) {
 func handleFFmpegVersions(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/ffmpeg/versions"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		ohttp.WriteData(ctx, w, r, &struct {
 			Version string `json:"version"`
@@ -474,6 +602,7 @@ func handleFFmpegVersions(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/init"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			b, err := ioutil.ReadAll(r.Body)
@@ -525,7 +654,7 @@ func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, envApiSecret())
+			expireAt, createAt, token, err := createToken(ctx, envApiSecret(), "")
 			if err != nil {
 				return errors.Wrapf(err, "build token")
 			}
@@ -551,6 +680,7 @@ func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtCheck(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/check"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			// Check whether redis is ok.
@@ -581,6 +711,7 @@ func handleMgmtCheck(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtEnvs(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/envs"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var locale string
@@ -688,6 +819,7 @@ func handleMgmtEnvs(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/token"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -700,11 +832,18 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			identity, err := AuthenticateIdentity(ctx, apiSecret, token, r)
+			if err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			expireAt, createAt, token, err := createToken(ctx, envApiSecret())
+			// Keep the user of token, never upgrade to root user.
+			var userName string
+			if identity.User != nil {
+				userName = identity.User.Name
+			}
+
+			expireAt, createAt, token, err := createToken(ctx, envApiSecret(), userName)
 			if err != nil {
 				return errors.Wrapf(err, "build token")
 			}
@@ -716,7 +855,7 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 			}{
 				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
 			})
-			logger.Tf(ctx, "login by token ok, create=%v, expire=%v, token=%vB", createAt, expireAt, len(token))
+			logger.Tf(ctx, "login by token ok, user=%v, create=%v, expire=%v, token=%vB", userName, createAt, expireAt, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -726,10 +865,19 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 
 func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 	var loginLock sync.Mutex
+	// Track the failed logins by client IP and account, to protect from brute force attack.
+	loginGuard := NewLoginGuard()
+
 	ep := "/terraform/v1/mgmt/login"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
+			ip := httpClientIP(r)
+			if locked := loginGuard.Locked(fmt.Sprintf("ip:%v", ip)); locked > 0 {
+				return errors.Errorf("too many failed logins, ip=%v, retry after %v", ip, locked.Round(time.Second))
+			}
+
 			if !loginLock.TryLock() {
 				return errors.New("login is running, try later")
 			}
@@ -744,11 +892,12 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "read body")
 			}
 
-			var password string
+			var name, password string
 			if err := json.Unmarshal(b, &struct {
+				Name     *string `json:"name"`
 				Password *string `json:"password"`
 			}{
-				Password: &password,
+				Name: &name, Password: &password,
 			}); err != nil {
 				return errors.Wrapf(err, "json unmarshal %v", string(b))
 			}
@@ -757,9 +906,24 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 				return errors.New("no password")
 			}
 
-			if password != envMgmtPassword() {
+			guardKeys := []string{fmt.Sprintf("ip:%v", ip), fmt.Sprintf("user:%v", name)}
+			if locked := loginGuard.Locked(guardKeys...); locked > 0 {
+				return errors.Errorf("too many failed logins, name=%v, retry after %v", name, locked.Round(time.Second))
+			}
+
+			// Login by the named user if specified, or the root user by MGMT_PASSWORD.
+			var user *SrsUser
+			if name != "" {
+				if user, err = LoadSrsUser(ctx, name); err != nil {
+					return errors.Wrapf(err, "load user %v", name)
+				}
+			}
+
+			if (name == "" && password != envMgmtPassword()) || (name != "" && (user == nil || !user.VerifyPassword(password))) {
+				loginGuard.Failed(guardKeys...)
+
 				wait := time.Duration(10) * time.Second
-				logger.Wf(ctx, "Invalid password, wait for %v", wait)
+				logger.Wf(ctx, "Invalid password, name=%v, ip=%v, wait for %v", name, ip, wait)
 
 				select {
 				case <-time.After(wait):
@@ -768,24 +932,36 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 
 				return errors.Errorf("invalid password, wait %v", wait)
 			}
+			loginGuard.Succeeded(guardKeys...)
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, apiSecret)
+			expireAt, createAt, token, err := createToken(ctx, apiSecret, name)
 			if err != nil {
 				return errors.Wrapf(err, "build token")
 			}
 
+			// Never expose the bearer secret to named user, which is the root secret.
+			var bearer string
+			var role UserRole = UserRoleAdmin
+			if user == nil {
+				bearer = apiSecret
+			} else {
+				role = user.Role
+			}
+
 			ohttp.WriteData(ctx, w, r, &struct {
 				Token    string `json:"token"`
 				CreateAt string `json:"createAt"`
 				ExpireAt string `json:"expireAt"`
 				// Allow user to directly use Bearer token.
-				Bearer string `json:"bearer"`
+				Bearer string `json:"bearer,omitempty"`
+				// The role of user.
+				Role UserRole `json:"role"`
 			}{
 				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
-				Bearer: apiSecret,
+				Bearer: bearer, Role: role,
 			})
-			logger.Tf(ctx, "login by password ok, create=%v, expire=%v, token=%vB", createAt, expireAt, len(token))
+			logger.Tf(ctx, "login by password ok, name=%v, role=%v, create=%v, expire=%v, token=%vB", name, role, createAt, expireAt, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -796,6 +972,7 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtStatus(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/status"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -808,7 +985,7 @@ func handleMgmtStatus(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -839,6 +1016,7 @@ func handleMgmtStatus(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtBilibili(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/bilibili"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, bvid string
@@ -852,7 +1030,7 @@ func handleMgmtBilibili(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -932,6 +1110,7 @@ func handleMgmtBilibili(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtOpenAIQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/openai/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -944,7 +1123,7 @@ func handleMgmtOpenAIQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -985,6 +1164,7 @@ func handleMgmtOpenAIQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtOpenAIUpdate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/openai/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1002,7 +1182,7 @@ func handleMgmtOpenAIUpdate(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1035,6 +1215,7 @@ func handleMgmtOpenAIUpdate(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtLimitsQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/limits/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1047,7 +1228,7 @@ func handleMgmtLimitsQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1085,6 +1266,7 @@ func handleMgmtLimitsQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtLimitsUpdate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/limits/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1100,7 +1282,7 @@ func handleMgmtLimitsUpdate(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1131,6 +1313,7 @@ func handleMgmtLimitsUpdate(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtBeianQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/beian/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			r0, err := rdb.HGetAll(ctx, SRS_BEIAN).Result()
@@ -1150,6 +1333,7 @@ func handleMgmtBeianQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtSecretQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/secret/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1162,7 +1346,7 @@ func handleMgmtSecretQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1178,6 +1362,7 @@ func handleMgmtSecretQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtBeianUpdate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/beian/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, beian, text string
@@ -1192,7 +1377,7 @@ func handleMgmtBeianUpdate(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1219,6 +1404,7 @@ func handleMgmtBeianUpdate(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtNginxHlsUpdate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/hphls/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1233,7 +1419,7 @@ func handleMgmtNginxHlsUpdate(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1258,6 +1444,7 @@ func handleMgmtNginxHlsUpdate(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtNginxHlsQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/hphls/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1270,7 +1457,7 @@ func handleMgmtNginxHlsQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1297,6 +1484,7 @@ func handleMgmtNginxHlsQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtHlsLowLatencyUpdate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/hlsll/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1311,7 +1499,7 @@ func handleMgmtHlsLowLatencyUpdate(ctx context.Context, handler *http.ServeMux)
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1336,6 +1524,7 @@ func handleMgmtHlsLowLatencyUpdate(ctx context.Context, handler *http.ServeMux)
 func handleMgmtHlsLowLatencyQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/hlsll/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1348,7 +1537,7 @@ func handleMgmtHlsLowLatencyQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1375,6 +1564,7 @@ func handleMgmtHlsLowLatencyQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtAutoSelfSignedCertificate(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/auto-self-signed-certificate"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1387,7 +1577,7 @@ func handleMgmtAutoSelfSignedCertificate(ctx context.Context, handler *http.Serv
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1407,6 +1597,7 @@ func handleMgmtAutoSelfSignedCertificate(ctx context.Context, handler *http.Serv
 func handleMgmtSsl(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/ssl"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1422,7 +1613,7 @@ func handleMgmtSsl(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1457,6 +1648,7 @@ func handleMgmtSsl(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtLetsEncrypt(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/letsencrypt"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1471,7 +1663,7 @@ func handleMgmtLetsEncrypt(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1506,6 +1698,7 @@ func handleMgmtLetsEncrypt(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtCertQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/cert/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1518,7 +1711,7 @@ func handleMgmtCertQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1561,6 +1754,7 @@ func handleMgmtCertQuery(ctx context.Context, handler *http.ServeMux) {
 func handleMgmtStreamsQuery(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/streams/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1573,7 +1767,7 @@ func handleMgmtStreamsQuery(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1611,6 +1805,7 @@ const ErrorRtmpClientNotFound = 2049
 func handleMgmtStreamsKickoff(ctx context.Context, handler *http.ServeMux) {
 	ep := "/terraform/v1/mgmt/streams/kickoff"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -1627,7 +1822,7 @@ func handleMgmtStreamsKickoff(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -1753,9 +1948,11 @@ func handleMgmtUI(ctx context.Context, handler *http.ServeMux) {
 
 	ep := "/mgmt"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, mgmtHandler)
 
 	ep = "/mgmt/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, mgmtHandler)
 }
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -38,6 +39,8 @@ const (
 	SrsActionOnPublish SrsAction = "on_publish"
 	// The unpublish action.
 	SrsActionOnUnpublish = "on_unpublish"
+	// The play action.
+	SrsActionOnPlay = "on_play"
 
 	// The hls action, for SRS server only.
 	SrsActionOnHls = "on_hls"
@@ -49,6 +52,13 @@ const (
 
 	// The on_ocr action.
 	SrsActionOnOcr = "on_ocr"
+
+	// The on_motion action, for IP camera motion detection.
+	SrsActionOnMotion = "on_motion"
+	// The on_camera_offline action, when IP camera is offline longer than threshold.
+	SrsActionOnCameraOffline = "on_camera_offline"
+	// The on_camera_online action, when IP camera recovers from the notified offline.
+	SrsActionOnCameraOnline = "on_camera_online"
 )
 
 func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
@@ -62,15 +72,18 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep := "/terraform/v1/tencent/versions"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, versionHandler)
 
 	ep = "/terraform/v1/hooks/versions"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, versionHandler)
 
 	// See https://ossrs.io/lts/en-us/docs/v5/doc/http-callback
 	ep = "/terraform/v1/hooks/srs/verify"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			if noAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubNoAuth").Result(); err != nil && err != redis.Nil {
@@ -88,18 +101,40 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 			requestBody := string(b)
 
 			var action SrsAction
+			var clientIP string
 			var streamObj SrsStream
 			if err := json.Unmarshal(b, &struct {
 				Action *SrsAction `json:"action"`
+				IP     *string    `json:"ip"`
 				*SrsStream
 			}{
-				Action: &action, SrsStream: &streamObj,
+				Action: &action, IP: &clientIP, SrsStream: &streamObj,
 			}); err != nil {
 				return errors.Wrapf(err, "json unmarshal %v", string(b))
 			}
 
 			verifiedBy := "noVerify"
 			if action == SrsActionOnPublish {
+				// Oryx's own publishers, such as fallback, mosaic, vLive and IP camera, publish by the secret, which
+				// is verified by the room or global secret below, so they are not blocked by the stream keys.
+				secret, err := publishSecretOf(ctx, streamObj.Stream)
+				if err != nil {
+					return errors.Wrapf(err, "publish secret of %v", streamObj.Stream)
+				}
+
+				// Use the stream keys bound to the stream if any, which is exact match.
+				if !isPublishSecretOK(secret, streamObj.Param) {
+					bound, err := verifyStreamKey(ctx, &streamObj, clientIP)
+					if err != nil {
+						return errors.Wrapf(err, "invalid key stream=%v, action=%v", streamObj.Stream, action)
+					}
+					if bound {
+						verifiedBy = "key"
+					}
+				}
+			}
+
+			if action == SrsActionOnPublish && verifiedBy != "key" {
 				// Note that we allow pass secret by params or in stream name, for example, some encoder does not support params
 				// with ?secret=xxx, so it will fail when url is:
 				//      rtmp://ip/live/livestream?secret=xxx
@@ -129,6 +164,23 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				}
 			}
 
+			// Verify the play token, if required by the live room or globally. Note that the internal players,
+			// such as forward and transcode, and the HTTP players verified by platform proxy, are always allowed.
+			if action == SrsActionOnPlay {
+				if isInternalPlay(streamObj.App, streamObj.Stream, streamObj.Param) {
+					verifiedBy = "internal"
+				} else {
+					q, err := url.ParseQuery(strings.TrimPrefix(streamObj.Param, "?"))
+					if err != nil {
+						return errors.Wrapf(err, "parse param %v", streamObj.Param)
+					}
+
+					if verifiedBy, err = verifyPlayAuth(ctx, streamObj.App, streamObj.Stream, q.Get("token"), clientIP); err != nil {
+						return errors.Wrapf(err, "verify play action=%v", action)
+					}
+				}
+			}
+
 			// Verify some actions, before all other hooks.
 			preAllHook := action == SrsActionOnPublish
 			if preAllHook {
@@ -163,6 +215,10 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 					}
 				}
 			} else if action == SrsActionOnUnpublish {
+				if err := releaseStreamKey(ctx, &streamObj, clientIP); err != nil {
+					logger.Wf(ctx, "ignore release stream key err %+v", err)
+				}
+
 				if err := rdb.HDel(ctx, SRS_STREAM_ACTIVE, streamURL).Err(); err != nil && err != redis.Nil {
 					return errors.Wrapf(err, "hset %v %v", SRS_STREAM_ACTIVE, streamURL)
 				}
@@ -176,12 +232,17 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 						return errors.Wrapf(err, "hset %v %v", SRS_STREAM_RTC_ACTIVE, streamURL)
 					}
 				}
-			} else if action == "on_play" {
+			} else if action == SrsActionOnPlay {
 				if err := rdb.HIncrBy(ctx, SRS_STAT_COUNTER, "play", 1).Err(); err != nil && err != redis.Nil {
 					return errors.Wrapf(err, "hincrby %v play 1", SRS_STAT_COUNTER)
 				}
 			}
 
+			// Switch the stable stream between live and filler, after the stream status is updated.
+			fallbackWorker.OnStreamMessage(ctx, action, &streamObj)
+			// Update the mosaic tiles between stream and placeholder.
+			mosaicWorker.OnStreamMessage(ctx, action, &streamObj)
+
 			// For some events, hook after all other hooks are done.
 			if !preAllHook {
 				if err := callbackWorker.OnStreamMessage(ctx, action, &streamObj); err != nil {
@@ -210,7 +271,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -236,14 +297,17 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/srs/secret"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, secretQueryHandler)
 
 	ep = "/terraform/v1/hooks/srs/secret/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, secretQueryHandler)
 
 	ep = "/terraform/v1/hooks/srs/secret/update"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, secret string
@@ -257,7 +321,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -282,6 +346,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 
 	ep = "/terraform/v1/hooks/srs/secret/disable"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -296,7 +361,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -312,9 +377,142 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	ep = "/terraform/v1/hooks/srs/play/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			playAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playAuth").Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v playAuth", SRS_AUTH_SECRET)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				PlayAuth bool `json:"playAuth"`
+			}{
+				PlayAuth: playAuth == "true",
+			})
+			logger.Tf(ctx, "hooks query play auth ok, playAuth=%v, token=%vB", playAuth, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/play/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var playAuth bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				PlayAuth *bool   `json:"playAuth"`
+			}{
+				Token: &token, PlayAuth: &playAuth,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := rdb.HSet(ctx, SRS_AUTH_SECRET, "playAuth", fmt.Sprintf("%v", playAuth)).Err(); err != nil {
+				return errors.Wrapf(err, "hset %v playAuth %v", SRS_AUTH_SECRET, playAuth)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks update play auth, playAuth=%v, token=%vB", playAuth, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/play/token"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream, ip string
+			var expire int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+				IP     *string `json:"ip"`
+				Expire *int    `json:"expire"`
+			}{
+				Token: &token, App: &app, Stream: &stream, IP: &ip, Expire: &expire,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if stream == "" {
+				return errors.New("no stream")
+			}
+			if app == "" {
+				app = "live"
+			}
+			if expire < 0 {
+				return errors.Errorf("invalid expire %v", expire)
+			} else if expire == 0 {
+				expire = 3600
+			}
+
+			playSecret, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playSecret").Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v playSecret", SRS_AUTH_SECRET)
+			} else if playSecret == "" {
+				return errors.New("system not boot yet")
+			}
+
+			expireAt := time.Now().Add(time.Duration(expire) * time.Second)
+			playToken, err := createPlayToken(playSecret, fmt.Sprintf("/%v/%v", app, stream), ip, expireAt)
+			if err != nil {
+				return errors.Wrapf(err, "create play token")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				PlayToken string `json:"playToken"`
+				ExpireAt  string `json:"expireAt"`
+			}{
+				PlayToken: playToken, ExpireAt: expireAt.Format(time.RFC3339),
+			})
+			logger.Tf(ctx, "hooks create play token ok, app=%v, stream=%v, ip=%v, expire=%v, token=%vB",
+				app, stream, ip, expire, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	// See https://console.cloud.tencent.com/cam
 	ep = "/terraform/v1/tencent/cam/secret"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, secretId, secretKey string
@@ -329,7 +527,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -714,6 +912,7 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 	// See https://github.com/ossrs/srs/wiki/v4_EN_HTTPCallback
 	ep := "/terraform/v1/hooks/srs/hls"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			b, err := ioutil.ReadAll(r.Body)
@@ -736,7 +935,7 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 			// Handle TS file by Record task if enabled.
 			if recordAll, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hget %v all", SRS_RECORD_PATTERNS)
-			} else if recordAll == "true" {
+			} else if recordAll == "true" || recordWorker.Triggered(msg.App, msg.Stream) {
 				if err = recordWorker.OnHlsTsMessage(ctx, &msg); err != nil {
 					return errors.Wrapf(err, "feed %v", msg.String())
 				}
@@ -800,3 +999,61 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 
 	return nil
 }
+
+// publishSecretOf returns the publish secret of stream, the secret of live room or the global one, which is
+// verified by on_publish, for internal publishers such as fallback.
+func publishSecretOf(ctx context.Context, stream string) (string, error) {
+	roomPublishAuthKey := GenerateRoomPublishKey(path.Base(stream))
+	publish, err := rdb.HGet(ctx, SRS_AUTH_SECRET, roomPublishAuthKey).Result()
+	if publish == "" {
+		publish, err = rdb.HGet(ctx, SRS_AUTH_SECRET, "pubSecret").Result()
+	}
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v pubSecret", SRS_AUTH_SECRET)
+	}
+	return publish, nil
+}
+
+// isPublishSecretOK whether the publisher passes the publish secret by param, for example, ?secret=xxx, see
+// publishSecretOf. Note that the empty secret never matches.
+func isPublishSecretOK(secret, param string) bool {
+	if secret == "" {
+		return false
+	}
+
+	q, err := url.ParseQuery(strings.TrimPrefix(param, "?"))
+	if err != nil {
+		return false
+	}
+	return q.Get("secret") == secret
+}
+
+// verifyPlayAuth verifies the play token of stream, if required by the live room or globally. Returns how the
+// player is verified, noVerify if not required.
+func verifyPlayAuth(ctx context.Context, app, stream, token, ip string) (string, error) {
+	roomPlayAuthKey := GenerateRoomPlayKey(stream)
+	playAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, roomPlayAuthKey).Result()
+	verifiedBy := "room"
+	if playAuth != "true" {
+		playAuth, err = rdb.HGet(ctx, SRS_AUTH_SECRET, "playAuth").Result()
+		verifiedBy = "global"
+	}
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v playAuth", SRS_AUTH_SECRET)
+	}
+
+	if playAuth != "true" {
+		return "noVerify", nil
+	}
+
+	playSecret, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playSecret").Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v playSecret", SRS_AUTH_SECRET)
+	}
+
+	streamURL := fmt.Sprintf("/%v/%v", app, stream)
+	if err := verifyPlayToken(playSecret, token, streamURL, ip); err != nil {
+		return "", errors.Wrapf(err, "invalid play stream=%v, ip=%v", streamURL, ip)
+	}
+	return verifiedBy, nil
+}
@@ -0,0 +1,535 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The max number of usage history of stream key.
+const StreamKeyMaxHistory = 50
+
+// Serialize the update of stream keys by hooks, such as the publishers and history.
+var streamKeyLock sync.Mutex
+
+// SrsStreamKeyUsage is a usage record of stream key.
+type SrsStreamKeyUsage struct {
+	// The time in RFC3339.
+	Time string `json:"time"`
+	// The action, on_publish or on_unpublish, or reject.
+	Action string `json:"action"`
+	// The client IP and id of publisher.
+	IP     string `json:"ip"`
+	Client string `json:"client"`
+	// The reason if rejected.
+	Reason string `json:"reason,omitempty"`
+}
+
+// SrsStreamKey is a publish key bound to an exact app and stream, with optional time window, CIDR
+// allow-list and max publishers.
+type SrsStreamKey struct {
+	// The stream key ID, a UUID string.
+	ID string `json:"id"`
+	// The name of key, for example, the name of streamer.
+	Name string `json:"name"`
+	// The app and stream the key is bound to, exact match.
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The prefix of key, to identify the key without exposing it.
+	Prefix string `json:"prefix"`
+	// The SHA256 hash of key, never save the key itself.
+	Hash string `json:"hash,omitempty"`
+	// The time window in RFC3339, no limit if empty.
+	NotBefore string `json:"not_before,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// The CIDR allow-list of publisher IP, allow any IP if empty.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// The max number of concurrent publishers, no limit if zero.
+	MaxPublishers int `json:"max_publishers"`
+	// The client ids of active publishers.
+	Publishers []string `json:"publishers"`
+	// Whether the key is revoked.
+	Revoked bool `json:"revoked"`
+	// The usage history, the latest first.
+	History []*SrsStreamKeyUsage `json:"history"`
+	// Create time.
+	CreatedAt string `json:"created_at"`
+}
+
+func NewSrsStreamKey(opts ...func(key *SrsStreamKey)) *SrsStreamKey {
+	v := &SrsStreamKey{
+		ID:        uuid.NewString(),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *SrsStreamKey) String() string {
+	return fmt.Sprintf("id=%v, name=%v, app=%v, stream=%v, prefix=%v, notBefore=%v, expires=%v, cidrs=%v, "+
+		"maxPublishers=%v, publishers=%v, revoked=%v, history=%v",
+		v.ID, v.Name, v.App, v.Stream, v.Prefix, v.NotBefore, v.ExpiresAt, v.CIDRs,
+		v.MaxPublishers, len(v.Publishers), v.Revoked, len(v.History),
+	)
+}
+
+// Generate a new key, save the hash and return the key, which is only visible once.
+func (v *SrsStreamKey) Generate() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "generate key")
+	}
+
+	key := hex.EncodeToString(b)
+	v.Prefix = key[:6]
+	v.Hash = streamKeyHash(key)
+	return key, nil
+}
+
+// Validate the time window and CIDRs of key.
+func (v *SrsStreamKey) Validate() error {
+	if v.App == "" || v.Stream == "" {
+		return errors.Errorf("no app or stream")
+	}
+
+	for _, t := range []string{v.NotBefore, v.ExpiresAt} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, t); err != nil {
+			return errors.Wrapf(err, "invalid time %v", t)
+		}
+	}
+
+	for _, cidr := range v.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Wrapf(err, "invalid cidr %v", cidr)
+		}
+	}
+
+	if v.MaxPublishers < 0 {
+		return errors.Errorf("invalid max publishers %v", v.MaxPublishers)
+	}
+	return nil
+}
+
+// Active whether the key is not revoked, and in the time window.
+func (v *SrsStreamKey) Active(now time.Time) bool {
+	if v.Revoked {
+		return false
+	}
+	if v.NotBefore != "" {
+		if t, err := time.Parse(time.RFC3339, v.NotBefore); err != nil || now.Before(t) {
+			return false
+		}
+	}
+	if v.ExpiresAt != "" {
+		if t, err := time.Parse(time.RFC3339, v.ExpiresAt); err != nil || now.After(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify whether the publisher of ip is allowed by the key.
+func (v *SrsStreamKey) Verify(ip string) error {
+	if len(v.CIDRs) > 0 {
+		addr := net.ParseIP(ip)
+		var allowed bool
+		for _, cidr := range v.CIDRs {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && addr != nil && network.Contains(addr) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.Errorf("ip %v not in %v", ip, v.CIDRs)
+		}
+	}
+
+	if v.MaxPublishers > 0 && len(v.Publishers) >= v.MaxPublishers {
+		return errors.Errorf("publishers %v exceed %v", len(v.Publishers), v.MaxPublishers)
+	}
+	return nil
+}
+
+// Reconcile removes the stale publishers which are not alive, for example, SRS restarts without the
+// on_unpublish callback. Returns whether any publisher is removed.
+func (v *SrsStreamKey) Reconcile(alive func(client string) bool) bool {
+	var publishers []string
+	for _, client := range v.Publishers {
+		if alive(client) {
+			publishers = append(publishers, client)
+		}
+	}
+
+	removed := len(publishers) != len(v.Publishers)
+	v.Publishers = publishers
+	return removed
+}
+
+// AddHistory appends the usage record, and keeps the latest StreamKeyMaxHistory records.
+func (v *SrsStreamKey) AddHistory(usage *SrsStreamKeyUsage) {
+	v.History = append([]*SrsStreamKeyUsage{usage}, v.History...)
+	if len(v.History) > StreamKeyMaxHistory {
+		v.History = v.History[:StreamKeyMaxHistory]
+	}
+}
+
+// Public returns a copy of key without the hash, to response to client.
+func (v *SrsStreamKey) Public() *SrsStreamKey {
+	key := *v
+	key.Hash = ""
+	return &key
+}
+
+// Save the key to redis, indexed by the hash of key.
+func (v *SrsStreamKey) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_STREAM_KEYS, v.Hash, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_STREAM_KEYS, v.ID)
+	}
+	return nil
+}
+
+// streamKeyHash builds the SHA256 hash of key.
+func streamKeyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// LoadSrsStreamKeys loads all stream keys, sorted by create time.
+func LoadSrsStreamKeys(ctx context.Context) ([]*SrsStreamKey, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_STREAM_KEYS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_KEYS)
+	}
+
+	keys := []*SrsStreamKey{}
+	for _, obj := range objs {
+		var key SrsStreamKey
+		if err := json.Unmarshal([]byte(obj), &key); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", obj)
+		}
+		keys = append(keys, &key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+	return keys, nil
+}
+
+// srsClientAlive whether the client exists in SRS server, by the HTTP API of SRS. Note that the client is
+// considered alive if failed to query, to avoid removing publishers by mistake.
+func srsClientAlive(ctx context.Context, client string) bool {
+	clientURL := fmt.Sprintf("http://127.0.0.1:1985/api/v1/clients/%v", url.PathEscape(client))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, clientURL, nil)
+	if err != nil {
+		return true
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Wf(ctx, "ignore query client %v err %+v", client, err)
+		return true
+	}
+	defer res.Body.Close()
+
+	var code int
+	if err := json.NewDecoder(res.Body).Decode(&struct {
+		Code *int `json:"code"`
+	}{
+		Code: &code,
+	}); err != nil {
+		return true
+	}
+	return code != ErrorRtmpClientNotFound
+}
+
+// verifyStreamKey verifies the publisher by the stream keys bound to the stream. Return false if no key
+// is bound to the stream, so the publisher should be verified by other secrets. Note that the expired or
+// revoked keys are also bound to the stream, so the publisher is rejected rather than verified by others.
+func verifyStreamKey(ctx context.Context, streamObj *SrsStream, ip string) (bool, error) {
+	streamKeyLock.Lock()
+	defer streamKeyLock.Unlock()
+
+	keys, err := LoadSrsStreamKeys(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "load stream keys")
+	}
+
+	now := time.Now()
+	var bound []*SrsStreamKey
+	for _, key := range keys {
+		if key.App == streamObj.App && key.Stream == streamObj.Stream {
+			bound = append(bound, key)
+		}
+	}
+	if len(bound) == 0 {
+		return false, nil
+	}
+
+	// The key is passed by param, for example, rtmp://ip/live/livestream?key=xxx
+	q, err := url.ParseQuery(strings.TrimPrefix(streamObj.Param, "?"))
+	if err != nil {
+		return true, errors.Wrapf(err, "parse param %v", streamObj.Param)
+	}
+
+	hash := streamKeyHash(q.Get("key"))
+	for _, key := range bound {
+		if key.Hash != hash {
+			continue
+		}
+
+		usage := &SrsStreamKeyUsage{
+			Time: now.Format(time.RFC3339), Action: string(SrsActionOnPublish), IP: ip, Client: streamObj.Client,
+		}
+
+		// Remove the stale publishers, only when exceed the limit, to avoid querying SRS for each publish.
+		if key.MaxPublishers > 0 && len(key.Publishers) >= key.MaxPublishers {
+			key.Reconcile(func(client string) bool {
+				return srsClientAlive(ctx, client)
+			})
+		}
+
+		err := key.Verify(ip)
+		if err == nil && !key.Active(now) {
+			err = errors.Errorf("key is revoked or not in time window")
+		}
+		if err != nil {
+			usage.Action, usage.Reason = "reject", err.Error()
+			key.AddHistory(usage)
+			if r0 := key.Save(ctx); r0 != nil {
+				logger.Wf(ctx, "ignore save stream key %v err %+v", key.String(), r0)
+			}
+			return true, errors.Wrapf(err, "verify key %v", key.ID)
+		}
+
+		key.Publishers = append(key.Publishers, streamObj.Client)
+		key.AddHistory(usage)
+		if err := key.Save(ctx); err != nil {
+			return true, errors.Wrapf(err, "save stream key %v", key.String())
+		}
+		return true, nil
+	}
+
+	return true, errors.Errorf("invalid key for stream /%v/%v, keys=%v", streamObj.App, streamObj.Stream, len(bound))
+}
+
+// releaseStreamKey removes the publisher from the stream keys, when unpublish.
+func releaseStreamKey(ctx context.Context, streamObj *SrsStream, ip string) error {
+	streamKeyLock.Lock()
+	defer streamKeyLock.Unlock()
+
+	keys, err := LoadSrsStreamKeys(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load stream keys")
+	}
+
+	for _, key := range keys {
+		if key.App != streamObj.App || key.Stream != streamObj.Stream {
+			continue
+		}
+
+		var publishers []string
+		for _, client := range key.Publishers {
+			if client != streamObj.Client {
+				publishers = append(publishers, client)
+			}
+		}
+		if len(publishers) == len(key.Publishers) {
+			continue
+		}
+
+		key.Publishers = publishers
+		key.AddHistory(&SrsStreamKeyUsage{
+			Time: time.Now().Format(time.RFC3339), Action: string(SrsActionOnUnpublish), IP: ip, Client: streamObj.Client,
+		})
+		if err := key.Save(ctx); err != nil {
+			return errors.Wrapf(err, "save stream key %v", key.String())
+		}
+	}
+
+	return nil
+}
+
+func handleStreamKeyService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/srs/keys/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var obj SrsStreamKey
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*SrsStreamKey
+			}{
+				Token: &token, SrsStreamKey: &obj,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := obj.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", obj.String())
+			}
+
+			key := NewSrsStreamKey(func(key *SrsStreamKey) {
+				key.Name, key.App, key.Stream = obj.Name, obj.App, obj.Stream
+				key.NotBefore, key.ExpiresAt = obj.NotBefore, obj.ExpiresAt
+				key.CIDRs, key.MaxPublishers = obj.CIDRs, obj.MaxPublishers
+			})
+			secret, err := key.Generate()
+			if err != nil {
+				return errors.Wrapf(err, "generate key")
+			}
+
+			streamKeyLock.Lock()
+			defer streamKeyLock.Unlock()
+			if err := key.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save stream key %v", key.String())
+			}
+
+			// Note that the key is only visible once, we never save it.
+			ohttp.WriteData(ctx, w, r, &struct {
+				*SrsStreamKey
+				Key string `json:"key"`
+			}{
+				SrsStreamKey: key.Public(), Key: secret,
+			})
+			logger.Tf(ctx, "stream key create ok, %v, token=%vB", key.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/keys/list"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+			}{
+				Token: &token, App: &app, Stream: &stream,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			keys, err := LoadSrsStreamKeys(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load stream keys")
+			}
+
+			publicKeys := []*SrsStreamKey{}
+			for _, key := range keys {
+				if (app == "" || key.App == app) && (stream == "" || key.Stream == stream) {
+					publicKeys = append(publicKeys, key.Public())
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Keys []*SrsStreamKey `json:"keys"`
+			}{
+				Keys: publicKeys,
+			})
+			logger.Tf(ctx, "stream key list ok, app=%v, stream=%v, keys=%v, token=%vB", app, stream, len(publicKeys), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/keys/revoke"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			streamKeyLock.Lock()
+			defer streamKeyLock.Unlock()
+
+			keys, err := LoadSrsStreamKeys(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load stream keys")
+			}
+
+			var key *SrsStreamKey
+			for _, obj := range keys {
+				if obj.ID == id {
+					key = obj
+					break
+				}
+			}
+			if key == nil {
+				return errors.Errorf("stream key %v not exists", id)
+			}
+
+			// Note that the active publishers are not kicked off, only the new publishers are rejected.
+			key.Revoked = true
+			if err := key.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save stream key %v", key.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "stream key revoke ok, %v, token=%vB", key.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
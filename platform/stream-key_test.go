@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamKey_Active(t *testing.T) {
+	now := time.Now()
+	for _, e := range []struct {
+		key    SrsStreamKey
+		active bool
+	}{
+		{key: SrsStreamKey{}, active: true},
+		{key: SrsStreamKey{Revoked: true}, active: false},
+		{key: SrsStreamKey{NotBefore: now.Add(time.Hour).Format(time.RFC3339)}, active: false},
+		{key: SrsStreamKey{NotBefore: now.Add(-time.Hour).Format(time.RFC3339)}, active: true},
+		{key: SrsStreamKey{ExpiresAt: now.Add(-time.Hour).Format(time.RFC3339)}, active: false},
+		{key: SrsStreamKey{ExpiresAt: now.Add(time.Hour).Format(time.RFC3339)}, active: true},
+	} {
+		if active := e.key.Active(now); active != e.active {
+			t.Errorf("Fail for key %v, expect %v, actual %v", e.key.String(), e.active, active)
+		}
+	}
+}
+
+func TestStreamKey_Verify(t *testing.T) {
+	for _, e := range []struct {
+		key   SrsStreamKey
+		ip    string
+		valid bool
+	}{
+		{key: SrsStreamKey{}, ip: "1.2.3.4", valid: true},
+		{key: SrsStreamKey{CIDRs: []string{"10.0.0.0/8"}}, ip: "10.1.2.3", valid: true},
+		{key: SrsStreamKey{CIDRs: []string{"10.0.0.0/8"}}, ip: "1.2.3.4", valid: false},
+		{key: SrsStreamKey{CIDRs: []string{"10.0.0.0/8", "1.2.3.0/24"}}, ip: "1.2.3.4", valid: true},
+		{key: SrsStreamKey{MaxPublishers: 1}, ip: "1.2.3.4", valid: true},
+		{key: SrsStreamKey{MaxPublishers: 1, Publishers: []string{"c0"}}, ip: "1.2.3.4", valid: false},
+	} {
+		if err := e.key.Verify(e.ip); (err == nil) != e.valid {
+			t.Errorf("Fail for key %v of ip %v, expect valid=%v, err=%v", e.key.String(), e.ip, e.valid, err)
+		}
+	}
+}
+
+func TestStreamKey_Generate(t *testing.T) {
+	key := NewSrsStreamKey(func(key *SrsStreamKey) {
+		key.App, key.Stream = "live", "livestream"
+	})
+	secret, err := key.Generate()
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if key.Hash != streamKeyHash(secret) || key.Prefix != secret[:6] {
+		t.Errorf("Fail for key %v", key.String())
+	}
+	if err := key.Validate(); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	}
+
+	for i := 0; i < StreamKeyMaxHistory+10; i++ {
+		key.AddHistory(&SrsStreamKeyUsage{Action: "on_publish"})
+	}
+	if len(key.History) != StreamKeyMaxHistory {
+		t.Errorf("Fail for history %v should be capped to %v", len(key.History), StreamKeyMaxHistory)
+	}
+}
+
+func TestStreamKey_Reconcile(t *testing.T) {
+	key := SrsStreamKey{MaxPublishers: 1, Publishers: []string{"c0", "c1"}}
+	if !key.Reconcile(func(client string) bool { return client == "c1" }) {
+		t.Errorf("Fail for key %v", key.String())
+	}
+	if len(key.Publishers) != 1 || key.Publishers[0] != "c1" {
+		t.Errorf("Fail for publishers %v", key.Publishers)
+	}
+	if key.Reconcile(func(client string) bool { return true }) {
+		t.Errorf("Fail for key %v", key.String())
+	}
+
+	// The stale publisher should not exceed the limit.
+	key = SrsStreamKey{MaxPublishers: 1, Publishers: []string{"c0"}}
+	key.Reconcile(func(client string) bool { return false })
+	if err := key.Verify("1.2.3.4"); err != nil {
+		t.Errorf("Fail for key %v, err %+v", key.String(), err)
+	}
+}
+
+func TestStreamKey_InternalPublisher(t *testing.T) {
+	// Oryx's own publishers, such as fallback and mosaic, publish by the secret to the key-bound stream.
+	for _, e := range []struct {
+		secret string
+		param  string
+		ok     bool
+	}{
+		{secret: "xxx", param: "?secret=xxx", ok: true},
+		{secret: "xxx", param: "?vhost=__defaultVhost__&secret=xxx", ok: true},
+		{secret: "xxx", param: "?key=yyy", ok: false},
+		{secret: "xxx", param: "?secret=yyy", ok: false},
+		{secret: "xxx", param: "?secret=xxxyyy", ok: false},
+		{secret: "", param: "?secret=", ok: false},
+		{secret: "", param: "", ok: false},
+	} {
+		if ok := isPublishSecretOK(e.secret, e.param); ok != e.ok {
+			t.Errorf("Fail for secret=%v, param=%v, expect %v, actual %v", e.secret, e.param, e.ok, ok)
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"os/exec"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -31,8 +33,21 @@ type TranscodeWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global transcode task, only support one transcode task.
+	// The global transcode task, for the global config.
 	task *TranscodeTask
+	// The tasks of transcode rules, key is the pair of rule name and source stream in string, see
+	// TranscodeTask.Key, value is *TranscodeTask.
+	tasks sync.Map
+
+	// The global config in memory, to serve the HLS master playlist without loading from redis.
+	config TranscodeConfig
+
+	// The limit of concurrent transcoding, 0 for no limit.
+	limit int
+	// The number of running transcoding.
+	running int
+	// To protect the fields.
+	lock sync.Mutex
 }
 
 func NewTranscodeWorker() *TranscodeWorker {
@@ -42,9 +57,74 @@ func NewTranscodeWorker() *TranscodeWorker {
 	return v
 }
 
+// GetTasks returns the tasks of transcode rules, sorted by rule name and source stream.
+func (v *TranscodeWorker) GetTasks() []*TranscodeTask {
+	var tasks []*TranscodeTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		tasks = append(tasks, value.(*TranscodeTask))
+		return true
+	})
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Key() < tasks[j].Key()
+	})
+	return tasks
+}
+
+// acquire a slot to run transcoding, return false if exceed the limit.
+func (v *TranscodeWorker) acquire() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.limit > 0 && v.running >= v.limit {
+		return false
+	}
+	v.running++
+	return true
+}
+
+// updateConfig updates the global config in memory, when loaded from redis or applied.
+func (v *TranscodeWorker) updateConfig(config TranscodeConfig) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.config = config
+}
+
+// queryConfig returns the global config in memory.
+func (v *TranscodeWorker) queryConfig() TranscodeConfig {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.config
+}
+
+// release the slot of transcoding.
+func (v *TranscodeWorker) release() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.running--
+}
+
+// isOutput whether the source stream in /app/stream is the output of transcoding, which should never be
+// transcoded again.
+func (v *TranscodeWorker) isOutput(source string) bool {
+	tasks := append(v.GetTasks(), v.task)
+	for _, task := range tasks {
+		for _, output := range task.outputs() {
+			if u, err := url.Parse(output); err == nil && path.Clean(u.Path) == source {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/transcode/query"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -57,7 +137,7 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -80,6 +160,7 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 
 	ep = "/terraform/v1/ffmpeg/transcode/apply"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -95,19 +176,29 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
 			if b, err := json.Marshal(config); err != nil {
 				return errors.Wrapf(err, "marshal conf %v", config)
 			} else if err := rdb.HSet(ctx, SRS_TRANSCODE_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hset %v global %v", SRS_TRANSCODE_CONFIG, string(b))
 			}
+			v.updateConfig(config)
 
 			if err := v.task.Restart(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", config.String())
 			}
+			for _, task := range v.GetTasks() {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", task.String())
+				}
+			}
 
 			ohttp.WriteData(ctx, w, r, nil)
 			logger.Tf(ctx, "transcode apply ok, %v, token=%vB", config, len(token))
@@ -119,6 +210,7 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 
 	ep = "/terraform/v1/ffmpeg/transcode/task"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -131,7 +223,7 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -155,6 +247,12 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				InputStream string `json:"input"`
 				// The output stream URL.
 				OutputStream string `json:"output"`
+				// The HLS master playlist, for ABR ladder.
+				Master string `json:"master,omitempty"`
+				// Whether waiting for the limit of concurrent transcoding.
+				Waiting bool `json:"waiting"`
+				// The tasks of transcode rules.
+				Tasks []map[string]interface{} `json:"tasks"`
 				// The FFmpeg log.
 				Frame struct {
 					// The FFmpeg log lines.
@@ -165,6 +263,10 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}{}
 			res.Enabled = config.All
 			res.UUID = v.task.UUID
+			res.Waiting = v.task.queryWaiting()
+			if config.ABR() {
+				res.Master = fmt.Sprintf("%v.m3u8", config.ABRStream())
+			}
 			if pid > 0 {
 				res.InputStream = input
 				res.OutputStream = output
@@ -172,6 +274,29 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				res.Frame.Update = update
 			}
 
+			// The status of tasks for transcode rules.
+			tasks := make([]map[string]interface{}, 0)
+			for _, task := range v.GetTasks() {
+				pid, input, output, frame, update := task.queryFrame()
+				elem := map[string]interface{}{
+					"uuid":    task.UUID,
+					"rule":    task.Rule,
+					"source":  task.Source,
+					"waiting": task.queryWaiting(),
+				}
+				if pid > 0 {
+					elem["input"] = input
+					elem["output"] = output
+					elem["frame"] = map[string]string{
+						"log":    frame,
+						"update": update,
+					}
+				}
+				tasks = append(tasks, elem)
+			}
+
+			res.Tasks = tasks
+
 			ohttp.WriteData(ctx, w, r, &res)
 			logger.Tf(ctx, "transcode task ok, %v, pid=%v, input=%v, output=%v, frame=%v, update=%v, token=%vB",
 				config, pid, input, output, frame, update, len(token))
@@ -184,6 +309,61 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 	return nil
 }
 
+// ServeMasterPlaylist serves the HLS master playlist of ABR ladder, return false if not match. The token of
+// verified player is appended to each rendition.
+func (v *TranscodeWorker) ServeMasterPlaylist(ctx context.Context, w http.ResponseWriter, r *http.Request, token string) bool {
+	for _, config := range v.abrConfigs() {
+		if r.URL.Path != fmt.Sprintf("%v.m3u8", config.ABRStream()) {
+			continue
+		}
+
+		// The renditions are also verified by the token of ABR stream, see ABRStreamOf.
+		playlist := config.MasterPlaylist()
+		if token != "" {
+			playlist = hlsRewritePlaylist(playlist, token)
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(playlist))
+		logger.Tf(ctx, "transcode serve master playlist %v, ladder=%v", r.URL.Path, len(config.Ladder))
+		return true
+	}
+	return false
+}
+
+// ABRStreamOf returns the ABR stream of the rendition app/stream, for example, live/livestream for the rendition
+// live/livestream_720p, so that the players are able to play the renditions by the token of the ABR stream.
+func (v *TranscodeWorker) ABRStreamOf(app, stream string) (string, string, bool) {
+	for _, config := range v.abrConfigs() {
+		abrStream := config.ABRStream()
+		for _, rendition := range config.Ladder {
+			if fmt.Sprintf("/%v/%v", app, stream) == fmt.Sprintf("%v_%v", abrStream, rendition.Name) {
+				abrApp, abrName := httpStreamOfPath(abrStream)
+				return abrApp, abrName, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// abrConfigs returns the ABR configs of the global config and each rule task, all in memory, because the player
+// requests the playlist frequently.
+func (v *TranscodeWorker) abrConfigs() []TranscodeConfig {
+	all := []TranscodeConfig{v.queryConfig()}
+	for _, task := range v.GetTasks() {
+		all = append(all, task.queryConfig())
+	}
+
+	var configs []TranscodeConfig
+	for _, config := range all {
+		if config.All && config.ABR() {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
 func (v *TranscodeWorker) Close() error {
 	if v.cancel != nil {
 		v.cancel()
@@ -201,6 +381,14 @@ func (v *TranscodeWorker) Start(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "transcode start a worker")
 
+	if envTranscodeLimit() != "" {
+		if iv, err := strconv.ParseInt(envTranscodeLimit(), 10, 64); err != nil {
+			return errors.Wrapf(err, "parse env transcode limit %v", envTranscodeLimit())
+		} else {
+			v.limit = int(iv)
+		}
+	}
+
 	// Load tasks from redis and force to kill all.
 	if objs, err := rdb.HGetAll(ctx, SRS_TRANSCODE_TASK).Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hgetall %v", SRS_TRANSCODE_TASK)
@@ -223,6 +411,17 @@ func (v *TranscodeWorker) Start(ctx context.Context) error {
 		}
 	}
 
+	// Load the global config to memory, which is refreshed when applied, or when loading tasks.
+	var config TranscodeConfig
+	if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	v.updateConfig(config)
+
 	// Start global transcode task.
 	wg.Add(1)
 	go func() {
@@ -245,6 +444,86 @@ func (v *TranscodeWorker) Start(ctx context.Context) error {
 		}
 	}()
 
+	// Load the transcode rules and start a task for each matched active stream.
+	loadTasks := func() error {
+		var config TranscodeConfig
+		if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
+		} else if len(b) > 0 {
+			if err := json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+		}
+		v.updateConfig(config)
+
+		if len(config.Rules) == 0 {
+			return nil
+		}
+
+		streams, err := rdb.HGetAll(ctx, SRS_STREAM_ACTIVE).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
+		}
+
+		for _, rule := range config.Rules {
+			if !rule.Enabled {
+				continue
+			}
+
+			for k, value := range streams {
+				var stream SrsStream
+				if err := json.Unmarshal([]byte(value), &stream); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", k, value)
+				}
+
+				// Ignore the output of transcoding, to avoid transcode it again.
+				source := fmt.Sprintf("/%v/%v", stream.App, stream.Stream)
+				if !rule.Match(source) || v.isOutput(source) {
+					continue
+				}
+
+				task := NewTranscodeTask()
+				task.Rule, task.Source, task.transcodeWorker = rule.Name, source, v
+				if _, loaded := v.tasks.LoadOrStore(task.Key(), task); loaded {
+					continue
+				}
+				logger.Tf(ctx, "transcode create rule=%v, source=%v, task is %v", rule.Name, source, task.String())
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					// Remove the task when done, the worker will start it again if required.
+					defer task.remove(ctx)
+
+					if err := task.Run(ctx); err != nil {
+						logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+					}
+				}()
+			}
+		}
+
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			duration := 3 * time.Second
+			if err := loadTasks(); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 10 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
 	return nil
 }
 
@@ -269,18 +548,372 @@ type TranscodeConfig struct {
 	Server string `json:"server"`
 	// The RTMP stream and secret, for example, livestream
 	Secret string `json:"secret"`
+	// The ABR ladder, each rendition is a separate stream named by the secret and rendition name, for example,
+	// livestream_720p, and the HLS master playlist is the secret, for example, livestream.m3u8. Transcode to
+	// one stream if empty.
+	Ladder []*TranscodeRendition `json:"ladder,omitempty"`
+	// The transcode rules, each matched stream is transcoded by the profile of rule, which is independent to the
+	// global config.
+	Rules []*TranscodeRule `json:"rules,omitempty"`
 }
 
 func (v TranscodeConfig) String() string {
-	return fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v",
+	return fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v, ladder=%v",
 		v.All, v.VideoCodec, v.AudioCodec, v.VideoBitrate, v.AudioBitrate, v.AudioChannels, v.VideoProfile,
-		v.VideoPreset, v.Server, v.Secret,
+		v.VideoPreset, v.Server, v.Secret, len(v.Ladder),
+	)
+}
+
+func (v *TranscodeConfig) Validate() error {
+	rules := make(map[string]bool)
+	for _, rule := range v.Rules {
+		if err := rule.Validate(); err != nil {
+			return errors.Wrapf(err, "rule %v", rule.String())
+		}
+		if rules[rule.Name] {
+			return errors.Errorf("duplicated rule %v", rule.Name)
+		}
+		rules[rule.Name] = true
+	}
+
+	if !v.ABR() {
+		return nil
+	}
+
+	// We generate the CODECS of master playlist, so only support H.264 and AAC.
+	if v.VideoCodec != "libx264" || v.AudioCodec != "aac" {
+		return errors.Errorf("ladder requires libx264 and aac, vcodec=%v, acodec=%v", v.VideoCodec, v.AudioCodec)
+	}
+
+	names := make(map[string]bool)
+	for _, rendition := range v.Ladder {
+		if err := rendition.Validate(); err != nil {
+			return errors.Wrapf(err, "rendition %v", rendition.String())
+		}
+		if names[rendition.Name] {
+			return errors.Errorf("duplicated rendition %v", rendition.Name)
+		}
+		names[rendition.Name] = true
+	}
+	return nil
+}
+
+// ABR whether transcode to the ABR ladder.
+func (v *TranscodeConfig) ABR() bool {
+	return len(v.Ladder) > 0
+}
+
+// OutputURL returns the URL of output stream, or the base URL of renditions for ABR.
+func (v *TranscodeConfig) OutputURL() string {
+	outputServer := v.Server
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.Secret, "/") && v.Secret != "" {
+		outputServer += "/"
+	}
+	return fmt.Sprintf("%v%v", outputServer, v.Secret)
+}
+
+// OutputURLs returns the URLs of all output streams, the renditions for ABR.
+func (v *TranscodeConfig) OutputURLs() []string {
+	if !v.ABR() {
+		return []string{v.OutputURL()}
+	}
+
+	var outputs []string
+	for _, rendition := range v.Ladder {
+		outputs = append(outputs, rendition.OutputURL(v.OutputURL()))
+	}
+	return outputs
+}
+
+// ABRStream returns the stream path of ABR in /app/stream, for example, /live/livestream, and the master
+// playlist is /live/livestream.m3u8.
+func (v *TranscodeConfig) ABRStream() string {
+	u, err := url.Parse(v.OutputURL())
+	if err != nil {
+		return ""
+	}
+	return path.Clean(u.Path)
+}
+
+// ABRArgs builds the FFmpeg arguments to transcode the input to all renditions, which share the same input
+// and decoder.
+func (v *TranscodeConfig) ABRArgs(outputURL string) []string {
+	var videos []*TranscodeRendition
+	for _, rendition := range v.Ladder {
+		if !rendition.AudioOnly {
+			videos = append(videos, rendition)
+		}
+	}
+
+	args := []string{}
+	if len(videos) > 0 {
+		// Split the video and scale for each rendition, pad to keep the exact resolution in master playlist.
+		var filters []string
+		var splits string
+		for i := range videos {
+			splits += fmt.Sprintf("[v%v]", i)
+		}
+		filters = append(filters, fmt.Sprintf("[0:v]split=%v%v", len(videos), splits))
+		for i, rendition := range videos {
+			width, height := rendition.Resolution()
+			filters = append(filters, fmt.Sprintf(
+				"[v%v]scale=%v:%v:force_original_aspect_ratio=decrease,pad=%v:%v:(ow-iw)/2:(oh-ih)/2[v%vo]",
+				i, width, height, width, height, i,
+			))
+		}
+		args = append(args, "-filter_complex", strings.Join(filters, ";"))
+	}
+
+	var index int
+	for _, rendition := range v.Ladder {
+		if rendition.AudioOnly {
+			args = append(args, "-map", "0:a", "-vn")
+		} else {
+			args = append(args, "-map", fmt.Sprintf("[v%vo]", index), "-map", "0:a?")
+			args = append(args,
+				"-vcodec", v.VideoCodec,
+				"-profile:v", rendition.profile(v.VideoProfile),
+				"-level:v", rendition.level(),
+				"-preset:v", v.VideoPreset,
+				"-tune", "zerolatency", // Low latency mode.
+				"-b:v", fmt.Sprintf("%vk", rendition.VideoBitrate),
+				"-maxrate", fmt.Sprintf("%vk", rendition.VideoBitrate),
+				"-bufsize", fmt.Sprintf("%vk", rendition.VideoBitrate*2),
+				"-r", "25", "-g", "50", // Set gop to 2s, all renditions are aligned.
+				"-bf", "0", // Disable B frame for WebRTC.
+			)
+			index++
+		}
+
+		args = append(args, "-acodec", v.AudioCodec, "-b:a", fmt.Sprintf("%vk", rendition.AudioBitrate))
+		if v.AudioChannels > 0 {
+			args = append(args, "-ac", fmt.Sprintf("%v", v.AudioChannels))
+		}
+
+		output := rendition.OutputURL(outputURL)
+		// If RTMP use flv, if SRT use mpegts, otherwise do not set.
+		if strings.HasPrefix(output, "rtmp://") || strings.HasPrefix(output, "rtmps://") {
+			args = append(args, "-f", "flv")
+		} else if strings.HasPrefix(output, "srt://") {
+			args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+		}
+		args = append(args, output)
+	}
+	return args
+}
+
+// MasterPlaylist generates the HLS master playlist, which references the HLS playlist of each rendition,
+// in the same directory.
+func (v *TranscodeConfig) MasterPlaylist() string {
+	base := path.Base(v.ABRStream())
+
+	lines := []string{"#EXTM3U", "#EXT-X-VERSION:3", "#EXT-X-INDEPENDENT-SEGMENTS"}
+	for _, rendition := range v.Ladder {
+		attrs := []string{fmt.Sprintf("BANDWIDTH=%v", rendition.Bandwidth())}
+		if !rendition.AudioOnly {
+			width, height := rendition.Resolution()
+			attrs = append(attrs, fmt.Sprintf("RESOLUTION=%vx%v", width, height))
+			attrs = append(attrs, "FRAME-RATE=25.000")
+		}
+		attrs = append(attrs, fmt.Sprintf("CODECS=\"%v\"", rendition.Codecs(v.VideoProfile)))
+
+		lines = append(lines, fmt.Sprintf("#EXT-X-STREAM-INF:%v", strings.Join(attrs, ",")))
+		lines = append(lines, fmt.Sprintf("%v_%v.m3u8", base, rendition.Name))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// TranscodeRule is a rule to transcode the matched streams, by the profile of rule.
+type TranscodeRule struct {
+	// The name of rule, for example, 720p.
+	Name string `json:"name"`
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The globs of source streams in /app/stream, for example, /live/*.
+	Streams []string `json:"streams"`
+	// The output URL template, the [app] and [stream] is replaced by the source stream, for example,
+	// rtmp://localhost/[app]/[stream]_720p
+	Output string `json:"output"`
+	// The transcode profile, such as codec, bitrate and ABR ladder, the server and secret is ignored.
+	Profile TranscodeConfig `json:"profile"`
+}
+
+func (v *TranscodeRule) String() string {
+	return fmt.Sprintf("name=%v, enabled=%v, streams=%v, output=%v, profile is %v",
+		v.Name, v.Enabled, v.Streams, v.Output, v.Profile.String(),
+	)
+}
+
+func (v *TranscodeRule) Validate() error {
+	if v.Name == "" {
+		return errors.New("no name")
+	}
+	if len(v.Streams) == 0 {
+		return errors.New("no streams")
+	}
+	for _, glob := range v.Streams {
+		if _, err := path.Match(glob, ""); err != nil {
+			return errors.Wrapf(err, "invalid stream glob %v", glob)
+		}
+	}
+	if !strings.Contains(v.Output, "://") {
+		return errors.Errorf("invalid output %v", v.Output)
+	}
+	if len(v.Profile.Rules) > 0 {
+		return errors.New("nested rules")
+	}
+	if err := v.Profile.Validate(); err != nil {
+		return errors.Wrapf(err, "profile")
+	}
+	return nil
+}
+
+// Match whether the source stream in /app/stream matches the rule.
+func (v *TranscodeRule) Match(source string) bool {
+	for _, glob := range v.Streams {
+		if ok, err := path.Match(glob, source); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Config builds the transcode config for the source stream in /app/stream.
+func (v *TranscodeRule) Config(source string) TranscodeConfig {
+	var app, stream string
+	if parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2); len(parts) == 2 {
+		app, stream = parts[0], parts[1]
+	}
+
+	config := v.Profile
+	config.All, config.Rules = true, nil
+	config.Server = strings.NewReplacer("[app]", app, "[stream]", stream).Replace(v.Output)
+	config.Secret = ""
+	return config
+}
+
+// TranscodeRendition is a rendition of ABR ladder, for example, 720p at 2500kbps.
+type TranscodeRendition struct {
+	// The name of rendition, the suffix of output stream, for example, 720p.
+	Name string `json:"name"`
+	// Whether audio only, without video.
+	AudioOnly bool `json:"audioOnly"`
+	// The resolution of video, use 16:9 if width is zero. The video is scaled and padded to the resolution.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// The video bitrate in kbps.
+	VideoBitrate int `json:"vbitrate"`
+	// The audio bitrate in kbps.
+	AudioBitrate int `json:"abitrate"`
+}
+
+func (v *TranscodeRendition) String() string {
+	return fmt.Sprintf("name=%v, audioOnly=%v, width=%v, height=%v, vbitrate=%v, abitrate=%v",
+		v.Name, v.AudioOnly, v.Width, v.Height, v.VideoBitrate, v.AudioBitrate,
 	)
 }
 
+func (v *TranscodeRendition) Validate() error {
+	if v.Name == "" {
+		return errors.New("no name")
+	}
+	for _, c := range v.Name {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' && c != '-' {
+			return errors.Errorf("invalid name %v", v.Name)
+		}
+	}
+
+	if v.AudioBitrate <= 0 {
+		return errors.Errorf("invalid abitrate %v", v.AudioBitrate)
+	}
+	if v.AudioOnly {
+		return nil
+	}
+
+	if v.Height <= 0 || v.Width < 0 {
+		return errors.Errorf("invalid resolution %vx%v", v.Width, v.Height)
+	}
+	if v.VideoBitrate <= 0 {
+		return errors.Errorf("invalid vbitrate %v", v.VideoBitrate)
+	}
+	return nil
+}
+
+// OutputURL returns the URL of rendition, by the base URL of ABR.
+func (v *TranscodeRendition) OutputURL(base string) string {
+	if u, err := url.Parse(base); err == nil && u.RawQuery != "" {
+		u.Path = fmt.Sprintf("%v_%v", u.Path, v.Name)
+		return u.String()
+	}
+	return fmt.Sprintf("%v_%v", base, v.Name)
+}
+
+// Resolution returns the width and height of video, both are even numbers.
+func (v *TranscodeRendition) Resolution() (int, int) {
+	width, height := v.Width, v.Height
+	if width <= 0 {
+		width = height * 16 / 9
+	}
+	return width / 2 * 2, height / 2 * 2
+}
+
+// Bandwidth returns the peak bandwidth in bps, with about 10% overhead of container.
+func (v *TranscodeRendition) Bandwidth() int {
+	bitrate := v.AudioBitrate
+	if !v.AudioOnly {
+		bitrate += v.VideoBitrate
+	}
+	return bitrate * 1100
+}
+
+func (v *TranscodeRendition) profile(profile string) string {
+	if profile == "baseline" || profile == "main" || profile == "high" {
+		return profile
+	}
+	return "high"
+}
+
+// level returns the H.264 level by the resolution.
+func (v *TranscodeRendition) level() string {
+	if _, height := v.Resolution(); height <= 480 {
+		return "3.0"
+	} else if height <= 720 {
+		return "3.1"
+	} else if height <= 1080 {
+		return "4.0"
+	}
+	return "5.1"
+}
+
+// Codecs returns the CODECS attribute of HLS master playlist, see RFC 6381.
+func (v *TranscodeRendition) Codecs(profile string) string {
+	if v.AudioOnly {
+		return "mp4a.40.2"
+	}
+
+	// The profile_idc and constraint flags, for example, 6400 for high profile.
+	var pc string
+	switch v.profile(profile) {
+	case "baseline":
+		pc = "42e0"
+	case "main":
+		pc = "4d40"
+	default:
+		pc = "6400"
+	}
+
+	// The level_idc, for example, 1f for level 3.1.
+	level, _ := strconv.ParseFloat(v.level(), 64)
+	return fmt.Sprintf("avc1.%v%02x,mp4a.40.2", pc, int(level*10+0.5))
+}
+
 type TranscodeTask struct {
 	// The ID for task.
 	UUID string `json:"uuid"`
+	// The rule name for task, empty for the global task.
+	Rule string `json:"rule"`
+	// The source stream in /app/stream matched by rule.
+	Source string `json:"source"`
 
 	// The input url.
 	Input string `json:"input"`
@@ -295,6 +928,8 @@ type TranscodeTask struct {
 	frame string
 	// The last update time.
 	update time.Time
+	// Whether waiting for the limit of concurrent transcoding.
+	waiting bool
 
 	// The context for current task.
 	cancel context.CancelFunc
@@ -313,11 +948,54 @@ func NewTranscodeTask() *TranscodeTask {
 }
 
 func (v *TranscodeTask) String() string {
-	return fmt.Sprintf("uuid=%v, pid=%v, config is %v",
-		v.UUID, v.PID, v.config.String(),
+	return fmt.Sprintf("uuid=%v, rule=%v, source=%v, pid=%v, config is %v",
+		v.UUID, v.Rule, v.Source, v.PID, v.config.String(),
 	)
 }
 
+// Key is the identity of rule task, the pair of rule name and source stream.
+func (v *TranscodeTask) Key() string {
+	return fmt.Sprintf("%v %v", v.Rule, v.Source)
+}
+
+// outputs returns the URLs of output streams, empty if not enabled.
+func (v *TranscodeTask) outputs() []string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if !v.config.All {
+		return nil
+	}
+	return v.config.OutputURLs()
+}
+
+func (v *TranscodeTask) queryConfig() TranscodeConfig {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.config
+}
+
+func (v *TranscodeTask) queryWaiting() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.waiting
+}
+
+func (v *TranscodeTask) setWaiting(waiting bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.waiting = waiting
+}
+
+// remove the rule task from worker and redis, when task is done.
+func (v *TranscodeTask) remove(ctx context.Context) {
+	v.transcodeWorker.tasks.Delete(v.Key())
+
+	if err := rdb.HDel(ctx, SRS_TRANSCODE_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "ignore hdel %v %v err %+v", SRS_TRANSCODE_TASK, v.UUID, err)
+	}
+}
+
 func (v *TranscodeTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -365,9 +1043,23 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 				return nil, errors.Wrapf(err, "unmarshal %v", value)
 			}
 
+			// For rule task, only use the matched source stream.
+			if v.Source != "" {
+				if fmt.Sprintf("/%v/%v", stream.App, stream.Stream) == v.Source {
+					best = &stream
+					break
+				}
+				continue
+			}
+
 			// Ignore the transcode stream itself.
-			if isSameStream(fmt.Sprintf("%v/%v", v.config.Server, v.config.Secret),
-				fmt.Sprintf("rtmp://%v/%v/%v", stream.Vhost, stream.App, stream.Stream)) {
+			var isOutput bool
+			for _, output := range v.config.OutputURLs() {
+				if isSameStream(output, fmt.Sprintf("rtmp://%v/%v/%v", stream.Vhost, stream.App, stream.Stream)) {
+					isOutput = true
+				}
+			}
+			if isOutput || v.transcodeWorker.isOutput(fmt.Sprintf("/%v/%v", stream.App, stream.Stream)) {
 				continue
 			}
 
@@ -400,15 +1092,38 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 		return best, nil
 	}
 
+	// Whether the rule task should quit, for example, the rule is removed or the stream is unpublished.
+	var quit bool
+
 	pfn := func(ctx context.Context) error {
+		var config TranscodeConfig
 		if b, err := rdb.HGet(ctx, SRS_TRANSCODE_CONFIG, "global").Result(); err != nil && err != redis.Nil {
 			return errors.Wrapf(err, "hget %v global", SRS_TRANSCODE_CONFIG)
 		} else if len(b) > 0 {
-			if err := json.Unmarshal([]byte(b), &v.config); err != nil {
+			if err := json.Unmarshal([]byte(b), &config); err != nil {
 				return errors.Wrapf(err, "unmarshal %v", b)
 			}
 		}
 
+		// For rule task, use the config of rule, quit if rule is removed, disabled or not matched.
+		if v.Rule != "" {
+			var rule *TranscodeRule
+			for _, r := range config.Rules {
+				if r.Name == v.Rule {
+					rule = r
+				}
+			}
+			if rule == nil || !rule.Enabled || !rule.Match(v.Source) {
+				quit = true
+				return nil
+			}
+			config = rule.Config(v.Source)
+		}
+
+		v.lock.Lock()
+		v.config = config
+		v.lock.Unlock()
+
 		// Ignore if not enabled.
 		if !v.config.All {
 			return nil
@@ -418,6 +1133,14 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 			return errors.Wrapf(err, "save task")
 		}
 
+		// Wait for the limit of concurrent transcoding.
+		if !v.transcodeWorker.acquire() {
+			v.setWaiting(true)
+			return nil
+		}
+		defer v.transcodeWorker.release()
+		v.setWaiting(false)
+
 		// Use a active stream as input.
 		input, err := selectActiveStream()
 		if err != nil {
@@ -425,6 +1148,7 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 		}
 
 		if input == nil {
+			quit = v.Rule != ""
 			return nil
 		}
 
@@ -436,7 +1160,7 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 		return nil
 	}
 
-	for ctx.Err() == nil {
+	for ctx.Err() == nil && !quit {
 		if err := pfn(ctx); err != nil {
 			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
 
@@ -464,14 +1188,10 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 
 	// Build input URL.
 	host := "localhost"
-	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
+	inputURL := withInternalPlay(fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream))
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL := strings.ReplaceAll(v.config.OutputURL(), "localhost", host)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -494,27 +1214,31 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	} else {
 		args = append(args, "-i", inputURL)
 	}
-	args = append(args,
-		"-vcodec", v.config.VideoCodec,
-		"-profile:v", v.config.VideoProfile,
-		"-preset:v", v.config.VideoPreset,
-		"-tune", "zerolatency", // Low latency mode.
-		"-b:v", fmt.Sprintf("%vk", v.config.VideoBitrate),
-		"-r", "25", "-g", "50", // Set gop to 2s.
-		"-bf", "0", // Disable B frame for WebRTC.
-		"-acodec", v.config.AudioCodec,
-		"-b:a", fmt.Sprintf("%vk", v.config.AudioBitrate),
-	)
-	if v.config.AudioChannels > 0 {
-		args = append(args, "-ac", fmt.Sprintf("%v", v.config.AudioChannels))
-	}
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
-		args = append(args, "-f", "flv")
-	} else if strings.HasPrefix(outputURL, "srt://") {
-		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	if v.config.ABR() {
+		args = append(args, v.config.ABRArgs(outputURL)...)
+	} else {
+		args = append(args,
+			"-vcodec", v.config.VideoCodec,
+			"-profile:v", v.config.VideoProfile,
+			"-preset:v", v.config.VideoPreset,
+			"-tune", "zerolatency", // Low latency mode.
+			"-b:v", fmt.Sprintf("%vk", v.config.VideoBitrate),
+			"-r", "25", "-g", "50", // Set gop to 2s.
+			"-bf", "0", // Disable B frame for WebRTC.
+			"-acodec", v.config.AudioCodec,
+			"-b:a", fmt.Sprintf("%vk", v.config.AudioBitrate),
+		)
+		if v.config.AudioChannels > 0 {
+			args = append(args, "-ac", fmt.Sprintf("%v", v.config.AudioChannels))
+		}
+		// If RTMP use flv, if SRT use mpegts, otherwise do not set.
+		if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+			args = append(args, "-f", "flv")
+		} else if strings.HasPrefix(outputURL, "srt://") {
+			args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+		}
+		args = append(args, outputURL)
 	}
-	args = append(args, outputURL)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
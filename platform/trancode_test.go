@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTranscode_RenditionCodecs(t *testing.T) {
+	for _, e := range []struct {
+		rendition TranscodeRendition
+		profile   string
+		codecs    string
+	}{
+		{rendition: TranscodeRendition{AudioOnly: true}, profile: "high", codecs: "mp4a.40.2"},
+		{rendition: TranscodeRendition{Height: 1080}, profile: "high", codecs: "avc1.640028,mp4a.40.2"},
+		{rendition: TranscodeRendition{Height: 720}, profile: "main", codecs: "avc1.4d401f,mp4a.40.2"},
+		{rendition: TranscodeRendition{Height: 480}, profile: "baseline", codecs: "avc1.42e01e,mp4a.40.2"},
+		{rendition: TranscodeRendition{Height: 2160}, profile: "", codecs: "avc1.640033,mp4a.40.2"},
+	} {
+		if codecs := e.rendition.Codecs(e.profile); codecs != e.codecs {
+			t.Errorf("Fail for rendition %v, expect %v, actual %v", e.rendition.String(), e.codecs, codecs)
+		}
+	}
+}
+
+func TestTranscode_MasterPlaylist(t *testing.T) {
+	config := TranscodeConfig{
+		VideoCodec: "libx264", AudioCodec: "aac", VideoProfile: "high",
+		Server: "rtmp://localhost/live", Secret: "livestream",
+		Ladder: []*TranscodeRendition{
+			{Name: "720p", Height: 720, VideoBitrate: 2500, AudioBitrate: 128},
+			{Name: "480p", Width: 640, Height: 480, VideoBitrate: 1000, AudioBitrate: 64},
+			{Name: "audio", AudioOnly: true, AudioBitrate: 64},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Fail for config %v, err %+v", config.String(), err)
+	}
+
+	if stream := config.ABRStream(); stream != "/live/livestream" {
+		t.Errorf("Fail for stream %v", stream)
+	}
+
+	if outputs := strings.Join(config.OutputURLs(), " "); outputs != "rtmp://localhost/live/livestream_720p "+
+		"rtmp://localhost/live/livestream_480p rtmp://localhost/live/livestream_audio" {
+		t.Errorf("Fail for outputs %v", outputs)
+	}
+
+	expect := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-INDEPENDENT-SEGMENTS",
+		`#EXT-X-STREAM-INF:BANDWIDTH=2890800,RESOLUTION=1280x720,FRAME-RATE=25.000,CODECS="avc1.64001f,mp4a.40.2"`,
+		"livestream_720p.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=1170400,RESOLUTION=640x480,FRAME-RATE=25.000,CODECS="avc1.64001e,mp4a.40.2"`,
+		"livestream_480p.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=70400,CODECS="mp4a.40.2"`,
+		"livestream_audio.m3u8",
+	}, "\n") + "\n"
+	if playlist := config.MasterPlaylist(); playlist != expect {
+		t.Errorf("Fail for playlist %v, expect %v", playlist, expect)
+	}
+}
+
+func TestTranscode_Validate(t *testing.T) {
+	for _, e := range []struct {
+		config TranscodeConfig
+		valid  bool
+	}{
+		{config: TranscodeConfig{VideoCodec: "libx265"}, valid: true},
+		{config: TranscodeConfig{VideoCodec: "libx265", AudioCodec: "aac", Ladder: []*TranscodeRendition{
+			{Name: "720p", Height: 720, VideoBitrate: 2500, AudioBitrate: 128},
+		}}, valid: false},
+		{config: TranscodeConfig{VideoCodec: "libx264", AudioCodec: "aac", Ladder: []*TranscodeRendition{
+			{Name: "720p", Height: 720, VideoBitrate: 2500, AudioBitrate: 128},
+			{Name: "720p", Height: 720, VideoBitrate: 1500, AudioBitrate: 128},
+		}}, valid: false},
+		{config: TranscodeConfig{VideoCodec: "libx264", AudioCodec: "aac", Ladder: []*TranscodeRendition{
+			{Name: "720p", VideoBitrate: 2500, AudioBitrate: 128},
+		}}, valid: false},
+		{config: TranscodeConfig{VideoCodec: "libx264", AudioCodec: "aac", Ladder: []*TranscodeRendition{
+			{Name: "../720p", Height: 720, VideoBitrate: 2500, AudioBitrate: 128},
+		}}, valid: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for config %v, expect %v, actual %v", e.config.String(), e.valid, err)
+		}
+	}
+}
+
+func TestTranscode_Rule(t *testing.T) {
+	rule := TranscodeRule{
+		Name: "720p", Enabled: true, Streams: []string{"/live/*"}, Output: "rtmp://localhost/[app]/[stream]_720p",
+		Profile: TranscodeConfig{VideoCodec: "libx264", AudioCodec: "aac", VideoBitrate: 2500, AudioBitrate: 128},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Errorf("Fail for rule %v, err %+v", rule.String(), err)
+	}
+
+	if !rule.Match("/live/livestream") || rule.Match("/game/livestream") {
+		t.Errorf("Fail for rule %v match", rule.String())
+	}
+
+	config := rule.Config("/live/livestream")
+	if !config.All || config.VideoBitrate != 2500 {
+		t.Errorf("Fail for config %v", config.String())
+	}
+	if outputs := strings.Join(config.OutputURLs(), " "); outputs != "rtmp://localhost/live/livestream_720p" {
+		t.Errorf("Fail for outputs %v", outputs)
+	}
+
+	for _, e := range []TranscodeRule{
+		{Streams: []string{"/live/*"}, Output: "rtmp://localhost/[app]/[stream]_720p"},
+		{Name: "720p", Output: "rtmp://localhost/[app]/[stream]_720p"},
+		{Name: "720p", Streams: []string{"/live/["}, Output: "rtmp://localhost/[app]/[stream]_720p"},
+		{Name: "720p", Streams: []string{"/live/*"}, Output: "[stream]_720p"},
+		{Name: "720p", Streams: []string{"/live/*"}, Output: "rtmp://localhost/[app]/[stream]_720p",
+			Profile: TranscodeConfig{Rules: []*TranscodeRule{{}}}},
+	} {
+		if err := e.Validate(); err == nil {
+			t.Errorf("Fail for rule %v, expect invalid", e.String())
+		}
+	}
+}
+
+func TestTranscode_Limit(t *testing.T) {
+	worker := &TranscodeWorker{limit: 2}
+	if !worker.acquire() || !worker.acquire() || worker.acquire() {
+		t.Errorf("Fail for limit %v, running %v", worker.limit, worker.running)
+	}
+
+	worker.release()
+	if !worker.acquire() {
+		t.Errorf("Fail for limit %v, running %v", worker.limit, worker.running)
+	}
+
+	worker = &TranscodeWorker{}
+	for i := 0; i < 10; i++ {
+		if !worker.acquire() {
+			t.Errorf("Fail for no limit, running %v", worker.running)
+		}
+	}
+}
+
+func TestTranscode_ServeMasterPlaylist(t *testing.T) {
+	worker := NewTranscodeWorker()
+	worker.updateConfig(TranscodeConfig{
+		All: true, VideoCodec: "libx264", AudioCodec: "aac", Server: "rtmp://localhost/live", Secret: "livestream",
+		Ladder: []*TranscodeRendition{{Name: "720p", Height: 720, VideoBitrate: 2500, AudioBitrate: 128}},
+	})
+
+	// Serve the master playlist by the config in memory, without redis.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/live/livestream.m3u8", nil)
+	if !worker.ServeMasterPlaylist(context.Background(), w, r, "") || !strings.Contains(w.Body.String(), "livestream_720p.m3u8\n") {
+		t.Errorf("Fail for playlist %v", w.Body.String())
+	}
+
+	// The rendition is played by the token of player.
+	w = httptest.NewRecorder()
+	if !worker.ServeMasterPlaylist(context.Background(), w, r, "xxx") || !strings.Contains(w.Body.String(), "livestream_720p.m3u8?token=xxx") {
+		t.Errorf("Fail for playlist %v", w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/live/livestream_720p.m3u8", nil)
+	if worker.ServeMasterPlaylist(context.Background(), httptest.NewRecorder(), r, "") {
+		t.Errorf("Fail for %v", r.URL.Path)
+	}
+
+	// The rendition is verified by the ABR stream.
+	if app, stream, ok := worker.ABRStreamOf("live", "livestream_720p"); !ok || app != "live" || stream != "livestream" {
+		t.Errorf("Fail for rendition, app=%v, stream=%v, ok=%v", app, stream, ok)
+	}
+	if _, _, ok := worker.ABRStreamOf("live", "livestream"); ok {
+		t.Errorf("Fail for ABR stream")
+	}
+}
@@ -0,0 +1,495 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The duration to expire the abandoned upload, which is not updated for a while.
+const UploadExpireDuration = 24 * time.Hour
+
+// The duration to remove the uploaded file, if it's not used by vLive or dubbing source.
+const UploadDoneExpireDuration = 2 * time.Hour
+
+// The max size of a chunk, client should split file to chunks no larger than it.
+const UploadMaxChunkSize = 64 * 1024 * 1024
+
+var uploadWorker *UploadWorker
+
+// UploadWorker is the resumable chunked upload, the client creates an upload session, then uploads the
+// chunks by offset with optional checksum. If disconnected, the client queries the offset and resumes the
+// upload. The uploaded file is in dirUploadPath, which can be used as vLive, dubbing or camera source.
+type UploadWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The lock for each upload session, key is UUID in string, value is *sync.Mutex.
+	locks sync.Map
+}
+
+func NewUploadWorker() *UploadWorker {
+	return &UploadWorker{}
+}
+
+// lock the upload session, to write chunks in order.
+func (v *UploadWorker) lock(uuid string) func() {
+	lock, _ := v.locks.LoadOrStore(uuid, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// loadLocked validates and loads the upload session, then locks and reloads it. Note that the lock is only
+// created for the existing session, and removed if the session is gone, so it never leaks for garbage UUID.
+func (v *UploadWorker) loadLocked(ctx context.Context, uploadUUID string) (*UploadSession, func(), error) {
+	if _, err := uuid.Parse(uploadUUID); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid upload %v", uploadUUID)
+	}
+	if err := (&UploadSession{UUID: uploadUUID}).Load(ctx); err != nil {
+		return nil, nil, errors.Wrapf(err, "load upload %v", uploadUUID)
+	}
+
+	unlock := v.lock(uploadUUID)
+	session := &UploadSession{UUID: uploadUUID}
+	if err := session.Load(ctx); err != nil {
+		v.locks.Delete(uploadUUID)
+		unlock()
+		return nil, nil, errors.Wrapf(err, "load upload %v", uploadUUID)
+	}
+	return session, unlock, nil
+}
+
+func (v *UploadWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/upload/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, filename string
+			var size int64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Filename *string `json:"filename"`
+				Size     *int64  `json:"size"`
+			}{
+				Token: &token, Filename: &filename, Size: &size,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if filename == "" || path.Base(filename) != filename {
+				return errors.Errorf("invalid filename %v", filename)
+			}
+			if size <= 0 {
+				return errors.Errorf("invalid size %v", size)
+			}
+
+			session := NewUploadSession(func(session *UploadSession) {
+				session.Name, session.Size = filename, size
+			})
+
+			if f, err := os.OpenFile(session.PartFile(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				return errors.Wrapf(err, "open file %v", session.PartFile())
+			} else {
+				f.Close()
+			}
+
+			if err := session.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save %v", session.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				*UploadSession
+				// The max size of chunk.
+				ChunkSize int `json:"chunk"`
+			}{
+				UploadSession: session, ChunkSize: UploadMaxChunkSize,
+			})
+			logger.Tf(ctx, "upload: Create ok, %v, token=%vB", session.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	// The chunk is in body, while the offset and checksum are in query or headers, for example:
+	//		PUT /terraform/v1/ffmpeg/upload/chunk/:uuid?offset=0&checksum=:sha256
+	// Or use the headers like tus:
+	//		Upload-Offset: 0
+	//		Upload-Checksum: sha256 :sha256
+	ep = "/terraform/v1/ffmpeg/upload/chunk/"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func(ctx context.Context) error {
+			q := r.URL.Query()
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, q.Get("token"), r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			uploadUUID := r.URL.Path[len("/terraform/v1/ffmpeg/upload/chunk/"):]
+			offsetValue, checksum := q.Get("offset"), q.Get("checksum")
+			if value := r.Header.Get("Upload-Offset"); value != "" {
+				offsetValue = value
+			}
+			if value := r.Header.Get("Upload-Checksum"); value != "" {
+				checksum = strings.TrimPrefix(value, "sha256 ")
+			}
+
+			offset, err := strconv.ParseInt(offsetValue, 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "parse offset %v", offsetValue)
+			}
+
+			session, unlock, err := v.loadLocked(ctx, uploadUUID)
+			if err != nil {
+				return errors.Wrapf(err, "lock upload")
+			}
+			defer unlock()
+
+			// Client should query the offset and resume from it.
+			if session.Done {
+				return errors.Errorf("upload %v is done", uploadUUID)
+			}
+			if offset != session.Offset {
+				return errors.Errorf("offset %v mismatch, should be %v", offset, session.Offset)
+			}
+
+			// Never write more than the size of file.
+			size := session.Size - session.Offset
+			if size > UploadMaxChunkSize {
+				size = UploadMaxChunkSize
+			}
+			body := http.MaxBytesReader(w, r.Body, size)
+
+			written, err := session.WriteChunk(body, checksum)
+			if err != nil {
+				return errors.Wrapf(err, "write chunk offset=%v, checksum=%v", offset, checksum)
+			}
+
+			if err := session.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save %v", session.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, session)
+			logger.Tf(ctx, "upload: Write chunk ok, offset=%v, written=%v, %v", offset, written, session.String())
+			return nil
+		}(logger.WithContext(ctx)); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/upload/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uploadUUID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uploadUUID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			session := &UploadSession{UUID: uploadUUID}
+			if err := session.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load upload %v", uploadUUID)
+			}
+
+			ohttp.WriteData(ctx, w, r, session)
+			logger.Tf(ctx, "upload: Query ok, %v, token=%vB", session.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/upload/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uploadUUID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uploadUUID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			session, unlock, err := v.loadLocked(ctx, uploadUUID)
+			if err != nil {
+				return errors.Wrapf(err, "lock upload")
+			}
+			defer unlock()
+
+			if err := session.Remove(ctx); err != nil {
+				return errors.Wrapf(err, "remove %v", session.String())
+			}
+			v.locks.Delete(uploadUUID)
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "upload: Remove ok, %v, token=%vB", session.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *UploadWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *UploadWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "upload: Start a worker")
+
+	// Remove the expired uploads, which are abandoned or not used.
+	expireUploads := func() error {
+		objs, err := rdb.HGetAll(ctx, SRS_UPLOAD_SESSIONS).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_UPLOAD_SESSIONS)
+		}
+
+		for uploadUUID, obj := range objs {
+			var session UploadSession
+			if err := json.Unmarshal([]byte(obj), &session); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", uploadUUID, obj)
+			}
+
+			if !session.Expired(time.Now()) {
+				continue
+			}
+
+			func() {
+				unlock := v.lock(uploadUUID)
+				defer unlock()
+
+				if err := session.Remove(ctx); err != nil {
+					logger.Wf(ctx, "ignore remove %v err %+v", session.String(), err)
+				}
+				v.locks.Delete(uploadUUID)
+			}()
+			logger.Tf(ctx, "upload: Expire %v", session.String())
+		}
+
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			duration := 1 * time.Minute
+			if err := expireUploads(); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 3 * time.Minute
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// UploadSession is a resumable upload, the file is written to the part file by chunks, and renamed to the
+// target file when done.
+type UploadSession struct {
+	// The upload UUID.
+	UUID string `json:"uuid"`
+	// The file name.
+	Name string `json:"name"`
+	// The size of file in bytes.
+	Size int64 `json:"size"`
+	// The bytes already written, the offset of next chunk.
+	Offset int64 `json:"offset"`
+	// Whether the upload is done.
+	Done bool `json:"done"`
+	// The target file, for vLive, dubbing or camera source.
+	Target string `json:"target"`
+	// The create and update time.
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func NewUploadSession(opts ...func(session *UploadSession)) *UploadSession {
+	v := &UploadSession{
+		UUID:      uuid.NewString(),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	v.UpdatedAt = v.CreatedAt
+	v.Target = path.Join(dirUploadPath, fmt.Sprintf("%v%v", v.UUID, path.Ext(v.Name)))
+	return v
+}
+
+func (v *UploadSession) String() string {
+	return fmt.Sprintf("uuid=%v, name=%v, size=%v, offset=%v, done=%v, target=%v, created=%v, updated=%v",
+		v.UUID, v.Name, v.Size, v.Offset, v.Done, v.Target, v.CreatedAt, v.UpdatedAt,
+	)
+}
+
+// PartFile is the file to write chunks, before upload is done.
+func (v *UploadSession) PartFile() string {
+	return fmt.Sprintf("%v.part", v.Target)
+}
+
+// Expired whether the session is abandoned, or the uploaded file is not used for a while.
+func (v *UploadSession) Expired(now time.Time) bool {
+	updated, err := time.Parse(time.RFC3339, v.UpdatedAt)
+	if err != nil {
+		return true
+	}
+
+	if v.Done {
+		return now.Sub(updated) > UploadDoneExpireDuration
+	}
+	return now.Sub(updated) > UploadExpireDuration
+}
+
+// WriteChunk writes the chunk at the offset, and verify the sha256 checksum in hex if not empty. The part
+// file is truncated to the offset if failed, so client is able to retry. If all bytes are written, rename
+// the part file to target.
+func (v *UploadSession) WriteChunk(r io.Reader, checksum string) (written int64, err error) {
+	f, err := os.OpenFile(v.PartFile(), os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open file %v", v.PartFile())
+	}
+	defer f.Close()
+
+	// Discard the partial written chunk, for example, client disconnected or platform restarted.
+	if err = f.Truncate(v.Offset); err != nil {
+		return 0, errors.Wrapf(err, "truncate %v", v.Offset)
+	}
+	defer func() {
+		if err != nil {
+			f.Truncate(v.Offset)
+		}
+	}()
+
+	if _, err = f.Seek(v.Offset, io.SeekStart); err != nil {
+		return 0, errors.Wrapf(err, "seek %v", v.Offset)
+	}
+
+	hash := sha256.New()
+	if written, err = io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		return 0, errors.Wrapf(err, "copy to %v", v.PartFile())
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); checksum != "" && !strings.EqualFold(checksum, actual) {
+		err = errors.Errorf("checksum mismatch, expect %v, actual %v", checksum, actual)
+		return 0, err
+	}
+
+	v.Offset += written
+	v.UpdatedAt = time.Now().Format(time.RFC3339)
+	if v.Offset < v.Size {
+		return written, nil
+	}
+
+	if err = os.Rename(v.PartFile(), v.Target); err != nil {
+		v.Offset -= written
+		return 0, errors.Wrapf(err, "rename %v to %v", v.PartFile(), v.Target)
+	}
+	v.Done = true
+
+	return written, nil
+}
+
+func (v *UploadSession) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_UPLOAD_SESSIONS, v.UUID).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_UPLOAD_SESSIONS, v.UUID)
+	} else if b == "" {
+		return errors.Errorf("upload %v not exists", v.UUID)
+	} else if err = json.Unmarshal([]byte(b), v); err != nil {
+		return errors.Wrapf(err, "unmarshal %v %v", v.UUID, b)
+	}
+
+	return nil
+}
+
+func (v *UploadSession) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_UPLOAD_SESSIONS, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_UPLOAD_SESSIONS, v.UUID, string(b))
+	}
+
+	return nil
+}
+
+// Remove the session and files, note that the target file might be already moved by vLive or dubbing.
+func (v *UploadSession) Remove(ctx context.Context) error {
+	for _, file := range []string{v.PartFile(), v.Target} {
+		if _, err := os.Stat(file); err == nil {
+			os.Remove(file)
+		}
+	}
+
+	if err := rdb.HDel(ctx, SRS_UPLOAD_SESSIONS, v.UUID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_UPLOAD_SESSIONS, v.UUID)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpload_WriteChunk(t *testing.T) {
+	session := &UploadSession{Size: 10, Target: path.Join(t.TempDir(), "a.mp4")}
+	if err := os.WriteFile(session.PartFile(), nil, 0644); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	checksum := func(s string) string {
+		hash := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(hash[:])
+	}
+
+	if written, err := session.WriteChunk(strings.NewReader("hello"), checksum("hello")); err != nil || written != 5 {
+		t.Errorf("Fail for written=%v, err %+v", written, err)
+	}
+
+	// The chunk should be discarded if checksum mismatch, so client is able to retry.
+	if _, err := session.WriteChunk(strings.NewReader("world"), checksum("hello")); err == nil {
+		t.Errorf("Fail for checksum should mismatch")
+	}
+	if info, err := os.Stat(session.PartFile()); err != nil || info.Size() != 5 || session.Offset != 5 {
+		t.Errorf("Fail for offset %v, err %+v", session.Offset, err)
+	}
+
+	if written, err := session.WriteChunk(strings.NewReader("world"), ""); err != nil || written != 5 || !session.Done {
+		t.Errorf("Fail for written=%v, done=%v, err %+v", written, session.Done, err)
+	}
+	if b, err := os.ReadFile(session.Target); err != nil || string(b) != "helloworld" {
+		t.Errorf("Fail for target %v, err %+v", string(b), err)
+	}
+}
+
+func TestUpload_Expired(t *testing.T) {
+	now := time.Now()
+	for _, e := range []struct {
+		session UploadSession
+		expired bool
+	}{
+		{session: UploadSession{UpdatedAt: now.Format(time.RFC3339)}, expired: false},
+		{session: UploadSession{UpdatedAt: now.Add(-UploadExpireDuration - time.Minute).Format(time.RFC3339)}, expired: true},
+		{session: UploadSession{Done: true, UpdatedAt: now.Add(-time.Hour).Format(time.RFC3339)}, expired: false},
+		{session: UploadSession{Done: true, UpdatedAt: now.Add(-UploadDoneExpireDuration - time.Minute).Format(time.RFC3339)}, expired: true},
+		{session: UploadSession{}, expired: true},
+	} {
+		if expired := e.session.Expired(now); expired != e.expired {
+			t.Errorf("Fail for session %v, expect %v, actual %v", e.session.String(), e.expired, expired)
+		}
+	}
+}
+
+func TestUpload_LockInvalid(t *testing.T) {
+	worker := NewUploadWorker()
+	for _, uploadUUID := range []string{"", "xxx", "../../etc/passwd"} {
+		if _, _, err := worker.loadLocked(context.Background(), uploadUUID); err == nil {
+			t.Errorf("Fail for uuid %v", uploadUUID)
+		}
+		if _, ok := worker.locks.Load(uploadUUID); ok {
+			t.Errorf("Fail for lock leaks, uuid %v", uploadUUID)
+		}
+	}
+}
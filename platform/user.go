@@ -0,0 +1,447 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// UserRole is the role of user, which defines the permission of management API.
+type UserRole string
+
+const (
+	// The viewer is only allowed to query the status, read-only.
+	UserRoleViewer UserRole = "viewer"
+	// The operator is allowed to operate the streams, for example, start or stop forwarding, kickoff
+	// stream, etc.
+	UserRoleOperator UserRole = "operator"
+	// The admin is allowed to do everything, like the root user by MGMT_PASSWORD.
+	UserRoleAdmin UserRole = "admin"
+)
+
+// Level of role, the higher level includes all permissions of lower level.
+func (v UserRole) Level() int {
+	switch v {
+	case UserRoleViewer:
+		return 1
+	case UserRoleOperator:
+		return 2
+	case UserRoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Allows whether the role has the permission of required role.
+func (v UserRole) Allows(required UserRole) bool {
+	return v.Level() > 0 && v.Level() >= required.Level()
+}
+
+// The management API prefixes, which expose or update secrets, so always require admin, even for query.
+var userRoleAdminPrefixes = []string{
+	"/terraform/v1/mgmt/users/",
+	"/terraform/v1/mgmt/apikeys/",
+	"/terraform/v1/mgmt/audit",
+	"/terraform/v1/mgmt/secret/",
+	"/terraform/v1/mgmt/openai/",
+	"/terraform/v1/mgmt/hooks/",
+	"/terraform/v1/hooks/srs/secret",
+	"/terraform/v1/tencent/cam/",
+}
+
+// The management API prefixes, which operate the streams, require operator.
+var userRoleOperatorPrefixes = []string{
+	"/terraform/v1/mgmt/streams/",
+	"/terraform/v1/ffmpeg/forward/",
+	"/terraform/v1/ffmpeg/vlive/",
+	"/terraform/v1/ffmpeg/camera/",
+	"/terraform/v1/ffmpeg/transcode/",
+	"/terraform/v1/ffmpeg/fallback/",
+	"/terraform/v1/ffmpeg/mosaic/",
+	"/terraform/v1/ffmpeg/upload/",
+	"/terraform/v1/hooks/record/",
+	"/terraform/v1/hooks/dvr/",
+	"/terraform/v1/hooks/vod/",
+	"/terraform/v1/live/room/",
+}
+
+// The read-only management APIs, allow viewer. Note that it's an explicit allow-list, because some query
+// APIs expose secrets, such as the stream keys of forwarding, which require operator or admin.
+var userRoleViewerEndpoints = []string{
+	"/terraform/v1/mgmt/status",
+	"/terraform/v1/mgmt/streams/query",
+	"/terraform/v1/mgmt/limits/query",
+	"/terraform/v1/mgmt/hphls/query",
+	"/terraform/v1/mgmt/hlsll/query",
+	"/terraform/v1/ffmpeg/vlive/xmltv",
+	"/terraform/v1/ffmpeg/fallback/query",
+	"/terraform/v1/ffmpeg/mosaic/query",
+	"/terraform/v1/ffmpeg/upload/query",
+	"/terraform/v1/ffmpeg/camera/motion/events",
+	"/terraform/v1/hooks/srs/play/query",
+	"/terraform/v1/hooks/record/query",
+	"/terraform/v1/hooks/record/files",
+	"/terraform/v1/hooks/dvr/query",
+	"/terraform/v1/hooks/dvr/files",
+	"/terraform/v1/hooks/vod/query",
+	"/terraform/v1/hooks/vod/files",
+}
+
+// RequiredUserRole returns the role required by the management API request.
+func RequiredUserRole(r *http.Request) UserRole {
+	p := r.URL.Path
+
+	// The SRS HTTP API, allow viewer to query, while operator to kickoff clients.
+	if strings.HasPrefix(p, "/api/") {
+		if r.Method == http.MethodGet {
+			return UserRoleViewer
+		}
+		return UserRoleOperator
+	}
+
+	// Any user is allowed to refresh the token of itself.
+	if p == "/terraform/v1/mgmt/token" {
+		return UserRoleViewer
+	}
+
+	for _, prefix := range userRoleAdminPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return UserRoleAdmin
+		}
+	}
+
+	if slicesContains(userRoleViewerEndpoints, p) {
+		return UserRoleViewer
+	}
+
+	for _, prefix := range userRoleOperatorPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return UserRoleOperator
+		}
+	}
+
+	return UserRoleAdmin
+}
+
+// SrsUser is a named user of management API, with a role.
+type SrsUser struct {
+	// The user name, unique.
+	Name string `json:"name"`
+	// The role of user.
+	Role UserRole `json:"role"`
+	// The salt and hash of password, never return to client.
+	Salt string `json:"salt,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	// Create and update time.
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func NewSrsUser(opts ...func(user *SrsUser)) *SrsUser {
+	v := &SrsUser{
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	v.UpdatedAt = v.CreatedAt
+
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *SrsUser) String() string {
+	return fmt.Sprintf("name=%v, role=%v, created=%v, updated=%v", v.Name, v.Role, v.CreatedAt, v.UpdatedAt)
+}
+
+// SetPassword generates a new salt, and save the hash of password.
+func (v *SrsUser) SetPassword(password string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return errors.Wrapf(err, "generate salt")
+	}
+
+	v.Salt = hex.EncodeToString(salt)
+	v.Hash = userPasswordHash(v.Salt, password)
+	return nil
+}
+
+// VerifyPassword whether the password matches the hash.
+func (v *SrsUser) VerifyPassword(password string) bool {
+	if v.Salt == "" || v.Hash == "" {
+		return false
+	}
+	return hmac.Equal([]byte(v.Hash), []byte(userPasswordHash(v.Salt, password)))
+}
+
+// Public returns a copy of user without the password hash, to response to client.
+func (v *SrsUser) Public() *SrsUser {
+	user := *v
+	user.Salt, user.Hash = "", ""
+	return &user
+}
+
+// Save the user to redis.
+func (v *SrsUser) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_USERS, v.Name, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_USERS, v.Name)
+	}
+	return nil
+}
+
+// userPasswordHash builds the hash of password by HMAC-SHA256 with salt, and stretch it by iterations
+// to slow down the brute force attack.
+func userPasswordHash(salt, password string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(password))
+	sum := mac.Sum(nil)
+
+	for i := 0; i < 10000; i++ {
+		h := sha256.Sum256(append(sum, []byte(salt)...))
+		sum = h[:]
+	}
+	return hex.EncodeToString(sum)
+}
+
+// The valid user name, to avoid confusing with other identities.
+var userNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{1,64}$`)
+
+// LoadSrsUser loads the user by name, or nil if not exists.
+func LoadSrsUser(ctx context.Context, name string) (*SrsUser, error) {
+	r0, err := rdb.HGet(ctx, SRS_USERS, name).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_USERS, name)
+	} else if r0 == "" {
+		return nil, nil
+	}
+
+	var user SrsUser
+	if err = json.Unmarshal([]byte(r0), &user); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v %v", name, r0)
+	}
+	return &user, nil
+}
+
+func handleUserService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/mgmt/users/create"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name, password string
+			var role UserRole
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string   `json:"token"`
+				Name     *string   `json:"name"`
+				Password *string   `json:"password"`
+				Role     *UserRole `json:"role"`
+			}{
+				Token: &token, Name: &name, Password: &password, Role: &role,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if !userNameRegexp.MatchString(name) {
+				return errors.Errorf("invalid name %v", name)
+			}
+			if password == "" {
+				return errors.New("no password")
+			}
+			if role.Level() == 0 {
+				return errors.Errorf("invalid role %v", role)
+			}
+
+			if user, err := LoadSrsUser(ctx, name); err != nil {
+				return errors.Wrapf(err, "load user %v", name)
+			} else if user != nil {
+				return errors.Errorf("user %v exists", name)
+			}
+
+			user := NewSrsUser(func(user *SrsUser) {
+				user.Name, user.Role = name, role
+			})
+			if err := user.SetPassword(password); err != nil {
+				return errors.Wrapf(err, "set password")
+			}
+			if err := user.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save user %v", user.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, user.Public())
+			logger.Tf(ctx, "user create ok, %v, token=%vB", user.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name, password string
+			var role UserRole
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string   `json:"token"`
+				Name     *string   `json:"name"`
+				Password *string   `json:"password"`
+				Role     *UserRole `json:"role"`
+			}{
+				Token: &token, Name: &name, Password: &password, Role: &role,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			user, err := LoadSrsUser(ctx, name)
+			if err != nil {
+				return errors.Wrapf(err, "load user %v", name)
+			} else if user == nil {
+				return errors.Errorf("user %v not exists", name)
+			}
+
+			// Only update the specified fields.
+			if role != "" {
+				if role.Level() == 0 {
+					return errors.Errorf("invalid role %v", role)
+				}
+				user.Role = role
+			}
+			if password != "" {
+				if err := user.SetPassword(password); err != nil {
+					return errors.Wrapf(err, "set password")
+				}
+			}
+
+			user.UpdatedAt = time.Now().Format(time.RFC3339)
+			if err := user.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save user %v", user.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, user.Public())
+			logger.Tf(ctx, "user update ok, %v, password=%vB, token=%vB", user.String(), len(password), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/list"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			users := []*SrsUser{}
+			if configs, err := rdb.HGetAll(ctx, SRS_USERS).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_USERS)
+			} else {
+				for k, v := range configs {
+					var obj SrsUser
+					if err = json.Unmarshal([]byte(v), &obj); err != nil {
+						return errors.Wrapf(err, "unmarshal %v %v", k, v)
+					}
+					users = append(users, obj.Public())
+				}
+			}
+
+			sort.Slice(users, func(i, j int) bool {
+				return users[i].Name < users[j].Name
+			})
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Users []*SrsUser `json:"users"`
+			}{
+				Users: users,
+			})
+			logger.Tf(ctx, "user list ok, users=%v, token=%vB", len(users), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/users/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, name string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				Name  *string `json:"name"`
+			}{
+				Token: &token, Name: &name,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if user, err := LoadSrsUser(ctx, name); err != nil {
+				return errors.Wrapf(err, "load user %v", name)
+			} else if user == nil {
+				return errors.Errorf("user %v not exists", name)
+			}
+
+			// Note that the tokens of user are invalid immediately, because we always load the user when
+			// authenticate the token.
+			if err := rdb.HDel(ctx, SRS_USERS, name).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_USERS, name)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "user remove ok, name=%v, token=%vB", name, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
@@ -5,7 +5,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -282,9 +286,18 @@ const (
 	// For IP camera live channel/stream.
 	SRS_CAMERA_CONFIG = "SRS_CAMERA_CONFIG"
 	SRS_CAMERA_TASK   = "SRS_CAMERA_TASK"
+	SRS_CAMERA_ONVIF  = "SRS_CAMERA_ONVIF"
+	SRS_CAMERA_MOTION = "SRS_CAMERA_MOTION"
+	SRS_CAMERA_HEALTH = "SRS_CAMERA_HEALTH"
 	// For transcoding.
 	SRS_TRANSCODE_CONFIG = "SRS_TRANSCODE_CONFIG"
 	SRS_TRANSCODE_TASK   = "SRS_TRANSCODE_TASK"
+	// For fallback to filler when publisher drops.
+	SRS_FALLBACK_CONFIG = "SRS_FALLBACK_CONFIG"
+	SRS_FALLBACK_TASK   = "SRS_FALLBACK_TASK"
+	// For mosaic composition of multiple inputs.
+	SRS_MOSAIC_CONFIG = "SRS_MOSAIC_CONFIG"
+	SRS_MOSAIC_TASK   = "SRS_MOSAIC_TASK"
 	// For transcription.
 	SRS_TRANSCRIPT_CONFIG = "SRS_TRANSCRIPT_CONFIG"
 	SRS_TRANSCRIPT_TASK   = "SRS_TRANSCRIPT_TASK"
@@ -304,6 +317,8 @@ const (
 	// For dubbing service.
 	SRS_DUBBING_PROJECTS = "SRS_DUBBING_PROJECTS"
 	SRS_DUBBING_TASKS    = "SRS_DUBBING_TASKS"
+	// For resumable upload.
+	SRS_UPLOAD_SESSIONS = "SRS_UPLOAD_SESSIONS"
 	// About authentication.
 	SRS_AUTH_SECRET    = "SRS_AUTH_SECRET"
 	SRS_SECRET_PUBLISH = "SRS_SECRET_PUBLISH"
@@ -318,6 +333,13 @@ const (
 	SRS_HTTPS           = "SRS_HTTPS"
 	SRS_HTTPS_DOMAIN    = "SRS_HTTPS_DOMAIN"
 	SRS_HOOKS           = "SRS_HOOKS"
+	SRS_HOOKS_DELIVERY  = "SRS_HOOKS_DELIVERY"
+	SRS_HOOKS_TARGETS   = "SRS_HOOKS_TARGETS"
+	SRS_USERS           = "SRS_USERS"
+	SRS_API_KEYS        = "SRS_API_KEYS"
+	SRS_API_KEYS_USED   = "SRS_API_KEYS_USED"
+	SRS_AUDIT           = "SRS_AUDIT"
+	SRS_STREAM_KEYS     = "SRS_STREAM_KEYS"
 	SRS_SYS_LIMITS      = "SRS_SYS_LIMITS"
 	SRS_SYS_OPENAI      = "SRS_SYS_OPENAI"
 )
@@ -327,6 +349,12 @@ func GenerateRoomPublishKey(roomStreamName string) string {
 	return fmt.Sprintf("room-pub-%v", roomStreamName)
 }
 
+// GenerateRoomPlayKey to build the redis hashset key from room stream name, which indicates whether the
+// room requires play token.
+func GenerateRoomPlayKey(roomStreamName string) string {
+	return fmt.Sprintf("room-play-%v", roomStreamName)
+}
+
 // Default limit to 5Mbps for virtual live streaming.
 const SrsSysLimitsVLive = 5 * 1000
 
@@ -348,10 +376,16 @@ const FFprobeSourceTypeFile FFprobeSourceType = "file"
 const FFprobeSourceTypeYTDL FFprobeSourceType = "ytdl"
 const FFprobeSourceTypeStream FFprobeSourceType = "stream"
 
+// FFprobeSourceTypeRecord is the finished record, which references the MP4 file of record by UUID, so the
+// file is never moved or removed by the source.
+const FFprobeSourceTypeRecord FFprobeSourceType = "record"
+
 // For vLive upload directory.
 var dirUploadPath = path.Join(".", "upload")
 var dirVLivePath = path.Join(".", "vlive")
 var dirDubbingPath = path.Join(".", "dub")
+var dirFallbackPath = path.Join(".", "fallback")
+var dirMosaicPath = path.Join(".", "mosaic")
 
 // For Oryx to use the files.
 const serverDataDirectory = "/data"
@@ -487,6 +521,22 @@ func envCameraLimit() string {
 	return os.Getenv("SRS_CAMERA_LIMIT")
 }
 
+func envTranscodeLimit() string {
+	return os.Getenv("SRS_TRANSCODE_LIMIT")
+}
+
+func envApiRateLimit() string {
+	return os.Getenv("API_RATE_LIMIT")
+}
+
+func envApiRateLimitExpensive() string {
+	return os.Getenv("API_RATE_LIMIT_EXPENSIVE")
+}
+
+func envTrustedProxies() string {
+	return os.Getenv("TRUSTED_PROXIES")
+}
+
 func envGoPprof() string {
 	return os.Getenv("GO_PPROF")
 }
@@ -514,16 +564,19 @@ func InitRdb() error {
 }
 
 // For platform to build token by jwt.
-func createToken(ctx context.Context, apiSecret string) (expireAt, createAt time.Time, token string, err error) {
+// The user is the name of SrsUser, or empty for the root user by MGMT_PASSWORD.
+func createToken(ctx context.Context, apiSecret, user string) (expireAt, createAt time.Time, token string, err error) {
 	createAt, expireAt = time.Now(), time.Now().Add(365*24*time.Hour)
 
 	claims := struct {
 		Version string `json:"v"`
 		Nonce   string `json:"nonce"`
+		User    string `json:"user,omitempty"`
 		jwt.RegisteredClaims
 	}{
 		Version: "1.0",
 		Nonce:   fmt.Sprintf("%x", rand.Uint64()),
+		User:    user,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expireAt),
 			IssuedAt:  jwt.NewNumericDate(createAt),
@@ -540,6 +593,253 @@ func createToken(ctx context.Context, apiSecret string) (expireAt, createAt time
 	return expireAt, createAt, token, nil
 }
 
+// PlayTokenClaims is the claims of play token, to authenticate the player in on_play.
+type PlayTokenClaims struct {
+	// The stream to play, in the form of /app/stream.
+	Stream string `json:"stream"`
+	// The client IP of player, allow any IP if empty.
+	IP string `json:"ip,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// createPlayToken builds the play token for stream by jwt, signed by the play secret.
+func createPlayToken(playSecret, stream, ip string, expireAt time.Time) (string, error) {
+	claims := PlayTokenClaims{
+		Stream: stream, IP: ip,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expireAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(playSecret))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return token, nil
+}
+
+// verifyPlayToken verifies the signature and expiration of play token, and whether it's issued for the
+// stream and client IP.
+func verifyPlayToken(playSecret, token, stream, ip string) error {
+	if token == "" {
+		return errors.New("no play token")
+	}
+
+	var claims PlayTokenClaims
+	if _, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("invalid method %v", token.Header["alg"])
+		}
+		return []byte(playSecret), nil
+	}); err != nil {
+		return errors.Wrapf(err, "verify token %v", token)
+	}
+
+	if claims.Stream != stream {
+		return errors.Errorf("token for stream %v, not %v", claims.Stream, stream)
+	}
+	if claims.IP != "" && claims.IP != ip {
+		return errors.Errorf("token for ip %v, not %v", claims.IP, ip)
+	}
+	return nil
+}
+
+// InternalPlayTimeout is the lifetime of the internal play token, which is only used to pass the on_play of
+// SRS when the internal player starts, so it should be short.
+const InternalPlayTimeout = 60 * time.Second
+
+// internalPlayToken is the token for internal players to play the app/stream, expired at the expire unix time.
+// It's derived from the api secret, so it's not able to be forged by the external players.
+func internalPlayToken(app, stream string, expire int64) string {
+	h := hmac.New(sha256.New, []byte(envApiSecret()))
+	h.Write([]byte(fmt.Sprintf("oryx-internal-play:%v/%v:%v", app, stream, expire)))
+	return fmt.Sprintf("%v.%v", expire, hex.EncodeToString(h.Sum(nil)))
+}
+
+// newInternalPlayToken creates a short-lived internal play token for the app/stream.
+func newInternalPlayToken(app, stream string) string {
+	return internalPlayToken(app, stream, time.Now().Add(InternalPlayTimeout).Unix())
+}
+
+// withInternalPlay appends the internal play token to the stream URL, for internal players such as forward
+// and transcode, which are always allowed by on_play.
+func withInternalPlay(streamURL string) string {
+	var app, stream string
+	if u, err := url.Parse(streamURL); err == nil {
+		app, stream = httpStreamOfPath(u.Path)
+	}
+
+	separator := "?"
+	if strings.Contains(streamURL, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%v%voryx_internal=%v", streamURL, separator, newInternalPlayToken(app, stream))
+}
+
+// isInternalPlay whether the param of app/stream is from internal players, see withInternalPlay.
+func isInternalPlay(app, stream, param string) bool {
+	if envApiSecret() == "" {
+		return false
+	}
+
+	q, err := url.ParseQuery(strings.TrimPrefix(param, "?"))
+	if err != nil {
+		return false
+	}
+
+	token := q.Get("oryx_internal")
+	index := strings.Index(token, ".")
+	if index <= 0 {
+		return false
+	}
+
+	expire, err := strconv.ParseInt(token[:index], 10, 64)
+	if err != nil || time.Now().Unix() > expire {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(internalPlayToken(app, stream, expire)))
+}
+
+// hlsStripInternal removes the internal play token from the HLS playlist or URL, so that it's never exposed to
+// the players, for example, by the hls_ctx redirect or the session playlist of SRS.
+func hlsStripInternal(playlist string) string {
+	return hlsInternalPlayRegexp.ReplaceAllStringFunc(playlist, func(s string) string {
+		if strings.HasSuffix(s, "&") {
+			return s[:1]
+		}
+		return ""
+	})
+}
+
+var hlsInternalPlayRegexp = regexp.MustCompile(`[?&]oryx_internal=[^&"\s]*&?`)
+
+// hlsStripInternalResponse removes the internal play token from the redirect and HLS playlist responded by SRS.
+func hlsStripInternalResponse(resp *http.Response) error {
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", hlsStripInternal(location))
+	}
+
+	if resp.Request == nil || !strings.HasSuffix(resp.Request.URL.Path, ".m3u8") {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read playlist")
+	}
+	resp.Body.Close()
+
+	b = []byte(hlsStripInternal(string(b)))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", fmt.Sprintf("%v", len(b)))
+	return nil
+}
+
+// httpStreamOfPath parses the app and stream of HTTP stream path, such as /live/livestream.flv or the HLS
+// segment /live/livestream-10-1706247398.ts, see hls_ts_file of SRS config.
+func httpStreamOfPath(p string) (app, stream string) {
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	ext := path.Ext(p)
+	p = strings.TrimSuffix(p, ext)
+
+	if ext == ".ts" {
+		if parts := strings.Split(p, "-"); len(parts) > 2 {
+			p = strings.Join(parts[:len(parts)-2], "-")
+		}
+	}
+
+	if index := strings.LastIndex(p, "/"); index > 0 {
+		return p[:index], p[index+1:]
+	}
+	return "", p
+}
+
+// hlsRewritePlaylist appends the play token to each segment of the HLS media playlist, so that the segments
+// are also verified.
+func hlsRewritePlaylist(playlist, token string) string {
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		separator := "?"
+		if strings.Contains(line, "?") {
+			separator = "&"
+		}
+		lines[i] = fmt.Sprintf("%v%vtoken=%v", line, separator, url.QueryEscape(token))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// httpClientIP returns the client IP of request, which is the peer address. The headers set by proxy are
+// only used when the peer is a trusted proxy in env TRUSTED_PROXIES, because the client is able to forge
+// them.
+func httpClientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+
+	proxies := envTrustedProxies()
+	if !httpTrustedProxy(proxies, peer) {
+		return peer
+	}
+
+	// Each proxy appends the address of its peer, so the client is the last address which is not a trusted
+	// proxy, and the addresses before it might be forged.
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ips := strings.Split(fwd, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !httpTrustedProxy(proxies, ip) {
+				return ip
+			}
+		}
+		return peer
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return peer
+}
+
+// httpTrustedProxy whether the ip is in the trusted proxies, which is a comma separated list of IPs or
+// CIDRs, for example, 10.0.0.1,192.168.0.0/16
+func httpTrustedProxy(proxies, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, proxy := range strings.Split(proxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxyAddr := net.ParseIP(proxy); proxyAddr != nil && proxyAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopbackIP whether the ip is a loopback address, for example, the players in the same host.
+func isLoopbackIP(ip string) bool {
+	if addr := net.ParseIP(ip); addr != nil {
+		return addr.IsLoopback()
+	}
+	return false
+}
+
 // Refresh the ipv4 address.
 func refreshIPv4(ctx context.Context) error {
 	discoverPrivateIPv4 := func(ctx context.Context) (string, net.IP, error) {
@@ -1100,6 +1400,31 @@ func slicesContains(arr []string, elem string) bool {
 	return false
 }
 
+// copyFile copies the src file to dst, and cleanup the dst if failed.
+func copyFile(src, dst string) (err error) {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open file %v", src)
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open file %v", dst)
+	}
+	defer func() {
+		targetFile.Close()
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if _, err = io.Copy(targetFile, sourceFile); err != nil {
+		return errors.Wrapf(err, "copy %v to %v", src, dst)
+	}
+	return nil
+}
+
 // TsFile is a ts file object.
 type TsFile struct {
 	// The identify key of TS file, renamed local ts path or COS key, format is record/{m3u8UUID}/{tsID}.ts
@@ -1334,16 +1659,51 @@ func ParseBody(ctx context.Context, r io.ReadCloser, v interface{}) error {
 // Authenticate check by Bearer or token.
 // If use bearer secret, there is the header Authorization: Bearer {apiSecret}.
 // If use token, there is a JWT token which is signed by apiSecret.
-func Authenticate(ctx context.Context, apiSecret, token string, header http.Header) error {
+func Authenticate(ctx context.Context, apiSecret, token string, r *http.Request) error {
+	_, err := AuthenticateIdentity(ctx, apiSecret, token, r)
+	return err
+}
+
+// AuthIdentity is the caller of management API, the root user if both user and API key are nil.
+type AuthIdentity struct {
+	// The named user, by login with name and password.
+	User *SrsUser
+	// The API key, by bearer or token.
+	ApiKey *SrsApiKey
+}
+
+func (v *AuthIdentity) String() string {
+	if v.User != nil {
+		return fmt.Sprintf("user:%v", v.User.Name)
+	} else if v.ApiKey != nil {
+		return fmt.Sprintf("apikey:%v", v.ApiKey.ID)
+	}
+	return "root"
+}
+
+// AuthenticateIdentity verifies the bearer secret, API key or token, and whether the caller has the role
+// or scope required by the request.
+func AuthenticateIdentity(ctx context.Context, apiSecret, token string, r *http.Request) (*AuthIdentity, error) {
+	identity, err := authenticateIdentity(ctx, apiSecret, token, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the caller for audit log.
+	auditIdentity(r, identity)
+	return identity, nil
+}
+
+func authenticateIdentity(ctx context.Context, apiSecret, token string, r *http.Request) (*AuthIdentity, error) {
 	// Check system api secret.
 	if apiSecret == "" {
-		return errors.New("no api secret")
+		return nil, errors.New("no api secret")
 	}
 
 	// Should use bearer secret or token.
-	authorization := header.Get("Authorization")
+	authorization := r.Header.Get("Authorization")
 	if authorization == "" && token == "" {
-		return errors.New("no Authorization or token")
+		return nil, errors.New("no Authorization or token")
 	}
 
 	// Verify bearer secret first.
@@ -1359,24 +1719,62 @@ func Authenticate(ctx context.Context, apiSecret, token string, header http.Head
 
 		authSecret, err := parseBearerToken(authorization)
 		if err != nil {
-			return errors.Wrapf(err, "parse bearer token")
+			return nil, errors.Wrapf(err, "parse bearer token")
+		}
+
+		if strings.HasPrefix(authSecret, ApiKeyPrefix) {
+			apiKey, err := authenticateApiKey(ctx, authSecret, r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "verify api key")
+			}
+			return &AuthIdentity{ApiKey: apiKey}, nil
 		}
 
 		if authSecret != apiSecret {
-			return errors.New("invalid bearer token")
+			return nil, errors.New("invalid bearer token")
 		}
-		return nil
+		return &AuthIdentity{}, nil
+	}
+
+	// Allow API key as token, for example, in the query string.
+	if strings.HasPrefix(token, ApiKeyPrefix) {
+		apiKey, err := authenticateApiKey(ctx, token, r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "verify api key")
+		}
+		return &AuthIdentity{ApiKey: apiKey}, nil
 	}
 
 	// Verify token first, @see https://www.npmjs.com/package/jsonwebtoken#errors--codes
 	// See https://pkg.go.dev/github.com/golang-jwt/jwt/v4#example-Parse-Hmac
-	if _, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+	var claims struct {
+		User string `json:"user"`
+		jwt.RegisteredClaims
+	}
+	if _, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(apiSecret), nil
 	}); err != nil {
-		return errors.Wrapf(err, "verify token %v", token)
+		return nil, errors.Wrapf(err, "verify token %v", token)
 	}
 
-	return nil
+	// The token of root user by MGMT_PASSWORD, allow all.
+	if claims.User == "" {
+		return &AuthIdentity{}, nil
+	}
+
+	// Always load the user, so the role change or removing of user takes effect immediately.
+	user, err := LoadSrsUser(ctx, claims.User)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load user %v", claims.User)
+	} else if user == nil {
+		return nil, errors.Errorf("user %v not exists", claims.User)
+	}
+
+	if required := RequiredUserRole(r); !user.Role.Allows(required) {
+		return nil, errors.Errorf("user %v role %v not allowed, %v requires %v", user.Name, user.Role, r.URL.Path, required)
+	}
+
+	return &AuthIdentity{User: user}, nil
 }
 
 // ChooseNotEmpty choose the first not empty string.
@@ -1673,6 +2071,8 @@ type FFmpegHeartbeat struct {
 	veryFastSpeedCount uint64
 	// The most recent continuous low speed, such as 0.5x.
 	verySlowSpeedCount uint64
+	// Whether FFmpeg is stalled, not update for a while and canceled by heartbeat.
+	stalled bool
 
 	// FFmpeg frame logs.
 	FrameLogs chan string
@@ -1754,6 +2154,7 @@ func (v *FFmpegHeartbeat) Polling(ctx context.Context, stderr io.Reader) {
 
 			if v.update.Add(10 * time.Second).Before(time.Now()) {
 				logger.Wf(ctx, "FFmpeg: not update for %v, restart it", time.Since(v.update))
+				v.stalled = true
 				v.cancelFFmpeg()
 				return
 			}
@@ -1934,6 +2335,33 @@ func ParseFFmpegCycleLog(line string) (timestamp, speed string, err error) {
 	return
 }
 
+// ParseFFmpegTimestamp parses the time of FFmpeg cycle log, for example, 00:01:02.50, to seconds.
+func ParseFFmpegTimestamp(timestamp string) (float64, error) {
+	var negative bool
+	if strings.HasPrefix(timestamp, "-") {
+		negative, timestamp = true, timestamp[1:]
+	}
+
+	parts := strings.Split(timestamp, ":")
+	if len(parts) != 3 {
+		return 0, errors.Errorf("invalid timestamp %v", timestamp)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		fv, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %v of %v", part, timestamp)
+		}
+		seconds = seconds*60 + fv
+	}
+
+	if negative {
+		return -seconds, nil
+	}
+	return seconds, nil
+}
+
 // MediaFormat is the format object in ffprobe response.
 type MediaFormat struct {
 	Starttime string  `json:"start_time"`
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUtils_RebuildStreamURL(t *testing.T) {
@@ -58,3 +64,284 @@ func TestUtils_ParseFFmpegLogs(t *testing.T) {
 		}
 	}
 }
+
+func TestUtils_ParseFFmpegTimestamp(t *testing.T) {
+	for _, e := range []struct {
+		ts      string
+		seconds float64
+	}{
+		{ts: "00:00:00.00", seconds: 0},
+		{ts: "00:10:09.50", seconds: 609.5},
+		{ts: "01:00:01.25", seconds: 3601.25},
+		{ts: "-00:00:00.50", seconds: -0.5},
+	} {
+		if seconds, err := ParseFFmpegTimestamp(e.ts); err != nil {
+			t.Errorf("Fail parse %v for err %+v", e, err)
+		} else if seconds != e.seconds {
+			t.Errorf("Fail for seconds %v of %v", seconds, e)
+		}
+	}
+
+	if _, err := ParseFFmpegTimestamp("N/A"); err == nil {
+		t.Errorf("Fail for N/A")
+	}
+}
+
+func TestUtils_PlayToken(t *testing.T) {
+	secret := "play-secret"
+	expireAt := time.Now().Add(time.Hour)
+
+	token, err := createPlayToken(secret, "/live/livestream", "", expireAt)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if err := verifyPlayToken(secret, token, "/live/livestream", "10.0.0.1"); err != nil {
+		t.Errorf("Fail for valid token, err %+v", err)
+	}
+	if err := verifyPlayToken(secret, token, "/live/other", "10.0.0.1"); err == nil {
+		t.Errorf("Fail for token of other stream should be rejected")
+	}
+	if err := verifyPlayToken("other-secret", token, "/live/livestream", "10.0.0.1"); err == nil {
+		t.Errorf("Fail for token of other secret should be rejected")
+	}
+	if err := verifyPlayToken(secret, "", "/live/livestream", "10.0.0.1"); err == nil {
+		t.Errorf("Fail for empty token should be rejected")
+	}
+
+	token, err = createPlayToken(secret, "/live/livestream", "10.0.0.1", expireAt)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if err := verifyPlayToken(secret, token, "/live/livestream", "10.0.0.1"); err != nil {
+		t.Errorf("Fail for valid token with ip, err %+v", err)
+	}
+	if err := verifyPlayToken(secret, token, "/live/livestream", "10.0.0.2"); err == nil {
+		t.Errorf("Fail for token of other ip should be rejected")
+	}
+
+	token, err = createPlayToken(secret, "/live/livestream", "", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if err := verifyPlayToken(secret, token, "/live/livestream", "10.0.0.1"); err == nil {
+		t.Errorf("Fail for expired token should be rejected")
+	}
+}
+
+func TestUtils_RequiredUserRole(t *testing.T) {
+	for _, e := range []struct {
+		method string
+		path   string
+		role   UserRole
+	}{
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/status", role: UserRoleViewer},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/query", role: UserRoleViewer},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/streams/kickoff", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/forward/streams", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/vlive/streams", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/live/room/query", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/live/room/list", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/cert/query", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/ai/transcript/query", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/ai/ocr/query", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/record/query", role: UserRoleViewer},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/forward/secret", role: UserRoleOperator},
+		// Only the exact endpoint of motion events is allowed for viewer, not any path ends with events.
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/camera/motion/events", role: UserRoleViewer},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/forward/events", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/terraform/v1/ffmpeg/camera/motion/events/remove", role: UserRoleOperator},
+		{method: http.MethodPost, path: "/foo/events", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/hooks/srs/secret/query", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/hooks/query", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/users/list", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/ssl", role: UserRoleAdmin},
+		{method: http.MethodPost, path: "/terraform/v1/mgmt/token", role: UserRoleViewer},
+		{method: http.MethodGet, path: "/api/v1/streams/", role: UserRoleViewer},
+		{method: http.MethodDelete, path: "/api/v1/clients/1234", role: UserRoleOperator},
+	} {
+		r := httptest.NewRequest(e.method, e.path, nil)
+		if role := RequiredUserRole(r); role != e.role {
+			t.Errorf("Fail for %v %v, expect %v, actual %v", e.method, e.path, e.role, role)
+		}
+	}
+
+	if !UserRoleAdmin.Allows(UserRoleOperator) || !UserRoleOperator.Allows(UserRoleViewer) {
+		t.Errorf("Fail for higher role should allow lower role")
+	}
+	if UserRoleViewer.Allows(UserRoleOperator) || UserRole("unknown").Allows(UserRoleViewer) {
+		t.Errorf("Fail for lower or unknown role should not allow")
+	}
+}
+
+func TestUtils_UserPassword(t *testing.T) {
+	user := NewSrsUser()
+	if user.VerifyPassword("") {
+		t.Errorf("Fail for user without password should not verify")
+	}
+
+	if err := user.SetPassword("secret"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if !user.VerifyPassword("secret") || user.VerifyPassword("Secret") {
+		t.Errorf("Fail for verify password, user=%v", user.String())
+	}
+	if public := user.Public(); public.Salt != "" || public.Hash != "" {
+		t.Errorf("Fail for public user should not have hash, %v", public)
+	}
+}
+
+func TestUtils_CopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := path.Join(dir, "src.mp4"), path.Join(dir, "dst.mp4")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if b, err := os.ReadFile(dst); err != nil || string(b) != "hello" {
+		t.Errorf("Fail for dst %v, err %+v", string(b), err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("Fail for src should exists, err %+v", err)
+	}
+
+	if err := copyFile(path.Join(dir, "none.mp4"), path.Join(dir, "none-dst.mp4")); err == nil {
+		t.Errorf("Fail for copy not exists file")
+	}
+}
+
+func TestUtils_HttpClientIP(t *testing.T) {
+	defer os.Setenv("TRUSTED_PROXIES", os.Getenv("TRUSTED_PROXIES"))
+
+	for _, e := range []struct {
+		proxies string
+		peer    string
+		fwd     string
+		real    string
+		ip      string
+	}{
+		// Never trust the headers from untrusted peer.
+		{peer: "1.2.3.4:1234", ip: "1.2.3.4"},
+		{peer: "1.2.3.4:1234", fwd: "127.0.0.1", ip: "1.2.3.4"},
+		{peer: "1.2.3.4:1234", real: "5.6.7.8", ip: "1.2.3.4"},
+		{proxies: "10.0.0.1", peer: "1.2.3.4:1234", fwd: "5.6.7.8", ip: "1.2.3.4"},
+		// Use the last untrusted address forwarded by trusted proxy.
+		{proxies: "10.0.0.1", peer: "10.0.0.1:1234", fwd: "5.6.7.8", ip: "5.6.7.8"},
+		{proxies: "10.0.0.0/8", peer: "10.0.0.1:1234", fwd: "127.0.0.1, 5.6.7.8, 10.0.0.2", ip: "5.6.7.8"},
+		{proxies: "10.0.0.0/8", peer: "10.0.0.1:1234", fwd: "10.0.0.3, 10.0.0.2", ip: "10.0.0.3"},
+		{proxies: "10.0.0.1", peer: "10.0.0.1:1234", fwd: "invalid", ip: "10.0.0.1"},
+		{proxies: "10.0.0.1", peer: "10.0.0.1:1234", real: "5.6.7.8", ip: "5.6.7.8"},
+	} {
+		os.Setenv("TRUSTED_PROXIES", e.proxies)
+
+		r := httptest.NewRequest(http.MethodPost, "/terraform/v1/mgmt/login", nil)
+		r.RemoteAddr = e.peer
+		if e.fwd != "" {
+			r.Header.Set("X-Forwarded-For", e.fwd)
+		}
+		if e.real != "" {
+			r.Header.Set("X-Real-IP", e.real)
+		}
+
+		if ip := httpClientIP(r); ip != e.ip {
+			t.Errorf("Fail for proxies=%v, peer=%v, fwd=%v, real=%v, expect %v, actual %v",
+				e.proxies, e.peer, e.fwd, e.real, e.ip, ip)
+		}
+	}
+}
+
+func TestUtils_InternalPlay(t *testing.T) {
+	defer os.Setenv("SRS_PLATFORM_SECRET", os.Getenv("SRS_PLATFORM_SECRET"))
+
+	os.Setenv("SRS_PLATFORM_SECRET", "")
+	if isInternalPlay("live", "a", "?"+strings.Split(withInternalPlay("rtmp://localhost/live/a"), "?")[1]) {
+		t.Errorf("Fail for empty api secret")
+	}
+
+	os.Setenv("SRS_PLATFORM_SECRET", "secret")
+	for _, e := range []struct {
+		url    string
+		expect string
+	}{
+		{url: "rtmp://localhost/live/a", expect: "rtmp://localhost/live/a?oryx_internal="},
+		{url: "rtmp://localhost/live/a?secret=xxx", expect: "rtmp://localhost/live/a?secret=xxx&oryx_internal="},
+	} {
+		u := withInternalPlay(e.url)
+		if !strings.HasPrefix(u, e.expect) {
+			t.Errorf("Fail for url %v, expect %v, actual %v", e.url, e.expect, u)
+		}
+		param := u[strings.Index(u, "?"):]
+		if !isInternalPlay("live", "a", param) {
+			t.Errorf("Fail for param %v", param)
+		}
+		if isInternalPlay("live", "b", param) || isInternalPlay("other", "a", param) {
+			t.Errorf("Fail for param %v of other stream", param)
+		}
+	}
+
+	expired := time.Now().Add(-time.Second).Unix()
+	for _, param := range []string{
+		"", "?token=xxx", "?oryx_internal=", "?oryx_internal=xxx", "?oryx_internal=.xxx",
+		"?oryx_internal=" + internalPlayToken("live", "a", expired),
+	} {
+		if isInternalPlay("live", "a", param) {
+			t.Errorf("Fail for param %v", param)
+		}
+	}
+}
+
+func TestUtils_HlsStripInternal(t *testing.T) {
+	for _, e := range []struct {
+		playlist string
+		expect   string
+	}{
+		{playlist: "", expect: ""},
+		{playlist: "a.ts?token=x", expect: "a.ts?token=x"},
+		{playlist: "a.ts?oryx_internal=1.x", expect: "a.ts"},
+		{playlist: "a.ts?oryx_internal=1.x&token=y", expect: "a.ts?token=y"},
+		{playlist: "a.ts?token=y&oryx_internal=1.x", expect: "a.ts?token=y"},
+		{playlist: "a.ts?hls_ctx=z&oryx_internal=1.x&token=y", expect: "a.ts?hls_ctx=z&token=y"},
+		{playlist: "#EXTM3U\n#EXTINF:10.000,\na.ts?hls_ctx=z&oryx_internal=1.x\n",
+			expect: "#EXTM3U\n#EXTINF:10.000,\na.ts?hls_ctx=z\n"},
+		{playlist: "#EXT-X-KEY:URI=\"k.key?oryx_internal=1.x\"", expect: "#EXT-X-KEY:URI=\"k.key\""},
+	} {
+		if v := hlsStripInternal(e.playlist); v != e.expect {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.playlist, e.expect, v)
+		}
+	}
+}
+
+func TestUtils_HttpStreamOfPath(t *testing.T) {
+	for _, e := range []struct {
+		path   string
+		app    string
+		stream string
+	}{
+		{path: "/live/livestream.flv", app: "live", stream: "livestream"},
+		{path: "/live/livestream.m3u8", app: "live", stream: "livestream"},
+		{path: "/live/livestream-10-1706247398.ts", app: "live", stream: "livestream"},
+		{path: "/live/my-stream-10-1706247398.ts", app: "live", stream: "my-stream"},
+		{path: "/live/my-stream.aac", app: "live", stream: "my-stream"},
+		{path: "/live/../livestream.flv", app: "", stream: "livestream"},
+	} {
+		if app, stream := httpStreamOfPath(e.path); app != e.app || stream != e.stream {
+			t.Errorf("Fail for path %v, expect %v/%v, actual %v/%v", e.path, e.app, e.stream, app, stream)
+		}
+	}
+}
+
+func TestUtils_HlsRewritePlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\nlivestream-1-100.ts\n" +
+		"#EXTINF:10.000,\nlivestream-2-110.ts?hls_ctx=x\n"
+	expect := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\nlivestream-1-100.ts?token=a%2Bb\n" +
+		"#EXTINF:10.000,\nlivestream-2-110.ts?hls_ctx=x&token=a%2Bb\n"
+	if v := hlsRewritePlaylist(playlist, "a+b"); v != expect {
+		t.Errorf("Fail for playlist, expect %v, actual %v", expect, v)
+	}
+}
@@ -6,9 +6,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -54,6 +57,7 @@ func (v *VLiveWorker) GetTask(platform string) *VLiveTask {
 func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/vlive/secret"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token, action string
@@ -69,7 +73,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -154,6 +158,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/vlive/streams"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -166,7 +171,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -182,8 +187,13 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 					var pid int32
 					var inputUUID, frame, update, starttime, ready string
+					var playlist map[string]interface{}
+					var finished bool
+					var program *VLiveScheduleEntry
 					if task := vLiveWorker.GetTask(config.Platform); task != nil {
 						pid, inputUUID, frame, update, starttime, ready = task.queryFrame()
+						playlist, finished = task.queryPlaylist()
+						program = task.queryProgram()
 					}
 
 					elem := map[string]interface{}{
@@ -192,6 +202,9 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 						"custom":   config.Customed,
 						"label":    config.Label,
 						"files":    config.Files,
+						"shuffle":  config.Shuffle,
+						"once":     config.Once,
+						"finished": finished,
 					}
 
 					if pid > 0 {
@@ -202,6 +215,13 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 							"log":    frame,
 							"update": update,
 						}
+						if playlist != nil {
+							elem["source"] = playlist["uuid"]
+							elem["playlist"] = playlist
+						}
+						if program != nil {
+							elem["program"] = program
+						}
 					}
 
 					res = append(res, elem)
@@ -220,6 +240,162 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	ep = "/terraform/v1/ffmpeg/vlive/schedule"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, action, platform string
+			var schedule *VLiveSchedule
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string         `json:"token"`
+				Action   *string         `json:"action"`
+				Platform *string         `json:"platform"`
+				Schedule **VLiveSchedule `json:"schedule"`
+			}{
+				Token: &token, Action: &action, Platform: &platform, Schedule: &schedule,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			allowedActions := []string{"update"}
+			if action != "" && !slicesContains(allowedActions, action) {
+				return errors.Errorf("invalid action=%v", action)
+			}
+			if platform == "" {
+				return errors.New("no platform")
+			}
+
+			var config VLiveConfigure
+			if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, platform).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, platform)
+			} else if b == "" {
+				return errors.Errorf("no platform %v", platform)
+			} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			if action == "update" {
+				// Remove the schedule if not specified, to play the playlist.
+				if schedule != nil {
+					if err := schedule.Resolve(platform, config.Files, config.Schedule); err != nil {
+						return errors.Wrapf(err, "resolve %v", schedule.String())
+					}
+					if err := schedule.Validate(); err != nil {
+						schedule.removeFiles(config.Schedule)
+						return errors.Wrapf(err, "validate %v", schedule.String())
+					}
+
+					sort.SliceStable(schedule.Entries, func(i, j int) bool {
+						si, _, _ := schedule.Entries[i].Range()
+						sj, _, _ := schedule.Entries[j].Range()
+						return si.Before(sj)
+					})
+				}
+				// Remove the linked files which are not used by the new schedule.
+				if config.Schedule != nil {
+					config.Schedule.removeFiles(schedule)
+				}
+				config.Schedule = schedule
+
+				if b, err := json.Marshal(&config); err != nil {
+					return errors.Wrapf(err, "marshal %v", config.String())
+				} else if err = rdb.HSet(ctx, SRS_VLIVE_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_CONFIG, platform, string(b))
+				}
+
+				// Restart the vLive if exists, to switch to the current program.
+				if task := vLiveWorker.GetTask(platform); task != nil {
+					if err := task.Restart(ctx); err != nil {
+						return errors.Wrapf(err, "restart task %v", platform)
+					}
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "vLive: Update schedule ok, platform=%v, schedule=(%v), token=%vB",
+					platform, schedule, len(token))
+				return nil
+			}
+
+			var program *VLiveScheduleEntry
+			if task := vLiveWorker.GetTask(platform); task != nil {
+				program = task.queryProgram()
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Platform string              `json:"platform"`
+				Schedule *VLiveSchedule      `json:"schedule"`
+				Program  *VLiveScheduleEntry `json:"program"`
+			}{
+				Platform: platform, Schedule: config.Schedule, Program: program,
+			})
+			logger.Tf(ctx, "vLive: Query schedule ok, platform=%v, token=%vB", platform, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/xmltv"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditReadOnly(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Allow token in query, because the player usually loads the EPG by GET.
+			token, platform := r.URL.Query().Get("token"), r.URL.Query().Get("platform")
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Platform *string `json:"platform"`
+			}{
+				Token: &token, Platform: &platform,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			var configs []*VLiveConfigure
+			if objs, err := rdb.HGetAll(ctx, SRS_VLIVE_CONFIG).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_VLIVE_CONFIG)
+			} else {
+				for k, v := range objs {
+					var config VLiveConfigure
+					if err = json.Unmarshal([]byte(v), &config); err != nil {
+						return errors.Wrapf(err, "unmarshal %v %v", k, v)
+					}
+					if platform == "" || platform == config.Platform {
+						configs = append(configs, &config)
+					}
+				}
+			}
+
+			sort.Slice(configs, func(i, j int) bool {
+				return configs[i].Platform < configs[j].Platform
+			})
+
+			b, err := xml.MarshalIndent(NewVLiveXMLTV(configs), "", "  ")
+			if err != nil {
+				return errors.Wrapf(err, "marshal xmltv")
+			}
+
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(xml.Header))
+			w.Write(b)
+			logger.Tf(ctx, "vLive: Export XMLTV ok, platform=%v, channels=%v, token=%vB", platform, len(configs), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	streamUrlHandler := func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -234,7 +410,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -280,14 +456,17 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/vlive/streamUrl"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, streamUrlHandler)
 
 	ep = "/terraform/v1/ffmpeg/vlive/stream-url"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, streamUrlHandler)
 
 	ep = "/terraform/v1/ffmpeg/vlive/ytdl"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -302,7 +481,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -417,6 +596,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/vlive/server"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
@@ -431,7 +611,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -499,8 +679,60 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	ep = "/terraform/v1/ffmpeg/vlive/record"
+	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, recordUUID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &recordUUID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			artifact, mp4File, err := RecordArtifactFile(ctx, recordUUID)
+			if err != nil {
+				return errors.Wrapf(err, "record %v", recordUUID)
+			}
+
+			info, err := os.Stat(mp4File)
+			if err != nil {
+				return errors.Wrapf(err, "stat %v", mp4File)
+			}
+
+			// Reference the record file, which is linked to dirVLivePath when used as source.
+			ohttp.WriteData(ctx, w, r, &struct {
+				Name   string            `json:"name"`
+				UUID   string            `json:"uuid"`
+				Target string            `json:"target"`
+				Size   int               `json:"size"`
+				Type   FFprobeSourceType `json:"type"`
+			}{
+				Name:   fmt.Sprintf("%v-%v.mp4", artifact.Stream, artifact.UUID),
+				UUID:   artifact.UUID,
+				Target: mp4File,
+				Size:   int(info.Size()),
+				Type:   FFprobeSourceTypeRecord,
+			})
+			logger.Tf(ctx, "vLive: Got record file uuid=%v, target=%v, size=%v", recordUUID, mp4File, info.Size())
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/ffmpeg/vlive/upload/"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func(ctx context.Context) error {
 			filename := r.URL.Path[len("/terraform/v1/ffmpeg/vlive/upload/"):]
@@ -601,6 +833,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 	ep = "/terraform/v1/ffmpeg/vlive/source"
 	logger.Tf(ctx, "Handle %v", ep)
+	auditMutating(ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			type VLiveTempFile struct {
@@ -631,7 +864,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			apiSecret := envApiSecret()
-			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+			if err := Authenticate(ctx, apiSecret, token, r); err != nil {
 				return errors.Wrapf(err, "authenticate")
 			}
 
@@ -642,7 +875,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			// Always cleanup the files in upload.
 			var tempFiles []string
 			for _, f := range files {
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRecord {
 					tempFiles = append(tempFiles, f.Target)
 				}
 			}
@@ -657,10 +890,17 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 			// Check files.
 			for _, f := range files {
+				if f.Type == FFprobeSourceTypeRecord {
+					if _, target, err := RecordArtifactFile(ctx, f.UUID); err != nil {
+						return errors.Wrapf(err, "record %v", f.UUID)
+					} else {
+						f.Target = target
+					}
+				}
 				if f.Target == "" {
 					return errors.New("no target")
 				}
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRecord {
 					if _, err := os.Stat(f.Target); err != nil {
 						return errors.Wrapf(err, "no file %v", f.Target)
 					}
@@ -789,17 +1029,36 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					Type:   file.Type,
 					Format: &format.Format, Video: matchVideo, Audio: matchAudio,
 				}
-				if file.Type != FFprobeSourceTypeStream {
-					parsedFile.Target = path.Join(dirVLivePath, fmt.Sprintf("%v%v", file.UUID, path.Ext(file.Target)))
-					if err = os.Rename(file.Target, parsedFile.Target); err != nil {
-						return errors.Wrapf(err, "rename %v to %v", file.Target, parsedFile.Target)
-					}
-				}
 
 				parsedFiles = append(parsedFiles, parsedFile)
 				logger.Tf(ctx, "vLive: Process file %v", parsedFile.String())
 			}
 
+			// The files are played in one playlist without transcoding, so they should be in the same codec.
+			if err := VLivePlaylistCompatible(parsedFiles); err != nil {
+				return errors.Wrapf(err, "playlist")
+			}
+
+			// Move file from dirUploadPath to dirVLivePath.
+			for _, file := range parsedFiles {
+				if file.Type != FFprobeSourceTypeStream && file.Type != FFprobeSourceTypeRecord {
+					target := path.Join(dirVLivePath, fmt.Sprintf("%v%v", file.UUID, path.Ext(file.Target)))
+					if err := os.Rename(file.Target, target); err != nil {
+						return errors.Wrapf(err, "rename %v to %v", file.Target, target)
+					}
+					file.Target = target
+				}
+
+				// Link the record file to dirVLivePath, because the record might be removed by user.
+				if file.Type == FFprobeSourceTypeRecord {
+					target := path.Join(dirVLivePath, fmt.Sprintf("record-%v-%v%v", platform, file.UUID, path.Ext(file.Target)))
+					if err := os.Link(file.Target, target); err != nil && !os.IsExist(err) {
+						return errors.Wrapf(err, "link %v to %v", file.Target, target)
+					}
+					file.Target = target
+				}
+			}
+
 			// For virtual live stream only.
 			if true {
 				// Update redis object.
@@ -812,12 +1071,20 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					}
 				}
 
-				// Remove old files.
+				// Remove old files, except the streams, the records not linked and the files still in use.
+				var used []string
+				for _, f := range parsedFiles {
+					used = append(used, f.Target)
+				}
 				for _, f := range confObj.Files {
-					if f.Type != FFprobeSourceTypeStream {
-						if _, err := os.Stat(f.Target); err == nil {
-							os.Remove(f.Target)
-						}
+					if f.Type == FFprobeSourceTypeStream || slicesContains(used, f.Target) {
+						continue
+					}
+					if f.Type == FFprobeSourceTypeRecord && !strings.HasPrefix(f.Target, dirVLivePath) {
+						continue
+					}
+					if _, err := os.Stat(f.Target); err == nil {
+						os.Remove(f.Target)
 					}
 				}
 				confObj.Files = parsedFiles
@@ -884,6 +1151,9 @@ func (v *VLiveWorker) Start(ctx context.Context) error {
 			if task.PID > 0 {
 				task.cleanup(ctx)
 			}
+			if task.PublisherPID > 0 {
+				task.cleanupPublisher(ctx)
+			}
 		}
 
 		if err = rdb.Del(ctx, SRS_VLIVE_TASK).Err(); err != nil && err != redis.Nil {
@@ -986,11 +1256,17 @@ type VLiveConfigure struct {
 
 	// The input files for vLive.
 	Files []*FFprobeSource `json:"files"`
+	// Whether play the files in random order, see VLiveShufflePasses.
+	Shuffle bool `json:"shuffle"`
+	// Whether play the files once and stop at the end, or loop forever.
+	Once bool `json:"once"`
+	// The programming schedule, to switch the files by time, which overwrites the playlist.
+	Schedule *VLiveSchedule `json:"schedule"`
 }
 
 func (v VLiveConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Files,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, shuffle=%v, once=%v, schedule=(%v)",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Files, v.Shuffle, v.Once, v.Schedule,
 	)
 }
 
@@ -1002,9 +1278,419 @@ func (v *VLiveConfigure) Update(u *VLiveConfigure) error {
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
 	v.Files = append([]*FFprobeSource{}, u.Files...)
+	v.Shuffle = u.Shuffle
+	v.Once = u.Once
 	return nil
 }
 
+// Scheduled whether switch the files by schedule. Note that the schedule is updated by its own API, so it's
+// not updated by Update.
+func (v *VLiveConfigure) Scheduled() bool {
+	return v.Schedule != nil && (len(v.Schedule.Entries) > 0 || v.Schedule.Filler != "")
+}
+
+// OutputURL returns the url to publish to, which is the server and secret.
+func (v *VLiveConfigure) OutputURL() string {
+	outputServer := v.Server
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.Secret, "/") && v.Secret != "" {
+		outputServer += "/"
+	}
+	return fmt.Sprintf("%v%v", outputServer, v.Secret)
+}
+
+// PublisherArgs returns the FFmpeg arguments to publish the relay of schedule to the output. Because the
+// programs have different codec parameters and timestamps, the relay is always transcoded to the same size
+// by wall clock, so the publisher keeps publishing when switching programs.
+func (v *VLiveConfigure) PublisherArgs(relay string) []string {
+	outputURL := v.OutputURL()
+	args := []string{
+		"-fflags", "+genpts", "-use_wallclock_as_timestamps", "1",
+		"-i", fmt.Sprintf("%v?overrun_nonfatal=1&fifo_size=50000000", relay),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2:color=black,setsar=1,fps=25",
+		"-af", "aresample=async=1",
+		"-vcodec", "libx264", "-pix_fmt", "yuv420p", "-g", "50", "-bf", "0",
+		"-acodec", "aac", "-ar", "44100", "-ac", "2", "-b:a", "128k",
+	}
+	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
+	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+		args = append(args, "-f", "flv")
+	} else if strings.HasPrefix(outputURL, "srt://") {
+		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	}
+	return append(args, outputURL)
+}
+
+// The number of shuffled passes in playlist. Note that FFmpeg loops the same playlist, so the order repeats
+// after these passes, until the task is restarted.
+const VLiveShufflePasses = 16
+
+// Playlist returns the files to play in order. Note that a stream can't be played in playlist, so only
+// the first stream is used if it's a stream.
+func (v *VLiveConfigure) Playlist() []*FFprobeSource {
+	if len(v.Files) == 0 {
+		return nil
+	}
+	if v.Files[0].Type == FFprobeSourceTypeStream {
+		return v.Files[:1]
+	}
+
+	var files []*FFprobeSource
+	for _, file := range v.Files {
+		if file.Type != FFprobeSourceTypeStream {
+			files = append(files, file)
+		}
+	}
+	if !v.Shuffle || len(files) <= 1 {
+		return files
+	}
+
+	passes := VLiveShufflePasses
+	if v.Once {
+		passes = 1
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var playlist []*FFprobeSource
+	for i := 0; i < passes; i++ {
+		pass := append([]*FFprobeSource{}, files...)
+		r.Shuffle(len(pass), func(i, j int) {
+			pass[i], pass[j] = pass[j], pass[i]
+		})
+		playlist = append(playlist, pass...)
+	}
+	return playlist
+}
+
+// VLivePlaylistCompatible checks whether the files are able to be played in one playlist. Because the concat
+// demuxer copies the codec, the files should be in the same codec parameters, except the streams.
+func VLivePlaylistCompatible(files []*FFprobeSource) error {
+	params := func(file *FFprobeSource) string {
+		var video, audio string
+		if v := file.Video; v != nil {
+			video = fmt.Sprintf("%v/%v/%vx%v/%v", v.CodecName, v.Profile, v.Width, v.Height, v.PixFormat)
+		}
+		if v := file.Audio; v != nil {
+			audio = fmt.Sprintf("%v/%v/%v", v.CodecName, v.SampleRate, v.Channels)
+		}
+		return fmt.Sprintf("video=%v, audio=%v", video, audio)
+	}
+
+	var first *FFprobeSource
+	for _, file := range files {
+		if file.Type == FFprobeSourceTypeStream {
+			continue
+		}
+		if first == nil {
+			first = file
+			continue
+		}
+
+		if expect, actual := params(first), params(file); expect != actual {
+			return errors.Errorf("file %v is %v, should be %v as %v", file.Name, actual, expect, first.Name)
+		}
+	}
+	return nil
+}
+
+// VLivePlaylistLocate locates the file in playlist by the position in seconds, return the index and the
+// offset in file. If loop, the position is wrapped by the duration of playlist.
+func VLivePlaylistLocate(playlist []*FFprobeSource, position float64, loop bool) (int, float64) {
+	durations := make([]float64, len(playlist))
+	var total float64
+	for i, file := range playlist {
+		if file.Format != nil {
+			durations[i], _ = strconv.ParseFloat(file.Format.Duration, 64)
+		}
+		total += durations[i]
+	}
+
+	if total <= 0 || position < 0 {
+		return 0, 0
+	}
+	if loop {
+		position = position - float64(int64(position/total))*total
+	}
+
+	for i, duration := range durations {
+		if position < duration || i == len(durations)-1 {
+			return i, position
+		}
+		position -= duration
+	}
+	return 0, 0
+}
+
+// VLiveScheduleEntry is a program in the schedule of vLive, to play the source during the time range.
+type VLiveScheduleEntry struct {
+	// The title of program, use the source name if empty.
+	Title string `json:"title"`
+	// The description of program.
+	Description string `json:"desc"`
+	// The start time in RFC3339, for example, 2024-01-01T20:00:00+08:00
+	Start string `json:"start"`
+	// The duration in seconds, ignored if the end time is set.
+	Duration int64 `json:"duration"`
+	// The end time in RFC3339, optional.
+	End string `json:"end"`
+	// The source UUID, should be one of the vLive files when updating the schedule.
+	Source string `json:"source"`
+}
+
+func (v *VLiveScheduleEntry) String() string {
+	return fmt.Sprintf("title=%v, start=%v, duration=%v, end=%v, source=%v",
+		v.Title, v.Start, v.Duration, v.End, v.Source,
+	)
+}
+
+// Range returns the start and end time of program.
+func (v *VLiveScheduleEntry) Range() (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, v.Start)
+	if err != nil {
+		return start, start, errors.Wrapf(err, "parse start %v", v.Start)
+	}
+
+	end := start.Add(time.Duration(v.Duration) * time.Second)
+	if v.End != "" {
+		if end, err = time.Parse(time.RFC3339, v.End); err != nil {
+			return start, end, errors.Wrapf(err, "parse end %v", v.End)
+		}
+	}
+
+	if !end.After(start) {
+		return start, end, errors.Errorf("invalid range from %v to %v", v.Start, end.Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// VLiveSchedule is the programming schedule of vLive, also known as EPG, to switch the sources by time.
+type VLiveSchedule struct {
+	// The programs in schedule.
+	Entries []*VLiveScheduleEntry `json:"entries"`
+	// The filler source UUID, to play in the gaps between programs, optional.
+	Filler string `json:"filler"`
+	// The sources used by schedule, resolved from the vLive files when updating the schedule, so that the
+	// programs still play when the vLive files are replaced.
+	Library []*FFprobeSource `json:"library"`
+}
+
+func (v *VLiveSchedule) String() string {
+	return fmt.Sprintf("entries=%v, filler=%v, library=%v", len(v.Entries), v.Filler, len(v.Library))
+}
+
+// vLiveFindSource finds the source in files by UUID, nil if not found.
+func vLiveFindSource(files []*FFprobeSource, uuid string) *FFprobeSource {
+	for _, file := range files {
+		if file.UUID == uuid {
+			return file
+		}
+	}
+	return nil
+}
+
+// Resolve builds the library of sources used by schedule, from the library of previous schedule, or the
+// files of vLive. The uploaded files are linked into the library, because the vLive files are removed when
+// replaced. Note that the library in request is ignored.
+func (v *VLiveSchedule) Resolve(platform string, files []*FFprobeSource, prev *VLiveSchedule) error {
+	var library []*FFprobeSource
+	if prev != nil {
+		library = prev.Library
+	}
+
+	var sources []string
+	for _, entry := range v.Entries {
+		sources = append(sources, entry.Source)
+	}
+	if v.Filler != "" {
+		sources = append(sources, v.Filler)
+	}
+
+	v.Library = nil
+	for _, source := range sources {
+		if source == "" || vLiveFindSource(v.Library, source) != nil {
+			continue
+		}
+
+		if file := vLiveFindSource(library, source); file != nil {
+			v.Library = append(v.Library, file)
+			continue
+		}
+
+		file := vLiveFindSource(files, source)
+		if file == nil {
+			v.removeFiles(prev)
+			return errors.Errorf("no source %v in files", source)
+		}
+
+		// The streams are not files, so there is no need to link them. Note that the records are also linked,
+		// because the record file might be removed by user.
+		linked := *file
+		if file.Type != FFprobeSourceTypeStream {
+			linked.Target = path.Join(dirVLivePath, fmt.Sprintf("schedule-%v-%v%v", platform, file.UUID, path.Ext(file.Target)))
+			if err := os.Link(file.Target, linked.Target); err != nil && !os.IsExist(err) {
+				v.removeFiles(prev)
+				return errors.Wrapf(err, "link %v to %v", file.Target, linked.Target)
+			}
+		}
+		v.Library = append(v.Library, &linked)
+	}
+
+	return nil
+}
+
+// removeFiles removes the linked files in library, which are not used by the new schedule.
+func (v *VLiveSchedule) removeFiles(next *VLiveSchedule) {
+	var used []string
+	if next != nil {
+		for _, file := range next.Library {
+			used = append(used, file.Target)
+		}
+	}
+
+	for _, file := range v.Library {
+		if slicesContains(used, file.Target) || !strings.HasPrefix(file.Target, path.Join(dirVLivePath, "schedule-")) {
+			continue
+		}
+		if _, err := os.Stat(file.Target); err == nil {
+			os.Remove(file.Target)
+		}
+	}
+}
+
+// Validate checks the schedule with its library, the programs should not overlap.
+func (v *VLiveSchedule) Validate() error {
+	if v.Filler != "" && vLiveFindSource(v.Library, v.Filler) == nil {
+		return errors.Errorf("no filler %v in library", v.Filler)
+	}
+
+	type programRange struct {
+		entry      *VLiveScheduleEntry
+		start, end time.Time
+	}
+	var ranges []*programRange
+	for _, entry := range v.Entries {
+		if entry.Source == "" {
+			return errors.Errorf("no source for %v", entry.String())
+		}
+		if vLiveFindSource(v.Library, entry.Source) == nil {
+			return errors.Errorf("no source %v in library", entry.Source)
+		}
+
+		start, end, err := entry.Range()
+		if err != nil {
+			return errors.Wrapf(err, "range of %v", entry.String())
+		}
+		ranges = append(ranges, &programRange{entry: entry, start: start, end: end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Before(ranges[j].start)
+	})
+	for i := 1; i < len(ranges); i++ {
+		if prev, r := ranges[i-1], ranges[i]; r.start.Before(prev.end) {
+			return errors.Errorf("program %v overlaps with %v", r.entry.String(), prev.entry.String())
+		}
+	}
+
+	return nil
+}
+
+// Locate returns the program and its source at the time, or the filler if no program, and the time to
+// switch to another program or filler, which is zero if there is no more program.
+func (v *VLiveSchedule) Locate(now time.Time) (*VLiveScheduleEntry, *FFprobeSource, time.Time) {
+	var until time.Time
+	switchAt := func(t time.Time) {
+		if until.IsZero() || t.Before(until) {
+			until = t
+		}
+	}
+
+	for _, entry := range v.Entries {
+		start, end, err := entry.Range()
+		if err != nil {
+			continue
+		}
+
+		if start.After(now) {
+			switchAt(start)
+		} else if now.Before(end) {
+			// Play the filler if the source is missing, until the program ends.
+			if source := vLiveFindSource(v.Library, entry.Source); source != nil {
+				return entry, source, end
+			}
+			switchAt(end)
+		}
+	}
+
+	return nil, vLiveFindSource(v.Library, v.Filler), until
+}
+
+// The time format of XMLTV, see https://wiki.xmltv.org/index.php/XMLTVFormat
+const vLiveXMLTVTimeFormat = "20060102150405 -0700"
+
+// VLiveXMLTV is the XMLTV document of vLive schedules, for players to show the EPG.
+type VLiveXMLTV struct {
+	XMLName    xml.Name               `xml:"tv"`
+	Generator  string                 `xml:"generator-info-name,attr"`
+	Channels   []*VLiveXMLTVChannel   `xml:"channel"`
+	Programmes []*VLiveXMLTVProgramme `xml:"programme"`
+}
+
+type VLiveXMLTVChannel struct {
+	ID          string `xml:"id,attr"`
+	DisplayName string `xml:"display-name"`
+}
+
+type VLiveXMLTVProgramme struct {
+	Start       string `xml:"start,attr"`
+	Stop        string `xml:"stop,attr"`
+	Channel     string `xml:"channel,attr"`
+	Title       string `xml:"title"`
+	Description string `xml:"desc,omitempty"`
+}
+
+// NewVLiveXMLTV builds the XMLTV document from the vLive configures, each platform with schedule is a
+// channel. Note that the filler is not exported as a program.
+func NewVLiveXMLTV(configs []*VLiveConfigure) *VLiveXMLTV {
+	tv := &VLiveXMLTV{Generator: "Oryx"}
+	for _, config := range configs {
+		if config.Schedule == nil {
+			continue
+		}
+
+		name := config.Label
+		if name == "" {
+			name = config.Platform
+		}
+		tv.Channels = append(tv.Channels, &VLiveXMLTVChannel{ID: config.Platform, DisplayName: name})
+
+		var programmes []*VLiveXMLTVProgramme
+		for _, entry := range config.Schedule.Entries {
+			start, end, err := entry.Range()
+			if err != nil {
+				continue
+			}
+
+			title := entry.Title
+			if source := vLiveFindSource(config.Schedule.Library, entry.Source); title == "" && source != nil {
+				title = source.Name
+			}
+
+			programmes = append(programmes, &VLiveXMLTVProgramme{
+				Start: start.UTC().Format(vLiveXMLTVTimeFormat), Stop: end.UTC().Format(vLiveXMLTVTimeFormat),
+				Channel: config.Platform, Title: title, Description: entry.Description,
+			})
+		}
+
+		// Sort by start time, which is in UTC so it's ok to compare the string.
+		sort.Slice(programmes, func(i, j int) bool {
+			return programmes[i].Start < programmes[j].Start
+		})
+		tv.Programmes = append(tv.Programmes, programmes...)
+	}
+	return tv
+}
+
 // VLiveTask is a task for FFmpeg to vLive stream, with a configure.
 type VLiveTask struct {
 	// The ID for task.
@@ -1018,9 +1704,15 @@ type VLiveTask struct {
 	inputUUID string
 	// The output url
 	Output string `json:"output"`
+	// The local UDP url to relay the program to publisher, for schedule only.
+	Relay string `json:"relay"`
 
 	// FFmpeg pid.
 	PID int32 `json:"pid"`
+	// The FFmpeg pid of publisher, for schedule only.
+	PublisherPID int32 `json:"publisherPid"`
+	// The output url of publisher.
+	publisherOutput string
 	// FFmpeg last frame.
 	frame string
 	// The last update time.
@@ -1030,8 +1722,19 @@ type VLiveTask struct {
 	// The first ready time.
 	firstReadyTime *time.Time
 
+	// The playlist in play order, for multiple files.
+	playlist []*FFprobeSource
+	// The FFmpeg time in seconds of playlist, to locate the current file.
+	position float64
+	// Whether the playlist is done, when play once.
+	finished bool
+	// The current program of schedule, nil if no schedule or playing the filler.
+	program *VLiveScheduleEntry
+
 	// The context for current task.
 	cancel context.CancelFunc
+	// The context for current publisher.
+	publisherCancel context.CancelFunc
 
 	// The configure for vLive task.
 	config *VLiveConfigure
@@ -1043,8 +1746,8 @@ type VLiveTask struct {
 }
 
 func (v *VLiveTask) String() string {
-	return fmt.Sprintf("uuid=%v, platform=%v, input=%v, output=%v, pid=%v, frame=%vB, config is %v",
-		v.UUID, v.Platform, v.Input, v.Output, v.PID, len(v.frame), v.config.String(),
+	return fmt.Sprintf("uuid=%v, platform=%v, input=%v, relay=%v, output=%v, pid=%v, publisher=%v, frame=%vB, config is %v",
+		v.UUID, v.Platform, v.Input, v.Relay, v.Output, v.PID, v.PublisherPID, len(v.frame), v.config.String(),
 	)
 }
 
@@ -1078,6 +1781,25 @@ func (v *VLiveTask) cleanup(ctx context.Context) error {
 	return nil
 }
 
+func (v *VLiveTask) cleanupPublisher(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PublisherPID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill publisher pid=%v", v.PublisherPID)
+	syscall.Kill(int(v.PublisherPID), syscall.SIGKILL)
+
+	v.PublisherPID = 0
+	v.publisherCancel = nil
+
+	return nil
+}
+
+// Restart the program or playlist for the new configure. For schedule, the publisher keeps running, unless
+// the schedule is removed or the output is changed.
 func (v *VLiveTask) Restart(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -1086,6 +1808,9 @@ func (v *VLiveTask) Restart(ctx context.Context) error {
 		v.cancel()
 	}
 
+	// Play the playlist again, for the new configure.
+	v.finished = false
+
 	// Reload config from redis.
 	if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, v.Platform).Result(); err != nil {
 		return errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, v.Platform)
@@ -1093,6 +1818,10 @@ func (v *VLiveTask) Restart(ctx context.Context) error {
 		return errors.Wrapf(err, "unmarshal %v", b)
 	}
 
+	if v.publisherCancel != nil && (!v.config.Enabled || !v.config.Scheduled() || v.config.OutputURL() != v.publisherOutput) {
+		v.publisherCancel()
+	}
+
 	return nil
 }
 
@@ -1104,6 +1833,41 @@ func (v *VLiveTask) updateFrame(frame string) {
 
 	var now = time.Now()
 	v.update = &now
+
+	if timestamp, _, err := ParseFFmpegCycleLog(frame); err == nil {
+		if position, err := ParseFFmpegTimestamp(timestamp); err == nil {
+			v.position = position
+		}
+	}
+}
+
+// queryPlaylist returns the current file and position of playlist, nil if no playlist. And whether the
+// playlist is finished.
+func (v *VLiveTask) queryPlaylist() (map[string]interface{}, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if len(v.playlist) == 0 {
+		return nil, v.finished
+	}
+
+	index, offset := VLivePlaylistLocate(v.playlist, v.position, !v.config.Once)
+	file := v.playlist[index]
+	return map[string]interface{}{
+		"index":    index,
+		"total":    len(v.playlist),
+		"uuid":     file.UUID,
+		"name":     file.Name,
+		"position": int64(offset),
+	}, v.finished
+}
+
+// queryProgram returns the current program of schedule, nil if no program.
+func (v *VLiveTask) queryProgram() *VLiveScheduleEntry {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.program
 }
 
 func (v *VLiveTask) queryFrame() (int32, string, string, string, string, string) {
@@ -1142,17 +1906,76 @@ func (v *VLiveTask) Run(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "vLive: Run task %v", v.String())
 
-	selectInputFile := func() *FFprobeSource {
+	// Allocate a local UDP port to relay the program to publisher.
+	if conn, err := net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		return errors.Wrapf(err, "listen udp")
+	} else {
+		v.Relay = fmt.Sprintf("udp://127.0.0.1:%v", conn.LocalAddr().(*net.UDPAddr).Port)
+		conn.Close()
+	}
+
+	// Start the publisher for schedule, which keeps publishing when switching programs, because only the
+	// FFmpeg of program is restarted at the boundary.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.doPublish(ctx); err != nil {
+				logger.Wf(ctx, "ignore publish %v err %+v", v.String(), err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+
+	// Select the input files, the position to seek to, and the time to switch to next program if schedule.
+	selectInputFiles := func() ([]*FFprobeSource, float64, time.Time) {
 		v.lock.Lock()
 		defer v.lock.Unlock()
 
-		if len(v.config.Files) == 0 {
-			return nil
+		// Switch the file by schedule, seek to the position if join in the middle of program.
+		if v.config.Scheduled() {
+			now := time.Now()
+			program, file, until := v.config.Schedule.Locate(now)
+			v.program = program
+			if file == nil {
+				return nil, 0, until
+			}
+
+			var seek float64
+			if program != nil && file.Type != FFprobeSourceTypeStream {
+				if start, _, err := program.Range(); err == nil {
+					_, seek = VLivePlaylistLocate([]*FFprobeSource{file}, now.Sub(start).Seconds(), true)
+				}
+			}
+
+			logger.Tf(ctx, "vLive: Use file=%v, program=(%v), seek=%.1f, until=%v as input for platform=%v",
+				file.UUID, program, seek, until.Format(time.RFC3339), v.Platform)
+			return []*FFprobeSource{file}, seek, until
+		}
+
+		// Ignore if the playlist is done.
+		v.program = nil
+		if v.finished {
+			return nil, 0, time.Time{}
+		}
+
+		files := v.config.Playlist()
+		if len(files) == 0 {
+			return nil, 0, time.Time{}
 		}
 
-		file := v.config.Files[0]
-		logger.Tf(ctx, "vLive: Use file=%v as input for platform=%v", file.UUID, v.Platform)
-		return file
+		logger.Tf(ctx, "vLive: Use file=%v, files=%v as input for platform=%v", files[0].UUID, len(files), v.Platform)
+		return files, 0, time.Time{}
 	}
 
 	pfn := func(ctx context.Context) error {
@@ -1161,14 +1984,14 @@ func (v *VLiveTask) Run(ctx context.Context) error {
 			return nil
 		}
 
-		// Use a active stream as input.
-		input := selectInputFile()
-		if input == nil {
+		// Use the files as input.
+		inputs, seek, until := selectInputFiles()
+		if len(inputs) == 0 {
 			return nil
 		}
 
 		// Start vLive task.
-		if err := v.doVirtualLiveStream(ctx, input); err != nil {
+		if err := v.doVirtualLiveStream(ctx, inputs, seek, until); err != nil {
 			return errors.Wrapf(err, "do vLive")
 		}
 
@@ -1195,21 +2018,45 @@ func (v *VLiveTask) Run(ctx context.Context) error {
 	return nil
 }
 
-func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSource) error {
+// buildPlaylist writes the playlist file for FFmpeg concat demuxer, which keeps the timestamp continuous
+// when switching between files.
+func (v *VLiveTask) buildPlaylist(inputs []*FFprobeSource) (string, error) {
+	lines := []string{"ffconcat version 1.0"}
+	for _, input := range inputs {
+		target, err := filepath.Abs(input.Target)
+		if err != nil {
+			return "", errors.Wrapf(err, "abs %v", input.Target)
+		}
+		lines = append(lines, fmt.Sprintf("file '%v'", strings.ReplaceAll(target, "'", `'\''`)))
+	}
+
+	playlistFile := path.Join(dirVLivePath, fmt.Sprintf("%v.txt", v.Platform))
+	if err := os.WriteFile(playlistFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return "", errors.Wrapf(err, "write %v", playlistFile)
+	}
+	return playlistFile, nil
+}
+
+func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, inputs []*FFprobeSource, seek float64, until time.Time) error {
+	input := inputs[0]
+
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	v.cancel = cancel
 
-	// Build input URL.
-	host := "localhost"
+	// Stop at the end of program, to switch to the next program or filler.
+	if !until.IsZero() {
+		timer := time.AfterFunc(time.Until(until), cancel)
+		defer timer.Stop()
+	}
 
-	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
+	// Build output URL. For schedule, relay the program to the publisher.
+	outputURL, scheduled := v.config.OutputURL(), v.config.Scheduled()
+	target := outputURL
+	if scheduled {
+		target = fmt.Sprintf("%v?pkt_size=1316", v.Relay)
 	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -1220,16 +2067,31 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 
 	// Start FFmpeg process.
 	args := []string{}
-	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL {
-		args = append(args, "-stream_loop", "-1")
+	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL ||
+		input.Type == FFprobeSourceTypeRecord {
+		if !v.config.Once || scheduled {
+			args = append(args, "-stream_loop", "-1")
+		}
 		args = append(args, "-re")
+		if seek > 0 {
+			args = append(args, "-ss", fmt.Sprintf("%.3f", seek))
+		}
 	}
 	// For RTSP stream source, always use TCP transport.
 	if strings.HasPrefix(input.Target, "rtsp://") {
 		args = append(args, "-rtsp_transport", "tcp")
 	}
-	// Rebuild the stream url, because it may contain special characters.
-	if strings.Contains(input.Target, "://") {
+	// For multiple files, use concat demuxer to play the playlist in one FFmpeg.
+	if len(inputs) > 1 {
+		playlistFile, err := v.buildPlaylist(inputs)
+		if err != nil {
+			return errors.Wrapf(err, "build playlist")
+		}
+		defer os.Remove(playlistFile)
+
+		args = append(args, "-f", "concat", "-safe", "0", "-i", playlistFile)
+	} else if strings.Contains(input.Target, "://") {
+		// Rebuild the stream url, because it may contain special characters.
 		if u, err := RebuildStreamURL(input.Target); err != nil {
 			return errors.Wrapf(err, "rebuild %v", input.Target)
 		} else {
@@ -1240,13 +2102,15 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 		args = append(args, "-i", input.Target)
 	}
 	args = append(args, "-c", "copy")
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+	// If RTMP use flv, if SRT or relay use mpegts, otherwise do not set.
+	if scheduled {
+		args = append(args, "-f", "mpegts")
+	} else if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
 		args = append(args, "-f", "flv")
 	} else if strings.HasPrefix(outputURL, "srt://") {
 		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
 	}
-	args = append(args, outputURL)
+	args = append(args, target)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
@@ -1261,6 +2125,12 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 
 	v.PID = int32(cmd.Process.Pid)
 	v.Input, v.inputUUID, v.Output = input.Target, input.UUID, outputURL
+	v.lock.Lock()
+	v.playlist, v.position = nil, 0
+	if len(inputs) > 1 || input.Type != FFprobeSourceTypeStream {
+		v.playlist = inputs
+	}
+	v.lock.Unlock()
 	defer func() {
 		// If we got a PID, sleep for a while, to avoid too fast restart.
 		if v.PID > 0 {
@@ -1313,5 +2183,87 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 		v.Platform, input.Target, v.PID, err,
 	)
 
+	// Stop at the end of playlist, if play once.
+	if err == nil && v.config.Once && !scheduled && input.Type != FFprobeSourceTypeStream {
+		v.lock.Lock()
+		v.finished = true
+		v.lock.Unlock()
+		logger.Tf(ctx, "vLive: Playlist finished, platform=%v, files=%v", v.Platform, len(inputs))
+	}
+
+	return err
+}
+
+func (v *VLiveTask) doPublish(ctx context.Context) error {
+	// Create context for current publisher.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	v.lock.Lock()
+	v.publisherCancel = cancel
+	relay, enabled, scheduled := v.Relay, v.config.Enabled, v.config.Scheduled()
+	args := v.config.PublisherArgs(relay)
+	v.publisherOutput = v.config.OutputURL()
+	outputURL := v.publisherOutput
+	v.lock.Unlock()
+
+	// Ignore if not schedule, which publishes the playlist directly.
+	if !enabled || !scheduled {
+		return nil
+	}
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Start FFmpeg process.
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	v.PublisherPID = int32(cmd.Process.Pid)
+	v.lock.Unlock()
+	defer func() {
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanupPublisher(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "vLive: Start publisher, platform=%v, relay=%v, output=%v, pid=%v",
+		v.Platform, relay, outputURL, v.PublisherPID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Drop the log frame, we use the frame of program to locate the position.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.FrameLogs:
+			}
+		}
+	}()
+
+	// Process terminated, or task is done, or schedule changed.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "vLive: Publisher stopping, platform=%v, pid=%v", v.Platform, v.PublisherPID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "vLive: Publisher done, platform=%v, pid=%v, err=%v", v.Platform, v.PublisherPID, err)
 	return err
 }
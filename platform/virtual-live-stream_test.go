@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVLive_Playlist(t *testing.T) {
+	newFile := func(uuid string, t FFprobeSourceType) *FFprobeSource {
+		return &FFprobeSource{UUID: uuid, Type: t}
+	}
+
+	config := VLiveConfigure{Files: []*FFprobeSource{
+		newFile("a", FFprobeSourceTypeUpload), newFile("b", FFprobeSourceTypeStream), newFile("c", FFprobeSourceTypeFile),
+	}}
+	if files := config.Playlist(); len(files) != 2 || files[0].UUID != "a" || files[1].UUID != "c" {
+		t.Errorf("Fail for playlist %v", files)
+	}
+
+	config.Files = []*FFprobeSource{newFile("s", FFprobeSourceTypeStream), newFile("a", FFprobeSourceTypeUpload)}
+	if files := config.Playlist(); len(files) != 1 || files[0].UUID != "s" {
+		t.Errorf("Fail for playlist %v", files)
+	}
+
+	config.Files = []*FFprobeSource{newFile("a", FFprobeSourceTypeUpload), newFile("b", FFprobeSourceTypeUpload)}
+	config.Shuffle = true
+	if files := config.Playlist(); len(files) != 2*VLiveShufflePasses {
+		t.Errorf("Fail for playlist %v", len(files))
+	}
+
+	config.Once = true
+	if files := config.Playlist(); len(files) != 2 || files[0].UUID == files[1].UUID {
+		t.Errorf("Fail for playlist %v", files)
+	}
+}
+
+func TestVLive_PlaylistLocate(t *testing.T) {
+	playlist := []*FFprobeSource{
+		{UUID: "a", Format: &FFprobeFormat{Duration: "10.0"}},
+		{UUID: "b", Format: &FFprobeFormat{Duration: "20.0"}},
+		{UUID: "c", Format: &FFprobeFormat{Duration: "30.0"}},
+	}
+
+	for _, e := range []struct {
+		position float64
+		loop     bool
+		index    int
+		offset   float64
+	}{
+		{position: 0, loop: true, index: 0, offset: 0},
+		{position: 5, loop: true, index: 0, offset: 5},
+		{position: 10, loop: true, index: 1, offset: 0},
+		{position: 35, loop: true, index: 2, offset: 5},
+		{position: 65, loop: true, index: 0, offset: 5},
+		{position: 65, loop: false, index: 2, offset: 35},
+		{position: -1, loop: true, index: 0, offset: 0},
+	} {
+		if index, offset := VLivePlaylistLocate(playlist, e.position, e.loop); index != e.index || offset != e.offset {
+			t.Errorf("Fail for %v, index=%v, offset=%v", e, index, offset)
+		}
+	}
+}
+
+func TestVLive_PlaylistCompatible(t *testing.T) {
+	newFile := func(name, vcodec string, width int32, acodec string) *FFprobeSource {
+		return &FFprobeSource{Name: name, Type: FFprobeSourceTypeUpload,
+			Video: &FFprobeVideo{CodecName: vcodec, Profile: "High", Width: width, Height: 720, PixFormat: "yuv420p"},
+			Audio: &FFprobeAudio{CodecName: acodec, SampleRate: "44100", Channels: 2},
+		}
+	}
+	stream := &FFprobeSource{Name: "stream", Type: FFprobeSourceTypeStream}
+
+	for _, e := range []struct {
+		files []*FFprobeSource
+		valid bool
+	}{
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac")}, valid: true},
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac"), newFile("b", "h264", 1280, "aac")}, valid: true},
+		{files: []*FFprobeSource{stream, newFile("a", "h264", 1280, "aac")}, valid: true},
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac"), newFile("b", "h265", 1280, "aac")}, valid: false},
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac"), newFile("b", "h264", 1920, "aac")}, valid: false},
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac"), newFile("b", "h264", 1280, "mp3")}, valid: false},
+		{files: []*FFprobeSource{newFile("a", "h264", 1280, "aac"), {Name: "b", Type: FFprobeSourceTypeUpload}}, valid: false},
+	} {
+		if err := VLivePlaylistCompatible(e.files); (err == nil) != e.valid {
+			t.Errorf("Fail for files %v, expect %v, err %v", len(e.files), e.valid, err)
+		}
+	}
+}
+
+func TestVLive_ScheduleValidate(t *testing.T) {
+	library := []*FFprobeSource{{UUID: "a"}, {UUID: "b"}}
+	for _, e := range []struct {
+		schedule VLiveSchedule
+		valid    bool
+	}{
+		{schedule: VLiveSchedule{}, valid: true},
+		{schedule: VLiveSchedule{Filler: "b"}, valid: true},
+		{schedule: VLiveSchedule{Filler: "c"}, valid: false},
+		{schedule: VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Start: "2024-01-01T10:00:00Z", Duration: 3600, Source: "a"},
+			{Start: "2024-01-01T11:00:00Z", End: "2024-01-01T12:00:00Z", Source: "b"},
+		}}, valid: true},
+		{schedule: VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Start: "2024-01-01T11:00:00Z", End: "2024-01-01T12:00:00Z", Source: "b"},
+			{Start: "2024-01-01T10:00:00Z", Duration: 3601, Source: "a"},
+		}}, valid: false},
+		{schedule: VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Start: "2024-01-01T10:00:00Z", Source: "a"},
+		}}, valid: false},
+		{schedule: VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Start: "2024-01-01 10:00:00", Duration: 3600, Source: "a"},
+		}}, valid: false},
+		{schedule: VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Start: "2024-01-01T10:00:00Z", Duration: 3600, Source: "c"},
+		}}, valid: false},
+	} {
+		e.schedule.Library = library
+		if err := e.schedule.Validate(); (err == nil) != e.valid {
+			t.Errorf("Fail for schedule %v, expect %v, actual %v", e.schedule.String(), e.valid, err)
+		}
+	}
+}
+
+func TestVLive_ScheduleLocate(t *testing.T) {
+	schedule := VLiveSchedule{Filler: "filler", Entries: []*VLiveScheduleEntry{
+		{Start: "2024-01-01T10:00:00Z", Duration: 3600, Source: "a"},
+		{Start: "2024-01-01T12:00:00Z", Duration: 3600, Source: "b"},
+		{Start: "2024-01-01T14:00:00Z", Duration: 3600, Source: "removed"},
+	}, Library: []*FFprobeSource{{UUID: "a"}, {UUID: "b"}, {UUID: "filler"}}}
+
+	for _, e := range []struct {
+		now     string
+		program string
+		source  string
+		until   string
+	}{
+		{now: "2024-01-01T09:00:00Z", program: "", source: "filler", until: "2024-01-01T10:00:00Z"},
+		{now: "2024-01-01T10:30:00Z", program: "a", source: "a", until: "2024-01-01T11:00:00Z"},
+		{now: "2024-01-01T11:00:00Z", program: "", source: "filler", until: "2024-01-01T12:00:00Z"},
+		{now: "2024-01-01T12:00:00Z", program: "b", source: "b", until: "2024-01-01T13:00:00Z"},
+		{now: "2024-01-01T14:30:00Z", program: "", source: "filler", until: "2024-01-01T15:00:00Z"},
+		{now: "2024-01-01T16:00:00Z", program: "", source: "filler", until: ""},
+	} {
+		now, _ := time.Parse(time.RFC3339, e.now)
+		program, source, until := schedule.Locate(now)
+
+		var programSource, sourceUUID, untilTime string
+		if program != nil {
+			programSource = program.Source
+		}
+		if source != nil {
+			sourceUUID = source.UUID
+		}
+		if !until.IsZero() {
+			untilTime = until.UTC().Format(time.RFC3339)
+		}
+		if programSource != e.program || sourceUUID != e.source || untilTime != e.until {
+			t.Errorf("Fail for %v, program=%v, source=%v, until=%v", e, programSource, sourceUUID, untilTime)
+		}
+	}
+
+	schedule.Filler = ""
+	if program, source, _ := schedule.Locate(time.Now()); program != nil || source != nil {
+		t.Errorf("Fail for program=%v, source=%v", program, source)
+	}
+}
+
+func TestVLive_XMLTV(t *testing.T) {
+	configs := []*VLiveConfigure{
+		{Platform: "vlive-a", Label: "News", Schedule: &VLiveSchedule{Entries: []*VLiveScheduleEntry{
+			{Title: "Evening", Start: "2024-01-01T20:00:00+08:00", Duration: 1800, Source: "a", Description: "Daily"},
+			{Start: "2024-01-01T10:00:00Z", Duration: 1800, Source: "a"},
+		}, Library: []*FFprobeSource{{UUID: "a", Name: "news.mp4"}}}},
+		{Platform: "vlive-b"},
+	}
+
+	b, err := xml.Marshal(NewVLiveXMLTV(configs))
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	expect := strings.Join([]string{
+		`<tv generator-info-name="Oryx">`,
+		`<channel id="vlive-a"><display-name>News</display-name></channel>`,
+		`<programme start="20240101100000 +0000" stop="20240101103000 +0000" channel="vlive-a">`,
+		`<title>news.mp4</title></programme>`,
+		`<programme start="20240101120000 +0000" stop="20240101123000 +0000" channel="vlive-a">`,
+		`<title>Evening</title><desc>Daily</desc></programme>`,
+		`</tv>`,
+	}, "")
+	if string(b) != expect {
+		t.Errorf("Fail for xmltv %v, expect %v", string(b), expect)
+	}
+}
+
+func TestVLive_ScheduleResolve(t *testing.T) {
+	dir, err := os.MkdirTemp("", "vlive")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Link the files in the same directory, because hard link does not work across devices.
+	defer func(dirPath string) {
+		dirVLivePath = dirPath
+	}(dirVLivePath)
+	dirVLivePath = dir
+
+	file := path.Join(dir, "a.mp4")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	record := path.Join(dir, "index.mp4")
+	if err := os.WriteFile(record, []byte("c"), 0644); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	files := []*FFprobeSource{
+		{UUID: "a", Target: file, Type: FFprobeSourceTypeUpload},
+		{UUID: "b", Target: "rtmp://localhost/live/b", Type: FFprobeSourceTypeStream},
+		{UUID: "c", Target: record, Type: FFprobeSourceTypeRecord},
+	}
+	schedule := VLiveSchedule{Filler: "b", Entries: []*VLiveScheduleEntry{
+		{Start: "2024-01-01T10:00:00Z", Duration: 3600, Source: "a"},
+		{Start: "2024-01-01T12:00:00Z", Duration: 3600, Source: "a"},
+		{Start: "2024-01-01T14:00:00Z", Duration: 3600, Source: "c"},
+	}}
+	if err := schedule.Resolve("vlive-test", files, nil); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	defer schedule.removeFiles(nil)
+
+	if len(schedule.Library) != 3 {
+		t.Errorf("Fail for library %v", schedule.Library)
+		return
+	}
+	if target := schedule.Library[2].Target; target != files[1].Target {
+		t.Errorf("Fail for stream target %v", target)
+	}
+
+	// The record is also linked, because it might be removed by user.
+	if target := schedule.Library[1].Target; target == record || !strings.HasPrefix(target, path.Join(dirVLivePath, "schedule-")) {
+		t.Errorf("Fail for record target %v", target)
+	}
+	os.Remove(record)
+	if _, err := os.Stat(schedule.Library[1].Target); err != nil {
+		t.Errorf("Fail for record target %v err %+v", schedule.Library[1].Target, err)
+	}
+
+	// The linked file is still available after the vLive file is removed.
+	linked := schedule.Library[0].Target
+	if linked == file || !strings.HasPrefix(linked, path.Join(dirVLivePath, "schedule-")) {
+		t.Errorf("Fail for linked target %v", linked)
+	}
+	os.Remove(file)
+	if _, err := os.Stat(linked); err != nil {
+		t.Errorf("Fail for linked target %v err %+v", linked, err)
+	}
+
+	// Use the library of previous schedule, even if the vLive files are replaced.
+	next := VLiveSchedule{Entries: []*VLiveScheduleEntry{
+		{Start: "2024-01-02T10:00:00Z", Duration: 3600, Source: "a"},
+	}}
+	if err := next.Resolve("vlive-test", nil, &schedule); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if len(next.Library) != 1 || next.Library[0].Target != linked {
+		t.Errorf("Fail for library %v", next.Library)
+	}
+
+	// Fail if the source is not in library or files.
+	if err := (&VLiveSchedule{Filler: "d"}).Resolve("vlive-test", files, &schedule); err == nil {
+		t.Errorf("Fail for no source")
+	}
+}
+
+func TestVLive_PublisherArgs(t *testing.T) {
+	config := VLiveConfigure{Server: "rtmp://localhost/live", Secret: "livestream"}
+	if output := config.OutputURL(); output != "rtmp://localhost/live/livestream" {
+		t.Errorf("Fail for output %v", output)
+	}
+
+	args := strings.Join(config.PublisherArgs("udp://127.0.0.1:5000"), " ")
+	for _, expect := range []string{
+		"-use_wallclock_as_timestamps 1 -i udp://127.0.0.1:5000?overrun_nonfatal=1",
+		"-vf scale=1280:720", "-f flv rtmp://localhost/live/livestream",
+	} {
+		if !strings.Contains(args, expect) {
+			t.Errorf("Fail for args %v, expect %v", args, expect)
+		}
+	}
+
+	config = VLiveConfigure{Server: "srt://localhost:10080?streamid=#!::r=live/livestream,m=publish"}
+	if args := strings.Join(config.PublisherArgs("udp://127.0.0.1:5000"), " "); !strings.HasSuffix(args,
+		"-pes_payload_size 0 -f mpegts srt://localhost:10080?streamid=#!::r=live/livestream,m=publish") {
+		t.Errorf("Fail for args %v", args)
+	}
+}
@@ -170,6 +170,45 @@ func TestApi_UpdatePublishSecret(t *testing.T) {
 	}
 }
 
+func TestApi_CreatePlayToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
+	defer cancel()
+
+	var r0 error
+	defer func(ctx context.Context) {
+		if err := filterTestError(ctx.Err(), r0); err != nil {
+			t.Errorf("Fail for err %+v", err)
+		} else {
+			logger.Tf(ctx, "test done")
+		}
+	}(ctx)
+
+	var playAuth bool
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/hooks/srs/play/query", nil, &struct {
+		PlayAuth *bool `json:"playAuth"`
+	}{
+		PlayAuth: &playAuth,
+	}); err != nil {
+		r0 = err
+		return
+	}
+
+	res := struct {
+		PlayToken string `json:"playToken"`
+		ExpireAt  string `json:"expireAt"`
+	}{}
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/hooks/srs/play/token", &struct {
+		Stream string `json:"stream"`
+		Expire int    `json:"expire"`
+	}{
+		Stream: "livestream", Expire: 60,
+	}, &res); err != nil {
+		r0 = err
+	} else if res.PlayToken == "" || res.ExpireAt == "" {
+		r0 = errors.Errorf("invalid response %v", res)
+	}
+}
+
 func TestApi_TutorialsQueryBilibili(t *testing.T) {
 	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
 	defer cancel()
@@ -3,7 +3,7 @@ module test
 go 1.16
 
 require (
-	github.com/google/uuid v1.5.0 // indirect
+	github.com/google/uuid v1.5.0
 	github.com/joho/godotenv v1.5.1
 	github.com/ossrs/go-oryx-lib v0.0.9
 )
@@ -2327,6 +2327,117 @@ func TestScenario_WithStream_CallbackOnPublishSuccess(t *testing.T) {
 	}
 }
 
+func TestScenario_WithStream_CallbackOnPublishSigned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
+	defer cancel()
+
+	if *noMediaTest {
+		return
+	}
+
+	var r0, r1, r2, r3, r4, r5 error
+	defer func(ctx context.Context) {
+		if err := filterTestError(ctx.Err(), r0, r1, r2, r3, r4, r5); err != nil {
+			t.Errorf("Fail for err %+v", err)
+		} else {
+			logger.Tf(ctx, "test done")
+		}
+	}(ctx)
+
+	var pubSecret string
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/hooks/srs/secret/query", nil, &struct {
+		Publish *string `json:"publish"`
+	}{
+		Publish: &pubSecret,
+	}); err != nil {
+		r0 = err
+		return
+	}
+
+	type CallbackConfig struct {
+		All    bool   `json:"all"`
+		Opaque string `json:"opaque"`
+		Target string `json:"target"`
+		Secret string `json:"secret"`
+	}
+	var conf CallbackConfig
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/mgmt/hooks/query", nil, &conf); err != nil {
+		r0 = errors.Wrapf(err, "request hooks apply failed")
+		return
+	}
+
+	// Restore the state of transcode.
+	backup := conf
+	defer func() {
+		logger.Tf(ctx, "restore config %v", backup)
+
+		// The ctx has already been cancelled by test case, which will cause the request failed.
+		ctx := context.Background()
+		NewApi().WithAuth(ctx, "/terraform/v1/mgmt/hooks/apply", backup, nil)
+	}()
+
+	// Enable the callback worker.
+	conf.All = true
+	// The example target will verify the signature by the secret.
+	conf.Secret = fmt.Sprintf("secret-%v", rand.Int())
+	conf.Target = fmt.Sprintf("%v/terraform/v1/mgmt/hooks/example?fail=false&secret=%v", *endpoint, conf.Secret)
+	conf.Opaque = fmt.Sprintf("opaque-%v", rand.Int())
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/mgmt/hooks/apply", &conf, nil); err != nil {
+		r0 = errors.Wrapf(err, "request hooks apply failed")
+		return
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Start FFmpeg to publish stream.
+	streamID := fmt.Sprintf("stream-%v-%v", os.Getpid(), rand.Int())
+	streamURL := fmt.Sprintf("%v/live/%v?secret=%v", *endpointRTMP, streamID, pubSecret)
+	ffmpeg := NewFFmpeg(func(v *ffmpegClient) {
+		v.args = []string{
+			"-re", "-stream_loop", "-1", "-i", *srsInputFile, "-c", "copy",
+			"-f", "flv", streamURL,
+		}
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r1 = ffmpeg.Run(ctx, cancel)
+	}()
+
+	// Start FFprobe to detect and verify stream.
+	duration := time.Duration(*srsFFprobeDuration) * time.Millisecond
+	ffprobe := NewFFprobe(func(v *ffprobeClient) {
+		v.dvrFile = fmt.Sprintf("srs-ffprobe-%v.flv", streamID)
+		v.streamURL = fmt.Sprintf("%v/live/%v.flv", *endpointHTTP, streamID)
+		v.duration, v.timeout = duration, time.Duration(*srsFFprobeTimeout)*time.Millisecond
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r2 = ffprobe.Run(ctx, cancel)
+	}()
+
+	// Fast quit for probe done.
+	select {
+	case <-ctx.Done():
+	case <-ffprobe.ProbeDoneCtx().Done():
+		cancel()
+	}
+
+	str, m := ffprobe.Result()
+	if len(m.Streams) != 2 {
+		r3 = errors.Errorf("invalid streams=%v, %v, %v", len(m.Streams), m.String(), str)
+	}
+
+	if ts := 90; m.Format.ProbeScore < ts {
+		r4 = errors.Errorf("low score=%v < %v, %v, %v", m.Format.ProbeScore, ts, m.String(), str)
+	}
+	if dv := m.Duration(); dv < duration/5 {
+		r5 = errors.Errorf("short duration=%v < %v, %v, %v", dv, duration, m.String(), str)
+	}
+}
+
 func TestScenario_WithStream_CallbackOnPublishFailed(t *testing.T) {
 	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
 	defer cancel()
@@ -2656,6 +2767,10 @@ func TestScenario_WithStream_CallbackOnRecordMp4(t *testing.T) {
 		ArtifactCode int    `json:"artifact_code"`
 		ArtifactPath string `json:"artifact_path"`
 		ArtifactURL  string `json:"artifact_url"`
+		// The HLS address and metadata of artifact.
+		ArtifactM3u8URL string  `json:"artifact_m3u8_url"`
+		Duration        float64 `json:"duration"`
+		NN              int     `json:"nn"`
 	}
 	var endReq RecordHooksEndReq
 	if err := json.Unmarshal([]byte(hooksReq), &endReq); err != nil {
@@ -2664,7 +2779,8 @@ func TestScenario_WithStream_CallbackOnRecordMp4(t *testing.T) {
 	}
 	if endReq.Action != "on_record_end" || endReq.Stream != streamID || endReq.UUID != recordFile.UUID ||
 		endReq.ArtifactCode != 0 || !strings.Contains(endReq.ArtifactPath, endReq.UUID) || endReq.UUID != beginReq.UUID ||
-		!strings.Contains(endReq.ArtifactURL, endReq.UUID) {
+		!strings.Contains(endReq.ArtifactURL, endReq.UUID) || !strings.Contains(endReq.ArtifactM3u8URL, endReq.UUID) ||
+		endReq.Duration < 10 || endReq.NN <= 0 {
 		r0 = errors.Errorf("invalid hooks req %v", hooksReq)
 		return
 	}
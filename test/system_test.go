@@ -85,6 +85,78 @@ func TestSystem_LoginByPassword(t *testing.T) {
 	}
 }
 
+func TestSystem_LoginByUserRole(t *testing.T) {
+	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
+	defer cancel()
+
+	var r0, r1 error
+	defer func(ctx context.Context) {
+		if err := filterTestError(ctx.Err(), r0, r1); err != nil {
+			t.Errorf("Fail for err %+v", err)
+		} else {
+			logger.Tf(ctx, "test done")
+		}
+	}(ctx)
+
+	name, password := fmt.Sprintf("viewer-%v", rand.Int()), fmt.Sprintf("%x", rand.Uint64())
+	if err := NewApi().WithAuth(ctx, "/terraform/v1/mgmt/users/create", &struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}{
+		Name: name, Password: password, Role: "viewer",
+	}, nil); err != nil {
+		r0 = err
+		return
+	}
+
+	defer func() {
+		if err := NewApi().WithAuth(ctx, "/terraform/v1/mgmt/users/remove", &struct {
+			Name string `json:"name"`
+		}{
+			Name: name,
+		}, nil); err != nil {
+			r1 = err
+		}
+	}()
+
+	res := struct {
+		Token  string `json:"token"`
+		Bearer string `json:"bearer"`
+		Role   string `json:"role"`
+	}{}
+	if err := NewApi().NoAuth(ctx, "/terraform/v1/mgmt/login", &struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{
+		Name: name, Password: password,
+	}, &res); err != nil {
+		r0 = err
+		return
+	} else if res.Token == "" || res.Bearer != "" || res.Role != "viewer" {
+		r0 = errors.Errorf("invalid response %v", res)
+		return
+	}
+
+	// The viewer is allowed to query the status, but not allowed to list users.
+	if err := NewApi().NoAuth(ctx, "/terraform/v1/mgmt/streams/query", &struct {
+		Token string `json:"token"`
+	}{
+		Token: res.Token,
+	}, nil); err != nil {
+		r0 = errors.Wrapf(err, "viewer should query streams")
+		return
+	}
+
+	if err := NewApi().NoAuth(ctx, "/terraform/v1/mgmt/users/list", &struct {
+		Token string `json:"token"`
+	}{
+		Token: res.Token,
+	}, &struct{}{}); err == nil {
+		r0 = errors.New("viewer should not list users")
+	}
+}
+
 func TestSystem_BootstrapQueryEnvs(t *testing.T) {
 	ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), time.Duration(*srsTimeout)*time.Millisecond)
 	defer cancel()